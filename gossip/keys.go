@@ -51,6 +51,13 @@ const (
 	// string address of the node. E.g. node-1bfa: fwd56.sjcb1:24001
 	KeyNodeIDPrefix = "node-"
 
+	// KeyStoreIDPrefix is the key prefix for gossiping the addresses
+	// of the nodes hosting each store. The actual key is suffixed with
+	// the hexadecimal representation of the store id and the value is
+	// the host:port address of the store's node. Raft transports use it
+	// to reach the replicas of a range.
+	KeyStoreIDPrefix = "store-"
+
 	// KeySentinel is a key for gossip which must not expire or else the
 	// node considers itself partitioned and will retry with bootstrap hosts.
 	KeySentinel = KeyClusterID
@@ -66,3 +73,8 @@ const (
 func MakeNodeIDGossipKey(nodeID int32) string {
 	return KeyNodeIDPrefix + strconv.FormatInt(int64(nodeID), 16)
 }
+
+// MakeStoreIDGossipKey returns the gossip key for store ID info.
+func MakeStoreIDGossipKey(storeID int32) string {
+	return KeyStoreIDPrefix + strconv.FormatInt(int64(storeID), 16)
+}
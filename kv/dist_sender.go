@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/cockroachdb/cockroach/client"
@@ -46,6 +47,10 @@ const (
 	// Maximum number of ranges to return from an internal range lookup.
 	// TODO(mrtracy): This value should be configurable.
 	rangeLookupMaxRanges = 8
+
+	// Maximum number of ranges to which the per-range requests of a
+	// multi-range request are sent concurrently.
+	maxParallelRangeRequests = 16
 )
 
 var rpcRetryOpts = util.RetryOptions{
@@ -303,8 +308,8 @@ func (ds *DistSender) sendRPC(desc *proto.RangeDescriptor, method string, args p
 // supplied key and sends the RPC according to the specified
 // options.
 // If the request spans multiple ranges (which is possible for
-// Scan or DeleteRange requests), Send sends requests to the
-// individual ranges sequentially and combines the results
+// Scan, ReverseScan or DeleteRange requests), Send sends requests to the
+// individual ranges in parallel and combines the results
 // transparently.
 func (ds *DistSender) Send(call *client.Call) {
 	// Verify permissions.
@@ -312,102 +317,228 @@ func (ds *DistSender) Send(call *client.Call) {
 		call.Reply.Header().SetGoError(err)
 		return
 	}
+	if err := ds.send(call.Method, call.Args, call.Reply); err != nil {
+		call.Reply.Header().SetGoError(err)
+	}
+}
 
+// send looks up the descriptors of the ranges covering the key span
+// of args and sends the request. A request addressing a single range
+// is sent to it directly; one spanning several ranges is split into
+// per-range requests via sendParallel, unless it's a write outside of
+// a transaction, which fails with a RangeKeyMismatchError.
+func (ds *DistSender) send(method string, args proto.Request, reply proto.Response) error {
 	// Retry logic for lookup of range by key and RPCs to range replicas.
 	retryOpts := rpcRetryOpts
-	retryOpts.Tag = fmt.Sprintf("routing %s rpc", call.Method)
+	retryOpts.Tag = fmt.Sprintf("routing %s rpc", method)
 
-	// responses and descNext are only used when executing across ranges.
-	var responses []proto.Response
-	var descNext *proto.RangeDescriptor
-	// args will be changed to point to a copy of call.Args if the request
-	// spans ranges since in that case we need to alter its contents.
-	args := call.Args
-	for {
-		reply := call.Reply
-		err := util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
-			descNext = nil
-			desc, err := ds.rangeCache.LookupRangeDescriptor(args.Header().Key)
-			if err == nil {
-				// If the request accesses keys beyond the end of this range,
-				// get the descriptor of the adjacent range to address next.
-				if desc.EndKey.Less(call.Args.Header().EndKey) {
-					if _, ok := call.Reply.(proto.Combinable); !ok {
-						return util.RetryBreak, util.Errorf("illegal cross-range operation", call)
-					}
-					// This next lookup is likely for free since we've read the
-					// previous descriptor and range lookups use cache
-					// prefetching.
-					descNext, err = ds.rangeCache.LookupRangeDescriptor(desc.EndKey)
-					// If this is the first step in a multi-range operation,
-					// additionally copy call.Args because we will have to
-					// mutate it as we talk to the involved ranges.
-					if len(responses) == 0 {
-						args = gogoproto.Clone(call.Args).(proto.Request)
-					}
-					// Truncate the request to our current range.
-					args.Header().EndKey = desc.EndKey
-				}
+	var descs []*proto.RangeDescriptor
+	err := util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
+		var err error
+		descs, err = ds.getRangeDescriptors(args.Header().Key, args.Header().EndKey)
+		if err == nil && len(descs) > 1 {
+			if _, ok := reply.(proto.Combinable); !ok {
+				return util.RetryBreak, util.Errorf("illegal cross-range operation: %s", method)
 			}
-			// true if we're dealing with a range-spanning request.
-			isMulti := len(responses) > 0 || descNext != nil
-			if err == nil {
-				if isMulti {
-					// Make a new reply object for this call.
-					reply = gogoproto.Clone(call.Reply).(proto.Response)
-				}
-				err = ds.sendRPC(desc, call.Method, args, reply)
+			// A write outside of a transaction is only atomic within a
+			// single range. It's refused so that the TxnCoordSender
+			// retries it in a transaction.
+			if args.Header().Txn == nil && !proto.IsReadOnly(method) {
+				return util.RetryBreak, proto.NewRangeKeyMismatchError(args.Header().Key, args.Header().EndKey, descs[0])
 			}
+			// The per-range requests are sent and retried individually.
+			return util.RetryBreak, nil
+		}
+		if err == nil {
+			err = ds.sendRPC(descs[0], method, args, reply)
+		}
 
-			if err != nil {
-				log.Warningf("failed to invoke %s: %s", call.Method, err)
-				// If retryable, allow retry. For range not found or range
-				// key mismatch errors, we don't backoff on the retry,
-				// but reset the backoff loop so we can retry immediately.
-				switch err.(type) {
-				case *proto.RangeNotFoundError, *proto.RangeKeyMismatchError:
-					// Range descriptor might be out of date - evict it.
-					ds.rangeCache.EvictCachedRangeDescriptor(args.Header().Key)
-					// On addressing errors, don't backoff and retry immediately.
-					return util.RetryReset, nil
-				default:
-					if retryErr, ok := err.(util.Retryable); ok && retryErr.CanRetry() {
-						return util.RetryContinue, nil
-					}
+		if err != nil {
+			log.Warningf("failed to invoke %s: %s", method, err)
+			// If retryable, allow retry. For range not found or range
+			// key mismatch errors, we don't backoff on the retry,
+			// but reset the backoff loop so we can retry immediately.
+			switch err.(type) {
+			case *proto.RangeNotFoundError, *proto.RangeKeyMismatchError:
+				// Range descriptor might be out of date - evict it.
+				ds.rangeCache.EvictCachedRangeDescriptor(args.Header().Key)
+				// On addressing errors, don't backoff and retry immediately.
+				return util.RetryReset, nil
+			default:
+				if retryErr, ok := err.(util.Retryable); ok && retryErr.CanRetry() {
+					return util.RetryContinue, nil
 				}
-			} else if isMulti {
-				// If this request spans ranges, collect the replies.
-				responses = append(responses, reply)
 			}
-			return util.RetryBreak, err
-		})
-		// Immediately return if querying a range failed non-retryably.
-		// For multi-range requests, we return the failing range's reply.
-		if err != nil {
-			reply.Header().SetGoError(err)
-			gogoproto.Merge(call.Reply, reply) // Only relevant in multi-range case.
-			return
 		}
-		// If this was the last range accessed by this call, exit loop.
-		if descNext == nil {
-			break
+		return util.RetryBreak, err
+	})
+	if err != nil || len(descs) == 1 {
+		return err
+	}
+	return ds.sendParallel(method, args, reply, descs)
+}
+
+// getRangeDescriptors returns the descriptors of the ranges covering
+// the span from key to endKey, in key order. If endKey is empty, only
+// the descriptor of the range containing key is returned. The
+// descriptors are looked up via the range cache, whose prefetching
+// makes looking up consecutive ranges cheap.
+func (ds *DistSender) getRangeDescriptors(key, endKey proto.Key) ([]*proto.RangeDescriptor, error) {
+	desc, err := ds.rangeCache.LookupRangeDescriptor(key)
+	if err != nil {
+		return nil, err
+	}
+	descs := []*proto.RangeDescriptor{desc}
+	for desc.EndKey.Less(endKey) {
+		if desc, err = ds.rangeCache.LookupRangeDescriptor(desc.EndKey); err != nil {
+			return nil, err
+		}
+		descs = append(descs, desc)
+	}
+	return descs, nil
+}
+
+// sendParallel sends a request spanning the ranges described by descs
+// as one request per range, truncated to the range's key span. At
+// most maxParallelRangeRequests requests are outstanding at a time.
+// The responses are combined in key order into reply; for a
+// ReverseScan, the ranges are visited and their responses combined
+// from right to left. For requests with a limit on the number of
+// results, the ranges are visited in batches until the limit is
+// reached, with each request limited to the results which remain to
+// be returned.
+//
+// Each per-range request is sent via send, so that a range which has
+// split since its descriptor was cached is handled transparently. On
+// failure, the reply of the first failed request in visiting order is
+// merged into reply.
+func (ds *DistSender) sendParallel(method string, args proto.Request, reply proto.Response,
+	descs []*proto.RangeDescriptor) error {
+	limit := maxResults(args)
+	parallelism := maxParallelRangeRequests
+	if limit > 0 && !proto.IsReadOnly(method) {
+		// Writes in excess of the limit can't be discarded afterwards,
+		// so limited writes visit one range at a time.
+		parallelism = 1
+	}
+	if method == proto.ReverseScan {
+		reversed := make([]*proto.RangeDescriptor, len(descs))
+		for i, desc := range descs {
+			reversed[len(descs)-1-i] = desc
+		}
+		descs = reversed
+	}
+	var responses []proto.Response
+	var count int64
+	for i := 0; i < len(descs) && (limit == 0 || count < limit); {
+		batch := descs[i:]
+		if len(batch) > parallelism {
+			batch = batch[:parallelism]
+		}
+		replies := make([]proto.Response, len(batch))
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for j, desc := range batch {
+			subArgs := gogoproto.Clone(args).(proto.Request)
+			// Truncate the request to the range's key span.
+			if subArgs.Header().Key.Less(desc.StartKey) {
+				subArgs.Header().Key = desc.StartKey
+			}
+			if desc.EndKey.Less(subArgs.Header().EndKey) {
+				subArgs.Header().EndKey = desc.EndKey
+			}
+			if limit > 0 {
+				setMaxResults(subArgs, limit-count)
+			}
+			replies[j] = gogoproto.Clone(reply).(proto.Response)
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				errs[j] = ds.send(method, subArgs, replies[j])
+			}(j)
+		}
+		wg.Wait()
+		i += len(batch)
+
+		for j := range batch {
+			if errs[j] != nil {
+				replies[j].Header().SetGoError(errs[j])
+				gogoproto.Merge(reply, replies[j])
+				return errs[j]
+			}
+			responses = append(responses, replies[j])
+			count += numResults(replies[j])
+		}
+	}
+
+	// Aggregate the individual range responses into one reply. We've
+	// already ascertained that we're dealing with a Combinable
+	// response type.
+	firstReply := responses[0].(proto.Combinable)
+	for _, r := range responses[1:] {
+		firstReply.Combine(r)
+	}
+	if limit > 0 && count > limit {
+		truncateResults(responses[0], limit)
+	}
+	gogoproto.Merge(reply, responses[0])
+	return nil
+}
+
+// maxResults returns the maximum number of results to be returned
+// by the request, or 0 if it's unlimited.
+func maxResults(args proto.Request) int64 {
+	switch t := args.(type) {
+	case *proto.ScanRequest:
+		return t.MaxResults
+	case *proto.ReverseScanRequest:
+		return t.MaxResults
+	case *proto.DeleteRangeRequest:
+		return t.MaxEntriesToDelete
+	}
+	return 0
+}
+
+// setMaxResults sets the maximum number of results to be returned by
+// the request.
+func setMaxResults(args proto.Request, max int64) {
+	switch t := args.(type) {
+	case *proto.ScanRequest:
+		t.MaxResults = max
+	case *proto.ReverseScanRequest:
+		t.MaxResults = max
+	case *proto.DeleteRangeRequest:
+		t.MaxEntriesToDelete = max
+	}
+}
+
+// numResults returns the number of results in the response.
+func numResults(reply proto.Response) int64 {
+	switch t := reply.(type) {
+	case *proto.ScanResponse:
+		return int64(len(t.Rows))
+	case *proto.ReverseScanResponse:
+		return int64(len(t.Rows))
+	case *proto.DeleteRangeResponse:
+		return t.NumDeleted
+	}
+	return 0
+}
+
+// truncateResults discards the results of a scan beyond the first
+// max. Requests within a batch of per-range requests are each limited
+// to the results remaining before the batch, so their combined
+// response may exceed the limit.
+func truncateResults(reply proto.Response, max int64) {
+	switch t := reply.(type) {
+	case *proto.ScanResponse:
+		if int64(len(t.Rows)) > max {
+			t.Rows = t.Rows[:max]
 		}
-		// In next iteration, query next range.
-		args.Header().Key = descNext.StartKey
-		// "Untruncate" EndKey to original.
-		args.Header().EndKey = call.Args.Header().EndKey
-	}
-
-	// If this was a multi-range request, aggregate the individual range
-	// responses into one reply.
-	if len(responses) > 0 {
-		// We've already ascertained earlier that we're dealing with a
-		// Combinable response type.
-		firstReply := responses[0].(proto.Combinable)
-		for _, r := range responses[1:] {
-			firstReply.Combine(r)
+	case *proto.ReverseScanResponse:
+		if int64(len(t.Rows)) > max {
+			t.Rows = t.Rows[:max]
 		}
-		gogoproto.Merge(call.Reply, responses[0])
 	}
 }
 
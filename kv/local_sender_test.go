@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/multiraft"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
@@ -123,7 +124,7 @@ func TestLocalSenderLookupReplica(t *testing.T) {
 	eng := engine.NewInMem(proto.Attributes{}, 1<<20)
 	ls := NewLocalSender()
 	db := client.NewKV(NewTxnCoordSender(ls, clock), nil)
-	store := storage.NewStore(clock, eng, db, nil)
+	store := storage.NewStore(clock, eng, db, nil, multiraft.NewLocalRPCTransport())
 	if err := store.Bootstrap(proto.StoreIdent{StoreID: 1}); err != nil {
 		t.Fatal(err)
 	}
@@ -150,14 +151,22 @@ func TestLocalSenderLookupReplica(t *testing.T) {
 		{3, proto.Key("x"), proto.Key("z")},
 	}
 	for i, rng := range ranges {
-		s[i] = storage.NewStore(clock, eng, db, nil)
-		s[i].Ident.StoreID = rng.storeID
+		s[i] = storage.NewStore(clock, engine.NewInMem(proto.Attributes{}, 1<<20), db, nil, multiraft.NewLocalRPCTransport())
+		if err := s[i].Bootstrap(proto.StoreIdent{StoreID: rng.storeID}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s[i].Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer s[i].Stop()
 		desc, err := store.NewRangeDescriptor(rng.start, rng.end, []proto.Replica{{StoreID: rng.storeID}})
 		if err != nil {
 			t.Fatal(err)
 		}
 		newRng := storage.NewRange(desc.FindReplica(rng.storeID).RangeID, desc, s[i])
-		s[i].AddRange(newRng)
+		if err := s[i].AddRange(newRng); err != nil {
+			t.Fatal(err)
+		}
 		ls.AddStore(s[i])
 	}
 
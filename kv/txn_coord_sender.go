@@ -19,6 +19,8 @@
 package kv
 
 import (
+	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -100,6 +102,20 @@ func (tm *txnMetadata) addKeyRange(start, end proto.Key) {
 	tm.keys.Add(key, nil)
 }
 
+// intents returns the key ranges covered by the transaction. Single
+// keys are returned with an empty end key.
+func (tm *txnMetadata) intents() []proto.Intent {
+	var intents []proto.Intent
+	for _, o := range tm.keys.GetOverlaps(engine.KeyMin, engine.KeyMax) {
+		intent := proto.Intent{Key: o.Key.Start().(proto.Key)}
+		if endKey := o.Key.End().(proto.Key); !intent.Key.Next().Equal(endKey) {
+			intent.EndKey = endKey
+		}
+		intents = append(intents, intent)
+	}
+	return intents
+}
+
 // close sends resolve intent commands for all key ranges this
 // transaction has covered, clears the keys cache and closes the
 // metadata heartbeat.
@@ -107,25 +123,22 @@ func (tm *txnMetadata) close(txn *proto.Transaction, sender client.KVSender) {
 	if tm.keys.Len() > 0 {
 		log.V(1).Infof("cleaning up %d intent(s) for transaction %s", tm.keys.Len(), txn)
 	}
-	for _, o := range tm.keys.GetOverlaps(engine.KeyMin, engine.KeyMax) {
+	// Single keys have no end key. This saves us from unnecessarily
+	// clearing intents as a range.
+	for _, intent := range tm.intents() {
 		call := &client.Call{
 			Method: proto.InternalResolveIntent,
 			Args: &proto.InternalResolveIntentRequest{
 				RequestHeader: proto.RequestHeader{
 					Timestamp: txn.Timestamp,
-					Key:       o.Key.Start().(proto.Key),
+					Key:       intent.Key,
+					EndKey:    intent.EndKey,
 					User:      storage.UserRoot,
 					Txn:       txn,
 				},
 			},
 			Reply: &proto.InternalResolveIntentResponse{},
 		}
-		// Set the end key only if it's not equal to Key.Next(). This
-		// saves us from unnecessarily clearing intents as a range.
-		endKey := o.Key.End().(proto.Key)
-		if !call.Args.Header().Key.Next().Equal(endKey) {
-			call.Args.Header().EndKey = endKey
-		}
 		// We don't care about the reply channel; these are best
 		// effort. We simply fire and forget, each in its own goroutine.
 		go func() {
@@ -175,18 +188,81 @@ func NewTxnCoordSender(wrapped client.KVSender, clock *hlc.Clock) *TxnCoordSende
 // Send implements the client.KVSender interface. If the call is part
 // of a transaction, the coordinator will initialize the transaction
 // if it's not nil but has an empty ID.
+//
+// Calls outside of a transaction which may access multiple ranges are
+// made consistent across ranges: reads are assigned a single timestamp
+// at which all ranges are read, and writes which the wrapped sender
+// finds to span ranges are executed in an implicit transaction, so
+// that they apply to all ranges or none. A sender refuses such a write
+// with a RangeKeyMismatchError; the DistSender does so whenever its
+// range descriptors show the write's key span crossing ranges.
 func (tc *TxnCoordSender) Send(call *client.Call) {
 	header := call.Args.Header()
-	// TODO(Tobias): for commands that may access multiple ranges,
-	// make sure to wrap in a txn for consistency.
 	tc.maybeBeginTxn(header)
 
 	// Process batch specially; otherwise, send via wrapped sender.
 	if call.Method == proto.Batch {
 		tc.sendBatch(call.Args.(*proto.BatchRequest), call.Reply.(*proto.BatchResponse))
-	} else {
+		return
+	}
+	if header.Txn != nil || !canSpanRanges(call) {
+		tc.sendOne(call)
+		return
+	}
+	if proto.IsReadOnly(call.Method) {
+		if header.Timestamp.Equal(proto.ZeroTimestamp) {
+			header.Timestamp = tc.clock.Now()
+		}
 		tc.sendOne(call)
+		return
+	}
+	tc.sendOne(call)
+	if _, ok := call.Reply.Header().GoError().(*proto.RangeKeyMismatchError); ok {
+		call.Reply.Header().Error = nil
+		tc.sendImplicitTxn(call)
+	}
+}
+
+// canSpanRanges returns whether the call may access keys of multiple
+// ranges; that is, whether it addresses a key range and its reply may
+// be combined from the replies of several ranges.
+func canSpanRanges(call *client.Call) bool {
+	if len(call.Args.Header().EndKey) == 0 {
+		return false
+	}
+	_, ok := call.Reply.(proto.Combinable)
+	return ok
+}
+
+// sendImplicitTxn executes the call, which is not part of a
+// transaction, in an implicit transaction which is committed once the
+// call succeeds. The transaction is retried on conflicts like any
+// other. On success, the reply is stripped of the transaction, which
+// is invisible to the caller.
+func (tc *TxnCoordSender) sendImplicitTxn(call *client.Call) {
+	header := call.Args.Header()
+	kv := client.NewKV(tc, nil)
+	kv.User = header.User
+	if header.UserPriority != nil {
+		kv.UserPriority = *header.UserPriority
 	}
+	txnOpts := &client.TransactionOptions{
+		Name: fmt.Sprintf("implicit %s %q-%q", call.Method, header.Key, header.EndKey),
+	}
+	var reply proto.Response
+	err := kv.RunTransaction(txnOpts, func(txn *client.KV) error {
+		// Each attempt uses fresh copies of args and reply.
+		args := gogoproto.Clone(call.Args).(proto.Request)
+		reply = gogoproto.Clone(call.Reply).(proto.Response)
+		return txn.Call(call.Method, args, reply)
+	})
+	if err != nil {
+		call.Reply.Header().SetGoError(err)
+		return
+	}
+	reply.Header().Txn = nil
+	call.Reply.Reset()
+	gogoproto.Merge(call.Reply, reply)
 }
 
 // Close implements the client.KVSender interface by stopping ongoing
@@ -246,9 +322,16 @@ func (tc *TxnCoordSender) sendOne(call *client.Call) {
 		} else {
 			header.Timestamp = header.Txn.Timestamp
 		}
-		// End transaction must have its key set to the txn ID.
+		// End transaction must have its key set to the txn ID and
+		// carries the intents written by the transaction, which are
+		// persisted with its record.
 		if call.Method == proto.EndTransaction {
 			header.Key = header.Txn.ID
+			tc.Lock()
+			if txnMeta, ok := tc.txns[string(header.Txn.ID)]; ok {
+				call.Args.(*proto.EndTransactionRequest).Intents = txnMeta.intents()
+			}
+			tc.Unlock()
 		}
 	}
 
@@ -288,6 +371,15 @@ func (tc *TxnCoordSender) sendOne(call *client.Call) {
 		}
 		txnMeta.lastUpdateTS = tc.clock.Now()
 		txnMeta.addKeyRange(header.Key, header.EndKey)
+		// Queue commands also leave intents on range-local keys.
+		switch call.Method {
+		case proto.EnqueueMessage, proto.ReapQueue:
+			txnMeta.addKeyRange(engine.InboxMetadataKey(header.Key), nil)
+			txnMeta.addKeyRange(engine.InboxMessageKey(header.Key, 0),
+				engine.InboxMessageKey(header.Key, math.MaxInt64).Next())
+		case proto.EnqueueUpdate:
+			txnMeta.addKeyRange(engine.UpdateQueueKey(header.Key, header.CmdID), nil)
+		}
 		tc.Unlock()
 	}
 
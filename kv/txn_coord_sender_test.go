@@ -26,6 +26,7 @@ import (
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/multiraft"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/rpc"
 	"github.com/cockroachdb/cockroach/storage"
@@ -48,7 +49,7 @@ func createTestDB(t *testing.T) (*client.KV, engine.Engine, *hlc.Clock, *hlc.Man
 	sender := NewTxnCoordSender(lSender, clock)
 	db := client.NewKV(sender, nil)
 	db.User = storage.UserRoot
-	store := storage.NewStore(clock, eng, db, g)
+	store := storage.NewStore(clock, eng, db, g, multiraft.NewLocalRPCTransport())
 	if err := store.Bootstrap(proto.StoreIdent{StoreID: 1}); err != nil {
 		t.Fatal(err)
 	}
@@ -539,3 +540,104 @@ func TestTxnCoordSenderTxnUpdatedOnError(t *testing.T) {
 		}
 	}
 }
+
+// TestTxnCoordSenderImplicitTxn verifies that a write outside of a
+// transaction which the wrapped sender finds to span ranges is
+// retried in an implicit transaction, which is committed and hidden
+// from the caller, that one confined to a single range is sent as is,
+// and that a range-spanning read is assigned a timestamp.
+func TestTxnCoordSenderImplicitTxn(t *testing.T) {
+	manual := hlc.ManualClock(1)
+	clock := hlc.NewClock(manual.UnixNano)
+
+	var methods []string
+	ts := NewTxnCoordSender(newTestSender(func(call *client.Call) {
+		// Intents are resolved asynchronously after the commit.
+		if call.Method == proto.InternalResolveIntent {
+			return
+		}
+		methods = append(methods, call.Method)
+		header := call.Args.Header()
+		switch call.Method {
+		case proto.DeleteRange:
+			// Only keys before "m" are on a single range.
+			if header.Txn == nil && proto.Key("m").Less(header.EndKey) {
+				call.Reply.Header().SetGoError(proto.NewRangeKeyMismatchError(header.Key, header.EndKey, nil))
+				return
+			}
+			call.Reply.(*proto.DeleteRangeResponse).NumDeleted = 2
+		case proto.EndTransaction:
+			if !call.Args.(*proto.EndTransactionRequest).Commit {
+				t.Errorf("expected implicit transaction to commit")
+			}
+			txn := gogoproto.Clone(header.Txn).(*proto.Transaction)
+			txn.Status = proto.COMMITTED
+			call.Reply.Header().Txn = txn
+		case proto.Scan:
+			if header.Txn != nil || header.Timestamp.Equal(proto.ZeroTimestamp) {
+				t.Errorf("expected %s to be sent with a timestamp and without a transaction; got %+v",
+					call.Method, header)
+			}
+		}
+	}), clock)
+	defer ts.Close()
+
+	delReply := &proto.DeleteRangeResponse{}
+	ts.Send(&client.Call{
+		Method: proto.DeleteRange,
+		Args: &proto.DeleteRangeRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:    proto.Key("a"),
+				EndKey: proto.Key("z"),
+				User:   storage.UserRoot,
+			},
+		},
+		Reply: delReply,
+	})
+	if err := delReply.GoError(); err != nil {
+		t.Fatal(err)
+	}
+	if delReply.NumDeleted != 2 || delReply.Txn != nil {
+		t.Errorf("expected 2 deletions and no transaction in reply; got %+v", delReply)
+	}
+	if exp := []string{proto.DeleteRange, proto.DeleteRange, proto.EndTransaction}; !reflect.DeepEqual(methods, exp) {
+		t.Errorf("expected %v; got %v", exp, methods)
+	}
+
+	methods = nil
+	delReply = &proto.DeleteRangeResponse{}
+	ts.Send(&client.Call{
+		Method: proto.DeleteRange,
+		Args: &proto.DeleteRangeRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:    proto.Key("a"),
+				EndKey: proto.Key("c"),
+				User:   storage.UserRoot,
+			},
+		},
+		Reply: delReply,
+	})
+	if err := delReply.GoError(); err != nil {
+		t.Fatal(err)
+	}
+	if delReply.NumDeleted != 2 || delReply.Txn != nil {
+		t.Errorf("expected 2 deletions and no transaction in reply; got %+v", delReply)
+	}
+	if !reflect.DeepEqual(methods, []string{proto.DeleteRange}) {
+		t.Errorf("expected a single DeleteRange; got %v", methods)
+	}
+
+	methods = nil
+	scanReply := &proto.ScanResponse{}
+	ts.Send(&client.Call{
+		Method: proto.Scan,
+		Args:   proto.ScanArgs(proto.Key("a"), proto.Key("z"), 0),
+		Reply:  scanReply,
+	})
+	if err := scanReply.GoError(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(methods, []string{proto.Scan}) {
+		t.Errorf("expected a single Scan; got %v", methods)
+	}
+}
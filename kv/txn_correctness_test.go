@@ -605,6 +605,11 @@ func (hv *historyVerifier) runCmd(db *client.KV, txnIdx, retry, cmdIdx int, cmds
 func checkConcurrency(name string, isolations []proto.IsolationType, txns []string,
 	verify *verifier, expSuccess bool, t *testing.T) {
 	setCorrectnessRetryOptions()
+	// Pushers don't wait on pushees, as the enforced ordering of
+	// commands in a history may have the pushee waiting on the pusher's
+	// command.
+	defer func(maxWait time.Duration) { storage.MaxTxnWait = maxWait }(storage.MaxTxnWait)
+	storage.MaxTxnWait = 0
 	verifier := newHistoryVerifier(name, txns, verify, expSuccess, t)
 	db, _, _, _, _ := createTestDB(t)
 	verifier.run(isolations, db, t)
@@ -25,6 +25,29 @@ type EventLeaderElection struct {
 }
 
 // An EventCommandCommitted is broadcast whenever a command has been committed.
+// Index is the position of the command in the group's log.
 type EventCommandCommitted struct {
+	GroupID uint64
+	Index   uint64
 	Command []byte
 }
+
+// An EventSnapshotReceived is broadcast when a group has been brought up
+// to date by a snapshot from the leader rather than by log entries. The
+// application must replace its state for the group with Data, which
+// reflects all commands up to and including Index.
+type EventSnapshotReceived struct {
+	GroupID uint64
+	Index   uint64
+	Data    []byte
+}
+
+// An EventMembershipChangeCommitted is broadcast whenever a change to a
+// group's membership has been committed and applied. NodeID is the node
+// which was added to or removed from the group, according to Operation.
+type EventMembershipChangeCommitted struct {
+	GroupID   uint64
+	Index     uint64
+	NodeID    uint64
+	Operation ChangeMembershipOperation
+}
@@ -25,6 +25,8 @@ import "fmt"
 type eventDemux struct {
 	LeaderElection   chan *EventLeaderElection
 	CommandCommitted chan *EventCommandCommitted
+	SnapshotReceived chan *EventSnapshotReceived
+	MembershipChange chan *EventMembershipChangeCommitted
 
 	events  <-chan interface{}
 	stopper chan struct{}
@@ -34,6 +36,8 @@ func newEventDemux(events <-chan interface{}) *eventDemux {
 	return &eventDemux{
 		make(chan *EventLeaderElection, 1000),
 		make(chan *EventCommandCommitted, 1000),
+		make(chan *EventSnapshotReceived, 1000),
+		make(chan *EventMembershipChangeCommitted, 1000),
 		events,
 		make(chan struct{}),
 	}
@@ -51,6 +55,12 @@ func (e *eventDemux) start() {
 				case *EventCommandCommitted:
 					e.CommandCommitted <- event
 
+				case *EventSnapshotReceived:
+					e.SnapshotReceived <- event
+
+				case *EventMembershipChangeCommitted:
+					e.MembershipChange <- event
+
 				default:
 					panic(fmt.Sprintf("got unknown event type %T", event))
 				}
@@ -7,11 +7,14 @@ import (
 	"net"
 	"net/rpc"
 	"strings"
+	"sync"
 
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
 type localRPCTransport struct {
+	mu        sync.Mutex
 	listeners map[uint64]net.Listener
 }
 
@@ -21,7 +24,7 @@ type localRPCTransport struct {
 // localhost.
 // Because this is just for local testing, it doesn't use TLS.
 func NewLocalRPCTransport() Transport {
-	return &localRPCTransport{make(map[uint64]net.Listener)}
+	return &localRPCTransport{listeners: make(map[uint64]net.Listener)}
 }
 
 func (lt *localRPCTransport) Listen(id uint64, server ServerInterface) error {
@@ -36,7 +39,9 @@ func (lt *localRPCTransport) Listen(id uint64, server ServerInterface) error {
 		return err
 	}
 
+	lt.mu.Lock()
 	lt.listeners[id] = listener
+	lt.mu.Unlock()
 	go lt.accept(rpcServer, listener)
 	return nil
 }
@@ -56,13 +61,22 @@ func (lt *localRPCTransport) accept(server *rpc.Server, listener net.Listener) {
 }
 
 func (lt *localRPCTransport) Stop(id uint64) {
-	lt.listeners[id].Close()
-	delete(lt.listeners, id)
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if listener, ok := lt.listeners[id]; ok {
+		listener.Close()
+		delete(lt.listeners, id)
+	}
 }
 
 func (lt *localRPCTransport) Connect(id uint64) (ClientInterface, error) {
-	address := lt.listeners[id].Addr().String()
-	client, err := rpc.Dial("tcp", address)
+	lt.mu.Lock()
+	listener, ok := lt.listeners[id]
+	lt.mu.Unlock()
+	if !ok {
+		return nil, util.Errorf("unknown peer %v", id)
+	}
+	client, err := rpc.Dial("tcp", listener.Addr().String())
 	if err != nil {
 		return nil, err
 	}
@@ -187,6 +187,15 @@ func (m *MultiRaft) CreateGroup(groupID uint64, initialMembers []uint64) error {
 	return <-op.ch
 }
 
+// RemoveGroup leaves the consensus group, which must have been
+// created on this node. The group's persistent state is left in
+// Storage for the application to clear.
+func (m *MultiRaft) RemoveGroup(groupID uint64) error {
+	op := &removeGroupOp{groupID, make(chan error, 1)}
+	m.ops <- op
+	return <-op.ch
+}
+
 // SubmitCommand sends a command (a binary blob) to the cluster. This method returns
 // when the command has been successfully sent, not when it has been committed.
 // TODO(bdarnell): should SubmitCommand wait until the commit?
@@ -213,6 +222,22 @@ func (m *MultiRaft) ChangeGroupMembership(groupID uint64, changeOp ChangeMembers
 	return <-op.ch
 }
 
+// Compact discards the group's log up to and including index, replacing it
+// with a snapshot whose contents are given by data. The snapshot is sent to
+// any follower which has fallen too far behind to be caught up from the log.
+// The application must supply data reflecting all commands up to and
+// including index.
+func (m *MultiRaft) Compact(groupID, index uint64, data []byte) error {
+	op := &compactOp{groupID, index, data, make(chan error, 1)}
+	m.ops <- op
+	if err := <-op.ch; err != nil {
+		return err
+	}
+	// The entry at index is kept to record the snapshot's index and term
+	// for a restart (see loadGroup).
+	return m.Storage.CompactLog(groupID, int(index)-1)
+}
+
 // pendingCall represents an RPC that we should not respond to until we have persisted
 // up to the given point. term and logIndex may be -1 if the rpc didn't modify that
 // variable and therefore can be resolved regardless of its value.
@@ -226,6 +251,14 @@ type pendingCall struct {
 type group struct {
 	groupID uint64
 
+	// members are the IDs of the nodes currently participating in the
+	// group, as required for the group's snapshots.
+	members []uint64
+
+	// raftStorage holds the group's log and hard state as seen by the
+	// raft state machine. It mirrors what has been written to Storage.
+	raftStorage *raft.MemoryStorage
+
 	// a List of *pendingCall
 	pendingCalls list.List
 
@@ -242,6 +275,11 @@ type createGroupOp struct {
 	ch             chan error
 }
 
+type removeGroupOp struct {
+	groupID uint64
+	ch      chan error
+}
+
 type submitCommandOp struct {
 	groupID uint64
 	command []byte
@@ -254,6 +292,13 @@ type changeGroupMembershipOp struct {
 	ch      chan error
 }
 
+type compactOp struct {
+	groupID uint64
+	index   uint64
+	data    []byte
+	ch      chan error
+}
+
 // node represents a connection to a remote node.
 type node struct {
 	nodeID   uint64
@@ -272,6 +317,9 @@ type state struct {
 	electionTimer *time.Timer
 	responses     chan *rpc.Call
 	writeTask     *writeTask
+	// persisted holds the hard state loaded from Storage of groups
+	// which have not yet been recreated since the node started.
+	persisted map[uint64]raftpb.HardState
 }
 
 func newState(m *MultiRaft) *state {
@@ -282,11 +330,15 @@ func newState(m *MultiRaft) *state {
 		nodes:     make(map[uint64]*node),
 		responses: make(chan *rpc.Call, 100),
 		writeTask: newWriteTask(m.Storage),
+		persisted: make(map[uint64]raftpb.HardState),
 	}
 }
 
 func (s *state) start() {
 	log.V(1).Infof("node %v starting", s.nodeID)
+	for gs := range s.Storage.LoadGroups() {
+		s.persisted[gs.GroupID] = gs.HardState
+	}
 	go s.writeTask.start()
 	// These maps form a kind of state machine: We don't want to read from the
 	// ready channel until the groups we got from the last read have made their
@@ -331,12 +383,18 @@ func (s *state) start() {
 			case *createGroupOp:
 				s.createGroup(op)
 
+			case *removeGroupOp:
+				s.removeGroup(op)
+
 			case *submitCommandOp:
 				s.submitCommand(op)
 
 			case *changeGroupMembershipOp:
 				s.changeGroupMembership(op)
 
+			case *compactOp:
+				s.compact(op)
+
 			default:
 				s.strictErrorLog("unknown op: %#v", op)
 			}
@@ -344,7 +402,7 @@ func (s *state) start() {
 		case call := <-s.requests:
 			log.V(6).Infof("node %v: got request %v", s.nodeID, call)
 			switch call.ServiceMethod {
-			case sendMessageName:
+			case SendMessageName:
 				s.sendMessageRequest(call.Args.(*SendMessageRequest),
 					call.Reply.(*SendMessageResponse), call)
 
@@ -355,7 +413,7 @@ func (s *state) start() {
 		case call := <-s.responses:
 			log.V(6).Infof("node %v: got response %v", s.nodeID, call)
 			switch call.ServiceMethod {
-			case sendMessageName:
+			case SendMessageName:
 
 			default:
 				s.strictErrorLog("unknown rpc response: %#v", call.Reply)
@@ -397,29 +455,142 @@ func (s *state) createGroup(op *createGroupOp) {
 		op.ch <- util.Errorf("group %v already exists", op.groupID)
 		return
 	}
-	log.V(6).Infof("node %v creating group %v", s.nodeID, op.groupID)
+	op.ch <- s.addGroup(op.groupID, op.initialMembers)
+}
+
+// addGroup creates the group with the given initial members, connecting
+// to any members not already known. A group created without members
+// awaits a snapshot from the leader of an existing group. A group which
+// has persisted state in Storage is restarted from that state instead;
+// its membership is recovered from its log.
+func (s *state) addGroup(groupID uint64, initialMembers []uint64) error {
+	log.V(6).Infof("node %v creating group %v", s.nodeID, groupID)
 
-	peers := make([]raft.Peer, len(op.initialMembers))
-	for i, member := range op.initialMembers {
+	peers := make([]raft.Peer, len(initialMembers))
+	for i, member := range initialMembers {
 		peers[i].ID = member
-		if node, ok := s.nodes[member]; ok {
-			node.refCount++
-			continue
+		if err := s.addNode(member); err != nil {
+			return err
+		}
+	}
+	raftStorage := raft.NewMemoryStorage()
+	if hs, ok := s.persisted[groupID]; ok {
+		log.V(6).Infof("node %v restarting group %v from %+v", s.nodeID, groupID, hs)
+		if err := s.loadGroup(groupID, hs, initialMembers, raftStorage); err != nil {
+			return err
 		}
-		conn, err := s.Transport.Connect(member)
+		delete(s.persisted, groupID)
+		peers = nil
+	}
+	if err := s.multiNode.CreateGroup(groupID, peers, raftStorage); err != nil {
+		return err
+	}
+	s.groups[groupID] = &group{
+		groupID:     groupID,
+		members:     append([]uint64(nil), initialMembers...),
+		raftStorage: raftStorage,
+	}
+	return nil
+}
+
+// loadGroup seeds raftStorage with the persisted hard state and log of
+// the group. The log extends in both directions from the entry at the
+// commit index. When the log has been compacted, its first remaining
+// entry marks the index and term of the snapshot which replaced the
+// entries before it (see Compact and writeTask) and is restored as
+// such; the application's own state reflects that snapshot.
+func (s *state) loadGroup(groupID uint64, hs raftpb.HardState, members []uint64,
+	raftStorage *raft.MemoryStorage) error {
+	first, last := int(hs.Commit), int(hs.Commit)
+	if first == 0 {
+		first, last = 1, 0
+	}
+	for ; first > 1; first-- {
+		entry, err := s.Storage.GetLogEntry(groupID, first-1)
 		if err != nil {
-			op.ch <- err
-			return
+			return err
+		}
+		if entry == nil {
+			break
+		}
+	}
+	for {
+		entry, err := s.Storage.GetLogEntry(groupID, last+1)
+		if err != nil {
+			return err
 		}
-		s.nodes[member] = &node{member, 1, &asyncClient{member, conn, s.responses}}
+		if entry == nil {
+			break
+		}
+		last++
 	}
-	s.multiNode.CreateGroup(op.groupID, peers)
-	s.groups[op.groupID] = &group{
-		groupID: op.groupID,
+
+	var entries []raftpb.Entry
+	if last >= first {
+		ch := make(chan *LogEntryState)
+		go s.Storage.GetLogEntries(groupID, first, last, ch)
+		var err error
+		for state := range ch {
+			if state.Error != nil {
+				err = state.Error
+				continue
+			}
+			entries = append(entries, state.Entry.Entry)
+		}
+		if err != nil {
+			return err
+		}
 	}
+	if len(entries) > 0 && entries[0].Index > 1 {
+		snap := raftpb.Snapshot{
+			Metadata: raftpb.SnapshotMetadata{
+				ConfState: raftpb.ConfState{Nodes: members},
+				Index:     entries[0].Index,
+				Term:      entries[0].Term,
+			},
+		}
+		if err := raftStorage.ApplySnapshot(snap); err != nil {
+			return err
+		}
+		entries = entries[1:]
+	}
+	if err := raftStorage.Append(entries); err != nil {
+		return err
+	}
+	return raftStorage.SetHardState(hs)
+}
+
+func (s *state) removeGroup(op *removeGroupOp) {
+	if _, ok := s.groups[op.groupID]; !ok {
+		op.ch <- util.Errorf("group %v not found", op.groupID)
+		return
+	}
+	log.V(6).Infof("node %v removing group %v", s.nodeID, op.groupID)
+	if err := s.multiNode.RemoveGroup(op.groupID); err != nil {
+		op.ch <- err
+		return
+	}
+	// Connections to the group's members are shared with other groups
+	// and are kept open.
+	delete(s.groups, op.groupID)
 	op.ch <- nil
 }
 
+// addNode takes a reference to the connection to the given node,
+// connecting to it if necessary.
+func (s *state) addNode(nodeID uint64) error {
+	if node, ok := s.nodes[nodeID]; ok {
+		node.refCount++
+		return nil
+	}
+	conn, err := s.Transport.Connect(nodeID)
+	if err != nil {
+		return err
+	}
+	s.nodes[nodeID] = &node{nodeID, 1, &asyncClient{nodeID, conn, s.responses}}
+	return nil
+}
+
 func (s *state) submitCommand(op *submitCommandOp) {
 	log.V(6).Infof("node %v submitting command to group %v", s.nodeID, op.groupID)
 	err := s.multiNode.Propose(context.Background(), op.groupID, op.command)
@@ -427,13 +598,60 @@ func (s *state) submitCommand(op *submitCommandOp) {
 }
 
 func (s *state) changeGroupMembership(op *changeGroupMembershipOp) {
-	log.V(6).Infof("node %v proposing membership change to group %v", s.nodeID, op.groupID)
-	err := s.multiNode.ProposeConfChange(context.Background(), op.groupID, raftpb.ConfChange{})
-	op.ch <- err
+	if _, ok := s.groups[op.groupID]; !ok {
+		op.ch <- util.Errorf("group %v not found", op.groupID)
+		return
+	}
+	cc := raftpb.ConfChange{NodeID: op.payload.Node}
+	switch op.payload.Operation {
+	case ChangeMembershipAddMember:
+		cc.Type = raftpb.ConfChangeAddNode
+	case ChangeMembershipRemoveMember:
+		cc.Type = raftpb.ConfChangeRemoveNode
+	default:
+		// TODO: support observers once etcd raft supports
+		// non-voting members.
+		op.ch <- util.Errorf("unsupported membership change operation %d", op.payload.Operation)
+		return
+	}
+	log.V(6).Infof("node %v proposing membership change %v to group %v", s.nodeID, cc, op.groupID)
+	op.ch <- s.multiNode.ProposeConfChange(context.Background(), op.groupID, cc)
+}
+
+func (s *state) compact(op *compactOp) {
+	g, ok := s.groups[op.groupID]
+	if !ok {
+		op.ch <- util.Errorf("group %v not found", op.groupID)
+		return
+	}
+	log.V(6).Infof("node %v compacting group %v through index %d", s.nodeID, op.groupID, op.index)
+	if _, err := g.raftStorage.CreateSnapshot(op.index, &raftpb.ConfState{Nodes: g.members},
+		op.data); err != nil {
+		op.ch <- err
+		return
+	}
+	op.ch <- g.raftStorage.Compact(op.index)
 }
 
 func (s *state) sendMessageRequest(req *SendMessageRequest, resp *SendMessageResponse,
 	call *rpc.Call) {
+	// A message for an unknown group is addressed to a node which has been
+	// added to the group; join it so that the leader can bring this node
+	// up to date with a snapshot.
+	if _, ok := s.groups[req.GroupID]; !ok {
+		if err := s.addGroup(req.GroupID, nil); err != nil {
+			call.Error = err
+			call.Done <- call
+			return
+		}
+	}
+	if _, ok := s.nodes[req.Message.From]; !ok {
+		if err := s.addNode(req.Message.From); err != nil {
+			call.Error = err
+			call.Done <- call
+			return
+		}
+	}
 	err := s.multiNode.Step(context.Background(), req.GroupID, req.Message)
 	if err != nil {
 		log.Errorf("raft: %s", err)
@@ -445,7 +663,11 @@ func (s *state) sendMessageRequest(req *SendMessageRequest, resp *SendMessageRes
 func (s *state) handleRaftReady(readyGroups map[uint64]raft.Ready) {
 	// Soft state is updated immediately; everything else waits for handleWriteReady.
 	for groupID, ready := range readyGroups {
-		g := s.groups[groupID]
+		g, ok := s.groups[groupID]
+		if !ok {
+			// The group has been removed.
+			continue
+		}
 		log.V(6).Infof("node %v: group %v: got %#v from raft", s.nodeID, groupID, ready)
 		if ready.SoftState != nil {
 			if ready.SoftState.Lead != g.softState.Lead {
@@ -473,6 +695,10 @@ func (s *state) handleWriteReady(readyGroups map[uint64]raft.Ready) {
 				gwr.entries[i] = &LogEntry{ent}
 			}
 		}
+		if !raft.IsEmptySnap(ready.Snapshot) {
+			snap := ready.Snapshot
+			gwr.snapshot = &snap
+		}
 		writeRequest.groups[groupID] = gwr
 	}
 	s.writeTask.in <- writeRequest
@@ -483,12 +709,24 @@ func (s *state) handleWriteResponse(response *writeResponse, readyGroups map[uin
 	// Everything has been written to disk; now we can apply updates to the state machine
 	// and send outgoing messages.
 	for groupID, ready := range readyGroups {
+		g, ok := s.groups[groupID]
+		if !ok {
+			// The group was removed while its ready data was written.
+			continue
+		}
+		s.updateRaftStorage(g, ready)
+		// A snapshot precedes any committed entries, which follow its index.
+		if !raft.IsEmptySnap(ready.Snapshot) {
+			g.members = append([]uint64(nil), ready.Snapshot.Metadata.ConfState.Nodes...)
+			s.sendEvent(&EventSnapshotReceived{groupID, ready.Snapshot.Metadata.Index,
+				ready.Snapshot.Data})
+		}
 		for _, entry := range ready.CommittedEntries {
 			switch entry.Type {
 			case raftpb.EntryNormal:
 				// TODO(bdarnell): etcd raft adds a nil entry upon election; should this be given a different Type?
 				if entry.Data != nil {
-					s.sendEvent(&EventCommandCommitted{entry.Data})
+					s.sendEvent(&EventCommandCommitted{groupID, entry.Index, entry.Data})
 				}
 			case raftpb.EntryConfChange:
 				cc := raftpb.ConfChange{}
@@ -498,6 +736,18 @@ func (s *state) handleWriteResponse(response *writeResponse, readyGroups map[uin
 				}
 				log.V(3).Infof("node %v applying configuration change %v", s.nodeID, cc)
 				s.multiNode.ApplyConfChange(groupID, cc)
+				g.applyConfChange(cc)
+				event := &EventMembershipChangeCommitted{groupID, entry.Index, cc.NodeID,
+					ChangeMembershipAddMember}
+				switch cc.Type {
+				case raftpb.ConfChangeAddNode:
+					if err := s.addNode(cc.NodeID); err != nil {
+						log.Errorf("node %v unable to connect to node %v: %s", s.nodeID, cc.NodeID, err)
+					}
+				case raftpb.ConfChangeRemoveNode:
+					event.Operation = ChangeMembershipRemoveMember
+				}
+				s.sendEvent(event)
 			}
 		}
 		for _, msg := range ready.Messages {
@@ -510,6 +760,45 @@ func (s *state) handleWriteResponse(response *writeResponse, readyGroups map[uin
 			s.nodes[msg.To].client.sendMessage(&SendMessageRequest{groupID, msg})
 		}
 	}
+	s.multiNode.Advance(readyGroups)
+}
+
+// updateRaftStorage adds the snapshot, entries and hard state of ready,
+// which have been written to Storage, to the group's raft storage.
+func (s *state) updateRaftStorage(g *group, ready raft.Ready) {
+	if !raft.IsEmptySnap(ready.Snapshot) {
+		if err := g.raftStorage.ApplySnapshot(ready.Snapshot); err != nil {
+			s.strictErrorLog("node %v: group %v: unable to apply snapshot: %s", s.nodeID, g.groupID, err)
+		}
+	}
+	if err := g.raftStorage.Append(ready.Entries); err != nil {
+		s.strictErrorLog("node %v: group %v: unable to append entries: %s", s.nodeID, g.groupID, err)
+	}
+	if !raft.IsEmptyHardState(ready.HardState) {
+		if err := g.raftStorage.SetHardState(ready.HardState); err != nil {
+			s.strictErrorLog("node %v: group %v: unable to set hard state: %s", s.nodeID, g.groupID, err)
+		}
+	}
+}
+
+// applyConfChange updates the group's membership to reflect cc.
+func (g *group) applyConfChange(cc raftpb.ConfChange) {
+	switch cc.Type {
+	case raftpb.ConfChangeAddNode:
+		for _, id := range g.members {
+			if id == cc.NodeID {
+				return
+			}
+		}
+		g.members = append(g.members, cc.NodeID)
+	case raftpb.ConfChangeRemoveNode:
+		for i, id := range g.members {
+			if id == cc.NodeID {
+				g.members = append(g.members[:i], g.members[i+1:]...)
+				return
+			}
+		}
+	}
 }
 
 func (s *state) addPendingCall(g *group, call *pendingCall) {
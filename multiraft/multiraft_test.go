@@ -140,6 +140,9 @@ func TestCommand(t *testing.T) {
 	for i, events := range cluster.events {
 		log.Infof("waiting for event to be commited on node %v", i)
 		commit := <-events.CommandCommitted
+		if commit.GroupID != groupID {
+			t.Errorf("unexpected group ID in committed command: %v", commit.GroupID)
+		}
 		if string(commit.Command) != "command" {
 			t.Errorf("unexpected value in committed command: %v", commit.Command)
 		}
@@ -206,5 +209,87 @@ func TestMembershipChange(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
+		select {
+		case e := <-cluster.events[0].MembershipChange:
+			if e.GroupID != groupID || e.NodeID != cluster.nodes[i].nodeID ||
+				e.Operation != ChangeMembershipAddMember {
+				t.Errorf("unexpected membership change event %+v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for node %v to be added", cluster.nodes[i].nodeID)
+		}
+	}
+
+	// Non-voting members are not supported.
+	err := cluster.nodes[0].ChangeGroupMembership(groupID, ChangeMembershipAddObserver,
+		cluster.nodes[3].nodeID)
+	if err == nil {
+		t.Error("expected error adding an observer")
+	}
+}
+
+func TestRestart(t *testing.T) {
+	storage := NewMemoryStorage()
+	groupID := uint64(1)
+	startNode := func() (*state, *manualTicker, *eventDemux) {
+		ticker := newManualTicker()
+		config := &Config{
+			Transport:              NewLocalRPCTransport(),
+			Storage:                storage,
+			Ticker:                 ticker,
+			ElectionTimeoutTicks:   1,
+			HeartbeatIntervalTicks: 1,
+			TickInterval:           time.Millisecond,
+			Strict:                 true,
+		}
+		mr, err := NewMultiRaft(1, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := newState(mr)
+		demux := newEventDemux(state.Events)
+		demux.start()
+		go state.start()
+		if err := state.CreateGroup(groupID, []uint64{1}); err != nil {
+			t.Fatal(err)
+		}
+		return state, ticker, demux
+	}
+	waitForCommand := func(demux *eventDemux, command string) {
+		select {
+		case commit := <-demux.CommandCommitted:
+			if commit.GroupID != groupID || string(commit.Command) != command {
+				t.Fatalf("unexpected committed command %+v", commit)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for command %q to be committed", command)
+		}
+	}
+
+	state, ticker, demux := startNode()
+	time.Sleep(time.Millisecond)
+	ticker.Tick()
+	ticker.Tick()
+	<-demux.LeaderElection
+	if err := state.SubmitCommand(groupID, []byte("command")); err != nil {
+		t.Fatal(err)
+	}
+	waitForCommand(demux, "command")
+	state.Stop()
+	demux.stop()
+
+	// The restarted group recovers its log from storage and replays the
+	// committed command; it can then elect a leader and commit more.
+	state, ticker, demux = startNode()
+	defer demux.stop()
+	defer state.Stop()
+	waitForCommand(demux, "command")
+	time.Sleep(time.Millisecond)
+	ticker.Tick()
+	ticker.Tick()
+	<-demux.LeaderElection
+	if err := state.SubmitCommand(groupID, []byte("command2")); err != nil {
+		t.Fatal(err)
 	}
+	waitForCommand(demux, "command2")
 }
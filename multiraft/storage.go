@@ -18,6 +18,9 @@
 package multiraft
 
 import (
+	"sync"
+
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
 	"github.com/coreos/etcd/raft/raftpb"
 )
@@ -73,30 +76,36 @@ type LogEntryState struct {
 }
 
 // The Storage interface is supplied by the application to manage persistent storage
-// of raft data.
+// of raft data. Its methods may be called concurrently.
 type Storage interface {
 	// LoadGroups is called at startup to load all previously-existing groups.
 	// The returned channel should be closed once all groups have been loaded.
-	//LoadGroups() <-chan *GroupPersistentState
+	LoadGroups() <-chan *GroupPersistentState
 
 	// SetGroupState is called to update the persistent state for the given group.
 	SetGroupState(groupID uint64, state *GroupPersistentState) error
 
 	// AppendLogEntries is called to add entries to the log. The entries will always span
-	// a contiguous range of indices just after the current end of the log.
+	// a contiguous range of indices. Any existing entries at or after the index of the
+	// first new entry are replaced.
 	AppendLogEntries(groupID uint64, entries []*LogEntry) error
 
 	// TruncateLog is called to delete all log entries with index > lastIndex.
-	//TruncateLog(groupID uint64, lastIndex int) error
+	TruncateLog(groupID uint64, lastIndex int) error
+
+	// CompactLog is called to delete all log entries with index <= lastIndex,
+	// once they are covered by a snapshot of the application's state.
+	CompactLog(groupID uint64, lastIndex int) error
 
 	// GetLogEntry is called to synchronously retrieve an entry from the log.
-	//GetLogEntry(groupID uint64, index int) (*LogEntry, error)
+	// Returns nil if there is no entry at the given index.
+	GetLogEntry(groupID uint64, index int) (*LogEntry, error)
 
 	// GetLogEntries is called to asynchronously retrieve entries from the log,
 	// from firstIndex to lastIndex inclusive. If there is an error the storage
 	// layer should send one LogEntryState with a non-nil error and then close the
 	// channel.
-	//GetLogEntries(groupID uint64, firstIndex, lastIndex int, ch chan<- *LogEntryState)
+	GetLogEntries(groupID uint64, firstIndex, lastIndex int, ch chan<- *LogEntryState)
 }
 
 type memoryGroup struct {
@@ -106,6 +115,7 @@ type memoryGroup struct {
 
 // MemoryStorage is an in-memory implementation of Storage for testing.
 type MemoryStorage struct {
+	mu     sync.Mutex
 	groups map[uint64]*memoryGroup
 }
 
@@ -114,52 +124,102 @@ var _ Storage = (*MemoryStorage)(nil)
 
 // NewMemoryStorage creates a MemoryStorage.
 func NewMemoryStorage() *MemoryStorage {
-	return &MemoryStorage{make(map[uint64]*memoryGroup)}
+	return &MemoryStorage{groups: make(map[uint64]*memoryGroup)}
 }
 
 // LoadGroups implements the Storage interface.
-/*func (m *MemoryStorage) LoadGroups() <-chan *GroupPersistentState {
-	// TODO(bdarnell): replay the group state.
-	ch := make(chan *GroupPersistentState)
+func (m *MemoryStorage) LoadGroups() <-chan *GroupPersistentState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan *GroupPersistentState, len(m.groups))
+	for _, g := range m.groups {
+		state := g.state
+		ch <- &state
+	}
 	close(ch)
 	return ch
-}*/
+}
 
 // SetGroupState implements the Storage interface.
 func (m *MemoryStorage) SetGroupState(groupID uint64,
 	state *GroupPersistentState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.getGroup(groupID).state = *state
 	return nil
 }
 
 // AppendLogEntries implements the Storage interface.
 func (m *MemoryStorage) AppendLogEntries(groupID uint64, entries []*LogEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	g := m.getGroup(groupID)
+	if len(entries) > 0 {
+		// Discard any entries which are being overwritten, or leave a gap
+		// of compacted entries if the new entries follow a snapshot.
+		if first := int(entries[0].Entry.Index); first > 0 {
+			for len(g.entries) < first {
+				g.entries = append(g.entries, nil)
+			}
+			g.entries = g.entries[:first]
+		}
+	}
 	g.entries = append(g.entries, entries...)
 	return nil
 }
 
 // TruncateLog implements the Storage interface.
-/*func (m *MemoryStorage) TruncateLog(groupID uint64, lastIndex int) error {
-	panic("unimplemented")
-}*/
+func (m *MemoryStorage) TruncateLog(groupID uint64, lastIndex int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g := m.getGroup(groupID)
+	if lastIndex+1 < len(g.entries) {
+		g.entries = g.entries[:lastIndex+1]
+	}
+	return nil
+}
+
+// CompactLog implements the Storage interface. Compacted entries are
+// replaced with nil so that the remaining entries keep their indexes.
+func (m *MemoryStorage) CompactLog(groupID uint64, lastIndex int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g := m.getGroup(groupID)
+	for i := 0; i <= lastIndex && i < len(g.entries); i++ {
+		g.entries[i] = nil
+	}
+	return nil
+}
 
 // GetLogEntry implements the Storage interface.
-/*func (m *MemoryStorage) GetLogEntry(groupID uint64, index int) (*LogEntry, error) {
-	panic("unimplemented")
-}*/
+func (m *MemoryStorage) GetLogEntry(groupID uint64, index int) (*LogEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g := m.getGroup(groupID)
+	if index < 0 || index >= len(g.entries) {
+		return nil, nil
+	}
+	return g.entries[index], nil
+}
 
 // GetLogEntries implements the Storage interface.
-/*func (m *MemoryStorage) GetLogEntries(groupID uint64, firstIndex, lastIndex int,
+func (m *MemoryStorage) GetLogEntries(groupID uint64, firstIndex, lastIndex int,
 	ch chan<- *LogEntryState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	g := m.getGroup(groupID)
 	for i := firstIndex; i <= lastIndex; i++ {
+		if i < 0 || i >= len(g.entries) || g.entries[i] == nil {
+			ch <- &LogEntryState{Index: i, Error: util.Errorf("log entry %d not found", i)}
+			break
+		}
 		ch <- &LogEntryState{i, *g.entries[i], nil}
 	}
 	close(ch)
-}*/
+}
 
 // getGroup returns a mutable memoryGroup object, creating if necessary.
+// The caller must hold m.mu.
 func (m *MemoryStorage) getGroup(groupID uint64) *memoryGroup {
 	g, ok := m.groups[groupID]
 	if !ok {
@@ -176,6 +236,9 @@ func (m *MemoryStorage) getGroup(groupID uint64) *memoryGroup {
 type groupWriteRequest struct {
 	state   *GroupPersistentState
 	entries []*LogEntry
+	// snapshot, if non-nil, has been received from the leader and
+	// supersedes all log entries up to and including its index.
+	snapshot *raftpb.Snapshot
 }
 
 // writeRequest is a collection of groupWriteRequests.
@@ -253,6 +316,21 @@ func (w *writeTask) start() {
 				}
 				groupResp.state = groupReq.state
 			}
+			if groupReq.snapshot != nil {
+				// The snapshot replaces the log through its index. An entry
+				// with the snapshot's index and term takes the place of the
+				// log so that the group can be restarted from it.
+				meta := groupReq.snapshot.Metadata
+				err := w.storage.CompactLog(groupID, int(meta.Index))
+				if err != nil {
+					continue
+				}
+				marker := &LogEntry{raftpb.Entry{Index: meta.Index, Term: meta.Term}}
+				err = w.storage.AppendLogEntries(groupID, []*LogEntry{marker})
+				if err != nil {
+					continue
+				}
+			}
 			if len(groupReq.entries) > 0 {
 				err := w.storage.AppendLogEntries(groupID, groupReq.entries)
 				if err != nil {
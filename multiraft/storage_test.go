@@ -31,10 +31,10 @@ func (b *BlockableStorage) Unblock() {
 	b.mu.Unlock()
 }
 
-/*func (b *BlockableStorage) LoadGroups() <-chan *GroupPersistentState {
+func (b *BlockableStorage) LoadGroups() <-chan *GroupPersistentState {
 	b.wait()
 	return b.storage.LoadGroups()
-}*/
+}
 
 func (b *BlockableStorage) SetGroupState(groupID uint64,
 	state *GroupPersistentState) error {
@@ -47,11 +47,16 @@ func (b *BlockableStorage) AppendLogEntries(groupID uint64, entries []*LogEntry)
 	return b.storage.AppendLogEntries(groupID, entries)
 }
 
-/*func (b *BlockableStorage) TruncateLog(groupID uint64, lastIndex int) error {
+func (b *BlockableStorage) TruncateLog(groupID uint64, lastIndex int) error {
 	b.wait()
 	return b.storage.TruncateLog(groupID, lastIndex)
 }
 
+func (b *BlockableStorage) CompactLog(groupID uint64, lastIndex int) error {
+	b.wait()
+	return b.storage.CompactLog(groupID, lastIndex)
+}
+
 func (b *BlockableStorage) GetLogEntry(groupID uint64, index int) (*LogEntry, error) {
 	b.wait()
 	return b.storage.GetLogEntry(groupID, index)
@@ -62,4 +67,3 @@ func (b *BlockableStorage) GetLogEntries(groupID uint64, firstIndex, lastIndex i
 	b.wait()
 	b.storage.GetLogEntries(groupID, firstIndex, lastIndex, ch)
 }
-*/
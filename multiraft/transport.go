@@ -18,8 +18,11 @@
 package multiraft
 
 import (
+	"encoding/binary"
+	"fmt"
 	"net/rpc"
 
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/coreos/etcd/raft/raftpb"
 )
 
@@ -52,6 +55,54 @@ type SendMessageRequest struct {
 type SendMessageResponse struct {
 }
 
+// Reset implements the gogoproto.Message interface, which together
+// with Marshal and Unmarshal allows SendMessageRequests to be sent via
+// protobuf-based RPC codecs.
+func (r *SendMessageRequest) Reset() { *r = SendMessageRequest{} }
+
+// String implements the gogoproto.Message interface.
+func (r *SendMessageRequest) String() string { return fmt.Sprintf("%+v", *r) }
+
+// ProtoMessage implements the gogoproto.Message interface.
+func (*SendMessageRequest) ProtoMessage() {}
+
+// Marshal encodes the request as the uvarint-encoded group ID
+// followed by the marshaled raft message.
+func (r *SendMessageRequest) Marshal() ([]byte, error) {
+	msg, err := r.Message.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(msg))
+	buf = buf[:binary.PutUvarint(buf, r.GroupID)]
+	return append(buf, msg...), nil
+}
+
+// Unmarshal decodes a request encoded by Marshal.
+func (r *SendMessageRequest) Unmarshal(data []byte) error {
+	groupID, n := binary.Uvarint(data)
+	if n <= 0 {
+		return util.Errorf("invalid group ID in raft message request")
+	}
+	r.GroupID = groupID
+	return r.Message.Unmarshal(data[n:])
+}
+
+// Reset implements the gogoproto.Message interface.
+func (r *SendMessageResponse) Reset() {}
+
+// String implements the gogoproto.Message interface.
+func (r *SendMessageResponse) String() string { return "{}" }
+
+// ProtoMessage implements the gogoproto.Message interface.
+func (*SendMessageResponse) ProtoMessage() {}
+
+// Marshal encodes the empty response.
+func (r *SendMessageResponse) Marshal() ([]byte, error) { return nil, nil }
+
+// Unmarshal decodes the empty response.
+func (r *SendMessageResponse) Unmarshal(data []byte) error { return nil }
+
 // ServerInterface is a generic interface based on net/rpc.
 type ServerInterface interface {
 	DoRPC(name string, req, resp interface{}) error
@@ -62,9 +113,10 @@ type RPCInterface interface {
 	SendMessage(req *SendMessageRequest, resp *SendMessageResponse) error
 }
 
-var (
-	sendMessageName = "MultiRaft.SendMessage"
-)
+// SendMessageName is the name under which raft messages are sent.
+// Transports must register their RPCInterface under the service name
+// "MultiRaft".
+const SendMessageName = "MultiRaft.SendMessage"
 
 // ClientInterface is the interface expected of the client provided by a transport.
 // It is satisfied by rpc.Client, but could be implemented in other ways (using
@@ -80,7 +132,7 @@ type rpcAdapter struct {
 }
 
 func (r *rpcAdapter) SendMessage(req *SendMessageRequest, resp *SendMessageResponse) error {
-	return r.server.DoRPC(sendMessageName, req, resp)
+	return r.server.DoRPC(SendMessageName, req, resp)
 }
 
 // asyncClient bridges MultiRaft's channel-oriented interface with the synchronous RPC interface.
@@ -93,5 +145,5 @@ type asyncClient struct {
 }
 
 func (a *asyncClient) sendMessage(req *SendMessageRequest) {
-	a.conn.Go(sendMessageName, req, &SendMessageResponse{}, a.ch)
+	a.conn.Go(SendMessageName, req, &SendMessageResponse{}, a.ch)
 }
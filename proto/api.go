@@ -52,12 +52,17 @@ const (
 	// args.RequestHeader.Key and args.RequestHeader.EndKey, with
 	// the latter endpoint excluded.
 	Scan = "Scan"
+	// ReverseScan fetches the values for all keys which fall between
+	// args.RequestHeader.Key and args.RequestHeader.EndKey, with the
+	// latter endpoint excluded, in descending key order.
+	ReverseScan = "ReverseScan"
 	// EndTransaction either commits or aborts an ongoing transaction.
 	EndTransaction = "EndTransaction"
 	// AccumulateTS is used to efficiently accumulate a time series of
-	// int64 quantities representing discrete subtimes. For example, a
-	// key/value might represent a minute of data. Each would contain 60
-	// int64 counts, each representing a second.
+	// data points sampled within a discrete interval. For example, a
+	// key/value might represent an hour of data, accumulating samples
+	// taken each second. The points are merged into the time series
+	// stored at the key without reading it.
 	AccumulateTS = "AccumulateTS"
 	// ReapQueue scans and deletes messages from a recipient message
 	// queue. ReapQueueRequest invocations must be part of an extant
@@ -73,6 +78,11 @@ const (
 	Batch = "Batch"
 	// AdminSplit is called to coordinate a split of a range.
 	AdminSplit = "AdminSplit"
+	// AdminMerge is called to coordinate a merge of a range with the
+	// range which follows it.
+	AdminMerge = "AdminMerge"
+	// AdminChangeReplicas is called to add or remove a replica of a range.
+	AdminChangeReplicas = "AdminChangeReplicas"
 )
 
 type stringSet map[string]struct{}
@@ -98,37 +108,45 @@ var AllMethods = stringSet{
 	Delete:                struct{}{},
 	DeleteRange:           struct{}{},
 	Scan:                  struct{}{},
+	ReverseScan:           struct{}{},
 	EndTransaction:        struct{}{},
 	AccumulateTS:          struct{}{},
 	ReapQueue:             struct{}{},
 	EnqueueUpdate:         struct{}{},
 	EnqueueMessage:        struct{}{},
 	AdminSplit:            struct{}{},
+	AdminMerge:            struct{}{},
+	AdminChangeReplicas:   struct{}{},
 	Batch:                 struct{}{},
 	InternalHeartbeatTxn:  struct{}{},
 	InternalPushTxn:       struct{}{},
 	InternalResolveIntent: struct{}{},
 	InternalSnapshotCopy:  struct{}{},
+	InternalLeaderLease:   struct{}{},
+	InternalGC:            struct{}{},
 }
 
 // PublicMethods specifies the set of methods accessible via the
 // public key-value API.
 var PublicMethods = stringSet{
-	Contains:       struct{}{},
-	Get:            struct{}{},
-	Put:            struct{}{},
-	ConditionalPut: struct{}{},
-	Increment:      struct{}{},
-	Delete:         struct{}{},
-	DeleteRange:    struct{}{},
-	Scan:           struct{}{},
-	EndTransaction: struct{}{},
-	AccumulateTS:   struct{}{},
-	ReapQueue:      struct{}{},
-	EnqueueUpdate:  struct{}{},
-	EnqueueMessage: struct{}{},
-	Batch:          struct{}{},
-	AdminSplit:     struct{}{},
+	Contains:            struct{}{},
+	Get:                 struct{}{},
+	Put:                 struct{}{},
+	ConditionalPut:      struct{}{},
+	Increment:           struct{}{},
+	Delete:              struct{}{},
+	DeleteRange:         struct{}{},
+	Scan:                struct{}{},
+	ReverseScan:         struct{}{},
+	EndTransaction:      struct{}{},
+	AccumulateTS:        struct{}{},
+	ReapQueue:           struct{}{},
+	EnqueueUpdate:       struct{}{},
+	EnqueueMessage:      struct{}{},
+	Batch:               struct{}{},
+	AdminSplit:          struct{}{},
+	AdminMerge:          struct{}{},
+	AdminChangeReplicas: struct{}{},
 }
 
 // InternalMethods specifies the set of methods accessible only
@@ -138,6 +156,8 @@ var InternalMethods = stringSet{
 	InternalPushTxn:       struct{}{},
 	InternalResolveIntent: struct{}{},
 	InternalSnapshotCopy:  struct{}{},
+	InternalLeaderLease:   struct{}{},
+	InternalGC:            struct{}{},
 }
 
 // ReadMethods specifies the set of methods which read and return data.
@@ -147,6 +167,7 @@ var ReadMethods = stringSet{
 	ConditionalPut:       struct{}{},
 	Increment:            struct{}{},
 	Scan:                 struct{}{},
+	ReverseScan:          struct{}{},
 	ReapQueue:            struct{}{},
 	InternalRangeLookup:  struct{}{},
 	InternalSnapshotCopy: struct{}{},
@@ -168,6 +189,8 @@ var WriteMethods = stringSet{
 	InternalHeartbeatTxn:  struct{}{},
 	InternalPushTxn:       struct{}{},
 	InternalResolveIntent: struct{}{},
+	InternalLeaderLease:   struct{}{},
+	InternalGC:            struct{}{},
 }
 
 // TxnMethods specifies the set of methods which leave key intents
@@ -178,7 +201,6 @@ var TxnMethods = stringSet{
 	Increment:      struct{}{},
 	Delete:         struct{}{},
 	DeleteRange:    struct{}{},
-	AccumulateTS:   struct{}{},
 	ReapQueue:      struct{}{},
 	EnqueueUpdate:  struct{}{},
 	EnqueueMessage: struct{}{},
@@ -188,7 +210,9 @@ var TxnMethods = stringSet{
 // read-only nor read-write commands but instead execute directly on
 // the Raft leader.
 var adminMethods = stringSet{
-	AdminSplit: struct{}{},
+	AdminSplit:          struct{}{},
+	AdminMerge:          struct{}{},
+	AdminChangeReplicas: struct{}{},
 }
 
 // NeedReadPerm returns true if the specified method requires read permissions.
@@ -294,6 +318,18 @@ func ScanArgs(key, endKey Key, maxResults int64) *ScanRequest {
 	}
 }
 
+// ReverseScanArgs returns a ReverseScanRequest object initialized to
+// scan from end to start keys with max results.
+func ReverseScanArgs(key, endKey Key, maxResults int64) *ReverseScanRequest {
+	return &ReverseScanRequest{
+		RequestHeader: RequestHeader{
+			Key:    key,
+			EndKey: endKey,
+		},
+		MaxResults: maxResults,
+	}
+}
+
 // MethodForRequest returns the method name corresponding to the type
 // of the request.
 func MethodForRequest(req Request) (string, error) {
@@ -314,6 +350,8 @@ func MethodForRequest(req Request) (string, error) {
 		return DeleteRange, nil
 	case *ScanRequest:
 		return Scan, nil
+	case *ReverseScanRequest:
+		return ReverseScan, nil
 	case *EndTransactionRequest:
 		return EndTransaction, nil
 	case *AccumulateTSRequest:
@@ -328,6 +366,10 @@ func MethodForRequest(req Request) (string, error) {
 		return Batch, nil
 	case *AdminSplitRequest:
 		return AdminSplit, nil
+	case *AdminMergeRequest:
+		return AdminMerge, nil
+	case *AdminChangeReplicasRequest:
+		return AdminChangeReplicas, nil
 	case *InternalHeartbeatTxnRequest:
 		return InternalHeartbeatTxn, nil
 	case *InternalPushTxnRequest:
@@ -336,6 +378,10 @@ func MethodForRequest(req Request) (string, error) {
 		return InternalResolveIntent, nil
 	case *InternalSnapshotCopyRequest:
 		return InternalSnapshotCopy, nil
+	case *InternalLeaderLeaseRequest:
+		return InternalLeaderLease, nil
+	case *InternalGCRequest:
+		return InternalGC, nil
 	}
 	return "", util.Errorf("unhandled request %T", req)
 }
@@ -369,6 +415,8 @@ func CreateArgs(method string) (Request, error) {
 		return &DeleteRangeRequest{}, nil
 	case Scan:
 		return &ScanRequest{}, nil
+	case ReverseScan:
+		return &ReverseScanRequest{}, nil
 	case EndTransaction:
 		return &EndTransactionRequest{}, nil
 	case AccumulateTS:
@@ -383,6 +431,10 @@ func CreateArgs(method string) (Request, error) {
 		return &BatchRequest{}, nil
 	case AdminSplit:
 		return &AdminSplitRequest{}, nil
+	case AdminMerge:
+		return &AdminMergeRequest{}, nil
+	case AdminChangeReplicas:
+		return &AdminChangeReplicasRequest{}, nil
 	case InternalHeartbeatTxn:
 		return &InternalHeartbeatTxnRequest{}, nil
 	case InternalPushTxn:
@@ -391,6 +443,10 @@ func CreateArgs(method string) (Request, error) {
 		return &InternalResolveIntentRequest{}, nil
 	case InternalSnapshotCopy:
 		return &InternalSnapshotCopyRequest{}, nil
+	case InternalLeaderLease:
+		return &InternalLeaderLeaseRequest{}, nil
+	case InternalGC:
+		return &InternalGCRequest{}, nil
 	}
 	return nil, util.Errorf("unhandled method %s", method)
 }
@@ -414,6 +470,8 @@ func CreateReply(method string) (Response, error) {
 		return &DeleteRangeResponse{}, nil
 	case Scan:
 		return &ScanResponse{}, nil
+	case ReverseScan:
+		return &ReverseScanResponse{}, nil
 	case EndTransaction:
 		return &EndTransactionResponse{}, nil
 	case AccumulateTS:
@@ -428,6 +486,10 @@ func CreateReply(method string) (Response, error) {
 		return &BatchResponse{}, nil
 	case AdminSplit:
 		return &AdminSplitResponse{}, nil
+	case AdminMerge:
+		return &AdminMergeResponse{}, nil
+	case AdminChangeReplicas:
+		return &AdminChangeReplicasResponse{}, nil
 	case InternalHeartbeatTxn:
 		return &InternalHeartbeatTxnResponse{}, nil
 	case InternalPushTxn:
@@ -436,6 +498,10 @@ func CreateReply(method string) (Response, error) {
 		return &InternalResolveIntentResponse{}, nil
 	case InternalSnapshotCopy:
 		return &InternalSnapshotCopyResponse{}, nil
+	case InternalLeaderLease:
+		return &InternalLeaderLeaseResponse{}, nil
+	case InternalGC:
+		return &InternalGCResponse{}, nil
 	}
 	return nil, util.Errorf("unhandled method %s", method)
 }
@@ -493,6 +559,17 @@ func (sr *ScanResponse) Combine(c Response) {
 	}
 }
 
+// Combine implements the Combinable interface for ReverseScanResponse.
+// The rows of c, which must have been read from keys preceding the
+// rows of sr, are appended to preserve descending order.
+func (sr *ReverseScanResponse) Combine(c Response) {
+	otherSR := c.(*ReverseScanResponse)
+	if sr != nil {
+		sr.Rows = append(sr.Rows, otherSR.GetRows()...)
+		sr.Header().Combine(otherSR.Header())
+	}
+}
+
 // Combine implements the Combinable interface for DeleteRangeResponse.
 func (dr *DeleteRangeResponse) Combine(c Response) {
 	otherDR := c.(*DeleteRangeResponse)
@@ -623,6 +700,17 @@ func (sr *ScanResponse) Verify(req Request) error {
 	return nil
 }
 
+// Verify verifies the integrity of every value returned in the
+// reverse scan.
+func (sr *ReverseScanResponse) Verify(req Request) error {
+	for _, kv := range sr.Rows {
+		if err := kv.Value.Verify(kv.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Add adds a request to the batch request. The batch inherits
 // the key range of the first request added to it.
 //
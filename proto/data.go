@@ -301,6 +301,10 @@ func NewTransaction(name string, baseKey Key, userPriority int32,
 	}
 }
 
+// MinTxnPriority is lower than any priority generated by
+// MakePriority.
+const MinTxnPriority int32 = 0
+
 // MakePriority generates a random priority value, biased by the
 // specified userPriority. If userPriority=100, the resulting
 // priority is 100x more likely to be probabilistically greater
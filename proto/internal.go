@@ -47,6 +47,14 @@ const (
 	// end key up to some maximum number of results from the given snapshot_id.
 	// It will create a snapshot if snapshot_id is empty.
 	InternalSnapshotCopy = "InternalSnapshotCopy"
+	// InternalLeaderLease requests a leader lease for a replica via
+	// Raft. The lease is granted unless another replica holds a lease
+	// which overlaps the requested lease's start.
+	InternalLeaderLease = "InternalLeaderLease"
+	// InternalGC garbage collects range-local data which has expired,
+	// such as the records of finished transactions and old response
+	// cache entries.
+	InternalGC = "InternalGC"
 )
 
 // ToValue generates a Value message which contains an encoded copy of this
@@ -76,3 +84,29 @@ func TimeSeriesFromValue(value *Value) (*TimeSeriesData, error) {
 	}
 	return &ts, nil
 }
+
+// Validate returns an error if the TimeSeriesData doesn't describe a
+// positive interval or if any of its data points falls outside of
+// the interval or doesn't hold exactly one of an integer and a
+// floating point value.
+func (ts *TimeSeriesData) Validate() error {
+	if ts.DurationInSeconds <= 0 {
+		return util.Errorf("time series duration must be positive: %d", ts.DurationInSeconds)
+	}
+	limit := ts.DurationInSeconds
+	if ts.SamplePrecision == MILLISECONDS {
+		limit *= 1000
+	}
+	for _, dp := range ts.Data {
+		if dp == nil {
+			return util.Errorf("time series contains a nil data point")
+		}
+		if dp.Offset < 0 || int64(dp.Offset) >= limit {
+			return util.Errorf("time series data point offset %d outside of interval [0, %d)", dp.Offset, limit)
+		}
+		if (dp.ValueInt == nil) == (dp.ValueFloat == nil) {
+			return util.Errorf("time series data point at offset %d must have exactly one of an integer or float value", dp.Offset)
+		}
+	}
+	return nil
+}
@@ -80,3 +80,41 @@ func TestTimeSeriesToValue(t *testing.T) {
 		t.Errorf("did not receive expected error when extracting TimeSeries from regular Byte value.")
 	}
 }
+
+func TestTimeSeriesValidate(t *testing.T) {
+	testCases := []struct {
+		ts    TimeSeriesData
+		valid bool
+	}{
+		{TimeSeriesData{DurationInSeconds: 60, Data: []*TimeSeriesDataPoint{
+			{Offset: 59999, ValueInt: gogoproto.Int64(1)},
+			{Offset: 0, ValueFloat: gogoproto.Float32(1.5)},
+		}}, true},
+		{TimeSeriesData{DurationInSeconds: 60, SamplePrecision: SECONDS, Data: []*TimeSeriesDataPoint{
+			{Offset: 59, ValueInt: gogoproto.Int64(1)},
+		}}, true},
+		// Empty or negative interval.
+		{TimeSeriesData{DurationInSeconds: 0}, false},
+		{TimeSeriesData{DurationInSeconds: -60}, false},
+		// Offsets outside of the interval.
+		{TimeSeriesData{DurationInSeconds: 60, SamplePrecision: SECONDS, Data: []*TimeSeriesDataPoint{
+			{Offset: 60, ValueInt: gogoproto.Int64(1)},
+		}}, false},
+		{TimeSeriesData{DurationInSeconds: 60, Data: []*TimeSeriesDataPoint{
+			{Offset: -1, ValueInt: gogoproto.Int64(1)},
+		}}, false},
+		// Data points with both or neither values.
+		{TimeSeriesData{DurationInSeconds: 60, Data: []*TimeSeriesDataPoint{
+			{Offset: 1},
+		}}, false},
+		{TimeSeriesData{DurationInSeconds: 60, Data: []*TimeSeriesDataPoint{
+			{Offset: 1, ValueInt: gogoproto.Int64(1), ValueFloat: gogoproto.Float32(1.5)},
+		}}, false},
+		{TimeSeriesData{DurationInSeconds: 60, Data: []*TimeSeriesDataPoint{nil}}, false},
+	}
+	for i, c := range testCases {
+		if err := c.ts.Validate(); (err == nil) != c.valid {
+			t.Errorf("%d: expected valid=%t; got error %v", i, c.valid, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package server
+
+import (
+	"strconv"
+
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/ts"
+	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metrics"
+)
+
+const (
+	// nodeMetricPrefix prefixes the names of the time series recorded
+	// for the metrics of a node. The source of each series is the
+	// node ID.
+	nodeMetricPrefix = "cr.node."
+	// storeMetricPrefix prefixes the names of the time series recorded
+	// for each store of a node. The source of each series is the store
+	// ID.
+	storeMetricPrefix = "cr.store."
+)
+
+// A metricsRecorder subscribes to the processed metrics of a metric
+// system and records them, along with the capacities of the node's
+// stores, as time series in the cluster. This keeps the operational
+// history of the node across restarts.
+type metricsRecorder struct {
+	node    *Node
+	db      *ts.DB
+	metrics *metrics.MetricSystem
+	sets    chan *metrics.ProcessedMetricSet
+}
+
+// newMetricsRecorder returns a recorder which records the metrics of
+// ms for the node via db.
+func newMetricsRecorder(node *Node, db *ts.DB, ms *metrics.MetricSystem) *metricsRecorder {
+	return &metricsRecorder{
+		node:    node,
+		db:      db,
+		metrics: ms,
+		// The metric system drops metrics rather than block on a full
+		// channel, so leave room for a slow write.
+		sets: make(chan *metrics.ProcessedMetricSet, 4),
+	}
+}
+
+// start subscribes to the processed metrics and records each metric
+// set as it arrives. Loops until the node is stopped and should be
+// invoked via goroutine.
+func (mr *metricsRecorder) start() {
+	mr.metrics.SubscribeToProcessedMetrics(mr.sets)
+	for {
+		select {
+		case set, ok := <-mr.sets:
+			if !ok {
+				// The metric system gave up on this subscriber.
+				return
+			}
+			if err := mr.record(set); err != nil {
+				log.Warningf("unable to record metrics at %s: %s", set.Time, err)
+			}
+		case <-mr.node.closer:
+			mr.metrics.UnsubscribeFromProcessedMetrics(mr.sets)
+			return
+		}
+	}
+}
+
+// record stores each metric of the set under a per-node time series
+// and the capacity of each of the node's stores under per-store time
+// series, all at the time of the set. Nothing is recorded until the
+// node has been assigned its ID.
+func (mr *metricsRecorder) record(set *metrics.ProcessedMetricSet) error {
+	nodeID := mr.node.Descriptor.NodeID
+	if nodeID == 0 {
+		return nil
+	}
+	timestamp := set.Time.UnixNano()
+	source := strconv.FormatInt(int64(nodeID), 10)
+	for name, value := range set.Metrics {
+		if err := mr.db.StoreData(ts.Resolution10s, nodeMetricPrefix+name, source,
+			[]ts.Datapoint{{Timestamp: timestamp, Value: value}}); err != nil {
+			return err
+		}
+	}
+
+	// Collect the store capacities before writing any of them, as
+	// writes may be routed back to the local sender while it's locked
+	// for the visit.
+	storeMetrics := map[string]map[string]float64{}
+	if err := mr.node.lSender.VisitStores(func(s *storage.Store) error {
+		capacity, err := s.Capacity()
+		if err != nil {
+			return err
+		}
+		storeMetrics[strconv.FormatInt(int64(s.Ident.StoreID), 10)] = map[string]float64{
+			"capacity":  float64(capacity.Capacity),
+			"available": float64(capacity.Available),
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for source, values := range storeMetrics {
+		for name, value := range values {
+			if err := mr.db.StoreData(ts.Resolution10s, storeMetricPrefix+name, source,
+				[]ts.Datapoint{{Timestamp: timestamp, Value: value}}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
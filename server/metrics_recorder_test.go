@@ -0,0 +1,73 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/ts"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/metrics"
+)
+
+// TestMetricsRecorder verifies that the metrics of a metric system
+// and the capacities of the node's stores are recorded as time series
+// which can be queried from the cluster.
+func TestMetricsRecorder(t *testing.T) {
+	s := StartTestServer(t)
+	defer s.Stop()
+
+	ms := metrics.NewMetricSystem(100*time.Millisecond, false)
+	ms.RegisterGaugeFunc("test.gauge", func() float64 { return 7 })
+	ms.Start()
+	defer ms.Stop()
+	go newMetricsRecorder(s.node, s.tsDB, ms).start()
+
+	now := time.Now().UnixNano()
+	query := func(name string) []ts.Datapoint {
+		points, err := s.tsDB.Query(ts.Query{
+			Name:       name,
+			Sources:    []string{"1"},
+			Start:      now - int64(time.Hour),
+			End:        now + int64(time.Hour),
+			Resolution: ts.Resolution10s,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return points
+	}
+	if err := util.IsTrueWithin(func() bool {
+		return len(query(nodeMetricPrefix+"test.gauge")) > 0
+	}, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, dp := range query(nodeMetricPrefix + "test.gauge") {
+		if dp.Value != 7 {
+			t.Errorf("expected gauge value 7; got %v", dp)
+		}
+	}
+
+	// Store capacities are recorded per store; the test server's only
+	// store has ID 1.
+	if err := util.IsTrueWithin(func() bool {
+		points := query(storeMetricPrefix + "capacity")
+		return len(points) > 0 && points[0].Value > 0
+	}, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/gossip"
 	"github.com/cockroachdb/cockroach/kv"
+	"github.com/cockroachdb/cockroach/multiraft"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/rpc"
 	"github.com/cockroachdb/cockroach/storage"
@@ -62,6 +63,7 @@ type Node struct {
 	gossip     *gossip.Gossip         // Nodes gossip cluster ID, node ID -> host:port
 	db         *client.KV             // KV DB client; used to access global id generators
 	lSender    *kv.LocalSender        // Local KV sender for access to node-local stores
+	transport  multiraft.Transport    // Raft transport shared by node-local stores
 	closer     chan struct{}
 
 	maxAvailPrefix string // Prefix for max avail capacity gossip topic
@@ -116,7 +118,7 @@ func BootstrapCluster(clusterID string, eng engine.Engine) (*client.KV, error) {
 	// Create a KV DB with a local sender.
 	lSender := kv.NewLocalSender()
 	localDB := client.NewKV(kv.NewTxnCoordSender(lSender, clock), nil)
-	s := storage.NewStore(clock, eng, localDB, nil)
+	s := storage.NewStore(clock, eng, localDB, nil, multiraft.NewLocalRPCTransport())
 
 	// Verify the store isn't already part of a cluster.
 	if len(s.Ident.ClusterID) > 0 {
@@ -184,6 +186,11 @@ func (n *Node) start(rpcServer *rpc.Server, clock *hlc.Clock,
 	if err := rpcServer.RegisterName("Node", n); err != nil {
 		log.Fatalf("unable to register node service with RPC server: %s", err)
 	}
+	transport, err := newRPCTransport(n.gossip, rpcServer)
+	if err != nil {
+		log.Fatalf("unable to register raft service with RPC server: %s", err)
+	}
+	n.transport = transport
 
 	// Initialize stores, including bootstrapping new ones.
 	if err := n.initStores(clock, engines); err != nil {
@@ -209,7 +216,7 @@ func (n *Node) initStores(clock *hlc.Clock, engines []engine.Engine) error {
 	bootstraps := list.New()
 
 	for _, e := range engines {
-		s := storage.NewStore(clock, e, n.db, n.gossip)
+		s := storage.NewStore(clock, e, n.db, n.gossip, n.transport)
 		// Initialize each store in turn, handling un-bootstrapped errors by
 		// adding the store to the bootstraps list.
 		if err := s.Start(); err != nil {
@@ -307,6 +314,7 @@ func (n *Node) bootstrapStores(bootstraps *list.List) {
 		s := e.Value.(*storage.Store)
 		s.Bootstrap(sIdent)
 		n.lSender.AddStore(s)
+		n.gossipStoreAddr(sIdent.StoreID)
 		sIdent.StoreID++
 		log.Infof("bootstrapped store %s", s)
 	}
@@ -334,12 +342,25 @@ func (n *Node) connectGossip() {
 	}
 	log.Infof("node connected via gossip and verified as part of cluster %q", gossipClusterID)
 
-	// Gossip node address keyed by node ID.
+	// Gossip node address keyed by node ID and by the IDs of its stores.
 	if n.Descriptor.NodeID != 0 {
 		nodeIDKey := gossip.MakeNodeIDGossipKey(n.Descriptor.NodeID)
 		if err := n.gossip.AddInfo(nodeIDKey, n.Descriptor.Address, ttlNodeIDGossip); err != nil {
 			log.Errorf("couldn't gossip address for node %d: %v", n.Descriptor.NodeID, err)
 		}
+		n.lSender.VisitStores(func(s *storage.Store) error {
+			n.gossipStoreAddr(s.StoreID())
+			return nil
+		})
+	}
+}
+
+// gossipStoreAddr gossips the node's address keyed by the store ID,
+// so that raft transports can reach the store's replicas.
+func (n *Node) gossipStoreAddr(storeID int32) {
+	storeIDKey := gossip.MakeStoreIDGossipKey(storeID)
+	if err := n.gossip.AddInfo(storeIDKey, n.Descriptor.Address, ttlNodeIDGossip); err != nil {
+		log.Errorf("couldn't gossip address for store %d: %v", storeID, err)
 	}
 }
 
@@ -431,6 +452,11 @@ func (n *Node) Scan(args *proto.ScanRequest, reply *proto.ScanResponse) error {
 	return n.executeCmd(proto.Scan, args, reply)
 }
 
+// ReverseScan .
+func (n *Node) ReverseScan(args *proto.ReverseScanRequest, reply *proto.ReverseScanResponse) error {
+	return n.executeCmd(proto.ReverseScan, args, reply)
+}
+
 // EndTransaction .
 func (n *Node) EndTransaction(args *proto.EndTransactionRequest, reply *proto.EndTransactionResponse) error {
 	return n.executeCmd(proto.EndTransaction, args, reply)
@@ -461,6 +487,16 @@ func (n *Node) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSplit
 	return n.executeCmd(proto.AdminSplit, args, reply)
 }
 
+// AdminMerge .
+func (n *Node) AdminMerge(args *proto.AdminMergeRequest, reply *proto.AdminMergeResponse) error {
+	return n.executeCmd(proto.AdminMerge, args, reply)
+}
+
+// AdminChangeReplicas .
+func (n *Node) AdminChangeReplicas(args *proto.AdminChangeReplicasRequest, reply *proto.AdminChangeReplicasResponse) error {
+	return n.executeCmd(proto.AdminChangeReplicas, args, reply)
+}
+
 // InternalRangeLookup .
 func (n *Node) InternalRangeLookup(args *proto.InternalRangeLookupRequest, reply *proto.InternalRangeLookupResponse) error {
 	return n.executeCmd(proto.InternalRangeLookup, args, reply)
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"math"
 	"net"
+	netrpc "net/rpc"
 	"reflect"
 	"testing"
 	"time"
@@ -29,12 +30,14 @@ import (
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/gossip"
 	"github.com/cockroachdb/cockroach/kv"
+	"github.com/cockroachdb/cockroach/multiraft"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/rpc"
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/hlc"
+	"github.com/coreos/etcd/raft/raftpb"
 )
 
 // createTestNode creates an rpc server using the specified address,
@@ -197,3 +200,59 @@ func TestNodeJoin(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// raftServerFunc adapts a function to the multiraft.ServerInterface.
+type raftServerFunc func(name string, req, resp interface{}) error
+
+func (f raftServerFunc) DoRPC(name string, req, resp interface{}) error {
+	return f(name, req, resp)
+}
+
+// TestRaftTransport verifies raft messages are delivered to stores on
+// other nodes via the nodes' RPC servers, which are found via gossip.
+func TestRaftTransport(t *testing.T) {
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	db, err := BootstrapCluster("cluster-1", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	*gossip.GossipInterval = 10 * time.Millisecond
+	addr1 := util.CreateTestAddr("tcp")
+	server1, node1 := createTestNode(addr1, []engine.Engine{e}, addr1, t)
+	defer server1.Close()
+	server2, node2 := createTestNode(util.CreateTestAddr("tcp"),
+		[]engine.Engine{engine.NewInMem(proto.Attributes{}, 1<<20)}, server1.Addr(), t)
+	defer server2.Close()
+
+	// Listen on node2 with a raft node ID which isn't a real store.
+	const raftID = 100
+	received := make(chan *multiraft.SendMessageRequest, 1)
+	node2.transport.Listen(raftID, raftServerFunc(func(name string, req, resp interface{}) error {
+		received <- req.(*multiraft.SendMessageRequest)
+		return nil
+	}))
+	node2.gossipStoreAddr(raftID)
+
+	client, err := node1.transport.Connect(raftID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &multiraft.SendMessageRequest{
+		GroupID: 1,
+		Message: raftpb.Message{Type: raftpb.MsgHeartbeat, To: raftID, From: 1, Term: 2},
+	}
+	// The message fails until node1 learns node2's address via gossip
+	// and connects to it.
+	if err := util.IsTrueWithin(func() bool {
+		call := <-client.Go(multiraft.SendMessageName, req, &multiraft.SendMessageResponse{}, make(chan *netrpc.Call, 1)).Done
+		return call.Error == nil
+	}, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got.GroupID != req.GroupID || got.Message.Type != req.Message.Type ||
+		got.Message.From != req.Message.From || got.Message.Term != req.Message.Term {
+		t.Errorf("expected %+v; got %+v", req, got)
+	}
+}
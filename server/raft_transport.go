@@ -0,0 +1,158 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package server
+
+import (
+	"net"
+	netrpc "net/rpc"
+	"sync"
+
+	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/multiraft"
+	"github.com/cockroachdb/cockroach/rpc"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// rpcTransport is a multiraft.Transport which carries raft messages
+// between the stores of different nodes via the nodes' RPC servers.
+// Raft node IDs are store IDs; the address of the node hosting a store
+// is looked up via gossip. Messages between stores of the same node
+// are delivered directly.
+type rpcTransport struct {
+	gossip *gossip.Gossip
+
+	mu      sync.Mutex                           // Protects servers
+	servers map[uint64]multiraft.ServerInterface // Local servers by raft node ID
+}
+
+// newRPCTransport returns a raft transport which registers the
+// "MultiRaft" service with the RPC server to receive raft messages
+// for the node's stores.
+func newRPCTransport(g *gossip.Gossip, rpcServer *rpc.Server) (*rpcTransport, error) {
+	t := &rpcTransport{
+		gossip:  g,
+		servers: map[uint64]multiraft.ServerInterface{},
+	}
+	if err := rpcServer.RegisterName("MultiRaft", &raftService{t}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Listen implements the multiraft.Transport interface.
+func (t *rpcTransport) Listen(id uint64, server multiraft.ServerInterface) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.servers[id] = server
+	return nil
+}
+
+// Stop implements the multiraft.Transport interface.
+func (t *rpcTransport) Stop(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.servers, id)
+}
+
+// Connect implements the multiraft.Transport interface. The address
+// of the peer's node is resolved on each message, so peers whose
+// address hasn't been gossiped yet, or whose node has moved, can
+// still be reached once gossip catches up.
+func (t *rpcTransport) Connect(id uint64) (multiraft.ClientInterface, error) {
+	return &rpcTransportClient{transport: t, id: id}, nil
+}
+
+// getServer returns the server of the local store with the given raft
+// node ID, if any.
+func (t *rpcTransport) getServer(id uint64) (multiraft.ServerInterface, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	server, ok := t.servers[id]
+	return server, ok
+}
+
+// getClient returns a connected RPC client to the node hosting the
+// store with the given raft node ID.
+func (t *rpcTransport) getClient(id uint64) (*rpc.Client, error) {
+	info, err := t.gossip.GetInfo(gossip.MakeStoreIDGossipKey(int32(id)))
+	if info == nil || err != nil {
+		return nil, util.Errorf("unable to look up address for store %d: %v", id, err)
+	}
+	client := rpc.NewClient(info.(net.Addr), nil, t.gossip.RPCContext)
+	select {
+	case <-client.Ready:
+		return client, nil
+	case <-client.Closed:
+		return nil, util.Errorf("connection to store %d at %s closed", id, client.Addr())
+	default:
+		return nil, util.Errorf("not yet connected to store %d at %s", id, client.Addr())
+	}
+}
+
+// raftService receives the raft messages sent to the node's stores
+// via the RPC server and hands them to the addressed store.
+type raftService struct {
+	transport *rpcTransport
+}
+
+// SendMessage implements the multiraft.RPCInterface interface.
+func (s *raftService) SendMessage(req *multiraft.SendMessageRequest, resp *multiraft.SendMessageResponse) error {
+	server, ok := s.transport.getServer(req.Message.To)
+	if !ok {
+		return util.Errorf("store %d not found on this node", req.Message.To)
+	}
+	return server.DoRPC(multiraft.SendMessageName, req, resp)
+}
+
+// rpcTransportClient is the multiraft.ClientInterface returned by
+// rpcTransport.Connect.
+type rpcTransportClient struct {
+	transport *rpcTransport
+	id        uint64
+}
+
+// Go implements the multiraft.ClientInterface interface. Raft messages
+// may be lost, so a message which can't be sent because the peer's
+// node is unknown or unreachable simply fails; raft resends as needed.
+func (c *rpcTransportClient) Go(serviceMethod string, args interface{}, reply interface{},
+	done chan *netrpc.Call) *netrpc.Call {
+	if server, ok := c.transport.getServer(c.id); ok {
+		call := &netrpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+		go func() {
+			call.Error = server.DoRPC(serviceMethod, args, reply)
+			call.Done <- call
+		}()
+		return call
+	}
+	client, err := c.transport.getClient(c.id)
+	if err != nil {
+		call := &netrpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done, Error: err}
+		// As with net/rpc, never block on a full done channel.
+		select {
+		case call.Done <- call:
+		default:
+		}
+		return call
+	}
+	return client.Go(serviceMethod, args, reply, done)
+}
+
+// Close implements the multiraft.ClientInterface interface. RPC
+// clients are shared by all users of a node's address and are not
+// closed.
+func (c *rpcTransportClient) Close() error {
+	return nil
+}
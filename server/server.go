@@ -43,9 +43,11 @@ import (
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/structured"
+	"github.com/cockroachdb/cockroach/ts"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metrics"
 )
 
 var (
@@ -107,7 +109,8 @@ A node exports an HTTP API with the following endpoints:
 
   Health check:           /healthz
   Key-value REST:         ` + kv.RESTPrefix + `
-  Structured Schema REST: ` + structured.StructuredKeyPrefix
+  Structured Schema REST: ` + structured.StructuredKeyPrefix + `
+  Time series query:      ` + ts.QueryPrefix
 
 // A CmdInit command initializes a new Cockroach cluster.
 var CmdInit = &commander.Command{
@@ -193,6 +196,9 @@ type server struct {
 	status         *statusServer
 	structuredDB   structured.DB
 	structuredREST *structured.RESTServer
+	tsDB           *ts.DB
+	tsServer       *ts.Server
+	recorder       *metricsRecorder
 	httpListener   *net.Listener // holds http endpoint information
 }
 
@@ -340,9 +346,11 @@ func newServer(rpcAddr, certDir string, maxOffset time.Duration) (*server, error
 	s.kvREST = kv.NewRESTServer(s.kv)
 	s.node = NewNode(s.kv, s.gossip)
 	s.admin = newAdminServer(s.kv)
-	s.status = newStatusServer(s.kv, s.gossip)
+	s.status = newStatusServer(s.kv, s.gossip, s.node.lSender)
 	s.structuredDB = structured.NewDB(s.kv)
 	s.structuredREST = structured.NewRESTServer(s.structuredDB)
+	s.tsDB = ts.NewDB(s.kv)
+	s.tsServer = ts.NewServer(s.tsDB)
 
 	return s, nil
 }
@@ -370,6 +378,11 @@ func (s *server) start(engines []engine.Engine, attrs, httpAddr string, selfBoot
 		return err
 	}
 
+	// Record the node's metrics as time series in the cluster.
+	metrics.Metrics.Start()
+	s.recorder = newMetricsRecorder(s.node, s.tsDB, metrics.Metrics)
+	go s.recorder.start()
+
 	// TODO(spencer): add tls to the HTTP server.
 	s.initHTTP()
 	if strings.HasPrefix(httpAddr, ":") {
@@ -399,6 +412,7 @@ func (s *server) initHTTP() {
 	s.mux.Handle(kv.RESTPrefix, s.kvREST)
 	s.mux.Handle(kv.DBPrefix, s.kvDB)
 	s.mux.Handle(structured.StructuredKeyPrefix, s.structuredREST)
+	s.mux.Handle(ts.QueryPrefix, s.tsServer)
 }
 
 func (s *server) stop() {
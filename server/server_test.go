@@ -317,3 +317,107 @@ func TestMultiRangeScanDeleteRange(t *testing.T) {
 		t.Fatalf("scan after delete returned rows: %v", rows)
 	}
 }
+
+// splitAndWriteMultipleRanges splits the first range at several keys
+// and writes values to keys on either side of the splits. It returns
+// the written keys in ascending order.
+func splitAndWriteMultipleRanges(t *testing.T, ts *TestServer, ds *kv.DistSender) []proto.Key {
+	splitKeys := []proto.Key{proto.Key("c"), proto.Key("f"), proto.Key("m"), proto.Key("t")}
+	for _, splitKey := range splitKeys {
+		if err := ts.node.db.Call(proto.AdminSplit,
+			&proto.AdminSplitRequest{
+				RequestHeader: proto.RequestHeader{
+					Key: splitKey,
+				},
+				SplitKey: splitKey,
+			}, &proto.AdminSplitResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writes := []proto.Key{proto.Key("a"), proto.Key("b"), proto.Key("d"), proto.Key("g"),
+		proto.Key("n"), proto.Key("u"), proto.Key("v")}
+	for _, k := range writes {
+		call := &client.Call{
+			Method: proto.Put,
+			Args:   proto.PutArgs(k, k),
+			Reply:  &proto.PutResponse{},
+		}
+		call.Args.Header().User = storage.UserRoot
+		ds.Send(call)
+		if err := call.Reply.Header().GoError(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return writes
+}
+
+// TestMultiRangeScanWithMaxResults verifies that a scan spanning
+// several ranges returns rows in key order, truncated to MaxResults.
+func TestMultiRangeScanWithMaxResults(t *testing.T) {
+	ts := StartTestServer(t)
+	defer ts.Stop()
+	ds := kv.NewDistSender(ts.Gossip())
+	writes := splitAndWriteMultipleRanges(t, ts, ds)
+
+	for _, maxResults := range []int64{0, 1, 2, 3, 5, 7, 10} {
+		scan := &client.Call{
+			Method: proto.Scan,
+			Args:   proto.ScanArgs(writes[0], writes[len(writes)-1].Next(), maxResults),
+			Reply:  &proto.ScanResponse{},
+		}
+		scan.Args.Header().User = storage.UserRoot
+		ds.Send(scan)
+		if err := scan.Reply.Header().GoError(); err != nil {
+			t.Fatal(err)
+		}
+		expCount := int64(len(writes))
+		if maxResults > 0 && maxResults < expCount {
+			expCount = maxResults
+		}
+		rows := scan.Reply.(*proto.ScanResponse).Rows
+		if int64(len(rows)) != expCount {
+			t.Fatalf("%d: expected %d rows; got %d", maxResults, expCount, len(rows))
+		}
+		for i, row := range rows {
+			if !row.Key.Equal(writes[i]) {
+				t.Errorf("%d: expected row %d to have key %q; got %q", maxResults, i, writes[i], row.Key)
+			}
+		}
+	}
+}
+
+// TestMultiRangeReverseScanWithMaxResults verifies that a reverse scan
+// spanning several ranges returns rows in descending key order,
+// truncated to MaxResults.
+func TestMultiRangeReverseScanWithMaxResults(t *testing.T) {
+	ts := StartTestServer(t)
+	defer ts.Stop()
+	ds := kv.NewDistSender(ts.Gossip())
+	writes := splitAndWriteMultipleRanges(t, ts, ds)
+
+	for _, maxResults := range []int64{0, 1, 2, 3, 5, 7, 10} {
+		scan := &client.Call{
+			Method: proto.ReverseScan,
+			Args:   proto.ReverseScanArgs(writes[0], writes[len(writes)-1].Next(), maxResults),
+			Reply:  &proto.ReverseScanResponse{},
+		}
+		scan.Args.Header().User = storage.UserRoot
+		ds.Send(scan)
+		if err := scan.Reply.Header().GoError(); err != nil {
+			t.Fatal(err)
+		}
+		expCount := int64(len(writes))
+		if maxResults > 0 && maxResults < expCount {
+			expCount = maxResults
+		}
+		rows := scan.Reply.(*proto.ReverseScanResponse).Rows
+		if int64(len(rows)) != expCount {
+			t.Fatalf("%d: expected %d rows; got %d", maxResults, expCount, len(rows))
+		}
+		for i, row := range rows {
+			if expKey := writes[len(writes)-1-i]; !row.Key.Equal(expKey) {
+				t.Errorf("%d: expected row %d to have key %q; got %q", maxResults, i, expKey, row.Key)
+			}
+		}
+	}
+}
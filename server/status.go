@@ -24,7 +24,9 @@ import (
 
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/kv"
 	"github.com/cockroachdb/cockroach/server/status"
+	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
@@ -62,13 +64,15 @@ const (
 type statusServer struct {
 	db     *client.KV
 	gossip *gossip.Gossip
+	stores *kv.LocalSender // The node's local stores
 }
 
 // newStatusServer allocates and returns a statusServer.
-func newStatusServer(db *client.KV, gossip *gossip.Gossip) *statusServer {
+func newStatusServer(db *client.KV, gossip *gossip.Gossip, stores *kv.LocalSender) *statusServer {
 	return &statusServer{
 		db:     db,
 		gossip: gossip,
+		stores: stores,
 	}
 }
 
@@ -154,11 +158,49 @@ func (s *statusServer) handleNodeStatus(w http.ResponseWriter, r *http.Request)
 	w.Write(b)
 }
 
-// handleStoresStatus handles GET requests for store status.
+// handleStoresStatus handles GET requests for store status. The
+// status of each of the node's local stores is returned.
 func (s *statusServer) handleStoresStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	w.Write([]byte(`{"stores": []}`))
+	stores := &status.StoreList{Stores: []status.StoreSummary{}}
+	if s.stores != nil {
+		if err := s.stores.VisitStores(func(store *storage.Store) error {
+			summary := status.StoreSummary{
+				ID:               store.StoreID(),
+				UpdateQueueDepth: store.UpdateQueueDepth(),
+				Ranges:           []status.RangeSummary{},
+			}
+			if err := store.VisitRanges(func(rng *storage.Range) error {
+				gcMeta, err := rng.GetGCMetadata()
+				if err != nil {
+					return err
+				}
+				summary.Ranges = append(summary.Ranges, status.RangeSummary{
+					ID:          rng.RangeID,
+					LastGCNanos: gcMeta.LastScanNanos,
+					GCBytesAge:  gcMeta.GCBytesAge,
+				})
+				return nil
+			}); err != nil {
+				return err
+			}
+			stores.Stores = append(stores.Stores, summary)
+			return nil
+		}); err != nil {
+			log.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	b, err := json.Marshal(stores)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(b)
 }
 
 // handleTransactionStatus handles GET requests for transaction status.
@@ -34,3 +34,30 @@ type NodeSummary struct {
 
 // Node represents an individual node within the cluster.
 type Node struct{}
+
+// StoreList contains a slice of summaries for each Store.
+type StoreList struct {
+	Stores []StoreSummary `json:"stores"`
+}
+
+// A StoreSummary contains a summary for a particular store.
+type StoreSummary struct {
+	ID int32 `json:"id"`
+	// UpdateQueueDepth is the number of updates enqueued on the
+	// store's ranges which have yet to be applied.
+	UpdateQueueDepth int64 `json:"updateQueueDepth"`
+	// Ranges summarizes each of the store's ranges.
+	Ranges []RangeSummary `json:"ranges"`
+}
+
+// A RangeSummary contains a summary for a particular range.
+type RangeSummary struct {
+	ID int64 `json:"id"`
+	// LastGCNanos is the wall time of the range's last garbage
+	// collection, in nanoseconds since the unix epoch. Zero if the
+	// range hasn't been garbage collected.
+	LastGCNanos int64 `json:"lastGCNanos"`
+	// GCBytesAge is the age-weighted size of the garbage left in the
+	// range as of its last garbage collection.
+	GCBytesAge int64 `json:"gcBytesAge"`
+}
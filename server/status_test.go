@@ -37,7 +37,7 @@ func startStatusServer() *httptest.Server {
 	if err != nil {
 		log.Fatal(err)
 	}
-	status := newStatusServer(db, nil)
+	status := newStatusServer(db, nil, nil)
 	mux := http.NewServeMux()
 	status.RegisterHandlers(mux)
 	httpServer := httptest.NewServer(mux)
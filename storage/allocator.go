@@ -25,6 +25,12 @@ import (
 	"github.com/cockroachdb/cockroach/util"
 )
 
+// rebalanceThreshold is the amount by which the fraction of a store's
+// capacity which is available must fall below (or exceed) the mean
+// across all stores for the store to be considered over-utilized (or
+// under-utilized) for the purposes of rebalancing.
+const rebalanceThreshold = 0.05
+
 // allocator makes allocation decisions based on a zone configuration,
 // existing range metadata and available stores. Configuration
 // settings and range metadata information is stored directly in the
@@ -76,3 +82,79 @@ func (a *allocator) allocate(required proto.Attributes, existingReplicas []proto
 	}
 	return nil, util.Errorf("unable to find an appropriate store for requested replica attributes")
 }
+
+// meanAvailable returns the mean fraction of capacity available
+// across all stores, and the stores keyed by store ID.
+func (a *allocator) meanAvailable() (float64, map[int32]*StoreDescriptor, error) {
+	stores, err := a.storeFinder(proto.Attributes{})
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(stores) == 0 {
+		return 0, nil, util.Errorf("no stores available for rebalancing")
+	}
+	storeMap := make(map[int32]*StoreDescriptor, len(stores))
+	var total float64
+	for _, s := range stores {
+		storeMap[s.StoreID] = s
+		total += s.Capacity.PercentAvail()
+	}
+	return total / float64(len(stores)), storeMap, nil
+}
+
+// rebalanceSource returns the replica from existingReplicas which
+// resides on the most over-utilized store, or nil if none of the
+// replicas' stores is over-utilized relative to the mean across all
+// stores.
+func (a *allocator) rebalanceSource(existingReplicas []proto.Replica) (*proto.Replica, error) {
+	mean, stores, err := a.meanAvailable()
+	if err != nil {
+		return nil, err
+	}
+	var source *proto.Replica
+	var sourceAvail float64
+	for i := range existingReplicas {
+		s, ok := stores[existingReplicas[i].StoreID]
+		if !ok {
+			continue
+		}
+		avail := s.Capacity.PercentAvail()
+		if avail < mean-rebalanceThreshold && (source == nil || avail < sourceAvail) {
+			source = &existingReplicas[i]
+			sourceAvail = avail
+		}
+	}
+	return source, nil
+}
+
+// rebalanceTarget returns the under-utilized store with the most
+// available capacity relative to the mean across all stores which
+// matches the required attributes and does not reside on a node
+// holding one of existingReplicas. Returns nil if there is no such
+// store.
+func (a *allocator) rebalanceTarget(required proto.Attributes, existingReplicas []proto.Replica) (
+	*StoreDescriptor, error) {
+	mean, _, err := a.meanAvailable()
+	if err != nil {
+		return nil, err
+	}
+	usedNodes := make(map[int32]struct{})
+	for _, replica := range existingReplicas {
+		usedNodes[replica.NodeID] = struct{}{}
+	}
+	stores, err := a.storeFinder(required)
+	if err != nil {
+		return nil, err
+	}
+	var target *StoreDescriptor
+	for _, s := range stores {
+		if _, ok := usedNodes[s.Node.NodeID]; ok {
+			continue
+		}
+		avail := s.Capacity.PercentAvail()
+		if avail > mean+rebalanceThreshold && (target == nil || avail > target.Capacity.PercentAvail()) {
+			target = s
+		}
+	}
+	return target, nil
+}
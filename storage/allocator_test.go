@@ -288,3 +288,78 @@ func TestExistingReplica(t *testing.T) {
 		t.Errorf("expected result to have node 3 and store 4: %+v", result)
 	}
 }
+
+var unbalancedStores = func(a proto.Attributes) ([]*StoreDescriptor, error) {
+	var stores []*StoreDescriptor
+	for i, avail := range []int64{10, 50, 60, 90} {
+		stores = append(stores, &StoreDescriptor{
+			StoreID: int32(i + 1),
+			Attrs:   proto.Attributes{Attrs: []string{"ssd"}},
+			Node: NodeDescriptor{
+				NodeID: int32(i + 1),
+				Attrs:  proto.Attributes{Attrs: []string{"a"}},
+			},
+			Capacity: engine.StoreCapacity{
+				Capacity:  100,
+				Available: avail,
+			},
+		})
+	}
+	return filterStores(a, stores)
+}
+
+func TestRebalanceSource(t *testing.T) {
+	var a = allocator{
+		storeFinder: unbalancedStores,
+		rand:        *rand.New(rand.NewSource(0)),
+	}
+	source, err := a.rebalanceSource([]proto.Replica{{NodeID: 1, StoreID: 1}, {NodeID: 3, StoreID: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source == nil || source.StoreID != 1 {
+		t.Errorf("expected replica on store 1 as rebalance source: %+v", source)
+	}
+	// Stores 2 and 3 are within the threshold of the mean.
+	source, err = a.rebalanceSource([]proto.Replica{{NodeID: 2, StoreID: 2}, {NodeID: 3, StoreID: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != nil {
+		t.Errorf("expected no rebalance source: %+v", source)
+	}
+	// Balanced stores never yield a source.
+	a.storeFinder = sameDCStores
+	source, err = a.rebalanceSource([]proto.Replica{{NodeID: 1, StoreID: 1}})
+	if err != nil || source != nil {
+		t.Errorf("expected no rebalance source: %+v, %v", source, err)
+	}
+}
+
+func TestRebalanceTarget(t *testing.T) {
+	var a = allocator{
+		storeFinder: unbalancedStores,
+		rand:        *rand.New(rand.NewSource(0)),
+	}
+	required := proto.Attributes{Attrs: []string{"a", "ssd"}}
+	target, err := a.rebalanceTarget(required, []proto.Replica{{NodeID: 1, StoreID: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target == nil || target.StoreID != 4 {
+		t.Errorf("expected store 4 as rebalance target: %+v", target)
+	}
+	// The under-utilized stores already hold replicas.
+	target, err = a.rebalanceTarget(required, []proto.Replica{{NodeID: 3, StoreID: 3}, {NodeID: 4, StoreID: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != nil {
+		t.Errorf("expected no rebalance target: %+v", target)
+	}
+	// No store matches the required attributes.
+	target, err = a.rebalanceTarget(proto.Attributes{Attrs: []string{"hdd"}}, nil)
+	if err != nil || target != nil {
+		t.Errorf("expected no rebalance target: %+v, %v", target, err)
+	}
+}
@@ -68,3 +68,21 @@ func UpdateRangeAddressing(db *client.KV, desc *proto.RangeDescriptor) error {
 	}
 	return nil
 }
+
+// RemoveRangeAddressing removes the range addressing record for the
+// range specified by desc, which is keyed by the range's end key. It's
+// used when a range's end key changes, as on a merge with the range
+// which follows it. Records keyed by KeyMax are left in place, as
+// UpdateRangeAddressing always replaces them.
+func RemoveRangeAddressing(db *client.KV, desc *proto.RangeDescriptor) error {
+	if bytes.Equal(desc.EndKey, engine.KeyMax) {
+		return nil
+	}
+	if bytes.HasPrefix(desc.EndKey, engine.KeyMeta1Prefix) {
+		return util.Errorf("meta1 addressing records cannot be merged: %+v", desc)
+	}
+	db.Prepare(proto.Delete, &proto.DeleteRequest{
+		RequestHeader: proto.RequestHeader{Key: engine.RangeMetaLookupKey(desc)},
+	}, &proto.DeleteResponse{})
+	return nil
+}
@@ -146,6 +146,67 @@ func (b *Batch) Iterate(start, end proto.EncodedKey, f func(proto.RawKeyValue) (
 	return nil
 }
 
+// ReverseIterate invokes f on key/value pairs merged from the
+// underlying engine and pending batch updates, in descending key
+// order. If f returns done or an error, the iteration ends and
+// propagates the error.
+func (b *Batch) ReverseIterate(start, end proto.EncodedKey, f func(proto.RawKeyValue) (bool, error)) error {
+	last := end
+	var done bool
+	if err := b.engine.ReverseIterate(start, end, func(kv proto.RawKeyValue) (bool, error) {
+		// Merge iteration from updates tree following each key/value.
+		var err error
+		done, err = b.reverseIterateUpdates(proto.EncodedKey(proto.Key(kv.Key).Next()), last, f)
+		last = kv.Key
+		if !done && err == nil {
+			val := b.updates.Get(proto.RawKeyValue{Key: kv.Key})
+			if val != nil {
+				switch t := val.(type) {
+				case BatchDelete:
+				case BatchPut:
+					done, err = f(t.RawKeyValue)
+				case BatchMerge:
+					mergedKV := proto.RawKeyValue{Key: t.Key}
+					mergedKV.Value, err = goMerge(kv.Value, t.Value)
+					if err == nil {
+						done, err = f(mergedKV)
+					}
+				}
+			} else {
+				done, err = f(kv)
+			}
+		}
+		return done, err
+	}); err != nil || done {
+		return err
+	}
+	// Final iteration from updates tree.
+	_, err := b.reverseIterateUpdates(start, last, f)
+	return err
+}
+
+// reverseIterateUpdates scans the updates tree from end to start,
+// invoking f on each value until f returns done or an error.
+func (b *Batch) reverseIterateUpdates(start, end proto.EncodedKey, f func(proto.RawKeyValue) (bool, error)) (bool, error) {
+	var done bool
+	var err error
+	reverseDoRange(&b.updates, func(n llrb.Comparable) bool {
+		switch t := n.(type) {
+		case BatchDelete: // On delete, skip.
+		case BatchPut: // On put, override the corresponding engine entry.
+			done, err = f(t.RawKeyValue)
+		case BatchMerge: // On merge, merge with corresponding engine entry.
+			kv := proto.RawKeyValue{Key: t.Key}
+			kv.Value, err = goMerge([]byte(nil), t.Value)
+			if err == nil {
+				done, err = f(kv)
+			}
+		}
+		return done || err != nil
+	}, start, end)
+	return done, err
+}
+
 // Scan scans from both the updates tree and the underlying engine
 // and combines the results, up to max.
 func (b *Batch) Scan(start, end proto.EncodedKey, max int64) ([]proto.RawKeyValue, error) {
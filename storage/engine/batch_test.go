@@ -185,6 +185,39 @@ func TestBatchMerge(t *testing.T) {
 	}
 }
 
+// TestBatchMergeTimeSeries verifies that time series merged within a
+// batch are combined with each other and with the time series in the
+// underlying engine.
+func TestBatchMergeTimeSeries(t *testing.T) {
+	e := NewInMem(proto.Attributes{}, 1<<20)
+	if err := e.Merge(proto.EncodedKey("a"), timeSeries(1415398729, 3600, 100)); err != nil {
+		t.Fatal(err)
+	}
+	b := e.NewBatch()
+	for _, offset := range []int32{200, 300} {
+		if err := b.Merge(proto.EncodedKey("a"), timeSeries(1415398729, 3600, offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := timeSeries(1415398729, 3600, 100, 200, 300)
+	val, err := b.Get(proto.EncodedKey("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compareMergedValues(val, expected) {
+		t.Errorf("unexpected batch time series merge result: %q", val)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if val, err = e.Get(proto.EncodedKey("a")); err != nil {
+		t.Fatal(err)
+	}
+	if !compareMergedValues(val, expected) {
+		t.Errorf("unexpected committed time series merge result: %q", val)
+	}
+}
+
 func TestBatchProto(t *testing.T) {
 	e := NewInMem(proto.Attributes{}, 1<<20)
 	b := e.NewBatch()
@@ -309,6 +342,61 @@ func TestBatchScan(t *testing.T) {
 	}
 }
 
+// TestBatchReverseScan verifies that reverse scans of a batch merge
+// the pending updates with the underlying engine, in descending
+// order.
+func TestBatchReverseScan(t *testing.T) {
+	e := NewInMem(proto.Attributes{}, 1<<20)
+	b := e.NewBatch()
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if err := e.Put(proto.EncodedKey(key), []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"a", "bb", "d", "ff", "g"} {
+		if err := b.Put(proto.EncodedKey(key), []byte("b"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Clear(proto.EncodedKey("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	scans := []struct {
+		start, end proto.EncodedKey
+		max        int64
+	}{
+		{start: proto.EncodedKey("a"), end: proto.EncodedKey("z"), max: 0},
+		{start: proto.EncodedKey("a"), end: proto.EncodedKey("z"), max: 3},
+		{start: proto.EncodedKey("bb"), end: proto.EncodedKey("ff"), max: 0},
+		{start: proto.EncodedKey("b0"), end: proto.EncodedKey("f0"), max: 0},
+	}
+
+	// Reverse scan each case using the batch and store the results.
+	results := map[int][]proto.RawKeyValue{}
+	for i, scan := range scans {
+		kvs, err := ReverseScan(b, scan.start, scan.end, scan.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results[i] = kvs
+	}
+
+	// Commit the batch and compare with a reverse scan of the engine.
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for i, scan := range scans {
+		kvs, err := ReverseScan(e, scan.start, scan.end, scan.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(kvs, results[i]) {
+			t.Errorf("%d: expected %v; got %v", i, kvs, results[i])
+		}
+	}
+}
+
 // TestBatchScanWithDelete verifies that a scan containing
 // a single deleted value returns nothing.
 func TestBatchScanWithDelete(t *testing.T) {
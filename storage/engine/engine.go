@@ -20,6 +20,9 @@
 package engine
 
 import (
+	"bytes"
+
+	"code.google.com/p/biogo.store/llrb"
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/util"
@@ -58,6 +61,12 @@ type Engine interface {
 	// invoked. If f returns an error or if the scan itself encounters
 	// an error, the iteration will stop and return f.
 	Iterate(start, end proto.EncodedKey, f func(proto.RawKeyValue) (bool, error)) error
+	// ReverseIterate scans from end to start keys, visiting the
+	// key/value pairs with keys from start (inclusive) to end
+	// (exclusive) in descending order. On each key value pair, the
+	// function f is invoked. If f returns an error or if the scan
+	// itself encounters an error, the iteration will stop and return f.
+	ReverseIterate(start, end proto.EncodedKey, f func(proto.RawKeyValue) (bool, error)) error
 	// Clear removes the item from the db with the given key.
 	// Note that clear actually removes entries from the storage
 	// engine, rather than inserting tombstones.
@@ -217,6 +226,50 @@ func Scan(engine Engine, start, end proto.EncodedKey, max int64) ([]proto.RawKey
 	return kvs, err
 }
 
+// ReverseScan returns up to max key/value objects starting from the
+// last key before end (non-inclusive) and ending at start
+// (inclusive), in descending order. Specify max=0 for unbounded
+// scans.
+func ReverseScan(engine Engine, start, end proto.EncodedKey, max int64) ([]proto.RawKeyValue, error) {
+	var kvs []proto.RawKeyValue
+	err := engine.ReverseIterate(start, end, func(kv proto.RawKeyValue) (bool, error) {
+		if max != 0 && int64(len(kvs)) >= max {
+			return true, nil
+		}
+		kvs = append(kvs, kv)
+		return false, nil
+	})
+	return kvs, err
+}
+
+// reverseDoRange invokes fn on the items of the tree with keys from
+// start (inclusive) to end (exclusive), from last to first, until fn
+// returns true. It returns whether fn returned true.
+func reverseDoRange(tree *llrb.Tree, fn func(llrb.Comparable) bool, start, end proto.EncodedKey) bool {
+	if bytes.Compare(start, end) >= 0 {
+		return false
+	}
+	// DoRangeReverse visits the interval (start, end], so the item at
+	// end is skipped and the item at start, if any, is visited last.
+	visitedStart := false
+	if tree.DoRangeReverse(func(item llrb.Comparable) bool {
+		key := item.(proto.KeyGetter).KeyGet()
+		if !bytes.Equal(key, end) {
+			visitedStart = bytes.Equal(key, start)
+			return fn(item)
+		}
+		return false
+	}, proto.RawKeyValue{Key: end}, proto.RawKeyValue{Key: start}) {
+		return true
+	}
+	if !visitedStart {
+		if item := tree.Get(proto.RawKeyValue{Key: start}); item != nil {
+			return fn(item)
+		}
+	}
+	return false
+}
+
 // ScanSnapshot scans using the given snapshot ID.
 func ScanSnapshot(engine Engine, start, end proto.EncodedKey, max int64, snapshotID string) ([]proto.RawKeyValue, error) {
 	var kvs []proto.RawKeyValue
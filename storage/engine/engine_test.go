@@ -447,6 +447,56 @@ func TestEngineScan2(t *testing.T) {
 	}, t)
 }
 
+func verifyReverseScan(start, end proto.EncodedKey, max int64, expKeys []proto.EncodedKey, engine Engine, t *testing.T) {
+	kvs, err := ReverseScan(engine, start, end, max)
+	if err != nil {
+		t.Errorf("reverse scan %q-%q: expected no error, but got %s", string(start), string(end), err)
+	}
+	if len(kvs) != len(expKeys) {
+		t.Errorf("reverse scan %q-%q: expected scanned keys mismatch %d != %d: %v",
+			start, end, len(kvs), len(expKeys), kvs)
+	}
+	for i, kv := range kvs {
+		if i < len(expKeys) && !bytes.Equal(kv.Key, expKeys[i]) {
+			t.Errorf("reverse scan %q-%q: expected keys equal %q != %q", string(start), string(end),
+				string(kv.Key), string(expKeys[i]))
+		}
+	}
+}
+
+func TestEngineReverseScan(t *testing.T) {
+	runWithAllEngines(func(engine Engine, t *testing.T) {
+		keys := []proto.EncodedKey{
+			proto.EncodedKey("a"),
+			proto.EncodedKey("aa"),
+			proto.EncodedKey("aaa"),
+			proto.EncodedKey("ab"),
+			proto.EncodedKey("abc"),
+			proto.EncodedKey(KeyMax),
+		}
+
+		insertKeys(keys, engine, t)
+
+		// Reverse scan all keys (non-inclusive of final key).
+		verifyReverseScan(proto.EncodedKey(KeyMin), proto.EncodedKey(KeyMax), 10,
+			[]proto.EncodedKey{keys[4], keys[3], keys[2], keys[1], keys[0]}, engine, t)
+
+		// Reverse scan sub range; the start key is inclusive.
+		verifyReverseScan(proto.EncodedKey("aa"), proto.EncodedKey("abc"), 10,
+			[]proto.EncodedKey{keys[3], keys[2], keys[1]}, engine, t)
+		verifyReverseScan(proto.EncodedKey("aa0"), proto.EncodedKey("abcc"), 10,
+			[]proto.EncodedKey{keys[4], keys[3], keys[2]}, engine, t)
+
+		// Reverse scan with max values.
+		verifyReverseScan(proto.EncodedKey(KeyMin), proto.EncodedKey(KeyMax), 2,
+			[]proto.EncodedKey{keys[4], keys[3]}, engine, t)
+
+		// Reverse scan of an empty span.
+		verifyReverseScan(proto.EncodedKey("b"), proto.EncodedKey("c"), 0, nil, engine, t)
+		verifyReverseScan(proto.EncodedKey("ab"), proto.EncodedKey("ab"), 0, nil, engine, t)
+	}, t)
+}
+
 func TestEngineDeleteRange(t *testing.T) {
 	runWithAllEngines(func(engine Engine, t *testing.T) {
 		keys := []proto.EncodedKey{
@@ -177,8 +177,10 @@ func (in *InMem) mergeLocked(key proto.EncodedKey, value []byte) error {
 	if err != nil {
 		return err
 	}
-	// Emulate RocksDB errors by... not having errors.
-	newValue, _ := goMerge(existingVal, value)
+	newValue, err := goMerge(existingVal, value)
+	if err != nil {
+		return err
+	}
 	return in.putLocked(key, newValue)
 }
 
@@ -251,6 +253,20 @@ func (in *InMem) iterateLocked(start, end proto.EncodedKey, f func(proto.RawKeyV
 	return err
 }
 
+// ReverseIterate iterates from end to start keys, invoking f on each
+// key/value pair. See engine.ReverseIterate for details.
+func (in *InMem) ReverseIterate(start, end proto.EncodedKey, f func(proto.RawKeyValue) (bool, error)) error {
+	in.RLock()
+	defer in.RUnlock()
+	var err error
+	reverseDoRange(&in.data, func(kv llrb.Comparable) bool {
+		var done bool
+		done, err = f(kv.(proto.RawKeyValue))
+		return done || err != nil
+	}, start, end)
+	return err
+}
+
 // Clear removes the item from the db with the given key.
 func (in *InMem) Clear(key proto.EncodedKey) error {
 	in.Lock()
@@ -19,6 +19,7 @@
 package engine
 
 import (
+	"bytes"
 	"fmt"
 	"runtime"
 	"testing"
@@ -70,6 +71,47 @@ func TestInMemCapacity(t *testing.T) {
 	}
 }
 
+// TestInMemMerge verifies that merges are applied by the in-memory
+// engine as by RocksDB's DBMergeOne, including the merge of time series
+// with duplicate offsets, and that a merge which fails returns an error
+// and leaves the existing value unchanged.
+func TestInMemMerge(t *testing.T) {
+	engine := NewInMem(proto.Attributes{}, 1<<20)
+	key := proto.EncodedKey("a")
+	merges := [][]byte{
+		timeSeries(1415398729, 3600, 100, 200),
+		timeSeries(1415398729, 3600, 200, 300),
+	}
+	var expected []byte
+	for i, update := range merges {
+		if err := engine.Merge(key, update); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		var err error
+		if expected, err = goMerge(expected, update); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+	}
+	val, err := engine.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compareMergedValues(val, expected) {
+		t.Errorf("unexpected time series merge result: %q != %q", val, expected)
+	}
+
+	if err := engine.Merge(key, appender("a")); err == nil {
+		t.Error("expected error merging bytes into a time series")
+	}
+	newVal, err := engine.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(newVal, val) {
+		t.Errorf("expected failed merge to leave value unchanged: %q != %q", newVal, val)
+	}
+}
+
 func TestInMemOverCapacity(t *testing.T) {
 	value := []byte("0123456789")
 	// Create an engine with enough space for one, but not two, nodes.
@@ -23,6 +23,7 @@ import (
 	"fmt"
 
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/encoding"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
@@ -68,6 +69,78 @@ func KeyAddress(k proto.Key) proto.Key {
 	return k[KeyLocalPrefixLength:]
 }
 
+// RaftLogPrefix returns the key prefix under which all Raft log
+// entries for the consensus group with the given Raft ID are stored.
+func RaftLogPrefix(raftID int64) proto.Key {
+	return MakeKey(KeyLocalRaftLogPrefix, encoding.EncodeInt(nil, raftID))
+}
+
+// RaftLogKey returns the key for the Raft log entry with the given
+// index in the consensus group with the given Raft ID. Log keys sort
+// in index order.
+func RaftLogKey(raftID int64, logIndex uint64) proto.Key {
+	return encoding.EncodeUint64(RaftLogPrefix(raftID), logIndex)
+}
+
+// RaftStateKey returns the key for the persistent Raft state of the
+// consensus group with the given Raft ID.
+func RaftStateKey(raftID int64) proto.Key {
+	return MakeKey(KeyLocalRaftStatePrefix, encoding.EncodeInt(nil, raftID))
+}
+
+// RaftAppliedIndexKey returns the key for the index of the last Raft
+// log entry applied to the range with the given Raft ID.
+func RaftAppliedIndexKey(raftID int64) proto.Key {
+	return MakeKey(KeyLocalRaftAppliedIndexPrefix, encoding.EncodeInt(nil, raftID))
+}
+
+// RangeLeaderLeaseKey returns the key for the leader lease of the
+// range with the given Raft ID.
+func RangeLeaderLeaseKey(raftID int64) proto.Key {
+	return MakeKey(KeyLocalRangeLeaderLeasePrefix, encoding.EncodeInt(nil, raftID))
+}
+
+// RangeGCMetadataKey returns the key for the GC metadata of the range
+// with the given Raft ID.
+func RangeGCMetadataKey(raftID int64) proto.Key {
+	return MakeKey(KeyLocalRangeGCMetadataPrefix, encoding.EncodeInt(nil, raftID))
+}
+
+// RangeMergeFreezeKey returns the key which marks the range starting
+// at startKey as frozen for a merge with the range preceding it. The
+// key addresses to startKey.
+func RangeMergeFreezeKey(startKey proto.Key) proto.Key {
+	return MakeLocalKey(KeyLocalRangeMergeFreezePrefix, startKey)
+}
+
+// UpdateQueueKey returns the key at which the update enqueued by the
+// command with the given ID is stored. The key addresses to key.
+func UpdateQueueKey(key proto.Key, cmdID proto.ClientCmdID) proto.Key {
+	k := MakeLocalKey(KeyLocalUpdateQueuePrefix, key)
+	k = encoding.EncodeUint64(k, uint64(cmdID.WallTime))
+	return encoding.EncodeUint64(k, uint64(cmdID.Random))
+}
+
+// UpdateDeadLetterKey returns the key to which the update queued at
+// queueKey is moved once it has failed too often to be applied. The
+// key addresses to the same key as queueKey.
+func UpdateDeadLetterKey(queueKey proto.Key) proto.Key {
+	return MakeLocalKey(KeyLocalUpdateDeadLetterPrefix, queueKey[KeyLocalPrefixLength:])
+}
+
+// InboxMetadataKey returns the key for the metadata of the message
+// queue of the given inbox. The key addresses to the inbox.
+func InboxMetadataKey(inbox proto.Key) proto.Key {
+	return MakeLocalKey(KeyLocalInboxMetadataPrefix, inbox)
+}
+
+// InboxMessageKey returns the key for the message with the given
+// sequence number in the queue of the given inbox. Message keys of an
+// inbox sort in sequence order and address to the inbox.
+func InboxMessageKey(inbox proto.Key, seq int64) proto.Key {
+	return encoding.EncodeUint64(MakeLocalKey(KeyLocalInboxMessagePrefix, inbox), uint64(seq))
+}
+
 // RangeMetaKey returns a range metadata key for the given key. For ordinary
 // keys this returns a level 2 metadata key - for level 2 keys, it returns a
 // level 1 key. For level 1 keys and local keys, KeyMin is returned.
@@ -173,12 +246,47 @@ var (
 	//   part of the local key.
 	KeyLocalPrefixLength = len(KeyLocalPrefix) + 4
 
+	// KeyLocalInboxMessagePrefix is the prefix for keys storing
+	// messages enqueued to an inbox. The suffix is the inbox key
+	// followed by the message sequence number.
+	KeyLocalInboxMessagePrefix = MakeKey(KeyLocalPrefix, proto.Key("ibxm"))
+	// KeyLocalInboxMetadataPrefix is the prefix for keys storing the
+	// metadata of an inbox message queue. The suffix is the inbox key
+	// and the value is a struct of type InboxMetadata.
+	KeyLocalInboxMetadataPrefix = MakeKey(KeyLocalPrefix, proto.Key("ibx-"))
 	// KeyLocalIdent stores an immutable identifier for this store,
 	// created when the store is first bootstrapped.
 	KeyLocalIdent = MakeKey(KeyLocalPrefix, proto.Key("iden"))
 	// KeyLocalRangeDescriptorPrefix is the prefix for keys storing
 	// range descriptors. The value is a struct of type RangeDescriptor.
 	KeyLocalRangeDescriptorPrefix = MakeKey(KeyLocalPrefix, proto.Key("rng-"))
+	// KeyLocalRaftLogPrefix is the prefix for keys storing Raft log
+	// entries. The suffix is the Raft ID of the consensus group
+	// followed by the log index. The value is a raftpb.Entry.
+	KeyLocalRaftLogPrefix = MakeKey(KeyLocalPrefix, proto.Key("rftl"))
+	// KeyLocalRaftAppliedIndexPrefix is the prefix for keys storing
+	// the index of the last Raft log entry applied to each range. The
+	// suffix is the Raft ID. The value is an integer.
+	KeyLocalRaftAppliedIndexPrefix = MakeKey(KeyLocalPrefix, proto.Key("rfta"))
+	// KeyLocalRaftStatePrefix is the prefix for keys storing the
+	// persistent Raft state (term, vote and commit index) of each
+	// consensus group. The suffix is the Raft ID. The value is a
+	// raftpb.HardState.
+	KeyLocalRaftStatePrefix = MakeKey(KeyLocalPrefix, proto.Key("rfts"))
+	// KeyLocalRangeLeaderLeasePrefix is the prefix for keys storing
+	// the leader lease of a range. The suffix is the Raft ID of the
+	// range. The value is a proto.Lease.
+	KeyLocalRangeLeaderLeasePrefix = MakeKey(KeyLocalPrefix, proto.Key("lls-"))
+	// KeyLocalRangeGCMetadataPrefix is the prefix for keys storing
+	// information about the last garbage collection of a range. The
+	// suffix is the Raft ID of the range. The value is a
+	// proto.GCMetadata.
+	KeyLocalRangeGCMetadataPrefix = MakeKey(KeyLocalPrefix, proto.Key("rgc-"))
+	// KeyLocalRangeMergeFreezePrefix is the prefix for keys marking a
+	// range as frozen while it's being merged into the range preceding
+	// it. The suffix is the start key of the frozen range. The value is
+	// the Raft ID of the subsuming range.
+	KeyLocalRangeMergeFreezePrefix = MakeKey(KeyLocalPrefix, proto.Key("rmf-"))
 	// KeyLocalRangeStatPrefix is the prefix for range statistics.
 	KeyLocalRangeStatPrefix = MakeKey(KeyLocalPrefix, proto.Key("rst-"))
 	// KeyLocalResponseCachePrefix is the prefix for keys storing command
@@ -189,6 +297,15 @@ var (
 	// KeyLocalTransactionPrefix specifies the key prefix for
 	// transaction records. The suffix is the transaction id.
 	KeyLocalTransactionPrefix = MakeKey(KeyLocalPrefix, proto.Key("txn-"))
+	// KeyLocalUpdateQueuePrefix is the prefix for keys storing updates
+	// enqueued for asynchronous execution. The suffix is the key of
+	// the enqueuing request followed by its command ID, and the value
+	// is a struct of type QueuedUpdate.
+	KeyLocalUpdateQueuePrefix = MakeKey(KeyLocalPrefix, proto.Key("upd-"))
+	// KeyLocalUpdateDeadLetterPrefix is the prefix for keys storing
+	// enqueued updates which repeatedly failed to be applied. The
+	// suffix and value are those of the update's queue key.
+	KeyLocalUpdateDeadLetterPrefix = MakeKey(KeyLocalPrefix, proto.Key("updx"))
 	// KeyLocalSnapshotIDGenerator is a snapshot ID generator sequence.
 	// Snapshot IDs must be unique per store ID.
 	KeyLocalSnapshotIDGenerator = MakeKey(KeyLocalPrefix, proto.Key("ssid"))
@@ -232,7 +349,16 @@ var (
 	KeyRangeIDGenerator = MakeKey(KeySystemPrefix, proto.Key("range-idgen"))
 	// KeySchemaPrefix specifies key prefixes for schema definitions.
 	KeySchemaPrefix = MakeKey(KeySystemPrefix, proto.Key("schema"))
+	// KeySequencePrefix specifies key prefixes for the sequences of
+	// auto-increment columns of structured tables. The suffix encodes
+	// the schema, table and column keys.
+	KeySequencePrefix = MakeKey(KeySystemPrefix, proto.Key("seq-"))
 	// KeyStoreIDGeneratorPrefix specifies key prefixes for sequence
 	// generators, one per node, for store IDs.
 	KeyStoreIDGeneratorPrefix = MakeKey(KeySystemPrefix, proto.Key("store-idgen-"))
+	// KeyTimeSeriesPrefix specifies the key prefix for time series
+	// data. The suffix encodes the series name, resolution, interval
+	// start and source (see package ts). The value is a
+	// proto.TimeSeriesData.
+	KeyTimeSeriesPrefix = MakeKey(KeySystemPrefix, proto.Key("tsd"))
 )
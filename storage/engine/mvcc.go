@@ -44,9 +44,11 @@ const (
 //  - Key count (count of all keys, including keys with deleted tombstones)
 //  - Value count (all versions, including deleted tombstones)
 //  - Intents (provisional values written during txns)
+//  - GC bytes age (non-live bytes weighted by age, as of the last GC)
 type MVCCStats struct {
 	LiveBytes, KeyBytes, ValBytes, IntentBytes int64
 	LiveCount, KeyCount, ValCount, IntentCount int64
+	GCBytesAge                                 int64
 }
 
 // MergeStats merges accumulated stats to stat counters for both the
@@ -60,6 +62,7 @@ func (ms *MVCCStats) MergeStats(engine Engine, rangeID int64, storeID int32) {
 	MergeStat(engine, rangeID, storeID, StatKeyCount, ms.KeyCount)
 	MergeStat(engine, rangeID, storeID, StatValCount, ms.ValCount)
 	MergeStat(engine, rangeID, storeID, StatIntentCount, ms.IntentCount)
+	MergeStat(engine, rangeID, storeID, StatGCBytesAge, ms.GCBytesAge)
 }
 
 // SetStats sets stat counters for both the affected range and store.
@@ -72,6 +75,7 @@ func (ms *MVCCStats) SetStats(engine Engine, rangeID int64, storeID int32) {
 	SetStat(engine, rangeID, storeID, StatKeyCount, ms.KeyCount)
 	SetStat(engine, rangeID, storeID, StatValCount, ms.ValCount)
 	SetStat(engine, rangeID, storeID, StatIntentCount, ms.IntentCount)
+	SetStat(engine, rangeID, storeID, StatGCBytesAge, ms.GCBytesAge)
 }
 
 // updateStatsForKey returns whether or not the bytes and counts for
@@ -229,6 +233,25 @@ func (ms *MVCCStats) updateStatsOnAbort(key proto.Key, origMetaKeySize, origMeta
 	}
 }
 
+// updateStatsOnGC updates stat counters after garbage collection of
+// a key or value by subtracting its key and value bytes. If isMeta is
+// true, the MVCC metadata of the key is being removed and the key
+// count is decremented; otherwise a versioned value is being removed
+// and the value count is decremented. Garbage collected values are
+// never live.
+func (ms *MVCCStats) updateStatsOnGC(key proto.Key, keySize, valSize int64, isMeta bool) {
+	if !ms.updateStatsForKey(key) {
+		return
+	}
+	ms.KeyBytes -= keySize
+	ms.ValBytes -= valSize
+	if isMeta {
+		ms.KeyCount--
+	} else {
+		ms.ValCount--
+	}
+}
+
 // MVCCGetRangeStats reads stat counters for the specified range and
 // returns an MVCCStats object on success.
 func MVCCGetRangeStats(engine Engine, rangeID int64) (*MVCCStats, error) {
@@ -258,6 +281,9 @@ func MVCCGetRangeStats(engine Engine, rangeID int64) (*MVCCStats, error) {
 	if ms.IntentCount, err = GetRangeStat(engine, rangeID, StatIntentCount); err != nil {
 		return nil, err
 	}
+	if ms.GCBytesAge, err = GetRangeStat(engine, rangeID, StatGCBytesAge); err != nil {
+		return nil, err
+	}
 	return ms, nil
 }
 
@@ -640,7 +666,9 @@ func MVCCMerge(engine Engine, ms *MVCCStats, key proto.Key, value proto.Value) e
 	if err != nil {
 		return err
 	}
-	engine.Merge(metaKey, data)
+	if err := engine.Merge(metaKey, data); err != nil {
+		return err
+	}
 	ms.updateStatsOnMerge(key, int64(len(value.Bytes)))
 	return nil
 }
@@ -732,6 +760,52 @@ func MVCCScan(engine Engine, key, endKey proto.Key, max int64, timestamp proto.T
 	return res, nil
 }
 
+// MVCCReverseScan scans the key range specified by start key through
+// end key in descending order, up to some maximum number of results.
+// Specify max=0 for unbounded scans.
+func MVCCReverseScan(engine Engine, key, endKey proto.Key, max int64, timestamp proto.Timestamp, txn *proto.Transaction) ([]proto.KeyValue, error) {
+	if len(endKey) == 0 {
+		return nil, emptyKeyError()
+	}
+	encKey := MVCCEncodeKey(key)
+	nextEndKey := MVCCEncodeKey(endKey)
+
+	res := []proto.KeyValue{}
+	for {
+		kvs, err := ReverseScan(engine, encKey, nextEndKey, 1)
+		if err != nil {
+			return nil, err
+		}
+		// No more keys exists in the given range.
+		if len(kvs) == 0 {
+			break
+		}
+
+		// The last key before nextEndKey is usually the oldest version
+		// of the preceding key; its metadata key sorts before all of
+		// its versions.
+		currentKey, _, _ := MVCCDecodeKey(kvs[0].Key)
+		value, err := MVCCGet(engine, currentKey, timestamp, txn)
+		if err != nil {
+			return res, err
+		}
+
+		if value != nil {
+			res = append(res, proto.KeyValue{Key: currentKey, Value: *value})
+		}
+
+		if max != 0 && max == int64(len(res)) {
+			break
+		}
+
+		// Skip the remaining versions of the current key by continuing
+		// the scan from before its metadata key.
+		nextEndKey = MVCCEncodeKey(currentKey)
+	}
+
+	return res, nil
+}
+
 // MVCCIterateCommitted iterates over the key range specified by start
 // and end keys, returning only the most recently committed version of
 // each key/value pair. Intents are ignored. If a key has an intent
@@ -981,6 +1055,68 @@ func MVCCResolveWriteIntentRange(engine Engine, ms *MVCCStats, key, endKey proto
 	return num, nil
 }
 
+// MVCCGarbageCollect deletes the versions of each key which are at
+// or older than the key's timestamp. If the most recent version is
+// among them, it must be a deletion tombstone and the key's metadata
+// is removed as well. Keys with inline values are removed outright;
+// their timestamps must be zero. Keys whose most recent version is an
+// intent may only have older versions garbage collected.
+func MVCCGarbageCollect(engine Engine, ms *MVCCStats, keys []proto.InternalGCRequest_GCKey) error {
+	for _, gcKey := range keys {
+		metaKey := MVCCEncodeKey(gcKey.Key)
+		meta := &proto.MVCCMetadata{}
+		ok, metaKeySize, metaValSize, err := GetProto(engine, metaKey, meta)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if meta.IsInline() {
+			if !gcKey.Timestamp.Equal(proto.ZeroTimestamp) {
+				return util.Errorf("cannot garbage collect versions of inline value at key %q", gcKey.Key)
+			}
+			if err := engine.Clear(metaKey); err != nil {
+				return err
+			}
+			ms.updateStatsForInline(gcKey.Key, metaKeySize, metaValSize, 0, 0)
+			continue
+		}
+		// If the most recent version is garbage collected, so is the
+		// metadata.
+		if !gcKey.Timestamp.Less(meta.Timestamp) {
+			if meta.Txn != nil {
+				return util.Errorf("cannot garbage collect intent at key %q", gcKey.Key)
+			} else if !meta.Deleted {
+				return util.Errorf("cannot garbage collect live value at key %q", gcKey.Key)
+			}
+			if err := engine.Clear(metaKey); err != nil {
+				return err
+			}
+			ms.updateStatsOnGC(gcKey.Key, metaKeySize, metaValSize, true)
+		}
+
+		// Collect the versions to delete before deleting them, so as not
+		// to modify the engine while iterating over it.
+		var versions []proto.RawKeyValue
+		start := MVCCEncodeVersionKey(gcKey.Key, gcKey.Timestamp)
+		end := MVCCEncodeKey(gcKey.Key.Next())
+		if err := engine.Iterate(start, end, func(kv proto.RawKeyValue) (bool, error) {
+			versions = append(versions, kv)
+			return false, nil
+		}); err != nil {
+			return err
+		}
+		for _, kv := range versions {
+			if err := engine.Clear(kv.Key); err != nil {
+				return err
+			}
+			ms.updateStatsOnGC(gcKey.Key, int64(len(kv.Key)), int64(len(kv.Value)), false)
+		}
+	}
+	return nil
+}
+
 // IsValidSplitKey returns whether the key is a valid split key.
 // Certain key ranges cannot be split; split keys chosen within
 // any of these ranges are considered invalid.
@@ -989,10 +1125,45 @@ func MVCCResolveWriteIntentRange(engine Engine, ms *MVCCStats, key, endKey proto
 //   - \x00acct < SplitKey < \x00accu
 //   - \x00perm < SplitKey < \x00pern
 //   - \x00zone < SplitKey < \x00zonf
-func IsValidSplitKey(key proto.Key) bool {
+//
+// If structuredRows is set, the key belongs to a range overlapping the
+// rows of a structured schema stored under KeySchemaPrefix, and a
+// split can't separate the rows of a structured table from the rows
+// interleaved in them: the only valid split key among the keys of a
+// top-level structured row and its interleaved rows is the top-level
+// row key itself.
+func IsValidSplitKey(key proto.Key, structuredRows bool) bool {
+	if structuredRows {
+		if rowKey := structuredRowKey(key); rowKey != nil && !rowKey.Equal(key) {
+			return false
+		}
+	}
 	return isValidEncodedSplitKey(MVCCEncodeKey(key))
 }
 
+// structuredRowKey returns the key of the top-level structured row to
+// which the key belongs, or nil if the key doesn't belong to a
+// structured row. The keys of a top-level row, of its column values
+// and of all rows interleaved in it consist of the top-level row key,
+// a sequence of ordered-encoded values beginning with the encoded
+// schema key string, followed by an encoded nil and further values.
+// See the structured package for details.
+func structuredRowKey(key proto.Key) proto.Key {
+	if len(key) == 0 || key[0] != encoding.EncodeString(nil, "")[0] {
+		return nil
+	}
+	for b := []byte(key); ; {
+		l := encoding.PeekLength(b)
+		if l == -1 {
+			return nil
+		}
+		if bytes.Equal(b[:l], encoding.EncodeNil()) {
+			return key[:len(key)-len(b)]
+		}
+		b = b[l:]
+	}
+}
+
 // illegalSplitKeyRanges detail illegal ranges for split keys,
 // exclusive of start and end.
 var illegalSplitKeyRanges = []struct {
@@ -1034,8 +1205,11 @@ func isValidEncodedSplitKey(key proto.EncodedKey) bool {
 // given, and in that case may safely be invoked in a goroutine.
 //
 // The split key will never be chosen from the key ranges listed in
-// illegalSplitKeyRanges.
-func MVCCFindSplitKey(engine Engine, rangeID int64, key, endKey proto.Key, snapshotID string) (proto.Key, error) {
+// illegalSplitKeyRanges. If structuredRows is set, the range holds
+// structured rows, whose keys are moved back to the key of their
+// top-level row, so that interleaved rows stay in the same range as
+// their parent.
+func MVCCFindSplitKey(engine Engine, rangeID int64, key, endKey proto.Key, snapshotID string, structuredRows bool) (proto.Key, error) {
 	if key.Less(KeyLocalMax) {
 		key = KeyLocalMax
 	}
@@ -1054,8 +1228,14 @@ func MVCCFindSplitKey(engine Engine, rangeID int64, key, endKey proto.Key, snaps
 	bestSplitDiff := int64(math.MaxInt64)
 
 	if err := engine.IterateSnapshot(encStartKey, encEndKey, snapshotID, func(kv proto.RawKeyValue) (bool, error) {
+		splitKey := kv.Key
+		if structuredRows {
+			if humanKey, _, _ := MVCCDecodeKey(kv.Key); structuredRowKey(humanKey) != nil {
+				splitKey = MVCCEncodeKey(structuredRowKey(humanKey))
+			}
+		}
 		// Is key within a legal key range?
-		valid := isValidEncodedSplitKey(kv.Key)
+		valid := isValidEncodedSplitKey(splitKey) && encStartKey.Less(splitKey)
 
 		// Determine if this key would make a better split than last "best" key.
 		diff := targetSize - sizeSoFar
@@ -1063,7 +1243,7 @@ func MVCCFindSplitKey(engine Engine, rangeID int64, key, endKey proto.Key, snaps
 			diff = -diff
 		}
 		if valid && diff < bestSplitDiff {
-			bestSplitKey = kv.Key
+			bestSplitKey = splitKey
 			bestSplitDiff = diff
 		}
 
@@ -34,6 +34,7 @@ import (
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
@@ -577,6 +578,61 @@ func TestMVCCScanMaxNum(t *testing.T) {
 	}
 }
 
+func TestMVCCReverseScan(t *testing.T) {
+	engine := createTestEngine()
+	err := MVCCPut(engine, nil, testKey1, makeTS(1, 0), value1, nil)
+	err = MVCCPut(engine, nil, testKey1, makeTS(2, 0), value4, nil)
+	err = MVCCPut(engine, nil, testKey2, makeTS(1, 0), value2, nil)
+	err = MVCCPut(engine, nil, testKey2, makeTS(3, 0), value3, nil)
+	err = MVCCPut(engine, nil, testKey3, makeTS(1, 0), value3, nil)
+	err = MVCCPut(engine, nil, testKey3, makeTS(4, 0), value2, nil)
+	err = MVCCPut(engine, nil, testKey4, makeTS(1, 0), value4, nil)
+	err = MVCCPut(engine, nil, testKey4, makeTS(5, 0), value1, nil)
+
+	kvs, err := MVCCReverseScan(engine, testKey2, testKey4, 0, makeTS(1, 0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 2 ||
+		!bytes.Equal(kvs[0].Key, testKey3) ||
+		!bytes.Equal(kvs[1].Key, testKey2) ||
+		!bytes.Equal(kvs[0].Value.Bytes, value3.Bytes) ||
+		!bytes.Equal(kvs[1].Value.Bytes, value2.Bytes) {
+		t.Fatalf("unexpected reverse scan results: %v", kvs)
+	}
+
+	kvs, err = MVCCReverseScan(engine, testKey2, testKey4, 0, makeTS(4, 0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 2 ||
+		!bytes.Equal(kvs[0].Key, testKey3) ||
+		!bytes.Equal(kvs[1].Key, testKey2) ||
+		!bytes.Equal(kvs[0].Value.Bytes, value2.Bytes) ||
+		!bytes.Equal(kvs[1].Value.Bytes, value3.Bytes) {
+		t.Fatalf("unexpected reverse scan results: %v", kvs)
+	}
+
+	kvs, err = MVCCReverseScan(engine, KeyMin, KeyMax, 1, makeTS(5, 0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 ||
+		!bytes.Equal(kvs[0].Key, testKey4) ||
+		!bytes.Equal(kvs[0].Value.Bytes, value1.Bytes) {
+		t.Fatalf("unexpected reverse scan results: %v", kvs)
+	}
+
+	// Keys with no visible version at the read timestamp are skipped.
+	kvs, err = MVCCReverseScan(engine, KeyMin, KeyMax, 0, makeTS(0, 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 0 {
+		t.Fatalf("expected no results; got %v", kvs)
+	}
+}
+
 func TestMVCCScanWithKeyPrefix(t *testing.T) {
 	engine := createTestEngine()
 	// Let's say you have:
@@ -1166,6 +1222,8 @@ func TestMVCCResolveTxnRange(t *testing.T) {
 }
 
 func TestValidSplitKeys(t *testing.T) {
+	// The key of a top-level structured row.
+	rowKey := proto.Key(encoding.EncodeInt(encoding.EncodeString(encoding.EncodeString(nil, "s"), "t"), 1))
 	testCases := []struct {
 		key   proto.Key
 		valid bool
@@ -1198,13 +1256,23 @@ func TestValidSplitKeys(t *testing.T) {
 		{proto.Key("\x01"), true},
 		{proto.Key("a"), true},
 		{proto.Key("\xff"), true},
+		// Structured rows may only be split in front of top-level rows.
+		{rowKey, true},
+		{proto.Key(encoding.EncodeString(append(append(proto.Key(nil), rowKey...), encoding.EncodeNil()...), "co")), false},
+		{proto.Key(encoding.EncodeBinary(append(append(proto.Key(nil), rowKey...), encoding.EncodeNil()...), []byte("c"))), false},
 	}
 
 	for i, test := range testCases {
-		if valid := IsValidSplitKey(test.key); valid != test.valid {
+		if valid := IsValidSplitKey(test.key, true); valid != test.valid {
 			t.Errorf("%d: expected %q valid %t; got %t", i, test.key, test.valid, valid)
 		}
 	}
+	// Outside of the rows of structured schemas, keys aren't taken for
+	// those of structured rows.
+	colKey := proto.Key(encoding.EncodeString(append(append(proto.Key(nil), rowKey...), encoding.EncodeNil()...), "co"))
+	if !IsValidSplitKey(colKey, false) {
+		t.Errorf("expected %q valid outside of structured rows", colKey)
+	}
 }
 
 func TestFindSplitKey(t *testing.T) {
@@ -1229,7 +1297,7 @@ func TestFindSplitKey(t *testing.T) {
 	if err := engine.CreateSnapshot("snap1"); err != nil {
 		t.Fatal(err)
 	}
-	humanSplitKey, err := MVCCFindSplitKey(engine, rangeID, KeyMin, KeyMax, "snap1")
+	humanSplitKey, err := MVCCFindSplitKey(engine, rangeID, KeyMin, KeyMax, "snap1", false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1246,10 +1314,24 @@ func TestFindSplitKey(t *testing.T) {
 // they avoid splits through invalid key ranges.
 func TestFindValidSplitKeys(t *testing.T) {
 	rangeID := int64(1)
+	// Keys of structured rows: a top-level row key, the key of a row
+	// interleaved in a row, and the key of a column value of a row.
+	rowKey := func(id int64) proto.Key {
+		return proto.Key(encoding.EncodeInt(encoding.EncodeString(encoding.EncodeString(nil, "s"), "t"), id))
+	}
+	childKey := func(key proto.Key, id int64) proto.Key {
+		key = append(append(proto.Key(nil), key...), encoding.EncodeNil()...)
+		return proto.Key(encoding.EncodeInt(encoding.EncodeBinary(key, []byte("c")), id))
+	}
+	colKey := func(key proto.Key) proto.Key {
+		key = append(append(proto.Key(nil), key...), encoding.EncodeNil()...)
+		return proto.Key(encoding.EncodeString(key, "co"))
+	}
 	testCases := []struct {
-		keys     []proto.Key
-		expSplit proto.Key
-		expError bool
+		keys       []proto.Key
+		structured bool
+		expSplit   proto.Key
+		expError   bool
 	}{
 		// All meta1 cannot be split.
 		{
@@ -1317,6 +1399,43 @@ func TestFindValidSplitKeys(t *testing.T) {
 			expSplit: proto.Key("\x00zonf"),
 			expError: false,
 		},
+		// A structured row and its interleaved rows cannot be split.
+		{
+			keys: []proto.Key{
+				colKey(rowKey(1)),
+				colKey(childKey(rowKey(1), 1)),
+				colKey(childKey(childKey(rowKey(1), 1), 1)),
+			},
+			structured: true,
+			expSplit:   nil,
+			expError:   true,
+		},
+		// Lopsided, split after the interleaved rows.
+		{
+			keys: []proto.Key{
+				colKey(rowKey(1)),
+				colKey(childKey(rowKey(1), 1)),
+				colKey(childKey(rowKey(1), 2)),
+				colKey(childKey(rowKey(1), 3)),
+				colKey(rowKey(2)),
+			},
+			structured: true,
+			expSplit:   rowKey(2),
+			expError:   false,
+		},
+		// Lopsided, split in front of the row with interleaved rows.
+		{
+			keys: []proto.Key{
+				colKey(rowKey(1)),
+				colKey(rowKey(2)),
+				colKey(childKey(rowKey(2), 1)),
+				colKey(childKey(rowKey(2), 2)),
+				colKey(childKey(rowKey(2), 3)),
+			},
+			structured: true,
+			expSplit:   rowKey(2),
+			expError:   false,
+		},
 	}
 
 	for i, test := range testCases {
@@ -1334,7 +1453,7 @@ func TestFindValidSplitKeys(t *testing.T) {
 		}
 		rangeStart := test.keys[0]
 		rangeEnd := test.keys[len(test.keys)-1].Next()
-		splitKey, err := MVCCFindSplitKey(engine, rangeID, rangeStart, rangeEnd, "snap1")
+		splitKey, err := MVCCFindSplitKey(engine, rangeID, rangeStart, rangeEnd, "snap1", test.structured)
 		if test.expError {
 			if err == nil {
 				t.Errorf("%d: expected error", i)
@@ -1419,7 +1538,7 @@ func TestFindBalancedSplitKeys(t *testing.T) {
 		if err := engine.CreateSnapshot("snap1"); err != nil {
 			t.Fatal(err)
 		}
-		splitKey, err := MVCCFindSplitKey(engine, rangeID, proto.Key("\x01"), proto.KeyMax, "snap1")
+		splitKey, err := MVCCFindSplitKey(engine, rangeID, proto.Key("\x01"), proto.KeyMax, "snap1", false)
 		if err != nil {
 			t.Errorf("unexpected error: %s", err)
 			continue
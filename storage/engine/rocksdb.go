@@ -301,6 +301,39 @@ func (r *RocksDB) iterateInternal(start, end proto.EncodedKey, f func(proto.RawK
 	return statusToError(C.DBIterError(it))
 }
 
+// ReverseIterate iterates from end to start keys, invoking f on
+// each key/value pair. See engine.ReverseIterate for details.
+func (r *RocksDB) ReverseIterate(start, end proto.EncodedKey, f func(proto.RawKeyValue) (bool, error)) error {
+	if bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+	it := C.DBNewIter(r.rdb, nil)
+	defer C.DBIterDestroy(it)
+
+	// Position the iterator at the last key before end.
+	C.DBIterSeek(it, goToCSlice(end))
+	if C.DBIterValid(it) == 1 {
+		C.DBIterPrev(it)
+	} else {
+		C.DBIterSeekToLast(it)
+	}
+	for ; C.DBIterValid(it) == 1; C.DBIterPrev(it) {
+		// As in iterateInternal, the key and value are copied.
+		data := C.DBIterKey(it)
+		k := cSliceToGoBytes(data)
+		if bytes.Compare(k, start) < 0 {
+			break
+		}
+		data = C.DBIterValue(it)
+		v := cSliceToGoBytes(data)
+		if done, err := f(proto.RawKeyValue{Key: k, Value: v}); done || err != nil {
+			return err
+		}
+	}
+	// Check for any errors during iteration.
+	return statusToError(C.DBIterError(it))
+}
+
 // WriteBatch applies the puts, merges and deletes atomically via
 // the RocksDB write batch facility. The list must only contain
 // elements of type Batch{Put,Merge,Delete}.
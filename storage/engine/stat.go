@@ -51,6 +51,10 @@ var (
 	StatValCount = proto.Key("val-count")
 	// StatIntentCount counts the number of unresolved intents.
 	StatIntentCount = proto.Key("intent-count")
+	// StatGCBytesAge sums the bytes of non-live keys and values, each
+	// weighted by its age in seconds, as of the last garbage collection
+	// of the range. It indicates how much garbage the range retains.
+	StatGCBytesAge = proto.Key("gc-bytes-age")
 )
 
 // MakeRangeStatKey returns the key for accessing the named stat
@@ -0,0 +1,177 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"bytes"
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+const (
+	// gcBatchSize is the maximum number of keys garbage collected by a
+	// single InternalGC command.
+	gcBatchSize = 1000
+)
+
+// gcInterval is the interval between successive passes of a store's
+// GC queue over its ranges.
+var gcInterval = 10 * time.Minute
+
+// gcQueue garbage collects expired MVCC versions from the ranges of a
+// store. Each pass visits every range whose leader lease the store
+// holds and applies the GC policy of the range's zone config to the
+// versions of each of its keys. Expired versions are deleted
+// via InternalGC commands, the last of which records the time of the
+// scan and the GC bytes age of the garbage left behind.
+//
+// Keys with inline values or intents are left alone; the intents are
+// garbage collected once resolved.
+type gcQueue struct {
+	*rangeScanner
+	store *Store
+}
+
+// newGCQueue returns a new GC queue for the store.
+func newGCQueue(store *Store) *gcQueue {
+	gcq := &gcQueue{store: store}
+	gcq.rangeScanner = newRangeScanner("gc queue", store, gcInterval, gcq.process)
+	return gcq
+}
+
+// process scans the key/value data of the range, garbage collecting
+// the versions which have expired according to the range's GC policy.
+func (gcq *gcQueue) process(rng *Range) error {
+	zone, err := rng.getZoneConfig()
+	if err != nil {
+		return err
+	}
+	now := gcq.store.clock.Now()
+	gc := engine.NewGarbageCollector(now, func(proto.Key) *proto.GCPolicy { return zone.GC })
+
+	rng.RLock()
+	start := rng.Desc.StartKey
+	end := rng.Desc.EndKey
+	rng.RUnlock()
+	// The first range's data excludes local keys.
+	if start.Less(engine.KeyLocalMax) {
+		start = engine.KeyLocalMax
+	}
+
+	// Collect the keys to garbage collect before sending any
+	// deletions, so as not to modify the engine being iterated.
+	var gcKeys []proto.InternalGCRequest_GCKey
+	var gcBytesAge int64
+	var keys []proto.EncodedKey
+	var vals [][]byte
+	processKey := func() {
+		if len(keys) == 0 {
+			return
+		}
+		gcKey, bytesAge := gcq.processVersions(gc, now, keys, vals)
+		if gcKey != nil {
+			gcKeys = append(gcKeys, *gcKey)
+		}
+		gcBytesAge += bytesAge
+	}
+	if err := gcq.store.Engine().Iterate(engine.MVCCEncodeKey(start), engine.MVCCEncodeKey(end), func(kv proto.RawKeyValue) (bool, error) {
+		// A new MVCC metadata key starts the next group of versions.
+		if len(keys) == 0 || !bytes.Equal(keys[0], kv.Key[:gc.MVCCPrefix(kv.Key)]) {
+			processKey()
+			keys, vals = keys[:0], vals[:0]
+		}
+		keys = append(keys, kv.Key)
+		vals = append(vals, kv.Value)
+		return false, nil
+	}); err != nil {
+		return err
+	}
+	processKey()
+
+	// Send the deletions in batches; the last one records the scan,
+	// even if there's nothing to garbage collect.
+	for {
+		batch := gcKeys
+		if len(batch) > gcBatchSize {
+			batch = batch[:gcBatchSize]
+		}
+		gcKeys = gcKeys[len(batch):]
+		args := &proto.InternalGCRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:     start,
+				User:    UserRoot,
+				Replica: *rng.GetReplica(),
+			},
+			Keys: batch,
+		}
+		if len(gcKeys) == 0 {
+			args.GCMeta = &proto.GCMetadata{
+				LastScanNanos: now.WallTime,
+				GCBytesAge:    gcBytesAge,
+			}
+		}
+		if err := gcq.store.ExecuteCmd(proto.InternalGC, args, &proto.InternalGCResponse{}); err != nil {
+			return err
+		}
+		if len(gcKeys) == 0 {
+			return nil
+		}
+	}
+}
+
+// processVersions applies the GC policy to the MVCC metadata and
+// versions of a single key, newest version first. Returns the key and
+// timestamp to garbage collect, or nil if nothing has expired, along
+// with the GC bytes age of the non-live versions which survive.
+func (gcq *gcQueue) processVersions(gc *engine.GarbageCollector, now proto.Timestamp,
+	keys []proto.EncodedKey, vals [][]byte) (*proto.InternalGCRequest_GCKey, int64) {
+	meta := &proto.MVCCMetadata{}
+	if err := gogoproto.Unmarshal(vals[0], meta); err != nil {
+		log.Errorf("unable to unmarshal MVCC metadata for key %q: %s", keys[0], err)
+		return nil, 0
+	}
+	if meta.IsInline() || meta.Txn != nil || len(keys) == 1 {
+		return nil, 0
+	}
+
+	toDelete := gc.Filter(keys, vals)
+	var gcKey *proto.InternalGCRequest_GCKey
+	var bytesAge int64
+	for i := 1; i < len(keys); i++ {
+		key, ts, _ := engine.MVCCDecodeKey(keys[i])
+		if toDelete != nil && toDelete[i] {
+			if gcKey == nil {
+				gcKey = &proto.InternalGCRequest_GCKey{Key: key, Timestamp: ts}
+			}
+			continue
+		}
+		// The most recent version is live unless it's a deletion
+		// tombstone, in which case the metadata is garbage as well.
+		age := (now.WallTime - ts.WallTime) / 1e9
+		if i == 1 {
+			if !meta.Deleted {
+				continue
+			}
+			bytesAge += int64(len(keys[0])+len(vals[0])) * age
+		}
+		bytesAge += int64(len(keys[i])+len(vals[i])) * age
+	}
+	return gcKey, bytesAge
+}
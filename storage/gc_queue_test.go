@@ -0,0 +1,155 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/hlc"
+)
+
+// TestGCQueue verifies that the GC queue deletes versions which have
+// expired according to the zone's GC policy, removes deleted keys
+// entirely once their tombstones expire, keeps the range's stats
+// consistent and records the scan in the range's GC metadata.
+func TestGCQueue(t *testing.T) {
+	store, manual := createTestStore(t)
+	defer store.Stop()
+	eng := store.Engine()
+
+	zoneConfig := testDefaultZoneConfig
+	zoneConfig.GC = &proto.GCPolicy{TTLSeconds: 60 * 60}
+	configMap, err := NewPrefixConfigMap([]*PrefixConfig{{engine.KeyMin, nil, &zoneConfig}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.gossip.AddInfo(gossip.KeyConfigZone, configMap, 0*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := func(d time.Duration) proto.Timestamp {
+		return proto.Timestamp{WallTime: d.Nanoseconds()}
+	}
+	// Key "a" has an expired version under its live value; key "b" is
+	// deleted and all of its versions have expired except for the
+	// tombstone; key "c" has a recent non-live version.
+	writes := []struct {
+		key   proto.Key
+		ts    proto.Timestamp
+		value []byte
+	}{
+		{proto.Key("a"), ts(1 * time.Second), []byte("a1")},
+		{proto.Key("b"), ts(1 * time.Second), []byte("b1")},
+		{proto.Key("a"), ts(2 * time.Second), []byte("a2")},
+		{proto.Key("b"), ts(2 * time.Second), nil},
+		{proto.Key("c"), ts(90 * time.Minute), []byte("c1")},
+		{proto.Key("c"), ts(100 * time.Minute), []byte("c2")},
+	}
+	for _, w := range writes {
+		if w.value == nil {
+			dArgs, dReply := deleteArgs(w.key, 1)
+			dArgs.Timestamp = w.ts
+			if err := store.ExecuteCmd(proto.Delete, dArgs, dReply); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		pArgs, pReply := putArgs(w.key, w.value, 1)
+		pArgs.Timestamp = w.ts
+		if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rng := store.LookupRange(proto.Key("a"), nil)
+	statsBefore, err := engine.MVCCGetRangeStats(eng, rng.RangeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	computedBefore, err := engine.MVCCComputeStats(eng, engine.KeyLocalMax, engine.KeyMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := ts(2 * time.Hour)
+	*manual = hlc.ManualClock(now.WallTime)
+	if err := store.gcQ.process(rng); err != nil {
+		t.Fatal(err)
+	}
+
+	if val, err := engine.MVCCGet(eng, proto.Key("a"), now, nil); err != nil || val == nil || string(val.Bytes) != "a2" {
+		t.Errorf("expected live value of \"a\" to be kept; got %+v, %v", val, err)
+	}
+	if val, err := engine.MVCCGet(eng, proto.Key("a"), ts(1*time.Second), nil); err != nil || val != nil {
+		t.Errorf("expected expired version of \"a\" to be deleted; got %+v, %v", val, err)
+	}
+	if val, err := engine.MVCCGet(eng, proto.Key("c"), ts(95*time.Minute), nil); err != nil || val == nil || string(val.Bytes) != "c1" {
+		t.Errorf("expected recent version of \"c\" to be kept; got %+v, %v", val, err)
+	}
+	count := 0
+	if err := eng.Iterate(engine.MVCCEncodeKey(proto.Key("b")), engine.MVCCEncodeKey(proto.Key("b").Next()), func(kv proto.RawKeyValue) (bool, error) {
+		count++
+		return false, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected deleted key \"b\" to be removed; found %d keys", count)
+	}
+
+	// The range's stats change by as much as the range's data.
+	statsAfter, err := engine.MVCCGetRangeStats(eng, rng.RangeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	computedAfter, err := engine.MVCCComputeStats(eng, engine.KeyLocalMax, engine.KeyMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stat := range []struct {
+		name                                string
+		before, after, computedB, computedA int64
+	}{
+		{"live bytes", statsBefore.LiveBytes, statsAfter.LiveBytes, computedBefore.LiveBytes, computedAfter.LiveBytes},
+		{"key bytes", statsBefore.KeyBytes, statsAfter.KeyBytes, computedBefore.KeyBytes, computedAfter.KeyBytes},
+		{"val bytes", statsBefore.ValBytes, statsAfter.ValBytes, computedBefore.ValBytes, computedAfter.ValBytes},
+		{"key count", statsBefore.KeyCount, statsAfter.KeyCount, computedBefore.KeyCount, computedAfter.KeyCount},
+		{"val count", statsBefore.ValCount, statsAfter.ValCount, computedBefore.ValCount, computedAfter.ValCount},
+	} {
+		if stat.before-stat.after != stat.computedB-stat.computedA {
+			t.Errorf("expected %s to decrease by %d; got %d", stat.name, stat.computedB-stat.computedA, stat.before-stat.after)
+		}
+	}
+
+	gcMeta, err := rng.GetGCMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gcMeta.LastScanNanos != now.WallTime {
+		t.Errorf("expected last scan at %d; got %d", now.WallTime, gcMeta.LastScanNanos)
+	}
+	// Only the non-live version of "c" remains as garbage.
+	if gcMeta.GCBytesAge <= 0 {
+		t.Errorf("expected positive GC bytes age; got %d", gcMeta.GCBytesAge)
+	}
+	if statsAfter.GCBytesAge != gcMeta.GCBytesAge {
+		t.Errorf("expected GC bytes age stat %d to equal recorded %d", statsAfter.GCBytesAge, gcMeta.GCBytesAge)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+var (
+	// intentResolveBatchSize is the maximum number of intents of a
+	// transaction resolved by a single InternalResolveIntent command.
+	intentResolveBatchSize = 100
+	// intentResolveInterval is the interval between successive passes
+	// of a store's intent queue over its ranges.
+	intentResolveInterval = 10 * time.Second
+	// intentResolveThreshold is the age past which an intent is
+	// considered abandoned. A transaction which is still alive
+	// heartbeats well within this time and survives the push.
+	intentResolveThreshold = 2 * DefaultHeartbeatInterval
+)
+
+// intentQueue resolves the intents left behind by transactions whose
+// coordinators have gone away. Each pass visits every range whose
+// leader lease the store holds and collects the intents older
+// than intentResolveThreshold. The transaction owning each batch of
+// intents is pushed with the lowest priority, which aborts it only if
+// it has stopped heartbeating, and the intents of transactions which
+// are no longer pending are resolved. Without the queue, such intents
+// linger until a reader trips over them.
+type intentQueue struct {
+	*rangeScanner
+	store *Store
+}
+
+// newIntentQueue returns a new intent queue for the store.
+func newIntentQueue(store *Store) *intentQueue {
+	iq := &intentQueue{store: store}
+	iq.rangeScanner = newRangeScanner("intent queue", store, intentResolveInterval, iq.process)
+	return iq
+}
+
+// txnIntents are the abandoned intents of a single transaction, in
+// key order.
+type txnIntents struct {
+	txn  *proto.Transaction
+	keys []proto.Key
+}
+
+// process resolves the abandoned intents of the range.
+func (iq *intentQueue) process(rng *Range) error {
+	now := iq.store.clock.Now()
+	expiry := now.WallTime - intentResolveThreshold.Nanoseconds()
+
+	rng.RLock()
+	start := rng.Desc.StartKey
+	end := rng.Desc.EndKey
+	rng.RUnlock()
+	// The first range's data excludes local keys.
+	if start.Less(engine.KeyLocalMax) {
+		start = engine.KeyLocalMax
+	}
+
+	// Collect the intents before pushing their transactions, as pushes
+	// and resolutions write to the engine being iterated.
+	var intents []*txnIntents
+	byTxnID := map[string]*txnIntents{}
+	if err := iq.store.Engine().Iterate(engine.MVCCEncodeKey(start), engine.MVCCEncodeKey(end), func(kv proto.RawKeyValue) (bool, error) {
+		key, _, isValue := engine.MVCCDecodeKey(kv.Key)
+		if isValue {
+			return false, nil
+		}
+		meta := &proto.MVCCMetadata{}
+		if err := gogoproto.Unmarshal(kv.Value, meta); err != nil {
+			return false, err
+		}
+		if meta.Txn == nil || meta.Timestamp.WallTime >= expiry {
+			return false, nil
+		}
+		ti, ok := byTxnID[string(meta.Txn.ID)]
+		if !ok {
+			ti = &txnIntents{txn: meta.Txn}
+			byTxnID[string(meta.Txn.ID)] = ti
+			intents = append(intents, ti)
+		}
+		ti.keys = append(ti.keys, key)
+		return false, nil
+	}); err != nil {
+		return err
+	}
+
+	for _, ti := range intents {
+		pushee, err := iq.pushTxn(ti.txn, now)
+		if err != nil {
+			log.V(1).Infof("abandoned intents of transaction %s not resolved: %s", ti.txn, err)
+			continue
+		}
+		if err := iq.resolveIntents(rng, pushee, ti.keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushTxn pushes the transaction which owns abandoned intents with the
+// lowest priority, aborting it only if it's no longer heartbeating.
+// Returns the pushed transaction, which is committed or aborted.
+func (iq *intentQueue) pushTxn(txn *proto.Transaction, now proto.Timestamp) (*proto.Transaction, error) {
+	pushArgs := &proto.InternalPushTxnRequest{
+		RequestHeader: proto.RequestHeader{
+			Timestamp: now,
+			Key:       txn.ID,
+			User:      UserRoot,
+		},
+		PusheeTxn:   *txn,
+		Abort:       true,
+		MinPriority: true,
+	}
+	pushReply := &proto.InternalPushTxnResponse{}
+	if err := iq.store.db.Call(proto.InternalPushTxn, pushArgs, pushReply); err != nil {
+		return nil, err
+	}
+	return pushReply.PusheeTxn, nil
+}
+
+// resolveIntents resolves the intents of the pushed transaction at the
+// keys, which are in key order, in batches of intentResolveBatchSize.
+// Each batch is resolved with a single ranged command, which leaves
+// the intents of other transactions within the batch's span alone.
+func (iq *intentQueue) resolveIntents(rng *Range, txn *proto.Transaction, keys []proto.Key) error {
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > intentResolveBatchSize {
+			batch = batch[:intentResolveBatchSize]
+		}
+		keys = keys[len(batch):]
+		if err := iq.store.ExecuteCmd(proto.InternalResolveIntent, &proto.InternalResolveIntentRequest{
+			RequestHeader: proto.RequestHeader{
+				Timestamp: txn.Timestamp,
+				Key:       batch[0],
+				EndKey:    batch[len(batch)-1].Next(),
+				User:      UserRoot,
+				Replica:   *rng.GetReplica(),
+				Txn:       txn,
+			},
+		}, &proto.InternalResolveIntentResponse{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
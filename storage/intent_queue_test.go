@@ -0,0 +1,85 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/hlc"
+)
+
+// TestIntentQueue verifies that the intent queue aborts transactions
+// which have abandoned old intents and resolves those intents in
+// batches, while leaving alone the intents of transactions which are
+// still heartbeating as well as recent intents.
+func TestIntentQueue(t *testing.T) {
+	defer func(size int) { intentResolveBatchSize = size }(intentResolveBatchSize)
+	intentResolveBatchSize = 2
+
+	store, manual := createTestStore(t)
+	defer store.Stop()
+	holdLeaderLease(t, store, 1)
+	eng := store.Engine()
+
+	*manual = hlc.ManualClock(time.Second.Nanoseconds())
+	abandoned := newTransaction("abandoned", proto.Key("a"), 1, proto.SERIALIZABLE, store.clock)
+	live := newTransaction("live", proto.Key("d"), 1, proto.SERIALIZABLE, store.clock)
+	writeIntents := func(txn *proto.Transaction, keys ...string) {
+		for _, key := range keys {
+			if err := engine.MVCCPut(eng, nil, proto.Key(key), txn.Timestamp, proto.Value{Bytes: []byte("value")}, txn); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	writeIntents(abandoned, "a", "b", "c")
+	writeIntents(live, "d")
+
+	now := time.Minute
+	*manual = hlc.ManualClock(now.Nanoseconds())
+	recent := newTransaction("recent", proto.Key("e"), 1, proto.SERIALIZABLE, store.clock)
+	writeIntents(recent, "e")
+	// The live transaction keeps heartbeating.
+	heartbeat := store.clock.Now()
+	live.LastHeartbeat = &heartbeat
+	if err := engine.MVCCPutProto(eng, nil, engine.MakeKey(engine.KeyLocalTransactionPrefix, live.ID), proto.ZeroTimestamp, nil, live); err != nil {
+		t.Fatal(err)
+	}
+
+	*manual = hlc.ManualClock((now + time.Second).Nanoseconds())
+	store.intentQ.processAll()
+
+	// The abandoned transaction is aborted and its intents removed.
+	record := &proto.Transaction{}
+	if ok, err := engine.MVCCGetProto(eng, engine.MakeKey(engine.KeyLocalTransactionPrefix, abandoned.ID), proto.ZeroTimestamp, nil, record); err != nil || !ok || record.Status != proto.ABORTED {
+		t.Errorf("expected abandoned transaction to be aborted; got %s, %t, %v", record, ok, err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if val, err := engine.MVCCGet(eng, proto.Key(key), store.clock.Now(), nil); err != nil || val != nil {
+			t.Errorf("expected abandoned intent at %q to be removed; got %+v, %v", key, val, err)
+		}
+	}
+	// The intents of the live and recent transactions remain.
+	for _, key := range []string{"d", "e"} {
+		if _, err := engine.MVCCGet(eng, proto.Key(key), store.clock.Now(), nil); err == nil {
+			t.Errorf("expected intent at %q to remain", key)
+		} else if _, ok := err.(*proto.WriteIntentError); !ok {
+			t.Errorf("expected write intent error at %q; got %v", key, err)
+		}
+	}
+}
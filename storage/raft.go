@@ -17,32 +17,411 @@
 
 package storage
 
-import "github.com/cockroachdb/cockroach/proto"
+import (
+	"sync"
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/multiraft"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+var (
+	// raftTickInterval is the resolution of the Raft timer; other raft
+	// timeouts are defined in terms of multiples of this value.
+	raftTickInterval = 100 * time.Millisecond
+	// raftElectionTimeoutTicks is the number of ticks without contact
+	// from the leader after which a follower calls an election.
+	raftElectionTimeoutTicks = 3
+	// raftHeartbeatIntervalTicks is the number of ticks between leader
+	// heartbeats.
+	raftHeartbeatIntervalTicks = 1
+	// raftProposalRetryTicks is the number of ticks after which a
+	// submitted proposal which hasn't been committed is re-proposed.
+	raftProposalRetryTicks = 10
+	// raftProposalMaxAttempts is the number of times a proposal is
+	// submitted before it's abandoned.
+	raftProposalMaxAttempts = 5
+)
+
+// committedEntry is a log entry of a consensus group which has been
+// committed by raft. It carries either a command, a change to the
+// group's membership or, for a replica which has fallen behind the
+// group's compacted log, a snapshot of the range's data which
+// replaces all entries up to index. An entry with a non-nil error
+// instead reports a command proposed by this node which was
+// abandoned without being committed.
+type committedEntry struct {
+	raftID   int64
+	index    uint64
+	cmd      proto.InternalRaftCommand
+	snapshot []byte        // Non-nil if the entry is a snapshot
+	change   *memberChange // Non-nil if the entry is a membership change
+	err      error         // Non-nil if the proposal of cmd was abandoned
+}
+
+// memberChange describes the addition or removal of a node to or
+// from a consensus group.
+type memberChange struct {
+	changeType proto.ReplicaChangeType
+	nodeID     uint64
+}
 
 // raft is the interface exposed by a raft implementation.
 type raft interface {
-	// propose a command to raft. If accepted by the consensus protocol it will
-	// eventually appear in the committed channel, but this is not guaranteed
-	// so callers may need to retry.
+	// createGroup joins the consensus group with the given ID, whose
+	// initial membership is given by members.
+	createGroup(raftID int64, members []uint64) error
+
+	// removeGroup leaves the consensus group with the given ID. The
+	// group's persisted log and state are left for the caller to clear.
+	removeGroup(raftID int64) error
+
+	// propose a command to raft. Commands are submitted in the order
+	// they're proposed and are re-proposed until they appear in the
+	// committed channel. A command which can't be committed is
+	// eventually abandoned, which is reported by an entry with a
+	// non-nil error on the committed channel. A re-proposed command
+	// may be committed more than once.
 	propose(proto.InternalRaftCommand)
 
-	// committed returns a channel that yields commands as they are
+	// committed returns a channel that yields entries as they are
 	// committed. Note that this includes commands proposed by this node
 	// and others.
-	committed() <-chan proto.InternalRaftCommand
+	committed() <-chan committedEntry
+
+	// compact discards the log of the consensus group up to and
+	// including index, which is replaced by a snapshot containing data.
+	compact(raftID int64, index uint64, data []byte) error
+
+	// changeMembership proposes the addition or removal of the node to
+	// or from the consensus group. Once committed, the change appears
+	// in the committed channel.
+	changeMembership(raftID int64, changeType proto.ReplicaChangeType, nodeID uint64) error
+
+	// stop shuts down the raft implementation.
+	stop()
 }
 
-// noopRaft is a trivial implementation of the raft interface for testing.
-type noopRaft chan proto.InternalRaftCommand
+// raftNodeID returns the ID under which the replica on the specified
+// store participates in Raft consensus groups.
+func raftNodeID(storeID int32) uint64 {
+	return uint64(storeID)
+}
 
-func newNoopRaft() noopRaft {
-	return make(noopRaft, 10)
+// raftMembers returns the Raft node IDs of all replicas listed in
+// the range descriptor.
+func raftMembers(desc *proto.RangeDescriptor) []uint64 {
+	members := make([]uint64, len(desc.Replicas))
+	for i, replica := range desc.Replicas {
+		members[i] = raftNodeID(replica.StoreID)
+	}
+	return members
 }
 
-func (nr noopRaft) propose(req proto.InternalRaftCommand) {
-	nr <- req
+// A proposal is a command proposed to a raft group by this node.
+type proposal struct {
+	cmd       proto.InternalRaftCommand
+	attempts  int       // Number of times the command has been submitted
+	submitted time.Time // Time of the most recent submission
 }
 
-func (nr noopRaft) committed() <-chan proto.InternalRaftCommand {
-	return nr
+// multiRaft is an implementation of the raft interface which
+// replicates commands via a multiraft.MultiRaft instance. Each range
+// is a consensus group keyed by its RaftID.
+//
+// Proposals are submitted by a single goroutine, in order per group.
+// Raft silently drops proposals which are in flight when a group's
+// leadership changes, so submitted proposals are tracked until they
+// are committed and re-proposed on a change of leader or when they
+// haven't been committed within raftProposalRetryTicks.
+type multiRaft struct {
+	mr       *multiraft.MultiRaft
+	commitCh chan committedEntry
+	stopper  chan struct{}
+	ready    chan struct{} // Signals the proposer that proposals are pending
+
+	mu       sync.Mutex             // Protects the following fields
+	leaders  map[uint64]uint64      // Known leaders by group ID
+	pending  map[uint64][]*proposal // Proposals awaiting submission
+	inflight map[uint64][]*proposal // Submitted proposals awaiting commit
+}
+
+// newMultiRaft creates and starts a MultiRaft instance for the given
+// node ID, communicating over transport and persisting raft state to
+// storage.
+func newMultiRaft(nodeID uint64, transport multiraft.Transport, storage multiraft.Storage) (*multiRaft, error) {
+	mr, err := multiraft.NewMultiRaft(nodeID, &multiraft.Config{
+		Transport:              transport,
+		Storage:                storage,
+		TickInterval:           raftTickInterval,
+		ElectionTimeoutTicks:   raftElectionTimeoutTicks,
+		HeartbeatIntervalTicks: raftHeartbeatIntervalTicks,
+	})
+	if err != nil {
+		return nil, err
+	}
+	m := &multiRaft{
+		mr:       mr,
+		commitCh: make(chan committedEntry, 10),
+		stopper:  make(chan struct{}),
+		ready:    make(chan struct{}, 1),
+		leaders:  map[uint64]uint64{},
+		pending:  map[uint64][]*proposal{},
+		inflight: map[uint64][]*proposal{},
+	}
+	mr.Start()
+	go m.processEvents()
+	go m.processProposals()
+	return m, nil
+}
+
+// createGroup implements the raft interface.
+func (m *multiRaft) createGroup(raftID int64, members []uint64) error {
+	return m.mr.CreateGroup(uint64(raftID), members)
+}
+
+// removeGroup implements the raft interface. Proposals which haven't
+// been committed are dropped.
+func (m *multiRaft) removeGroup(raftID int64) error {
+	groupID := uint64(raftID)
+	m.mu.Lock()
+	delete(m.leaders, groupID)
+	delete(m.pending, groupID)
+	delete(m.inflight, groupID)
+	m.mu.Unlock()
+	return m.mr.RemoveGroup(groupID)
+}
+
+// propose implements the raft interface. The command is queued for
+// the proposer, which holds it back until the group has elected a
+// leader, as there is no node to forward the proposal to before.
+func (m *multiRaft) propose(cmd proto.InternalRaftCommand) {
+	groupID := uint64(cmd.RaftID)
+	m.mu.Lock()
+	m.pending[groupID] = append(m.pending[groupID], &proposal{cmd: cmd})
+	m.mu.Unlock()
+	m.signalReady()
+}
+
+// signalReady wakes the proposer without blocking.
+func (m *multiRaft) signalReady() {
+	select {
+	case m.ready <- struct{}{}:
+	default:
+	}
+}
+
+// processProposals submits pending proposals to their raft groups and
+// periodically re-proposes those which haven't been committed. Runs
+// until the multiRaft is stopped.
+func (m *multiRaft) processProposals() {
+	ticker := time.NewTicker(raftTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ready:
+		case <-ticker.C:
+			for _, p := range m.retryProposals(time.Now()) {
+				m.abandon(p, util.Errorf("raft command %+v not committed after %d attempts",
+					p.cmd.CmdID, p.attempts))
+			}
+		case <-m.stopper:
+			return
+		}
+		for _, p := range m.nextProposals(time.Now()) {
+			data, err := gogoproto.Marshal(&p.cmd)
+			if err != nil {
+				log.Fatalf("unable to marshal raft command %+v: %s", p.cmd, err)
+			}
+			// Submitting blocks on the MultiRaft state machine, which is
+			// why proposals aren't submitted by processEvents.
+			if err := m.mr.SubmitCommand(uint64(p.cmd.RaftID), data); err != nil {
+				m.removeProposal(uint64(p.cmd.RaftID), p.cmd.CmdID)
+				m.abandon(p, util.Errorf("unable to submit command to raft group %d: %s", p.cmd.RaftID, err))
+			}
+		}
+	}
+}
+
+// nextProposals returns the pending proposals of all groups which
+// have a leader in the order they were queued, moving them to the
+// groups' inflight proposals.
+func (m *multiRaft) nextProposals(now time.Time) []*proposal {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var props []*proposal
+	for groupID, pending := range m.pending {
+		if _, ok := m.leaders[groupID]; !ok {
+			continue
+		}
+		for _, p := range pending {
+			p.attempts++
+			p.submitted = now
+		}
+		props = append(props, pending...)
+		m.inflight[groupID] = append(m.inflight[groupID], pending...)
+		delete(m.pending, groupID)
+	}
+	return props
+}
+
+// retryProposals queues the inflight proposals which have gone
+// uncommitted for raftProposalRetryTicks for resubmission ahead of
+// the group's pending proposals. The proposals which have been
+// submitted raftProposalMaxAttempts times are dropped instead and
+// returned.
+func (m *multiRaft) retryProposals(now time.Time) []*proposal {
+	timeout := time.Duration(raftProposalRetryTicks) * raftTickInterval
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var abandoned []*proposal
+	for groupID, inflight := range m.inflight {
+		var retry, remaining []*proposal
+		for _, p := range inflight {
+			switch {
+			case now.Sub(p.submitted) < timeout:
+				remaining = append(remaining, p)
+			case p.attempts >= raftProposalMaxAttempts:
+				abandoned = append(abandoned, p)
+			default:
+				retry = append(retry, p)
+			}
+		}
+		if len(retry) > 0 {
+			m.pending[groupID] = append(retry, m.pending[groupID]...)
+		}
+		if len(remaining) > 0 {
+			m.inflight[groupID] = remaining
+		} else {
+			delete(m.inflight, groupID)
+		}
+	}
+	return abandoned
+}
+
+// removeProposal stops tracking the group's proposal of the command
+// with the given ID, whether pending or inflight.
+func (m *multiRaft) removeProposal(groupID uint64, cmdID proto.ClientCmdID) {
+	idKey := makeCmdIDKey(cmdID)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, props := range []map[uint64][]*proposal{m.pending, m.inflight} {
+		for i, p := range props[groupID] {
+			if makeCmdIDKey(p.cmd.CmdID) == idKey {
+				props[groupID] = append(props[groupID][:i], props[groupID][i+1:]...)
+				break
+			}
+		}
+		if len(props[groupID]) == 0 {
+			delete(props, groupID)
+		}
+	}
+}
+
+// abandon reports the failure of a proposal on the commit channel.
+func (m *multiRaft) abandon(p *proposal, err error) {
+	log.Warning(err)
+	select {
+	case m.commitCh <- committedEntry{raftID: p.cmd.RaftID, cmd: p.cmd, err: err}:
+	case <-m.stopper:
+	}
+}
+
+// committed implements the raft interface.
+func (m *multiRaft) committed() <-chan committedEntry {
+	return m.commitCh
+}
+
+// compact implements the raft interface.
+func (m *multiRaft) compact(raftID int64, index uint64, data []byte) error {
+	return m.mr.Compact(uint64(raftID), index, data)
+}
+
+// changeMembership implements the raft interface.
+func (m *multiRaft) changeMembership(raftID int64, changeType proto.ReplicaChangeType, nodeID uint64) error {
+	op := multiraft.ChangeMembershipAddMember
+	if changeType == proto.REMOVE_REPLICA {
+		op = multiraft.ChangeMembershipRemoveMember
+	}
+	return m.mr.ChangeGroupMembership(uint64(raftID), op, nodeID)
+}
+
+// stop implements the raft interface.
+func (m *multiRaft) stop() {
+	close(m.stopper)
+	m.mr.Stop()
+}
+
+// processEvents consumes events from the MultiRaft instance, tracking
+// group leadership and decoding committed commands, membership
+// changes and snapshots onto the commit channel. Runs until the
+// multiRaft is stopped.
+func (m *multiRaft) processEvents() {
+	for {
+		select {
+		case e := <-m.mr.Events:
+			switch e := e.(type) {
+			case *multiraft.EventLeaderElection:
+				m.mu.Lock()
+				if e.NodeID == 0 {
+					delete(m.leaders, e.GroupID)
+					m.mu.Unlock()
+					continue
+				}
+				m.leaders[e.GroupID] = e.NodeID
+				// Proposals in flight under the previous leader may have been
+				// dropped and are re-proposed, in order, ahead of those pending.
+				if inflight := m.inflight[e.GroupID]; len(inflight) > 0 {
+					m.pending[e.GroupID] = append(inflight, m.pending[e.GroupID]...)
+					delete(m.inflight, e.GroupID)
+				}
+				m.mu.Unlock()
+				m.signalReady()
+
+			case *multiraft.EventCommandCommitted:
+				entry := committedEntry{raftID: int64(e.GroupID), index: e.Index}
+				if err := gogoproto.Unmarshal(e.Command, &entry.cmd); err != nil {
+					log.Fatalf("unable to unmarshal committed raft command for group %d: %s", e.GroupID, err)
+				}
+				m.removeProposal(e.GroupID, entry.cmd.CmdID)
+				select {
+				case m.commitCh <- entry:
+				case <-m.stopper:
+					return
+				}
+
+			case *multiraft.EventMembershipChangeCommitted:
+				entry := committedEntry{raftID: int64(e.GroupID), index: e.Index,
+					change: &memberChange{changeType: proto.ADD_REPLICA, nodeID: e.NodeID}}
+				if e.Operation == multiraft.ChangeMembershipRemoveMember {
+					entry.change.changeType = proto.REMOVE_REPLICA
+				}
+				select {
+				case m.commitCh <- entry:
+				case <-m.stopper:
+					return
+				}
+
+			case *multiraft.EventSnapshotReceived:
+				entry := committedEntry{raftID: int64(e.GroupID), index: e.Index, snapshot: e.Data}
+				if entry.snapshot == nil {
+					entry.snapshot = []byte{}
+				}
+				select {
+				case m.commitCh <- entry:
+				case <-m.stopper:
+					return
+				}
+
+			default:
+				log.Warningf("unhandled raft event %T", e)
+			}
+
+		case <-m.stopper:
+			return
+		}
+	}
 }
@@ -0,0 +1,171 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/multiraft"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// raftStorage is an implementation of multiraft.Storage which
+// persists the Raft log and hard state of every consensus group on a
+// store to the store's engine. Group state is stored under
+// range-local keys derived from the group's Raft ID (see
+// engine.RaftLogKey and engine.RaftStateKey), so a store which is
+// restarted can reload the state of each of its groups.
+type raftStorage struct {
+	engine engine.Engine
+}
+
+// Verifying implementation of Storage interface.
+var _ multiraft.Storage = (*raftStorage)(nil)
+
+// newRaftStorage returns a raftStorage backed by the given engine.
+func newRaftStorage(eng engine.Engine) *raftStorage {
+	return &raftStorage{engine: eng}
+}
+
+// LoadGroups implements the multiraft.Storage interface. The state
+// of each group is read from the engine in a separate goroutine.
+func (rs *raftStorage) LoadGroups() <-chan *multiraft.GroupPersistentState {
+	ch := make(chan *multiraft.GroupPersistentState)
+	go func() {
+		defer close(ch)
+		start := engine.KeyLocalRaftStatePrefix
+		end := start.PrefixEnd()
+		if err := engine.MVCCIterateCommitted(rs.engine, start, end, func(kv proto.KeyValue) (bool, error) {
+			_, raftID := encoding.DecodeInt(kv.Key[len(engine.KeyLocalRaftStatePrefix):])
+			state := &multiraft.GroupPersistentState{GroupID: uint64(raftID)}
+			if err := gogoproto.Unmarshal(kv.Value.Bytes, &state.HardState); err != nil {
+				return false, err
+			}
+			ch <- state
+			return false, nil
+		}); err != nil {
+			log.Errorf("unable to load raft group state: %s", err)
+		}
+	}()
+	return ch
+}
+
+// SetGroupState implements the multiraft.Storage interface.
+func (rs *raftStorage) SetGroupState(groupID uint64, state *multiraft.GroupPersistentState) error {
+	return engine.MVCCPutProto(rs.engine, nil, engine.RaftStateKey(int64(groupID)),
+		proto.ZeroTimestamp, nil, &state.HardState)
+}
+
+// AppendLogEntries implements the multiraft.Storage interface. The
+// entries are written in a single batch along with the removal of
+// any previously-written entries which they supersede.
+func (rs *raftStorage) AppendLogEntries(groupID uint64, entries []*multiraft.LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	batch := rs.engine.NewBatch()
+	// Entries following the last new entry were proposed under an
+	// earlier term and have been overwritten; remove them.
+	last := entries[len(entries)-1].Entry.Index
+	if err := rs.truncateLog(batch, groupID, last); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		key := engine.RaftLogKey(int64(groupID), entry.Entry.Index)
+		if err := engine.MVCCPutProto(batch, nil, key, proto.ZeroTimestamp, nil, &entry.Entry); err != nil {
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// TruncateLog implements the multiraft.Storage interface.
+func (rs *raftStorage) TruncateLog(groupID uint64, lastIndex int) error {
+	if lastIndex < 0 {
+		return util.Errorf("invalid raft log index %d", lastIndex)
+	}
+	return rs.truncateLog(rs.engine, groupID, uint64(lastIndex))
+}
+
+// truncateLog removes all log entries of the group with an index
+// greater than lastIndex from the supplied engine, which may be a
+// batch.
+func (rs *raftStorage) truncateLog(eng engine.Engine, groupID uint64, lastIndex uint64) error {
+	return clearSpan(eng, keySpan{
+		start: engine.MVCCEncodeKey(engine.RaftLogKey(int64(groupID), lastIndex+1)),
+		end:   engine.MVCCEncodeKey(engine.RaftLogPrefix(int64(groupID)).PrefixEnd()),
+	})
+}
+
+// CompactLog implements the multiraft.Storage interface.
+func (rs *raftStorage) CompactLog(groupID uint64, lastIndex int) error {
+	if lastIndex < 0 {
+		return util.Errorf("invalid raft log index %d", lastIndex)
+	}
+	return clearSpan(rs.engine, keySpan{
+		start: engine.MVCCEncodeKey(engine.RaftLogPrefix(int64(groupID))),
+		end:   engine.MVCCEncodeKey(engine.RaftLogKey(int64(groupID), uint64(lastIndex)+1)),
+	})
+}
+
+// GetLogEntry implements the multiraft.Storage interface.
+func (rs *raftStorage) GetLogEntry(groupID uint64, index int) (*multiraft.LogEntry, error) {
+	if index < 0 {
+		return nil, nil
+	}
+	entry := &multiraft.LogEntry{}
+	ok, err := engine.MVCCGetProto(rs.engine, engine.RaftLogKey(int64(groupID), uint64(index)),
+		proto.ZeroTimestamp, nil, &entry.Entry)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// GetLogEntries implements the multiraft.Storage interface. A missing
+// entry within the requested span is reported as an error.
+func (rs *raftStorage) GetLogEntries(groupID uint64, firstIndex, lastIndex int,
+	ch chan<- *multiraft.LogEntryState) {
+	defer close(ch)
+	if firstIndex < 0 || lastIndex < firstIndex {
+		ch <- &multiraft.LogEntryState{Error: util.Errorf("invalid raft log span [%d, %d]", firstIndex, lastIndex)}
+		return
+	}
+	start := engine.RaftLogKey(int64(groupID), uint64(firstIndex))
+	end := engine.RaftLogKey(int64(groupID), uint64(lastIndex+1))
+	index := firstIndex
+	err := engine.MVCCIterateCommitted(rs.engine, start, end, func(kv proto.KeyValue) (bool, error) {
+		state := &multiraft.LogEntryState{Index: index}
+		if err := gogoproto.Unmarshal(kv.Value.Bytes, &state.Entry.Entry); err != nil {
+			return false, err
+		}
+		if int(state.Entry.Entry.Index) != index {
+			return false, util.Errorf("raft log for group %d missing entry %d", groupID, index)
+		}
+		ch <- state
+		index++
+		return false, nil
+	})
+	if err == nil && index <= lastIndex {
+		err = util.Errorf("raft log for group %d missing entry %d", groupID, index)
+	}
+	if err != nil {
+		ch <- &multiraft.LogEntryState{Index: index, Error: err}
+	}
+}
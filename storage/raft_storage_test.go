@@ -0,0 +1,150 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/multiraft"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+func makeLogEntries(term uint64, first, last uint64) []*multiraft.LogEntry {
+	var entries []*multiraft.LogEntry
+	for i := first; i <= last; i++ {
+		entries = append(entries, &multiraft.LogEntry{Entry: raftpb.Entry{
+			Term:  term,
+			Index: i,
+			Data:  []byte{byte(i)},
+		}})
+	}
+	return entries
+}
+
+// TestRaftStorageGroupState verifies that hard state written for a
+// group is reloaded by a new raftStorage on the same engine.
+func TestRaftStorageGroupState(t *testing.T) {
+	eng := engine.NewInMem(proto.Attributes{}, 1<<20)
+	rs := newRaftStorage(eng)
+	states := map[uint64]raftpb.HardState{
+		1: {Term: 3, Vote: 1, Commit: 5},
+		7: {Term: 1, Vote: 2, Commit: 1},
+	}
+	for groupID, hs := range states {
+		if err := rs.SetGroupState(groupID, &multiraft.GroupPersistentState{GroupID: groupID, HardState: hs}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded := map[uint64]raftpb.HardState{}
+	for state := range newRaftStorage(eng).LoadGroups() {
+		loaded[state.GroupID] = state.HardState
+	}
+	if !reflect.DeepEqual(states, loaded) {
+		t.Errorf("expected loaded group state %+v; got %+v", states, loaded)
+	}
+}
+
+// TestRaftStorageLog verifies appending, overwriting, reading and
+// truncating log entries.
+func TestRaftStorageLog(t *testing.T) {
+	eng := engine.NewInMem(proto.Attributes{}, 1<<20)
+	rs := newRaftStorage(eng)
+
+	if err := rs.AppendLogEntries(1, makeLogEntries(1, 1, 5)); err != nil {
+		t.Fatal(err)
+	}
+	// Entries of another group must not be affected by writes to group 1.
+	if err := rs.AppendLogEntries(2, makeLogEntries(1, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	// Overwrite the tail of the log with entries from a later term.
+	if err := rs.AppendLogEntries(1, makeLogEntries(2, 3, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expTerm := range []uint64{0, 1, 1, 2} {
+		entry, err := rs.GetLogEntry(1, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			if entry != nil {
+				t.Errorf("expected no entry at index 0; got %+v", entry)
+			}
+			continue
+		}
+		if entry == nil || entry.Entry.Index != uint64(i) || entry.Entry.Term != expTerm {
+			t.Errorf("%d: expected entry with term %d; got %+v", i, expTerm, entry)
+		}
+	}
+	if entry, err := rs.GetLogEntry(1, 4); entry != nil || err != nil {
+		t.Errorf("expected overwritten entry 4 to be removed; got %+v, %v", entry, err)
+	}
+
+	ch := make(chan *multiraft.LogEntryState, 10)
+	rs.GetLogEntries(2, 1, 2, ch)
+	var count int
+	for state := range ch {
+		count++
+		if state.Error != nil || state.Index != count || state.Entry.Entry.Index != uint64(count) {
+			t.Errorf("unexpected log entry state %+v", state)
+		}
+	}
+	if count != 2 {
+		t.Errorf("expected 2 entries; got %d", count)
+	}
+
+	// Truncate group 2 and verify a read past the end reports an error.
+	if err := rs.TruncateLog(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	ch = make(chan *multiraft.LogEntryState, 10)
+	rs.GetLogEntries(2, 1, 2, ch)
+	var states []*multiraft.LogEntryState
+	for state := range ch {
+		states = append(states, state)
+	}
+	if len(states) != 2 || states[0].Error != nil || states[1].Error == nil {
+		t.Errorf("expected one entry followed by an error; got %+v", states)
+	}
+}
+
+// TestRaftStorageCompactLog verifies that compacting the log removes
+// only the entries up to and including the compacted index.
+func TestRaftStorageCompactLog(t *testing.T) {
+	eng := engine.NewInMem(proto.Attributes{}, 1<<20)
+	rs := newRaftStorage(eng)
+
+	if err := rs.AppendLogEntries(1, makeLogEntries(1, 1, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.CompactLog(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		entry, err := rs.GetLogEntry(1, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compacted := i <= 3; compacted != (entry == nil) {
+			t.Errorf("%d: expected compacted=%t; got entry %+v", i, compacted, entry)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/multiraft"
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+func init() {
+	// Shorten the Raft tick interval so that tests don't wait long for
+	// newly-created consensus groups to elect a leader.
+	raftTickInterval = 10 * time.Millisecond
+}
+
+// TestMultiRaftProposeCommitted verifies that a command proposed to a
+// single-member group is committed and decoded onto the committed
+// channel, even when proposed before the group has elected a leader.
+func TestMultiRaftProposeCommitted(t *testing.T) {
+	r, err := newMultiRaft(1, multiraft.NewLocalRPCTransport(), multiraft.NewMemoryStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.stop()
+
+	if err := r.createGroup(1, []uint64{1}); err != nil {
+		t.Fatal(err)
+	}
+	cmd := proto.InternalRaftCommand{RaftID: 1}
+	cmd.Cmd.SetValue(&proto.PutRequest{
+		RequestHeader: proto.RequestHeader{Key: proto.Key("a")},
+		Value:         proto.Value{Bytes: []byte("value")},
+	})
+	r.propose(cmd)
+
+	select {
+	case committed := <-r.committed():
+		put, ok := committed.cmd.Cmd.GetValue().(*proto.PutRequest)
+		if committed.raftID != 1 || committed.cmd.RaftID != 1 || committed.index == 0 ||
+			committed.snapshot != nil || !ok || !put.Key.Equal(proto.Key("a")) {
+			t.Errorf("expected committed command %+v; got %+v", cmd, committed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for command to commit")
+	}
+}
+
+// TestMultiRaftProposeOrdered verifies that commands are committed in
+// the order they were proposed, including those proposed before the
+// group elected a leader.
+func TestMultiRaftProposeOrdered(t *testing.T) {
+	r, err := newMultiRaft(1, multiraft.NewLocalRPCTransport(), multiraft.NewMemoryStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.stop()
+
+	if err := r.createGroup(1, []uint64{1}); err != nil {
+		t.Fatal(err)
+	}
+	const count = 20
+	for i := 0; i < count; i++ {
+		cmd := proto.InternalRaftCommand{RaftID: 1, CmdID: proto.ClientCmdID{WallTime: 1, Random: int64(i)}}
+		cmd.Cmd.SetValue(&proto.PutRequest{RequestHeader: proto.RequestHeader{Key: proto.Key("a")}})
+		r.propose(cmd)
+		if i == count/2 {
+			time.Sleep(10 * raftTickInterval)
+		}
+	}
+	for i := 0; i < count; i++ {
+		select {
+		case committed := <-r.committed():
+			if committed.err != nil || committed.cmd.CmdID.Random != int64(i) {
+				t.Fatalf("expected command %d to commit; got %+v", i, committed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for command %d to commit", i)
+		}
+	}
+}
+
+// TestMultiRaftRetryProposals verifies that proposals which aren't
+// committed in time are re-proposed ahead of pending proposals, and
+// abandoned after raftProposalMaxAttempts submissions.
+func TestMultiRaftRetryProposals(t *testing.T) {
+	m := &multiRaft{
+		leaders:  map[uint64]uint64{1: 1},
+		pending:  map[uint64][]*proposal{},
+		inflight: map[uint64][]*proposal{},
+	}
+	newCmd := func(random int64) proto.InternalRaftCommand {
+		return proto.InternalRaftCommand{RaftID: 1, CmdID: proto.ClientCmdID{WallTime: 1, Random: random}}
+	}
+	now := time.Unix(0, 0)
+	m.pending[1] = []*proposal{{cmd: newCmd(1)}, {cmd: newCmd(2)}}
+	if props := m.nextProposals(now); len(props) != 2 {
+		t.Fatalf("expected 2 proposals; got %d", len(props))
+	}
+	m.pending[1] = []*proposal{{cmd: newCmd(3)}}
+	// Command 1 commits; command 2 is retried once it times out.
+	m.removeProposal(1, newCmd(1).CmdID)
+	if abandoned := m.retryProposals(now); len(abandoned) != 0 || len(m.inflight[1]) != 1 {
+		t.Fatalf("expected no retries before timeout; got %+v, %+v", abandoned, m.inflight[1])
+	}
+	timeout := time.Duration(raftProposalRetryTicks) * raftTickInterval
+	for attempt := 1; attempt < raftProposalMaxAttempts; attempt++ {
+		now = now.Add(timeout)
+		if abandoned := m.retryProposals(now); len(abandoned) != 0 {
+			t.Fatalf("expected no abandoned proposals at attempt %d; got %+v", attempt, abandoned)
+		}
+		props := m.nextProposals(now)
+		if attempt == 1 {
+			if len(props) != 2 || props[0].cmd.CmdID.Random != 2 || props[1].cmd.CmdID.Random != 3 {
+				t.Fatalf("expected commands 2 and 3 to be proposed in order; got %+v", props)
+			}
+			m.removeProposal(1, newCmd(3).CmdID)
+		} else if len(props) != 1 || props[0].cmd.CmdID.Random != 2 {
+			t.Fatalf("expected command 2 to be re-proposed; got %+v", props)
+		}
+	}
+	now = now.Add(timeout)
+	abandoned := m.retryProposals(now)
+	if len(abandoned) != 1 || abandoned[0].cmd.CmdID.Random != 2 || abandoned[0].attempts != raftProposalMaxAttempts {
+		t.Errorf("expected command 2 to be abandoned; got %+v", abandoned)
+	}
+	if len(m.pending) != 0 || len(m.inflight) != 0 {
+		t.Errorf("expected no remaining proposals; got %+v, %+v", m.pending, m.inflight)
+	}
+}
+
+// TestRaftMembers verifies the mapping of range replicas to Raft node IDs.
+func TestRaftMembers(t *testing.T) {
+	desc := &proto.RangeDescriptor{
+		Replicas: []proto.Replica{{StoreID: 1}, {StoreID: 3}},
+	}
+	if members := raftMembers(desc); !reflect.DeepEqual(members, []uint64{1, 3}) {
+		t.Errorf("expected members [1 3]; got %v", members)
+	}
+}
@@ -35,6 +35,7 @@ import (
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
 	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
 )
@@ -58,6 +59,37 @@ var (
 	// it may be aborted by conflicting txns.
 	DefaultHeartbeatInterval = 5 * time.Second
 
+	// DefaultLeaderLeaseDuration is the duration of the leader lease
+	// granted to a replica. While a replica holds a valid lease, it may
+	// serve reads without a round trip through Raft.
+	DefaultLeaderLeaseDuration = 1 * time.Second
+
+	// raftLogCompactionThreshold is the number of Raft log entries
+	// applied to a range since its last snapshot after which a new
+	// snapshot is taken and the log is compacted.
+	raftLogCompactionThreshold uint64 = 1000
+
+	// maxSnapshotSize is the maximum size of the data of a Raft
+	// snapshot. A snapshot holds the range's entire contents in memory,
+	// so a range which has grown past this size, far beyond the size at
+	// which ranges are split, isn't snapshotted and its log isn't
+	// compacted until it has been split.
+	maxSnapshotSize int64 = 512 << 20
+
+	// replicaChangeTimeout is the maximum duration for which an
+	// AdminChangeReplicas command waits for the resulting change to the
+	// Raft group's membership to be applied.
+	replicaChangeTimeout = 10 * time.Second
+
+	// replicaChangeRetryInterval is the interval after which a change
+	// to the Raft group's membership which hasn't been applied is
+	// proposed again.
+	replicaChangeRetryInterval = 1 * time.Second
+
+	// mergeCheckInterval is the minimum interval between checks of
+	// whether a range has shrunk enough to be merged.
+	mergeCheckInterval = 10 * time.Second
+
 	// ttlClusterIDGossip is time-to-live for cluster ID. The cluster ID
 	// serves as the sentinel gossip key which informs a node whether or
 	// not it's connected to the primary gossip network and not just a
@@ -92,6 +124,7 @@ var tsCacheMethods = map[string]struct{}{
 	proto.ConditionalPut:        struct{}{},
 	proto.Increment:             struct{}{},
 	proto.Scan:                  struct{}{},
+	proto.ReverseScan:           struct{}{},
 	proto.Delete:                struct{}{},
 	proto.DeleteRange:           struct{}{},
 	proto.AccumulateTS:          struct{}{},
@@ -101,6 +134,16 @@ var tsCacheMethods = map[string]struct{}{
 	proto.InternalResolveIntent: struct{}{},
 }
 
+// updateMethods specifies the set of methods which may be enqueued
+// for asynchronous execution via EnqueueUpdate.
+var updateMethods = map[string]struct{}{
+	proto.Put:          struct{}{},
+	proto.Increment:    struct{}{},
+	proto.Delete:       struct{}{},
+	proto.DeleteRange:  struct{}{},
+	proto.AccumulateTS: struct{}{},
+}
+
 // UsesTimestampCache returns true if the method affects or is
 // affected by the timestamp cache.
 func UsesTimestampCache(method string) bool {
@@ -139,10 +182,14 @@ type RangeManager interface {
 	// Range manipulation methods.
 	NewRangeDescriptor(start, end proto.Key, replicas []proto.Replica) (*proto.RangeDescriptor, error)
 	SplitRange(origRng, newRng *Range) error
-	AddRange(rng *Range)
+	MergeRange(subsumingRng *Range, updatedEndKey proto.Key, subsumedRaftID int64) error
+	AddRange(rng *Range) error
+	CommitSnapshot(rng *Range, desc *proto.RangeDescriptor, batch engine.Engine) error
 	RemoveRange(rng *Range) error
 	CreateSnapshot() (string, error)
 	ProposeRaftCommand(proto.InternalRaftCommand)
+	CompactRaftLog(raftID int64, index uint64, data []byte) error
+	ChangeRaftMembership(raftID int64, changeType proto.ReplicaChangeType, storeID int32) error
 }
 
 // A Range is a contiguous keyspace with writes managed via an
@@ -155,14 +202,24 @@ type Range struct {
 	RangeID   int64
 	Desc      *proto.RangeDescriptor
 	rm        RangeManager  // Makes some store methods available
-	splitting int32         // 1 if a split is underway; updated atomically
+	splitting int32         // 1 if a split or merge is underway; updated atomically
+	changing  int32         // 1 if a replica change is underway; updated atomically
 	closer    chan struct{} // Channel for closing the range
+	// Physical time of the last check for a merge; updated atomically
+	lastMergeCheck int64
 
 	sync.RWMutex                 // Protects the following fields (and Desc)
 	cmdQ         *CommandQueue   // Enforce at most one command is running per key(s)
 	tsCache      *TimestampCache // Most recent timestamps for keys / key ranges
 	respCache    *ResponseCache  // Provides idempotence for retries
+	txnWaitQ     *TxnWaitQueue   // Pushers waiting on transactions anchored here
 	pendingCmds  map[cmdIDKey]*pendingCmd
+	lease        *proto.Lease        // Most recently granted leader lease; nil if none
+	appliedIndex uint64              // Index of the last applied Raft log entry
+	snapIndex    uint64              // Index of the last snapshot of the range
+	forceCompact bool                // Compact the Raft log after the next applied entry
+	compacted    chan error          // Notified of the next forced compaction; may be nil
+	confWatchers []chan memberChange // Notified of applied membership changes
 }
 
 // NewRange initializes the range using the given metadata.
@@ -175,6 +232,7 @@ func NewRange(rangeID int64, desc *proto.RangeDescriptor, rm RangeManager) *Rang
 		cmdQ:        NewCommandQueue(),
 		tsCache:     NewTimestampCache(rm.Clock()),
 		respCache:   NewResponseCache(rangeID, rm.Engine()),
+		txnWaitQ:    NewTxnWaitQueue(),
 		pendingCmds: map[cmdIDKey]*pendingCmd{},
 	}
 	return r
@@ -184,6 +242,8 @@ func NewRange(rangeID int64, desc *proto.RangeDescriptor, rm RangeManager) *Rang
 // range in the map and gossips config information if the range
 // contains any of the configuration maps.
 func (r *Range) start() {
+	r.loadAppliedIndex()
+	r.loadLeaderLease()
 	r.maybeGossipClusterID()
 	r.maybeGossipFirstRange()
 	r.maybeGossipConfigs(configDescriptors...)
@@ -198,6 +258,21 @@ func (r *Range) stop() {
 	close(r.closer)
 }
 
+// failPendingCmds signals the commands proposed to Raft by this range
+// which have not yet been executed with a RangeNotFoundError. It's
+// invoked when the range is removed from its store, after which it
+// executes no further commands.
+func (r *Range) failPendingCmds() {
+	r.Lock()
+	defer r.Unlock()
+	for idKey, cmd := range r.pendingCmds {
+		err := proto.NewRangeNotFoundError(r.RangeID)
+		cmd.Reply.Header().SetGoError(err)
+		cmd.done <- err
+		delete(r.pendingCmds, idKey)
+	}
+}
+
 // Destroy cleans up all data associated with this range.
 func (r *Range) Destroy() error {
 	start := engine.MVCCEncodeKey(proto.Key(r.Desc.StartKey))
@@ -216,11 +291,41 @@ func (r *Range) Destroy() error {
 	if err := engine.ClearRangeStats(r.rm.Engine(), r.RangeID); err != nil {
 		return util.Errorf("unable to clear range stats for range %d: %s", r.RangeID, err)
 	}
+	start = engine.MVCCEncodeKey(engine.RangeLeaderLeaseKey(r.Desc.RaftID))
+	end = engine.MVCCEncodeKey(engine.RangeLeaderLeaseKey(r.Desc.RaftID).Next())
+	if _, err := engine.ClearRange(r.rm.Engine(), start, end); err != nil {
+		return util.Errorf("unable to clear leader lease for range %d: %s", r.RangeID, err)
+	}
+	start = engine.MVCCEncodeKey(engine.RangeGCMetadataKey(r.Desc.RaftID))
+	end = engine.MVCCEncodeKey(engine.RangeGCMetadataKey(r.Desc.RaftID).Next())
+	if _, err := engine.ClearRange(r.rm.Engine(), start, end); err != nil {
+		return util.Errorf("unable to clear GC metadata for range %d: %s", r.RangeID, err)
+	}
+	start = engine.MVCCEncodeKey(engine.RaftAppliedIndexKey(r.Desc.RaftID))
+	end = engine.MVCCEncodeKey(engine.RaftAppliedIndexKey(r.Desc.RaftID).Next())
+	if _, err := engine.ClearRange(r.rm.Engine(), start, end); err != nil {
+		return util.Errorf("unable to clear applied index for range %d: %s", r.RangeID, err)
+	}
+	start = engine.MVCCEncodeKey(engine.RaftLogPrefix(r.Desc.RaftID))
+	end = engine.MVCCEncodeKey(engine.RaftLogPrefix(r.Desc.RaftID).PrefixEnd())
+	if _, err := engine.ClearRange(r.rm.Engine(), start, end); err != nil {
+		return util.Errorf("unable to clear raft log for range %d: %s", r.RangeID, err)
+	}
+	start = engine.MVCCEncodeKey(engine.RaftStateKey(r.Desc.RaftID))
+	end = engine.MVCCEncodeKey(engine.RaftStateKey(r.Desc.RaftID).Next())
+	if _, err := engine.ClearRange(r.rm.Engine(), start, end); err != nil {
+		return util.Errorf("unable to clear raft state for range %d: %s", r.RangeID, err)
+	}
 	start = engine.MVCCEncodeKey(makeRangeKey(r.Desc.StartKey))
 	end = engine.MVCCEncodeKey(makeRangeKey(r.Desc.StartKey).Next())
 	if _, err := engine.ClearRange(r.rm.Engine(), start, end); err != nil {
 		return util.Errorf("unable to clear metadata for range %d: %s", r.RangeID, err)
 	}
+	start = engine.MVCCEncodeKey(engine.RangeMergeFreezeKey(r.Desc.StartKey))
+	end = engine.MVCCEncodeKey(engine.RangeMergeFreezeKey(r.Desc.StartKey).Next())
+	if _, err := engine.ClearRange(r.rm.Engine(), start, end); err != nil {
+		return util.Errorf("unable to clear merge freeze for range %d: %s", r.RangeID, err)
+	}
 	return nil
 }
 
@@ -235,9 +340,112 @@ func (r *Range) IsLeader() bool {
 	return true
 }
 
-// GetReplica returns the replica for this range from the range descriptor.
+// loadLeaderLease reads the most recently granted leader lease from
+// the engine, if one exists.
+func (r *Range) loadLeaderLease() {
+	lease := &proto.Lease{}
+	ok, err := engine.MVCCGetProto(r.rm.Engine(), engine.RangeLeaderLeaseKey(r.Desc.RaftID), proto.ZeroTimestamp, nil, lease)
+	if err != nil {
+		log.Errorf("unable to load leader lease for range %d: %s", r.RangeID, err)
+		return
+	}
+	if ok {
+		r.Lock()
+		r.lease = lease
+		r.Unlock()
+	}
+}
+
+// getLeaderLease returns the most recently granted leader lease, or
+// nil if no lease has been granted for this range.
+func (r *Range) getLeaderLease() *proto.Lease {
+	r.RLock()
+	defer r.RUnlock()
+	return r.lease
+}
+
+// newNotLeaderError returns a NotLeaderError identifying the holder
+// of the supplied lease, if known.
+func (r *Range) newNotLeaderError(lease *proto.Lease) error {
+	err := &proto.NotLeaderError{}
+	if lease != nil {
+		err.Leader = lease.Replica
+	}
+	return err
+}
+
+// holderExpiration returns the timestamp after which the holder of
+// the lease stops using it. Another replica may acquire the lease
+// once its own clock passes the lease's expiration, and the holder's
+// clock may lag that replica's by up to the maximum clock offset, so
+// the holder gives up the lease that much earlier.
+func (r *Range) holderExpiration(lease *proto.Lease) proto.Timestamp {
+	expiration := lease.Expiration
+	expiration.WallTime -= r.rm.Clock().MaxOffset().Nanoseconds()
+	return expiration
+}
+
+// verifyLeaderLease returns nil if this replica holds a leader lease
+// which is valid at the supplied timestamp (see holderExpiration).
+// Otherwise, returns a NotLeaderError identifying the current lease
+// holder, if any.
+func (r *Range) verifyLeaderLease(timestamp proto.Timestamp) error {
+	lease := r.getLeaderLease()
+	if lease != nil && lease.Replica.StoreID == r.rm.StoreID() &&
+		!timestamp.Less(lease.Start) && timestamp.Less(r.holderExpiration(lease)) {
+		return nil
+	}
+	if lease != nil && !timestamp.Less(lease.Expiration) {
+		// The lease has expired; the former holder is no better a guess
+		// at the leader than any other replica.
+		lease = nil
+	}
+	return r.newNotLeaderError(lease)
+}
+
+// redirectOnOrAcquireLeaderLease verifies that this replica holds a
+// valid leader lease. If another replica holds a valid lease, returns
+// a NotLeaderError identifying it. If no replica holds a valid lease,
+// or this replica's lease is within the maximum clock offset of its
+// expiration, this replica requests one via Raft, blocking until the
+// request has been committed and applied.
+func (r *Range) redirectOnOrAcquireLeaderLease() error {
+	now := r.rm.Clock().Now()
+	if lease := r.getLeaderLease(); lease != nil {
+		if lease.Replica.StoreID == r.rm.StoreID() && now.Less(r.holderExpiration(lease)) ||
+			lease.Replica.StoreID != r.rm.StoreID() && now.Less(lease.Expiration) {
+			return r.verifyLeaderLease(now)
+		}
+	}
+	replica := r.GetReplica()
+	if replica == nil {
+		return util.Errorf("store %d does not contain a replica of range %d", r.rm.StoreID(), r.RangeID)
+	}
+	expiration := now
+	expiration.WallTime += DefaultLeaderLeaseDuration.Nanoseconds()
+	args := &proto.InternalLeaderLeaseRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:       r.Desc.StartKey,
+			Timestamp: now,
+			User:      UserRoot,
+		},
+		Lease: proto.Lease{
+			Start:      now,
+			Expiration: expiration,
+			Replica:    *replica,
+		},
+	}
+	reply := &proto.InternalLeaderLeaseResponse{}
+	if err := r.addReadWriteCmd(proto.InternalLeaderLease, args, reply, true); err != nil {
+		return err
+	}
+	return r.verifyLeaderLease(now)
+}
+
+// GetReplica returns the replica for this range from the range
+// descriptor, or nil if the replica has been removed from the range.
 func (r *Range) GetReplica() *proto.Replica {
-	return r.Desc.FindReplica(r.rm.StoreID())
+	return storeReplica(r.Desc, r.rm.StoreID())
 }
 
 // ContainsKey returns whether this range contains the specified key.
@@ -303,6 +511,10 @@ func (r *Range) addAdminCmd(method string, args proto.Request, reply proto.Respo
 	switch method {
 	case proto.AdminSplit:
 		r.AdminSplit(args.(*proto.AdminSplitRequest), reply.(*proto.AdminSplitResponse))
+	case proto.AdminMerge:
+		r.AdminMerge(args.(*proto.AdminMergeRequest), reply.(*proto.AdminMergeResponse))
+	case proto.AdminChangeReplicas:
+		r.AdminChangeReplicas(args.(*proto.AdminChangeReplicasRequest), reply.(*proto.AdminChangeReplicasResponse))
 	default:
 		return util.Errorf("unrecognized admin command type: %s", method)
 	}
@@ -315,6 +527,13 @@ func (r *Range) addAdminCmd(method string, args proto.Request, reply proto.Respo
 func (r *Range) addReadOnlyCmd(method string, args proto.Request, reply proto.Response) error {
 	header := args.Header()
 
+	// Reads are served only by the holder of the leader lease, which is
+	// acquired here if no replica currently holds it.
+	if err := r.redirectOnOrAcquireLeaderLease(); err != nil {
+		reply.Header().SetGoError(err)
+		return err
+	}
+
 	// Add the read to the command queue to gate subsequent
 	// overlapping, commands until this command completes.
 	cmdKey := r.beginCmd(header.Key, header.EndKey, true)
@@ -334,11 +553,14 @@ func (r *Range) addReadOnlyCmd(method string, args proto.Request, reply proto.Re
 	// timestamps. This is because the read-timestamp-cache prevents it
 	// for the active leader and leadership changes force the
 	// read-timestamp-cache to reset its low water mark.
-	if !r.IsLeader() {
-		// TODO(spencer): when we happen to know the leader, fill it in here via replica.
-		return &proto.NotLeaderError{}
+	if err := r.verifyLeaderLease(r.rm.Clock().Now()); err != nil {
+		r.Lock()
+		r.cmdQ.Remove(cmdKey)
+		r.Unlock()
+		reply.Header().SetGoError(err)
+		return err
 	}
-	err := r.executeCmd(method, args, reply)
+	err := r.executeCmd(0, method, args, reply)
 
 	// Only update the timestamp cache if the command succeeded.
 	r.Lock()
@@ -429,6 +651,14 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 	raftCmd := proto.InternalRaftCommand{
 		RaftID: r.Desc.RaftID,
 	}
+	if ok := raftCmd.Cmd.SetValue(args); !ok {
+		r.Lock()
+		r.cmdQ.Remove(cmdKey)
+		r.Unlock()
+		err := util.Errorf("cannot propose %s command via raft", method)
+		reply.Header().SetGoError(err)
+		return err
+	}
 	if !args.Header().CmdID.IsEmpty() {
 		raftCmd.CmdID = args.Header().CmdID
 	} else {
@@ -440,9 +670,8 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 	r.Lock()
 	r.pendingCmds[makeCmdIDKey(raftCmd.CmdID)] = pendingCmd
 	r.Unlock()
-	// TODO(bdarnell): In certain raft failover scenarios, proposed
-	// commands may be abandoned. We need to re-propose the command
-	// if too much time passes with no response on the done channel.
+	// Raft re-proposes the command until it commits, or reports it via
+	// abandonRaftCommand if it gives up.
 	r.rm.ProposeRaftCommand(raftCmd)
 
 	// Create a completion func for mandatory cleanups which we either
@@ -476,9 +705,20 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 	return nil
 }
 
-func (r *Range) processRaftCommand(raftCmd proto.InternalRaftCommand) {
+// processRaftCommand executes a command which raft has committed at
+// the given log index and records the index as applied. The caller
+// waiting on the command, if any, is then notified of the result.
+func (r *Range) processRaftCommand(raftCmd proto.InternalRaftCommand, index uint64) {
 	idKey := makeCmdIDKey(raftCmd.CmdID)
 	r.Lock()
+	// A restarted raft group replays its committed log; commands which
+	// were applied before the restart are skipped.
+	if index <= r.appliedIndex {
+		r.Unlock()
+		log.V(1).Infof("range %d: skipping raft command at index %d; already applied %d",
+			r.RangeID, index, r.appliedIndex)
+		return
+	}
 	cmd := r.pendingCmds[idKey]
 	delete(r.pendingCmds, idKey)
 	r.Unlock()
@@ -503,13 +743,285 @@ func (r *Range) processRaftCommand(raftCmd proto.InternalRaftCommand) {
 		if err != nil {
 			log.Fatal(err)
 		}
+		// A command which was re-proposed may be committed more than
+		// once; a cached response means it has already been executed.
+		if ok, err := r.respCache.hasResponse(args.Header().CmdID); err != nil {
+			log.Errorf("unable to read response cache of range %d: %s", r.RangeID, err)
+		} else if ok {
+			log.V(1).Infof("range %d: skipping replayed raft command %+v at index %d",
+				r.RangeID, raftCmd.CmdID, index)
+			if err := r.setAppliedIndex(r.rm.Engine(), index); err != nil {
+				log.Errorf("unable to persist applied index %d for range %d: %s", index, r.RangeID, err)
+			}
+			r.Lock()
+			r.appliedIndex = index
+			r.Unlock()
+			return
+		}
+	}
+	err = r.executeCmd(index, method, args, reply)
+	// A command which failed or didn't write left the engine unchanged,
+	// so its index is recorded as applied on its own.
+	if err != nil || !proto.IsReadWrite(method) {
+		if err := r.setAppliedIndex(r.rm.Engine(), index); err != nil {
+			log.Errorf("unable to persist applied index %d for range %d: %s", index, r.RangeID, err)
+		}
 	}
-	err = r.executeCmd(method, args, reply)
+	r.Lock()
+	r.appliedIndex = index
+	r.Unlock()
 	if cmd != nil {
 		cmd.done <- err
 	} else if err != nil {
 		log.Errorf("error executing raft command: %s", err)
 	}
+	r.maybeCompactRaftLog(index)
+}
+
+// abandonRaftCommand signals the caller waiting on a command which
+// raft gave up proposing with the supplied error. The command may
+// still be committed later, in which case it's executed as though it
+// had been proposed elsewhere.
+func (r *Range) abandonRaftCommand(raftCmd proto.InternalRaftCommand, err error) {
+	idKey := makeCmdIDKey(raftCmd.CmdID)
+	r.Lock()
+	cmd := r.pendingCmds[idKey]
+	delete(r.pendingCmds, idKey)
+	r.Unlock()
+	if cmd != nil {
+		cmd.Reply.Header().SetGoError(err)
+		cmd.done <- err
+	}
+}
+
+// loadAppliedIndex reads the index of the last applied Raft log entry
+// from the engine, if one has been recorded.
+func (r *Range) loadAppliedIndex() {
+	val, err := engine.MVCCGet(r.rm.Engine(), engine.RaftAppliedIndexKey(r.Desc.RaftID), proto.ZeroTimestamp, nil)
+	if err != nil {
+		log.Errorf("unable to load applied index for range %d: %s", r.RangeID, err)
+		return
+	}
+	if val != nil {
+		r.Lock()
+		r.appliedIndex = uint64(val.GetInteger())
+		r.snapIndex = r.appliedIndex
+		r.Unlock()
+	}
+}
+
+// setAppliedIndex writes index as the last applied Raft log entry to
+// the supplied engine, which may be a batch.
+func (r *Range) setAppliedIndex(eng engine.Engine, index uint64) error {
+	value := proto.Value{Integer: gogoproto.Int64(int64(index))}
+	return engine.MVCCPut(eng, nil, engine.RaftAppliedIndexKey(r.Desc.RaftID), proto.ZeroTimestamp, value, nil)
+}
+
+// maybeCompactRaftLog takes a snapshot of the range and compacts its
+// Raft log through index if raftLogCompactionThreshold entries have
+// been applied since the last snapshot, or if a compaction has been
+// forced by a change to the range's replicas. The engine snapshot is
+// taken synchronously so that it reflects exactly the entries up to
+// index; the snapshot data is assembled and handed to raft
+// asynchronously.
+func (r *Range) maybeCompactRaftLog(index uint64) {
+	r.Lock()
+	force := r.forceCompact
+	if index <= r.snapIndex || (!force && index-r.snapIndex < raftLogCompactionThreshold) {
+		r.Unlock()
+		return
+	}
+	r.snapIndex = index
+	r.forceCompact = false
+	var done chan error
+	if force {
+		done, r.compacted = r.compacted, nil
+	}
+	desc := *r.Desc
+	r.Unlock()
+
+	finish := func(err error) {
+		if err != nil {
+			log.Error(err)
+		} else {
+			log.V(1).Infof("compacted raft log of range %d through index %d", r.RangeID, index)
+		}
+		if done != nil {
+			done <- err
+		}
+	}
+	snapshotID, err := r.rm.CreateSnapshot()
+	if err != nil {
+		finish(util.Errorf("unable to create snapshot of range %d: %s", r.RangeID, err))
+		return
+	}
+	go func() {
+		defer func() {
+			if err := r.rm.Engine().ReleaseSnapshot(snapshotID); err != nil {
+				log.Warningf("unable to release snapshot %s of range %d: %s", snapshotID, r.RangeID, err)
+			}
+		}()
+		data, err := r.snapshotData(&desc, snapshotID)
+		if err != nil {
+			finish(util.Errorf("unable to read snapshot of range %d: %s", r.RangeID, err))
+			return
+		}
+		if err := r.rm.CompactRaftLog(desc.RaftID, index, data); err != nil {
+			finish(util.Errorf("unable to compact raft log of range %d: %s", r.RangeID, err))
+			return
+		}
+		finish(nil)
+	}()
+}
+
+// A keySpan is a span of encoded keys from start up to but not
+// including end.
+type keySpan struct {
+	start, end proto.EncodedKey
+}
+
+// snapshotSpans returns the spans of the engine which hold the data
+// of a replica of the range described by desc, whose range ID is
+// rangeID. This covers the range's key/value data, its transaction
+// records, response cache, range descriptor, leader lease, GC metadata
+// and merge freeze. Range stats and the applied index are specific to each
+// replica and are not included.
+func snapshotSpans(desc *proto.RangeDescriptor, rangeID int64) []keySpan {
+	// The first range's data excludes local keys.
+	dataStart := desc.StartKey
+	if dataStart.Less(engine.KeyLocalMax) {
+		dataStart = engine.KeyLocalMax
+	}
+	rangeKey := makeRangeKey(desc.StartKey)
+	leaseKey := engine.RangeLeaderLeaseKey(desc.RaftID)
+	gcMetaKey := engine.RangeGCMetadataKey(desc.RaftID)
+	freezeKey := engine.RangeMergeFreezeKey(desc.StartKey)
+	respCachePrefix := responseCacheKeyPrefix(rangeID)
+	return []keySpan{
+		{engine.MVCCEncodeKey(rangeKey), engine.MVCCEncodeKey(rangeKey.Next())},
+		{engine.MVCCEncodeKey(leaseKey), engine.MVCCEncodeKey(leaseKey.Next())},
+		{engine.MVCCEncodeKey(gcMetaKey), engine.MVCCEncodeKey(gcMetaKey.Next())},
+		{engine.MVCCEncodeKey(freezeKey), engine.MVCCEncodeKey(freezeKey.Next())},
+		{engine.MVCCEncodeKey(engine.MakeKey(engine.KeyLocalTransactionPrefix, desc.StartKey)),
+			engine.MVCCEncodeKey(engine.MakeKey(engine.KeyLocalTransactionPrefix, desc.EndKey))},
+		{engine.MVCCEncodeKey(respCachePrefix), engine.MVCCEncodeKey(respCachePrefix.PrefixEnd())},
+		{engine.MVCCEncodeKey(dataStart), engine.MVCCEncodeKey(desc.EndKey)},
+	}
+}
+
+// clearSpan removes all keys within the span from the supplied
+// engine, which may be a batch.
+func clearSpan(eng engine.Engine, span keySpan) error {
+	var keys []proto.EncodedKey
+	if err := eng.Iterate(span.start, span.end, func(kv proto.RawKeyValue) (bool, error) {
+		keys = append(keys, kv.Key)
+		return false, nil
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := eng.Clear(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotData reads the contents of the range described by desc
+// from the engine snapshot with the given ID and returns them
+// serialized as a RaftSnapshotData message. Returns an error if the
+// contents exceed maxSnapshotSize.
+func (r *Range) snapshotData(desc *proto.RangeDescriptor, snapshotID string) ([]byte, error) {
+	snap := &proto.RaftSnapshotData{
+		RangeDescriptor: *desc,
+		RangeID:         r.RangeID,
+	}
+	var size int64
+	for _, span := range snapshotSpans(desc, r.RangeID) {
+		if err := r.rm.Engine().IterateSnapshot(span.start, span.end, snapshotID, func(kv proto.RawKeyValue) (bool, error) {
+			size += int64(len(kv.Key) + len(kv.Value))
+			if size > maxSnapshotSize {
+				return true, util.Errorf("snapshot of range %d exceeds maximum size of %d bytes", r.RangeID, maxSnapshotSize)
+			}
+			snap.KV = append(snap.KV, kv)
+			return false, nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return gogoproto.Marshal(snap)
+}
+
+// applySnapshot replaces the contents of the range with the snapshot
+// data, which reflects all Raft log entries up to and including
+// index. The range's existing data is cleared and the snapshot is
+// written in a single batch, re-keying response cache entries for
+// this replica's range ID and recomputing the range stats. The
+// store commits the batch and installs the snapshot's descriptor,
+// whose key span may differ from the range's. Snapshots which are no
+// newer than the last applied entry are ignored.
+func (r *Range) applySnapshot(data []byte, index uint64) error {
+	r.RLock()
+	appliedIndex := r.appliedIndex
+	oldDesc := r.Desc
+	r.RUnlock()
+	if index <= appliedIndex {
+		log.V(1).Infof("ignoring snapshot of range %d at index %d; already applied %d", r.RangeID, index, appliedIndex)
+		return nil
+	}
+	snap := &proto.RaftSnapshotData{}
+	if err := gogoproto.Unmarshal(data, snap); err != nil {
+		return util.Errorf("unable to decode snapshot of range %d: %s", r.RangeID, err)
+	}
+	if snap.RangeDescriptor.RaftID != oldDesc.RaftID {
+		return util.Errorf("snapshot of raft group %d cannot be applied to range %d of raft group %d",
+			snap.RangeDescriptor.RaftID, r.RangeID, oldDesc.RaftID)
+	}
+
+	batch := r.rm.Engine().NewBatch()
+	// Clear the spans of both the existing and the new descriptor, as
+	// the range may have split since the replica's data was written.
+	spans := append(snapshotSpans(oldDesc, r.RangeID), snapshotSpans(&snap.RangeDescriptor, r.RangeID)...)
+	for _, span := range spans {
+		if err := clearSpan(batch, span); err != nil {
+			return util.Errorf("unable to clear data of range %d: %s", r.RangeID, err)
+		}
+	}
+	for _, kv := range snap.KV {
+		key := kv.Key
+		if k, _, _ := engine.MVCCDecodeKey(kv.Key); bytes.HasPrefix(k, engine.KeyLocalResponseCachePrefix) {
+			cmdID, err := r.respCache.decodeKey(kv.Key)
+			if err != nil {
+				return util.Errorf("unable to decode response cache key in snapshot of range %d: %s", r.RangeID, err)
+			}
+			key = engine.MVCCEncodeKey(responseCacheKey(r.RangeID, cmdID))
+		}
+		if err := batch.Put(key, kv.Value); err != nil {
+			return err
+		}
+	}
+	ms, err := engine.MVCCComputeStats(batch, snap.RangeDescriptor.StartKey, snap.RangeDescriptor.EndKey)
+	if err != nil {
+		return util.Errorf("unable to compute stats for range %d from snapshot: %s", r.RangeID, err)
+	}
+	ms.SetStats(batch, r.RangeID, 0)
+	if err := r.setAppliedIndex(batch, index); err != nil {
+		return err
+	}
+	if err := r.rm.CommitSnapshot(r, &snap.RangeDescriptor, batch); err != nil {
+		return util.Errorf("unable to apply snapshot to range %d: %s", r.RangeID, err)
+	}
+
+	r.Lock()
+	r.appliedIndex = index
+	r.snapIndex = index
+	r.lease = nil
+	// Commands applied via the snapshot were never seen by this replica.
+	r.tsCache.Clear(r.rm.Clock())
+	r.Unlock()
+	r.loadLeaderLease()
+	log.Infof("applied snapshot of range %d at index %d", r.RangeID, index)
+	return nil
 }
 
 // startGossip periodically gossips the cluster ID if it's the
@@ -618,13 +1130,11 @@ func (r *Range) ShouldSplit() bool {
 	}
 
 	// Fetch the zone config for the zone containing this range's start key.
-	zoneMap, err := r.rm.Gossip().GetInfo(gossip.KeyConfigZone)
-	if err != nil || zoneMap == nil {
+	zone, err := r.getZoneConfig()
+	if err != nil {
 		log.Errorf("unable to fetch zone config from gossip: %s", err)
 		return false
 	}
-	prefixConfig := zoneMap.(PrefixConfigMap).MatchByPrefix(r.Desc.StartKey)
-	zone := prefixConfig.Config.(*proto.ZoneConfig)
 
 	// Fetch the current size of this range in total bytes.
 	rangeSize, err := engine.GetRangeSize(r.rm.Engine(), r.RangeID)
@@ -636,6 +1146,70 @@ func (r *Range) ShouldSplit() bool {
 	return rangeSize > zone.RangeMaxBytes
 }
 
+// getZoneConfig returns the gossiped zone config for the zone
+// containing this range's start key.
+func (r *Range) getZoneConfig() (*proto.ZoneConfig, error) {
+	zoneMap, err := r.rm.Gossip().GetInfo(gossip.KeyConfigZone)
+	if err != nil || zoneMap == nil {
+		return nil, util.Errorf("no zone config available: %v", err)
+	}
+	prefixConfig := zoneMap.(PrefixConfigMap).MatchByPrefix(r.Desc.StartKey)
+	return prefixConfig.Config.(*proto.ZoneConfig), nil
+}
+
+// schemaScanBatchSize is the number of structured schemas read at a
+// time by holdsStructuredRows.
+const schemaScanBatchSize = 100
+
+// holdsStructuredRows returns whether the range overlaps the rows of
+// a structured schema. The schemas are stored under KeySchemaPrefix,
+// and the keys of a schema's rows share the ordered encoding of the
+// schema key as prefix (see the structured package).
+func (r *Range) holdsStructuredRows() (bool, error) {
+	r.RLock()
+	start, end := r.Desc.StartKey, r.Desc.EndKey
+	r.RUnlock()
+	key := engine.KeySchemaPrefix
+	for {
+		reply := &proto.ScanResponse{}
+		if err := r.rm.DB().Call(proto.Scan, &proto.ScanRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:    key,
+				EndKey: engine.KeySchemaPrefix.PrefixEnd(),
+			},
+			MaxResults: schemaScanBatchSize,
+		}, reply); err != nil {
+			return false, err
+		}
+		for _, kv := range reply.Rows {
+			prefix := proto.Key(encoding.EncodeString(nil, string(kv.Key[len(engine.KeySchemaPrefix):])))
+			if prefix.Less(end) && start.Less(prefix.PrefixEnd()) {
+				return true, nil
+			}
+		}
+		if len(reply.Rows) < schemaScanBatchSize {
+			return false, nil
+		}
+		key = reply.Rows[len(reply.Rows)-1].Key.Next()
+	}
+}
+
+// maybeNotifyTxnWaiters passes the transaction record written by a
+// successful EndTransaction, InternalHeartbeatTxn or InternalPushTxn
+// command to the pushers waiting on the transaction.
+func (r *Range) maybeNotifyTxnWaiters(method string, reply proto.Response) {
+	var txn *proto.Transaction
+	switch method {
+	case proto.EndTransaction, proto.InternalHeartbeatTxn:
+		txn = reply.Header().Txn
+	case proto.InternalPushTxn:
+		txn = reply.(*proto.InternalPushTxnResponse).PusheeTxn
+	}
+	if txn != nil {
+		r.txnWaitQ.UpdateTxn(txn)
+	}
+}
+
 // maybeSplit initiates an asynchronous split via AdminSplit request
 // if ShouldSplit is true. This operation is invoked after each
 // successful execution of a read/write command.
@@ -655,8 +1229,90 @@ func (r *Range) maybeSplit() {
 	}
 }
 
+// ShouldMerge returns whether the current size of the range has fallen
+// below the min size specified in the zone config. The last range has
+// no range to merge with.
+func (r *Range) ShouldMerge() bool {
+	// If not the leader or gossip is not enabled, ignore.
+	if !r.IsLeader() || r.rm.Gossip() == nil {
+		return false
+	}
+	r.RLock()
+	lastRange := bytes.Equal(r.Desc.EndKey, engine.KeyMax)
+	r.RUnlock()
+	if lastRange {
+		return false
+	}
+
+	zone, err := r.getZoneConfig()
+	if err != nil {
+		log.Errorf("unable to fetch zone config from gossip: %s", err)
+		return false
+	}
+	rangeSize, err := engine.GetRangeSize(r.rm.Engine(), r.RangeID)
+	if err != nil {
+		log.Errorf("unable to compute size from stats for range %d: %s", r.RangeID, err)
+		return false
+	}
+
+	return rangeSize < zone.RangeMinBytes
+}
+
+// maybeMerge initiates an asynchronous merge with the range which
+// follows this one via AdminMerge request if ShouldMerge is true. This
+// operation is invoked after deletions from the range. As ShouldMerge
+// looks up the zone config and size of the range, the check is made
+// at most once per mergeCheckInterval.
+func (r *Range) maybeMerge() {
+	// If we're already splitting or merging, ignore.
+	if atomic.LoadInt32(&r.splitting) == int32(1) {
+		return
+	}
+	now := r.rm.Clock().PhysicalNow()
+	last := atomic.LoadInt64(&r.lastMergeCheck)
+	if last != 0 && now-last < mergeCheckInterval.Nanoseconds() {
+		return
+	}
+	if !atomic.CompareAndSwapInt64(&r.lastMergeCheck, last, now) {
+		return
+	}
+	if r.ShouldMerge() {
+		// Admin commands run synchronously, so run this in a goroutine.
+		go r.AddCmd(proto.AdminMerge, &proto.AdminMergeRequest{
+			RequestHeader: proto.RequestHeader{Key: r.Desc.StartKey},
+		}, &proto.AdminMergeResponse{}, false)
+	}
+}
+
+// spansConfigBoundary returns whether the span from start to end
+// crosses a boundary of the gossiped accounting or zone configs.
+// Ranges are split along these boundaries and must not be merged
+// across them.
+func (r *Range) spansConfigBoundary(start, end proto.Key) (bool, error) {
+	for _, key := range []string{gossip.KeyConfigAccounting, gossip.KeyConfigZone} {
+		info, err := r.rm.Gossip().GetInfo(key)
+		if err != nil || info == nil {
+			continue
+		}
+		configMap, ok := info.(PrefixConfigMap)
+		if !ok {
+			return false, util.Errorf("gossiped info is not a prefix configuration map: %+v", info)
+		}
+		splits, err := configMap.SplitRangeByPrefixes(start, end)
+		if err != nil {
+			return false, err
+		}
+		if len(splits) > 1 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // executeCmd switches over the method and multiplexes to execute the
-// appropriate storage API command.
+// appropriate storage API command. A non-zero index is the Raft log
+// index of the command, which is recorded as applied in the same batch
+// as the command's effects.
 //
 // TODO(Spencer): Differentiate between errors caused by the normal culprits --
 // bad inputs from clients, stale information, etc. and errors which might
@@ -665,7 +1321,7 @@ func (r *Range) maybeSplit() {
 // errors which should be classified as a ReplicaCorruptionError--when those
 // bubble up to the point where we've just tried to execute a Raft command, the
 // Raft replica would need to stall itself.
-func (r *Range) executeCmd(method string, args proto.Request, reply proto.Response) error {
+func (r *Range) executeCmd(index uint64, method string, args proto.Request, reply proto.Response) error {
 	// Verify key is contained within range here to catch any range split
 	// or merge activity.
 	header := args.Header()
@@ -674,6 +1330,19 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 		reply.Header().SetGoError(err)
 		return err
 	}
+	// A range which is frozen for a merge doesn't change its data, as
+	// the range preceding it is about to take it over. Clients retry
+	// against the merged range once the merge has completed.
+	if mergeFreezeBlocks(method, args) {
+		frozen, err := mergeFrozen(r.rm.Engine(), r.Desc.StartKey)
+		if err == nil && frozen {
+			err = proto.NewRangeKeyMismatchError(header.Key, header.EndKey, nil)
+		}
+		if err != nil {
+			reply.Header().SetGoError(err)
+			return err
+		}
+	}
 
 	// Create a new batch for the command to ensure all or nothing semantics.
 	batch := r.rm.Engine().NewBatch()
@@ -697,16 +1366,18 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 		r.DeleteRange(batch, ms, args.(*proto.DeleteRangeRequest), reply.(*proto.DeleteRangeResponse))
 	case proto.Scan:
 		r.Scan(batch, args.(*proto.ScanRequest), reply.(*proto.ScanResponse))
+	case proto.ReverseScan:
+		r.ReverseScan(batch, args.(*proto.ReverseScanRequest), reply.(*proto.ReverseScanResponse))
 	case proto.EndTransaction:
 		r.EndTransaction(batch, args.(*proto.EndTransactionRequest), reply.(*proto.EndTransactionResponse))
 	case proto.AccumulateTS:
-		r.AccumulateTS(batch, args.(*proto.AccumulateTSRequest), reply.(*proto.AccumulateTSResponse))
+		r.AccumulateTS(batch, ms, args.(*proto.AccumulateTSRequest), reply.(*proto.AccumulateTSResponse))
 	case proto.ReapQueue:
-		r.ReapQueue(batch, args.(*proto.ReapQueueRequest), reply.(*proto.ReapQueueResponse))
+		r.ReapQueue(batch, ms, args.(*proto.ReapQueueRequest), reply.(*proto.ReapQueueResponse))
 	case proto.EnqueueUpdate:
-		r.EnqueueUpdate(batch, args.(*proto.EnqueueUpdateRequest), reply.(*proto.EnqueueUpdateResponse))
+		r.EnqueueUpdate(batch, ms, args.(*proto.EnqueueUpdateRequest), reply.(*proto.EnqueueUpdateResponse))
 	case proto.EnqueueMessage:
-		r.EnqueueMessage(batch, args.(*proto.EnqueueMessageRequest), reply.(*proto.EnqueueMessageResponse))
+		r.EnqueueMessage(batch, ms, args.(*proto.EnqueueMessageRequest), reply.(*proto.EnqueueMessageResponse))
 	case proto.InternalRangeLookup:
 		r.InternalRangeLookup(batch, args.(*proto.InternalRangeLookupRequest), reply.(*proto.InternalRangeLookupResponse))
 	case proto.InternalHeartbeatTxn:
@@ -717,6 +1388,10 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 		r.InternalResolveIntent(batch, ms, args.(*proto.InternalResolveIntentRequest), reply.(*proto.InternalResolveIntentResponse))
 	case proto.InternalSnapshotCopy:
 		r.InternalSnapshotCopy(r.rm.Engine(), args.(*proto.InternalSnapshotCopyRequest), reply.(*proto.InternalSnapshotCopyResponse))
+	case proto.InternalLeaderLease:
+		r.InternalLeaderLease(batch, args.(*proto.InternalLeaderLeaseRequest), reply.(*proto.InternalLeaderLeaseResponse))
+	case proto.InternalGC:
+		r.InternalGC(batch, ms, args.(*proto.InternalGCRequest), reply.(*proto.InternalGCResponse))
 	default:
 		return util.Errorf("unrecognized command %q", method)
 	}
@@ -724,11 +1399,37 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 	// On success, flush the MVCC stats to the batch and commit.
 	if proto.IsReadWrite(method) && reply.Header().Error == nil {
 		ms.MergeStats(batch, r.RangeID, r.rm.StoreID())
-		if err := batch.Commit(); err != nil {
+		var err error
+		if index > 0 {
+			err = r.setAppliedIndex(batch, index)
+		}
+		if err == nil {
+			err = batch.Commit()
+		}
+		if err != nil {
 			reply.Header().SetGoError(err)
 		} else {
+			// If a leader lease was granted, install it.
+			if method == proto.InternalLeaderLease {
+				r.applyLeaderLease(&args.(*proto.InternalLeaderLeaseRequest).Lease)
+			}
+			// If the replica set changed, the Raft group must follow.
+			if method == proto.EndTransaction {
+				if change := args.(*proto.EndTransactionRequest).ChangeReplicasTrigger; change != nil &&
+					reply.(*proto.EndTransactionResponse).Txn.Status == proto.COMMITTED {
+					r.maybeProposeMemberChange(change)
+				}
+			}
+			// Wake any pushers waiting on a transaction whose record
+			// has changed.
+			r.maybeNotifyTxnWaiters(method, reply)
 			// If the commit succeeded, potentially initiate a split of this range.
 			r.maybeSplit()
+			// Deletions may have shrunk the range enough to merge it with
+			// the range which follows it.
+			if method == proto.Delete || method == proto.DeleteRange {
+				r.maybeMerge()
+			}
 		}
 	}
 
@@ -823,6 +1524,15 @@ func (r *Range) Scan(batch engine.Engine, args *proto.ScanRequest, reply *proto.
 	reply.SetGoError(err)
 }
 
+// ReverseScan scans the key range specified by start key through end
+// key in descending order, up to some maximum number of results. The
+// last key before the end key is returned first.
+func (r *Range) ReverseScan(batch engine.Engine, args *proto.ReverseScanRequest, reply *proto.ReverseScanResponse) {
+	kvs, err := engine.MVCCReverseScan(batch, args.Key, args.EndKey, args.MaxResults, args.Timestamp, args.Txn)
+	reply.Rows = kvs
+	reply.SetGoError(err)
+}
+
 // EndTransaction either commits or aborts (rolls back) an extant
 // transaction according to the args.Commit parameter.
 func (r *Range) EndTransaction(batch engine.Engine, args *proto.EndTransactionRequest, reply *proto.EndTransactionResponse) {
@@ -898,8 +1608,13 @@ func (r *Range) EndTransaction(batch engine.Engine, args *proto.EndTransactionRe
 		reply.Txn.Status = proto.ABORTED
 	}
 
-	// Persist the transaction record with updated status (& possibly timestmap).
-	if err := engine.MVCCPutProto(batch, nil, key, proto.ZeroTimestamp, nil, reply.Txn); err != nil {
+	// Persist the transaction record with updated status (& possibly
+	// timestmap). The record of the finished transaction also lists the
+	// intents it wrote, which are resolved before the record is garbage
+	// collected.
+	record := gogoproto.Clone(reply.Txn).(*proto.Transaction)
+	record.Intents = args.Intents
+	if err := engine.MVCCPutProto(batch, nil, key, proto.ZeroTimestamp, nil, record); err != nil {
 		reply.SetGoError(err)
 		return
 	}
@@ -910,34 +1625,156 @@ func (r *Range) EndTransaction(batch engine.Engine, args *proto.EndTransactionRe
 		if args.SplitTrigger != nil {
 			reply.SetGoError(r.splitTrigger(batch, args.SplitTrigger))
 		}
+		if args.MergeTrigger != nil {
+			reply.SetGoError(r.mergeTrigger(batch, args.MergeTrigger))
+		}
+		if args.ChangeReplicasTrigger != nil {
+			reply.SetGoError(r.changeReplicasTrigger(args.ChangeReplicasTrigger))
+		}
 	}
 }
 
 // AccumulateTS is used internally to aggregate statistics over key
-// ranges throughout the distributed cluster.
-func (r *Range) AccumulateTS(batch engine.Engine, args *proto.AccumulateTSRequest, reply *proto.AccumulateTSResponse) {
-	reply.SetGoError(util.Error("unimplemented"))
-}
-
-// ReapQueue destructively queries messages from a delivery inbox
-// queue. This method must be called from within a transaction.
-func (r *Range) ReapQueue(batch engine.Engine, args *proto.ReapQueueRequest, reply *proto.ReapQueueResponse) {
-	reply.SetGoError(util.Error("unimplemented"))
-}
-
-// EnqueueUpdate sidelines an update for asynchronous execution.
-// AccumulateTS updates are sent this way. Eventually-consistent indexes
+// ranges throughout the distributed cluster. The data points are
+// merged into the time series stored at args.Key for the interval
+// described by args.Data. As merges can't be undone, AccumulateTS
+// may not be invoked within a transaction; transactional updates
+// are sent via EnqueueUpdate instead.
+func (r *Range) AccumulateTS(batch engine.Engine, ms *engine.MVCCStats, args *proto.AccumulateTSRequest, reply *proto.AccumulateTSResponse) {
+	if args.Txn != nil {
+		reply.SetGoError(util.Errorf("cannot accumulate time series within a transaction"))
+		return
+	}
+	if err := args.Data.Validate(); err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	value, err := args.Data.ToValue()
+	if err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	reply.SetGoError(engine.MVCCMerge(batch, ms, args.Key, *value))
+}
+
+// ReapQueue destructively queries messages from a delivery inbox
+// queue. This method must be called from within a transaction. Up to
+// args.MaxResults messages are read and deleted from the head of the
+// queue in the order they were enqueued.
+func (r *Range) ReapQueue(batch engine.Engine, ms *engine.MVCCStats, args *proto.ReapQueueRequest, reply *proto.ReapQueueResponse) {
+	if args.Txn == nil {
+		reply.SetGoError(util.Errorf("cannot reap queue %q outside of a transaction", args.Key))
+		return
+	}
+	if args.MaxResults <= 0 {
+		reply.SetGoError(util.Errorf("max results must be > 0: %d", args.MaxResults))
+		return
+	}
+	metaKey := engine.InboxMetadataKey(args.Key)
+	meta := &proto.InboxMetadata{}
+	if _, err := engine.MVCCGetProto(batch, metaKey, args.Timestamp, args.Txn, meta); err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	for ; meta.Head < meta.Tail && int64(len(reply.Messages)) < args.MaxResults; meta.Head++ {
+		msgKey := engine.InboxMessageKey(args.Key, meta.Head)
+		msg, err := engine.MVCCGet(batch, msgKey, args.Timestamp, args.Txn)
+		if err != nil {
+			reply.SetGoError(err)
+			return
+		}
+		if msg == nil {
+			reply.SetGoError(util.Errorf("message %d missing from queue %q", meta.Head, args.Key))
+			return
+		}
+		reply.Messages = append(reply.Messages, *msg)
+		if err := engine.MVCCDelete(batch, ms, msgKey, args.Timestamp, args.Txn); err != nil {
+			reply.SetGoError(err)
+			return
+		}
+	}
+	if len(reply.Messages) > 0 {
+		reply.SetGoError(engine.MVCCPutProto(batch, ms, metaKey, args.Timestamp, args.Txn, meta))
+	}
+}
+
+// EnqueueUpdate sidelines an update for asynchronous execution.
+// AccumulateTS updates are sent this way. Eventually-consistent indexes
 // are also built using update queues. Crucially, the enqueue happens
 // as part of the caller's transaction, so is guaranteed to be
-// executed if the transaction succeeded.
-func (r *Range) EnqueueUpdate(batch engine.Engine, args *proto.EnqueueUpdateRequest, reply *proto.EnqueueUpdateResponse) {
-	reply.SetGoError(util.Error("unimplemented"))
+// executed if the transaction succeeded. The update is stored under
+// the request's command ID and applied by the store's update queue
+// once committed.
+func (r *Range) EnqueueUpdate(batch engine.Engine, ms *engine.MVCCStats, args *proto.EnqueueUpdateRequest, reply *proto.EnqueueUpdateResponse) {
+	update, ok := args.Update.GetValue().(proto.Request)
+	if !ok {
+		reply.SetGoError(util.Errorf("no update specified to enqueue"))
+		return
+	}
+	method, err := proto.MethodForRequest(update)
+	if err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	if _, ok := updateMethods[method]; !ok {
+		reply.SetGoError(util.Errorf("cannot enqueue %s update", method))
+		return
+	}
+	if args.CmdID.IsEmpty() {
+		reply.SetGoError(util.Errorf("cannot enqueue update without a client command ID"))
+		return
+	}
+	if err := validateUpdate(update); err != nil {
+		reply.SetGoError(util.Errorf("invalid %s update: %s", method, err))
+		return
+	}
+	queued := &proto.QueuedUpdate{CmdID: args.CmdID, Update: args.Update}
+	reply.SetGoError(engine.MVCCPutProto(batch, ms, engine.UpdateQueueKey(args.Key, args.CmdID), args.Timestamp, args.Txn, queued))
+}
+
+// validateUpdate returns an error if the update could never be
+// applied, so that it's rejected when enqueued rather than left to
+// fail in the update queue.
+func validateUpdate(update proto.Request) error {
+	header := update.Header()
+	if err := verifyKeys(header.Key, header.EndKey); err != nil {
+		return err
+	}
+	if bytes.HasPrefix(header.Key, engine.KeyLocalPrefix) {
+		return util.Errorf("cannot update range-local key %q", header.Key)
+	}
+	switch t := update.(type) {
+	case *proto.PutRequest:
+		return t.Value.Verify(header.Key)
+	case *proto.DeleteRangeRequest:
+		if !header.Key.Less(header.EndKey) {
+			return util.Errorf("end key %q must be greater than start key %q", header.EndKey, header.Key)
+		}
+	case *proto.AccumulateTSRequest:
+		return t.Data.Validate()
+	}
+	return nil
 }
 
 // EnqueueMessage enqueues a message (Value) for delivery to a
-// recipient inbox.
-func (r *Range) EnqueueMessage(batch engine.Engine, args *proto.EnqueueMessageRequest, reply *proto.EnqueueMessageResponse) {
-	reply.SetGoError(util.Error("unimplemented"))
+// recipient inbox. The message is appended to the tail of the inbox
+// queue as part of the caller's transaction, if any. Concurrent
+// enqueues to the same inbox conflict on the queue's metadata and
+// are thereby serialized.
+func (r *Range) EnqueueMessage(batch engine.Engine, ms *engine.MVCCStats, args *proto.EnqueueMessageRequest, reply *proto.EnqueueMessageResponse) {
+	metaKey := engine.InboxMetadataKey(args.Key)
+	meta := &proto.InboxMetadata{}
+	if _, err := engine.MVCCGetProto(batch, metaKey, args.Timestamp, args.Txn, meta); err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	msgKey := engine.InboxMessageKey(args.Key, meta.Tail)
+	if err := engine.MVCCPut(batch, ms, msgKey, args.Timestamp, args.Msg, args.Txn); err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	meta.Tail++
+	reply.SetGoError(engine.MVCCPutProto(batch, ms, metaKey, args.Timestamp, args.Txn, meta))
 }
 
 // InternalRangeLookup is used to look up RangeDescriptors - a RangeDescriptor
@@ -1031,6 +1868,13 @@ func (r *Range) InternalHeartbeatTxn(batch engine.Engine, args *proto.InternalHe
 	// to the transaction in the request header.
 	if !ok {
 		gogoproto.Merge(&txn, args.Txn)
+		// The records of finished transactions are garbage collected
+		// after GCTransactionExpiration. A missing record of a
+		// transaction which started before then has been garbage
+		// collected and mustn't be recreated as pending.
+		if args.Header().Timestamp.WallTime-txn.OrigTimestamp.WallTime > GCTransactionExpiration.Nanoseconds() {
+			txn.Status = proto.ABORTED
+		}
 	}
 	if txn.Status == proto.PENDING {
 		if txn.LastHeartbeat == nil {
@@ -1044,6 +1888,8 @@ func (r *Range) InternalHeartbeatTxn(batch engine.Engine, args *proto.InternalHe
 			return
 		}
 	}
+	// The intents of a finished transaction are for garbage collection only.
+	txn.Intents = nil
 	reply.Txn = &txn
 }
 
@@ -1066,6 +1912,13 @@ func (r *Range) InternalHeartbeatTxn(batch engine.Engine, args *proto.InternalHe
 // the pushee txn should be either pushed forward or aborted,
 // depending on value of Request.Abort.
 //
+// Forced Push: If args.Force is set, the pusher is deadlocked with
+// the pushee and the pushee is pushed regardless of priority.
+//
+// Lowest Priority Push: If args.MinPriority is set and the pusher is
+// non-transactional, the pusher's priority is proto.MinTxnPriority
+// and the push prevails only for reasons other than priority.
+//
 // Old Txn Epoch: If persisted pushee txn entry has a newer Epoch than
 // PushTxn.Epoch, return success, as older epoch may be removed.
 //
@@ -1078,8 +1931,10 @@ func (r *Range) InternalHeartbeatTxn(batch engine.Engine, args *proto.InternalHe
 // args timestamp can advance during the txn).
 //
 // Higher Txn Priority: If pushee txn has a higher priority than
-// pusher, return TransactionPushError. Transaction will be retried
-// with priority one less than the pushee's higher priority.
+// pusher, return TransactionPushError. A transactional pusher waits
+// in the range's txn wait queue for the pushee to finish; otherwise,
+// the transaction will be retried with priority one less than the
+// pushee's higher priority.
 func (r *Range) InternalPushTxn(batch engine.Engine, args *proto.InternalPushTxnRequest, reply *proto.InternalPushTxnResponse) {
 	if !bytes.Equal(args.Key, args.PusheeTxn.ID) {
 		reply.SetGoError(util.Errorf("request key %q should match pushee's txn ID %q", args.Key, args.PusheeTxn.ID))
@@ -1097,6 +1952,7 @@ func (r *Range) InternalPushTxn(batch engine.Engine, args *proto.InternalPushTxn
 	if ok {
 		// Start with the persisted transaction record as final transaction.
 		reply.PusheeTxn = gogoproto.Clone(existTxn).(*proto.Transaction)
+		reply.PusheeTxn.Intents = nil
 		// Upgrade the epoch, timestamp and priority as necessary.
 		if reply.PusheeTxn.Epoch < args.PusheeTxn.Epoch {
 			reply.PusheeTxn.Epoch = args.PusheeTxn.Epoch
@@ -1139,10 +1995,13 @@ func (r *Range) InternalPushTxn(batch engine.Engine, args *proto.InternalPushTxn
 
 	// If there's no incoming transaction, the pusher is
 	// non-transactional. We make a random priority, biased by
-	// specified args.Header().UserPriority in this case.
+	// specified args.Header().UserPriority in this case, unless the
+	// lowest priority is requested.
 	var priority int32
 	if args.Txn != nil {
 		priority = args.Txn.Priority
+	} else if args.MinPriority {
+		priority = proto.MinTxnPriority
 	} else {
 		priority = proto.MakePriority(args.GetUserPriority())
 	}
@@ -1157,12 +2016,16 @@ func (r *Range) InternalPushTxn(batch engine.Engine, args *proto.InternalPushTxn
 	if reply.PusheeTxn.LastHeartbeat.Less(expiry) {
 		log.V(1).Infof("pushing expired txn %s", reply.PusheeTxn)
 		pusherWins = true
+	} else if args.Force {
+		// The pusher is deadlocked with the pushee.
+		log.V(1).Infof("pushing txn %s to break deadlock", reply.PusheeTxn)
+		pusherWins = true
 	} else if args.PusheeTxn.Epoch < reply.PusheeTxn.Epoch {
 		// Check for an intent from a prior epoch.
 		log.V(1).Infof("pushing intent from previous epoch for txn %s", reply.PusheeTxn)
 		pusherWins = true
 	} else if reply.PusheeTxn.Priority < priority ||
-		(reply.PusheeTxn.Priority == priority && args.Txn.Timestamp.Less(reply.PusheeTxn.Timestamp)) {
+		(reply.PusheeTxn.Priority == priority && args.Txn != nil && args.Txn.Timestamp.Less(reply.PusheeTxn.Timestamp)) {
 		// Finally, choose based on priority; if priorities are equal, order by lower txn timestamp.
 		log.V(1).Infof("pushing intent from txn with lower priority %s vs %d", reply.PusheeTxn, priority)
 		pusherWins = true
@@ -1240,6 +2103,112 @@ func (r *Range) InternalSnapshotCopy(e engine.Engine, args *proto.InternalSnapsh
 	reply.SetGoError(err)
 }
 
+// InternalGC garbage collects the keys listed in the request: the
+// expired MVCC versions of keys in the range, records of transactions
+// anchored in the range and entries of the range's response cache.
+// If the request carries GC metadata, it's recorded as the range's
+// latest GC and its GC bytes age replaces the range's stat.
+func (r *Range) InternalGC(batch engine.Engine, ms *engine.MVCCStats, args *proto.InternalGCRequest, reply *proto.InternalGCResponse) {
+	rcPrefix := responseCacheKeyPrefix(r.RangeID)
+	for _, gcKey := range args.Keys {
+		switch {
+		case bytes.HasPrefix(gcKey.Key, rcPrefix):
+		case bytes.HasPrefix(gcKey.Key, engine.KeyLocalTransactionPrefix),
+			!gcKey.Key.Less(engine.KeyLocalMax):
+			if !r.ContainsKey(gcKey.Key) {
+				reply.SetGoError(util.Errorf("key %q to garbage collect is not in range %d", gcKey.Key, r.RangeID))
+				return
+			}
+		default:
+			reply.SetGoError(util.Errorf("cannot garbage collect key %q", gcKey.Key))
+			return
+		}
+	}
+	if err := engine.MVCCGarbageCollect(batch, ms, args.Keys); err != nil {
+		reply.SetGoError(err)
+		return
+	}
+
+	if args.GCMeta != nil {
+		gcBytesAge, err := engine.GetRangeStat(batch, r.RangeID, engine.StatGCBytesAge)
+		if err != nil {
+			reply.SetGoError(err)
+			return
+		}
+		ms.GCBytesAge += args.GCMeta.GCBytesAge - gcBytesAge
+		reply.SetGoError(engine.MVCCPutProto(batch, nil, engine.RangeGCMetadataKey(r.Desc.RaftID), proto.ZeroTimestamp, nil, args.GCMeta))
+	}
+}
+
+// GetGCMetadata reads the metadata recorded by the range's latest
+// garbage collection. Returns a zero-valued metadata if the range
+// hasn't been garbage collected.
+func (r *Range) GetGCMetadata() (*proto.GCMetadata, error) {
+	gcMeta := &proto.GCMetadata{}
+	if _, err := engine.MVCCGetProto(r.rm.Engine(), engine.RangeGCMetadataKey(r.Desc.RaftID), proto.ZeroTimestamp, nil, gcMeta); err != nil {
+		return nil, err
+	}
+	return gcMeta, nil
+}
+
+// InternalLeaderLease sets the leader lease for this range. The lease
+// is granted unless the requesting replica is not a member of the
+// range or another replica holds a lease which has not yet expired
+// at the requested lease's start. A replica may always extend its
+// own lease. A lease no longer than the maximum clock offset is
+// rejected, as its holder could never use it (see holderExpiration).
+// The lease is persisted to the batch here and installed once the
+// batch has been committed (see applyLeaderLease).
+func (r *Range) InternalLeaderLease(batch engine.Engine, args *proto.InternalLeaderLeaseRequest, reply *proto.InternalLeaderLeaseResponse) {
+	if !args.Lease.Start.Less(r.holderExpiration(&args.Lease)) {
+		reply.SetGoError(util.Errorf("invalid leader lease %+v: expiration must follow start by more than the maximum clock offset %s",
+			args.Lease, r.rm.Clock().MaxOffset()))
+		return
+	}
+	if storeReplica(r.Desc, args.Lease.Replica.StoreID) == nil {
+		reply.SetGoError(util.Errorf("leader lease requested by store %d which does not contain a replica of range %d",
+			args.Lease.Replica.StoreID, r.RangeID))
+		return
+	}
+	if prev := r.getLeaderLease(); prev != nil && prev.Replica.StoreID != args.Lease.Replica.StoreID &&
+		args.Lease.Start.Less(prev.Expiration) {
+		reply.SetGoError(r.newNotLeaderError(prev))
+		return
+	}
+	if err := engine.MVCCPutProto(batch, nil, engine.RangeLeaderLeaseKey(r.Desc.RaftID), proto.ZeroTimestamp, nil, &args.Lease); err != nil {
+		reply.SetGoError(err)
+	}
+}
+
+// applyLeaderLease installs a newly granted leader lease. If this
+// replica has acquired the lease from a different holder, reads and
+// writes may have been served by the former holder which this
+// replica has no record of, so the timestamp cache is reset and any
+// commands inflight in the response cache are cleared.
+func (r *Range) applyLeaderLease(lease *proto.Lease) {
+	r.Lock()
+	defer r.Unlock()
+	prev := r.lease
+	r.lease = lease
+	if lease.Replica.StoreID != r.rm.StoreID() {
+		return
+	}
+	// Extending a lease this store already holds leaves its caches
+	// intact. Any other acquisition, including the range's first,
+	// starts with a cleared timestamp cache since reads may have been
+	// served elsewhere.
+	if prev != nil && prev.Replica.StoreID == lease.Replica.StoreID {
+		return
+	}
+	if prev != nil {
+		log.Infof("range %d: acquired leader lease from store %d", r.RangeID, prev.Replica.StoreID)
+	} else {
+		log.Infof("range %d: acquired leader lease", r.RangeID)
+	}
+	r.tsCache.Clear(r.rm.Clock())
+	r.respCache.ClearInflight()
+}
+
 // splitTrigger is called on a successful commit of an AdminSplit
 // transaction. It copies the response cache for the new range and
 // recomputes stats for both the existing, updated range and the new
@@ -1297,6 +2266,14 @@ func (r *Range) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSpli
 	}
 	defer func() { atomic.StoreInt32(&r.splitting, int32(0)) }()
 
+	// Ranges holding structured rows aren't split among the keys of
+	// a top-level row and the rows interleaved in it.
+	structuredRows, err := r.holdsStructuredRows()
+	if err != nil {
+		reply.SetGoError(util.Errorf("unable to look up structured schemas: %s", err))
+		return
+	}
+
 	// Determine split key if not provided with args. This scan is
 	// allowed to be relatively slow because admin commands don't block
 	// other commands.
@@ -1307,7 +2284,7 @@ func (r *Range) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSpli
 			reply.SetGoError(util.Errorf("unable to create snapshot: %s", err))
 			return
 		}
-		splitKey, err = engine.MVCCFindSplitKey(r.rm.Engine(), r.RangeID, r.Desc.StartKey, r.Desc.EndKey, snapshotID)
+		splitKey, err = engine.MVCCFindSplitKey(r.rm.Engine(), r.RangeID, r.Desc.StartKey, r.Desc.EndKey, snapshotID, structuredRows)
 		if releaseErr := r.rm.Engine().ReleaseSnapshot(snapshotID); releaseErr != nil {
 			log.Errorf("unable to release snapshot: %s", releaseErr)
 		}
@@ -1322,7 +2299,7 @@ func (r *Range) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSpli
 		reply.SetGoError(proto.NewRangeKeyMismatchError(splitKey, splitKey, r.Desc))
 		return
 	}
-	if !engine.IsValidSplitKey(splitKey) {
+	if !engine.IsValidSplitKey(splitKey, structuredRows) {
 		reply.SetGoError(util.Errorf("cannot split range at key %q", splitKey))
 		return
 	}
@@ -1380,3 +2357,480 @@ func (r *Range) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSpli
 		reply.SetGoError(util.Errorf("split at key %q failed: %s", splitKey, err))
 	}
 }
+
+// mergeFrozen returns whether the range starting at startKey has been
+// frozen for a merge with the range preceding it (see AdminMerge).
+func mergeFrozen(eng engine.Engine, startKey proto.Key) (bool, error) {
+	value, err := engine.MVCCGet(eng, engine.RangeMergeFreezeKey(startKey), proto.MaxTimestamp, nil)
+	return value != nil, err
+}
+
+// mergeFreezeBlocks returns whether the command is rejected by a range
+// which is frozen for a merge. A frozen range rejects all writes to its
+// key/value data and splits. Commands which only write range-local
+// keys, transaction records or the leader lease are still executed so
+// that the merge transaction and the transactions anchored on the
+// frozen range can complete.
+func mergeFreezeBlocks(method string, args proto.Request) bool {
+	if !proto.IsReadWrite(method) || bytes.HasPrefix(args.Header().Key, engine.KeyLocalPrefix) {
+		return false
+	}
+	switch method {
+	case proto.InternalLeaderLease, proto.InternalHeartbeatTxn, proto.InternalPushTxn:
+		return false
+	case proto.EndTransaction:
+		return args.(*proto.EndTransactionRequest).SplitTrigger != nil
+	}
+	return true
+}
+
+// mergeTrigger is called on a successful commit of an AdminMerge
+// transaction. It takes over the data of the subsumed range, which
+// already resides within the engine, by combining the subsumed range's
+// MVCC stats and response cache with those of this range and extending
+// this range to cover the subsumed range's keys.
+func (r *Range) mergeTrigger(batch engine.Engine, merge *proto.MergeTrigger) error {
+	if !bytes.Equal(r.Desc.StartKey, merge.UpdatedDesc.StartKey) ||
+		!bytes.Equal(r.Desc.EndKey, merge.SubsumedDesc.StartKey) ||
+		!bytes.Equal(merge.UpdatedDesc.EndKey, merge.SubsumedDesc.EndKey) {
+		return util.Errorf("range does not match merge: %q-%q + %q-%q != %q-%q", r.Desc.StartKey,
+			r.Desc.EndKey, merge.SubsumedDesc.StartKey, merge.SubsumedDesc.EndKey,
+			merge.UpdatedDesc.StartKey, merge.UpdatedDesc.EndKey)
+	}
+	// Find range ID of the subsumed range's replica on this store.
+	replica := storeReplica(&merge.SubsumedDesc, r.rm.StoreID())
+	if replica == nil {
+		return util.Errorf("subsumed range %q-%q has no replica on store %d",
+			merge.SubsumedDesc.StartKey, merge.SubsumedDesc.EndKey, r.rm.StoreID())
+	}
+	subsumedRangeID := replica.RangeID
+
+	// Add the subsumed range's stats to this range's and clear them.
+	ms, err := engine.MVCCGetRangeStats(r.rm.Engine(), subsumedRangeID)
+	if err != nil {
+		return util.Errorf("unable to fetch stats of subsumed range %d: %s", subsumedRangeID, err)
+	}
+	ms.MergeStats(batch, r.RangeID, 0)
+	statPrefix := engine.MakeKey(engine.KeyLocalRangeStatPrefix, encoding.EncodeInt(nil, subsumedRangeID))
+	if err := clearSpan(batch, keySpan{
+		start: engine.MVCCEncodeKey(statPrefix),
+		end:   engine.MVCCEncodeKey(statPrefix.PrefixEnd()),
+	}); err != nil {
+		return util.Errorf("unable to clear stats of subsumed range %d: %s", subsumedRangeID, err)
+	}
+
+	// Copy the subsumed range's response cache into this range's and
+	// clear it.
+	if err := NewResponseCache(subsumedRangeID, r.rm.Engine()).CopyInto(batch, r.RangeID); err != nil {
+		return util.Errorf("unable to copy response cache of subsumed range %d: %s", subsumedRangeID, err)
+	}
+	respCachePrefix := responseCacheKeyPrefix(subsumedRangeID)
+	if err := clearSpan(batch, keySpan{
+		start: engine.MVCCEncodeKey(respCachePrefix),
+		end:   engine.MVCCEncodeKey(respCachePrefix.PrefixEnd()),
+	}); err != nil {
+		return util.Errorf("unable to clear response cache of subsumed range %d: %s", subsumedRangeID, err)
+	}
+
+	// Clear the replica-specific state of the subsumed range, including
+	// its Raft log and the freeze written by AdminMerge. Its consensus
+	// group is shut down by MergeRange.
+	for _, key := range []proto.Key{
+		engine.RangeLeaderLeaseKey(merge.SubsumedDesc.RaftID),
+		engine.RaftAppliedIndexKey(merge.SubsumedDesc.RaftID),
+		engine.RaftStateKey(merge.SubsumedDesc.RaftID),
+		engine.RangeMergeFreezeKey(merge.SubsumedDesc.StartKey),
+	} {
+		if err := clearSpan(batch, keySpan{
+			start: engine.MVCCEncodeKey(key),
+			end:   engine.MVCCEncodeKey(key.Next()),
+		}); err != nil {
+			return util.Errorf("unable to clear state of subsumed range %d: %s", subsumedRangeID, err)
+		}
+	}
+	raftLogPrefix := engine.RaftLogPrefix(merge.SubsumedDesc.RaftID)
+	if err := clearSpan(batch, keySpan{
+		start: engine.MVCCEncodeKey(raftLogPrefix),
+		end:   engine.MVCCEncodeKey(raftLogPrefix.PrefixEnd()),
+	}); err != nil {
+		return util.Errorf("unable to clear raft log of subsumed range %d: %s", subsumedRangeID, err)
+	}
+
+	// Remove the subsumed range from the store. This step atomically
+	// updates the EndKey of this range and removes the subsumed range
+	// from the store's range map. Write-lock the mutex to protect
+	// Desc, as MergeRange will modify Desc.EndKey.
+	r.Lock()
+	defer r.Unlock()
+	// Reads served by the subsumed range aren't reflected in this
+	// range's timestamp cache; clear it to ratchet its low water mark.
+	r.tsCache.Clear(r.rm.Clock())
+	return r.rm.MergeRange(r, merge.UpdatedDesc.EndKey, merge.SubsumedDesc.RaftID)
+}
+
+// AdminMerge extends the range to subsume the range that comes next in
+// the key space. The range being subsumed must have replicas on the
+// same stores as this range. The merge is done inside of a distributed
+// txn which writes the updated range descriptor for the subsuming
+// range, deletes the subsumed range's descriptor and updates the range
+// addressing metadata. Beforehand, the subsumed range is frozen so
+// that its data doesn't change while it's taken over. The handover of
+// responsibility for the subsumed key range is carried out through a
+// merge trigger as part of the commit of that transaction.
+func (r *Range) AdminMerge(args *proto.AdminMergeRequest, reply *proto.AdminMergeResponse) {
+	// Only allow a single split or merge per range at a time.
+	if !atomic.CompareAndSwapInt32(&r.splitting, int32(0), int32(1)) {
+		reply.SetGoError(util.Errorf("already splitting or merging range %d", r.RangeID))
+		return
+	}
+	defer func() { atomic.StoreInt32(&r.splitting, int32(0)) }()
+
+	r.RLock()
+	desc := *r.Desc
+	r.RUnlock()
+	if bytes.Equal(desc.EndKey, engine.KeyMax) {
+		// The last range can't be merged with a following range.
+		reply.SetGoError(util.Errorf("cannot merge final range %d", r.RangeID))
+		return
+	}
+
+	log.Infof("initiating a merge of range %d %q-%q with the following range", r.RangeID,
+		proto.Key(desc.StartKey), proto.Key(desc.EndKey))
+
+	// Freeze the range to be subsumed. The freeze goes through its Raft
+	// log, so each of its replicas stops changing its data at the same
+	// point, and the store holding a replica applies the merge trigger
+	// only once that replica has applied the freeze (see
+	// Store.processRaft). The merge trigger lifts the freeze; if the
+	// merge fails, it's lifted here.
+	freezeKey := engine.RangeMergeFreezeKey(desc.EndKey)
+	if err := r.rm.DB().Call(proto.Put, &proto.PutRequest{
+		RequestHeader: proto.RequestHeader{Key: freezeKey},
+		Value:         proto.Value{Integer: gogoproto.Int64(desc.RaftID)},
+	}, &proto.PutResponse{}); err != nil {
+		reply.SetGoError(util.Errorf("unable to freeze the range following range %d: %s", r.RangeID, err))
+		return
+	}
+
+	txnOpts := &client.TransactionOptions{
+		Name: fmt.Sprintf("merge range %d", r.RangeID),
+	}
+	if err := r.rm.DB().RunTransaction(txnOpts, func(txn *client.KV) error {
+		// Read this range's descriptor to verify it's unchanged. Note
+		// that this read must go first in order to locate the
+		// transaction record on this range.
+		currentDesc := &proto.RangeDescriptor{}
+		ok, _, err := txn.GetProto(makeRangeKey(desc.StartKey), currentDesc)
+		if err != nil {
+			return err
+		}
+		if !ok || !bytes.Equal(currentDesc.EndKey, desc.EndKey) {
+			return util.Errorf("range %d changed during merge", r.RangeID)
+		}
+		// Fetch the descriptor of the range to be subsumed.
+		subsumedDesc := &proto.RangeDescriptor{}
+		ok, _, err = txn.GetProto(makeRangeKey(desc.EndKey), subsumedDesc)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return util.Errorf("no range descriptor found for the range following range %d at key %q",
+				r.RangeID, proto.Key(desc.EndKey))
+		}
+		if !replicasCollocated(desc.Replicas, subsumedDesc.Replicas) {
+			return util.Errorf("the replicas of range %d and the range following it are not co-located", r.RangeID)
+		}
+
+		updatedDesc := desc
+		updatedDesc.EndKey = subsumedDesc.EndKey
+		if r.rm.Gossip() != nil {
+			crosses, err := r.spansConfigBoundary(updatedDesc.StartKey, updatedDesc.EndKey)
+			if err != nil {
+				return err
+			}
+			if crosses {
+				return util.Errorf("cannot merge ranges %q-%q and %q-%q across a config boundary",
+					proto.Key(desc.StartKey), proto.Key(desc.EndKey),
+					proto.Key(subsumedDesc.StartKey), proto.Key(subsumedDesc.EndKey))
+			}
+		}
+
+		// Update the range descriptor of the subsuming range.
+		if err := txn.PreparePutProto(makeRangeKey(updatedDesc.StartKey), &updatedDesc); err != nil {
+			return err
+		}
+		// Remove the range descriptor of the subsumed range.
+		txn.Prepare(proto.Delete, &proto.DeleteRequest{
+			RequestHeader: proto.RequestHeader{Key: makeRangeKey(subsumedDesc.StartKey)},
+		}, &proto.DeleteResponse{})
+		// Replace the addressing record(s) of the subsuming range, which
+		// were keyed by its former end key. Those of the subsumed range
+		// are overwritten by the updated descriptor.
+		if err := RemoveRangeAddressing(txn, &desc); err != nil {
+			return err
+		}
+		if err := UpdateRangeAddressing(txn, &updatedDesc); err != nil {
+			return err
+		}
+		// End the transaction manually, instead of letting RunTransaction
+		// loop do it, in order to provide a merge trigger.
+		return txn.Call(proto.EndTransaction, &proto.EndTransactionRequest{
+			RequestHeader: proto.RequestHeader{Key: updatedDesc.StartKey},
+			Commit:        true,
+			MergeTrigger: &proto.MergeTrigger{
+				UpdatedDesc:  updatedDesc,
+				SubsumedDesc: *subsumedDesc,
+			},
+		}, &proto.EndTransactionResponse{})
+	}); err != nil {
+		if unfreezeErr := r.rm.DB().Call(proto.Delete, &proto.DeleteRequest{
+			RequestHeader: proto.RequestHeader{Key: freezeKey},
+		}, &proto.DeleteResponse{}); unfreezeErr != nil {
+			log.Errorf("unable to unfreeze the range following range %d: %s", r.RangeID, unfreezeErr)
+		}
+		reply.SetGoError(util.Errorf("merge of range %d failed: %s", r.RangeID, err))
+	}
+}
+
+// replicasCollocated returns whether both replica sets reside on the
+// same set of stores.
+func replicasCollocated(a, b []proto.Replica) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	stores := map[int32]struct{}{}
+	for _, replica := range a {
+		stores[replica.StoreID] = struct{}{}
+	}
+	for _, replica := range b {
+		if _, ok := stores[replica.StoreID]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// changeReplicasTrigger is called on a successful commit of an
+// AdminChangeReplicas transaction. It installs the updated range
+// descriptor. If a replica was added, the Raft log is compacted once
+// the commit has been applied, so that the new replica can be brought
+// up to date with a snapshot which includes the updated descriptor.
+// Once the commit has been applied, the lease holder proposes the
+// change to the Raft group (see maybeProposeMemberChange).
+func (r *Range) changeReplicasTrigger(change *proto.ChangeReplicasTrigger) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.Desc.RaftID != change.UpdatedDesc.RaftID || !bytes.Equal(r.Desc.StartKey, change.UpdatedDesc.StartKey) ||
+		!bytes.Equal(r.Desc.EndKey, change.UpdatedDesc.EndKey) {
+		return util.Errorf("range %d %q-%q does not match updated descriptor %+v", r.RangeID,
+			proto.Key(r.Desc.StartKey), proto.Key(r.Desc.EndKey), change.UpdatedDesc)
+	}
+	updatedDesc := change.UpdatedDesc
+	r.Desc = &updatedDesc
+	if change.ChangeType == proto.ADD_REPLICA {
+		r.forceCompact = true
+	}
+	return nil
+}
+
+// maybeProposeMemberChange proposes the committed change to the
+// range's replica set to its Raft consensus group if this replica
+// holds the leader lease, so that the group follows the descriptor.
+// An added replica is proposed once the Raft log has been compacted,
+// so that it can be brought up to date with a snapshot which includes
+// the updated descriptor. The change is proposed again every
+// replicaChangeRetryInterval until it has been applied or the range
+// is stopped.
+func (r *Range) maybeProposeMemberChange(change *proto.ChangeReplicasTrigger) {
+	if err := r.verifyLeaderLease(r.rm.Clock().Now()); err != nil {
+		return
+	}
+	var compacted chan error
+	if change.ChangeType == proto.ADD_REPLICA {
+		compacted = make(chan error, 1)
+		r.Lock()
+		r.compacted = compacted
+		r.Unlock()
+	}
+	changed, stopWatching := r.watchMemberChanges()
+	go func() {
+		defer stopWatching()
+		if compacted != nil {
+			select {
+			case err := <-compacted:
+				// The descriptor already lists the added replica, so the
+				// change is proposed regardless.
+				if err != nil {
+					log.Errorf("range %d: unable to compact raft log for added replica: %s", r.RangeID, err)
+				}
+			case <-r.closer:
+				return
+			}
+		}
+		nodeID := raftNodeID(change.Replica.StoreID)
+		for {
+			if err := r.rm.ChangeRaftMembership(change.UpdatedDesc.RaftID, change.ChangeType, change.Replica.StoreID); err != nil {
+				log.Warningf("range %d: unable to propose raft membership change %s %+v: %s",
+					r.RangeID, change.ChangeType, change.Replica, err)
+			}
+			retry := time.After(replicaChangeRetryInterval)
+		wait:
+			for {
+				select {
+				case c := <-changed:
+					if c.changeType == change.ChangeType && c.nodeID == nodeID {
+						return
+					}
+				case <-retry:
+					break wait
+				case <-r.closer:
+					return
+				}
+			}
+		}
+	}()
+}
+
+// watchMemberChanges returns a channel which is notified of the
+// changes to the membership of the range's Raft consensus group as
+// they are applied, and a function which stops the notifications.
+func (r *Range) watchMemberChanges() (<-chan memberChange, func()) {
+	ch := make(chan memberChange, 10)
+	r.Lock()
+	r.confWatchers = append(r.confWatchers, ch)
+	r.Unlock()
+	return ch, func() {
+		r.Lock()
+		defer r.Unlock()
+		for i, watcher := range r.confWatchers {
+			if watcher == ch {
+				r.confWatchers = append(r.confWatchers[:i], r.confWatchers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// memberChangeCommitted notifies the watchers of the range's
+// membership changes that a change to the membership of the range's
+// Raft consensus group has been applied.
+func (r *Range) memberChangeCommitted(change memberChange) {
+	r.RLock()
+	defer r.RUnlock()
+	for _, ch := range r.confWatchers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
+
+// AdminChangeReplicas adds or removes the replica specified in args
+// to or from the range's replica set. The updated range descriptor
+// and its addressing records are written in a distributed txn, whose
+// commit trigger installs the updated descriptor on each replica. The
+// range's Raft consensus group is then reconfigured to match by the
+// lease holder once the commit has been applied, and this command
+// waits for the reconfiguration. An added replica is brought up to
+// date via a snapshot of the range. A removed replica clears the
+// range's data from its store once its removal has been applied. Only
+// the holder of the leader lease may change the range's replicas.
+func (r *Range) AdminChangeReplicas(args *proto.AdminChangeReplicasRequest, reply *proto.AdminChangeReplicasResponse) {
+	// Only allow a single change to the replica set at a time. Raft
+	// drops proposed membership changes while another is pending.
+	if !atomic.CompareAndSwapInt32(&r.changing, int32(0), int32(1)) {
+		reply.SetGoError(util.Errorf("already changing replicas of range %d", r.RangeID))
+		return
+	}
+	defer func() { atomic.StoreInt32(&r.changing, int32(0)) }()
+	if err := r.redirectOnOrAcquireLeaderLease(); err != nil {
+		reply.SetGoError(err)
+		return
+	}
+
+	changeType, replica := args.ChangeType, args.Replica
+	r.RLock()
+	updatedDesc := *r.Desc
+	r.RUnlock()
+	updatedDesc.Replicas = append([]proto.Replica(nil), updatedDesc.Replicas...)
+
+	switch changeType {
+	case proto.ADD_REPLICA:
+		for _, existing := range updatedDesc.Replicas {
+			if existing.NodeID == replica.NodeID {
+				reply.SetGoError(util.Errorf("range %d already has a replica on node %d", r.RangeID, replica.NodeID))
+				return
+			}
+		}
+		updatedDesc.Replicas = append(updatedDesc.Replicas, replica)
+	case proto.REMOVE_REPLICA:
+		// The replica executing the change is the leader of the range
+		// and must remain in the group to see the change through.
+		if replica.StoreID == r.rm.StoreID() {
+			reply.SetGoError(util.Errorf("cannot remove replica of range %d from store %d executing the change",
+				r.RangeID, replica.StoreID))
+			return
+		}
+		found := false
+		for i, existing := range updatedDesc.Replicas {
+			if existing.StoreID == replica.StoreID {
+				updatedDesc.Replicas = append(updatedDesc.Replicas[:i], updatedDesc.Replicas[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			reply.SetGoError(util.Errorf("range %d has no replica on store %d", r.RangeID, replica.StoreID))
+			return
+		}
+	default:
+		reply.SetGoError(util.Errorf("unknown replica change type %s", changeType))
+		return
+	}
+
+	// Register for notification of the membership change.
+	changed, stopWatching := r.watchMemberChanges()
+	defer stopWatching()
+
+	log.Infof("changing replicas of range %d: %s %+v", r.RangeID, changeType, replica)
+	txnOpts := &client.TransactionOptions{
+		Name: fmt.Sprintf("change replicas of range %d", r.RangeID),
+	}
+	if err := r.rm.DB().RunTransaction(txnOpts, func(txn *client.KV) error {
+		// Update the range descriptor first in order to locate the
+		// transaction record on this range.
+		if err := txn.PreparePutProto(makeRangeKey(updatedDesc.StartKey), &updatedDesc); err != nil {
+			return err
+		}
+		// Update range descriptor addressing record(s).
+		if err := UpdateRangeAddressing(txn, &updatedDesc); err != nil {
+			return err
+		}
+		// End the transaction manually in order to provide a commit trigger.
+		return txn.Call(proto.EndTransaction, &proto.EndTransactionRequest{
+			RequestHeader: proto.RequestHeader{Key: updatedDesc.StartKey},
+			Commit:        true,
+			ChangeReplicasTrigger: &proto.ChangeReplicasTrigger{
+				UpdatedDesc: updatedDesc,
+				ChangeType:  changeType,
+				Replica:     replica,
+			},
+		}, &proto.EndTransactionResponse{})
+	}); err != nil {
+		reply.SetGoError(util.Errorf("change replicas of range %d failed: %s", r.RangeID, err))
+		return
+	}
+
+	timeout := time.After(replicaChangeTimeout)
+	for {
+		select {
+		case change := <-changed:
+			if change.changeType == changeType && change.nodeID == raftNodeID(replica.StoreID) {
+				return
+			}
+		case <-timeout:
+			reply.SetGoError(util.Errorf("timed out changing raft membership of range %d", r.RangeID))
+			return
+		}
+	}
+}
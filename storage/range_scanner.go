@@ -0,0 +1,81 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// A rangeScanner makes periodic passes over the ranges of a store,
+// invoking its process function for every range whose leader lease
+// the store holds. The store's background queues are built on range
+// scanners.
+type rangeScanner struct {
+	name     string             // Used in log messages
+	store    *Store             // The store whose ranges are scanned
+	interval time.Duration      // Interval between successive passes
+	process  func(*Range) error // Invoked for each lease holder range
+	passDone func()             // If not nil, invoked after each pass
+	mu       sync.Mutex         // Serializes passes
+}
+
+// newRangeScanner returns a new range scanner which invokes process
+// for the ranges whose leader lease the store holds every interval.
+func newRangeScanner(name string, store *Store, interval time.Duration, process func(*Range) error) *rangeScanner {
+	return &rangeScanner{
+		name:     name,
+		store:    store,
+		interval: interval,
+		process:  process,
+	}
+}
+
+// start makes a pass over the store's ranges every interval until
+// the closer channel is closed.
+func (rs *rangeScanner) start(closer chan struct{}) {
+	ticker := time.NewTicker(rs.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rs.processAll()
+		case <-closer:
+			return
+		}
+	}
+}
+
+// processAll makes a pass over all of the store's ranges, skipping
+// those whose leader lease the store doesn't hold. Errors processing a
+// range are logged and don't stop the pass.
+func (rs *rangeScanner) processAll() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, rng := range rs.store.allRanges() {
+		if err := rng.verifyLeaderLease(rs.store.Clock().Now()); err != nil {
+			continue
+		}
+		if err := rs.process(rng); err != nil {
+			log.Warningf("%s: unable to process range %d: %s", rs.name, rng.RangeID, err)
+		}
+	}
+	if rs.passDone != nil {
+		rs.passDone()
+	}
+}
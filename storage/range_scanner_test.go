@@ -0,0 +1,68 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// TestRangeScannerProcessAll verifies that a pass of a range scanner
+// processes the ranges whose leader lease the store holds, skipping
+// the others, and invokes the pass callback once finished, even if
+// processing a range fails.
+func TestRangeScannerProcessAll(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	holdLeaderLease(t, store, 1)
+
+	var processed []int64
+	rs := newRangeScanner("test", store, time.Hour, func(rng *Range) error {
+		processed = append(processed, rng.RangeID)
+		return util.Errorf("failed to process range %d", rng.RangeID)
+	})
+	var passes int
+	rs.passDone = func() { passes++ }
+
+	rs.processAll()
+	if len(processed) != 1 || processed[0] != 1 {
+		t.Errorf("expected range 1 to be processed; got %v", processed)
+	}
+	if passes != 1 {
+		t.Errorf("expected one finished pass; got %d", passes)
+	}
+
+	// Once another store holds the lease, the range is skipped.
+	rng, err := store.GetRange(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng.applyLeaderLease(&proto.Lease{
+		Expiration: proto.MaxTimestamp,
+		Replica:    proto.Replica{NodeID: 2, StoreID: 2, RangeID: 1},
+	})
+	processed = nil
+	rs.processAll()
+	if len(processed) != 0 {
+		t.Errorf("expected no ranges to be processed without the lease; got %v", processed)
+	}
+	if passes != 2 {
+		t.Errorf("expected two finished passes; got %d", passes)
+	}
+}
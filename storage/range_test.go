@@ -29,10 +29,12 @@ import (
 
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/multiraft"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/rpc"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
 	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
 )
@@ -49,12 +51,6 @@ var (
 				RangeID: 1,
 				Attrs:   proto.Attributes{Attrs: []string{"dc1", "mem"}},
 			},
-			{
-				NodeID:  2,
-				StoreID: 2,
-				RangeID: 2,
-				Attrs:   proto.Attributes{Attrs: []string{"dc2", "mem"}},
-			},
 		},
 	}
 	testDefaultAcctConfig = proto.AcctConfig{}
@@ -92,7 +88,7 @@ func createTestRange(t *testing.T) (*Store, *Range, *gossip.Gossip, engine.Engin
 	g := gossip.New(rpcContext)
 	clock := hlc.NewClock(hlc.UnixNano)
 	engine := engine.NewInMem(proto.Attributes{Attrs: []string{"dc1", "mem"}}, 1<<20)
-	store := NewStore(clock, engine, nil, g)
+	store := NewStore(clock, engine, nil, g, multiraft.NewLocalRPCTransport())
 	if err := store.Bootstrap(proto.StoreIdent{StoreID: 1}); err != nil {
 		t.Fatal(err)
 	}
@@ -101,7 +97,9 @@ func createTestRange(t *testing.T) (*Store, *Range, *gossip.Gossip, engine.Engin
 	}
 	initConfigs(engine, t)
 	r := NewRange(1, &testRangeDescriptor, store)
-	store.AddRange(r)
+	if err := store.AddRange(r); err != nil {
+		t.Fatal(err)
+	}
 	return store, r, g, engine
 }
 
@@ -121,7 +119,7 @@ func TestRangeContains(t *testing.T) {
 	}
 
 	clock := hlc.NewClock(hlc.UnixNano)
-	r := NewRange(0, desc, NewStore(clock, nil, nil, nil))
+	r := NewRange(0, desc, NewStore(clock, nil, nil, nil, multiraft.NewLocalRPCTransport()))
 	if !r.ContainsKey(proto.Key("aa")) {
 		t.Errorf("expected range to contain key \"aa\"")
 	}
@@ -210,7 +208,7 @@ func TestRangeGossipConfigWithMultipleKeyPrefixes(t *testing.T) {
 	}
 	reply := &proto.PutResponse{}
 
-	if err := r.executeCmd(proto.Put, req, reply); err != nil {
+	if err := r.executeCmd(0, proto.Put, req, reply); err != nil {
 		t.Fatal(err)
 	}
 
@@ -250,7 +248,7 @@ func TestRangeGossipConfigUpdates(t *testing.T) {
 	}
 	reply := &proto.PutResponse{}
 
-	if err := r.executeCmd(proto.Put, req, reply); err != nil {
+	if err := r.executeCmd(0, proto.Put, req, reply); err != nil {
 		t.Fatal(err)
 	}
 
@@ -335,7 +333,7 @@ func createTestRangeWithClock(t *testing.T) (*Store, *Range, *hlc.ManualClock, *
 	manual := hlc.ManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	engine := newBlockingEngine()
-	store := NewStore(clock, engine, nil, nil)
+	store := NewStore(clock, engine, nil, nil, multiraft.NewLocalRPCTransport())
 	if err := store.Bootstrap(proto.StoreIdent{StoreID: 1}); err != nil {
 		t.Fatal(err)
 	}
@@ -343,7 +341,9 @@ func createTestRangeWithClock(t *testing.T) (*Store, *Range, *hlc.ManualClock, *
 		t.Fatal(err)
 	}
 	rng := NewRange(1, &testRangeDescriptor, store)
-	store.AddRange(rng)
+	if err := store.AddRange(rng); err != nil {
+		t.Fatal(err)
+	}
 	return store, rng, &manual, clock, engine
 }
 
@@ -1070,6 +1070,31 @@ func TestEndTransactionWithIncrementedEpoch(t *testing.T) {
 	}
 }
 
+// TestInternalHeartbeatTxnGarbageCollected verifies that a heartbeat
+// which finds no record of a transaction started before the GC
+// expiration reports the transaction aborted instead of recreating
+// its record as pending.
+func TestInternalHeartbeatTxnGarbageCollected(t *testing.T) {
+	s, rng, mc, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	txn := newTransaction("test", proto.Key("a"), 1, proto.SERIALIZABLE, clock)
+	*mc = hlc.ManualClock(txn.OrigTimestamp.WallTime + GCTransactionExpiration.Nanoseconds() + 1)
+	hbArgs, hbReply := heartbeatArgs(txn, 1)
+	hbArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.InternalHeartbeatTxn, hbArgs, hbReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if hbReply.Txn.Status != proto.ABORTED {
+		t.Errorf("expected transaction status to be ABORTED; got %s", hbReply.Txn.Status)
+	}
+	txnKey := engine.MakeKey(engine.KeyLocalTransactionPrefix, txn.ID)
+	var record proto.Transaction
+	if ok, err := engine.MVCCGetProto(rng.rm.Engine(), txnKey, proto.ZeroTimestamp, nil, &record); err != nil || ok {
+		t.Errorf("expected no transaction record; got %s, %v", &record, err)
+	}
+}
+
 // TestEndTransactionWithErrors verifies various error conditions
 // are checked such as transaction already being committed or
 // aborted, or timestamp or epoch regression.
@@ -1114,6 +1139,91 @@ func TestEndTransactionWithErrors(t *testing.T) {
 	}
 }
 
+// TestEndTransactionRecordsIntents verifies that the intents supplied
+// to EndTransaction are persisted with the transaction record but not
+// returned with the transaction.
+func TestEndTransactionRecordsIntents(t *testing.T) {
+	s, rng, _, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	txn := newTransaction("test", proto.Key("a"), 1, proto.SERIALIZABLE, clock)
+	args, reply := endTxnArgs(txn, true, 1)
+	args.Timestamp = txn.Timestamp
+	args.Intents = []proto.Intent{{Key: proto.Key("a")}, {Key: proto.Key("b"), EndKey: proto.Key("c")}}
+	if err := rng.AddCmd(proto.EndTransaction, args, reply, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Txn.Intents) != 0 {
+		t.Errorf("expected no intents in reply txn; got %+v", reply.Txn.Intents)
+	}
+	record := &proto.Transaction{}
+	key := engine.MakeKey(engine.KeyLocalTransactionPrefix, txn.ID)
+	if ok, err := engine.MVCCGetProto(rng.rm.Engine(), key, proto.ZeroTimestamp, nil, record); !ok || err != nil {
+		t.Fatalf("expected transaction record; got %t, %v", ok, err)
+	}
+	if !reflect.DeepEqual(record.Intents, args.Intents) {
+		t.Errorf("expected intents %+v in transaction record; got %+v", args.Intents, record.Intents)
+	}
+}
+
+// TestRangeInternalGC verifies that InternalGC deletes transaction
+// records and response cache entries of the range, rejects other
+// range-local keys and refuses to delete live values.
+func TestRangeInternalGC(t *testing.T) {
+	s, rng, _, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+	eng := rng.rm.Engine()
+
+	txn := newTransaction("test", proto.Key("a"), 1, proto.SERIALIZABLE, clock)
+	txnKey := engine.MakeKey(engine.KeyLocalTransactionPrefix, txn.ID)
+	if err := engine.MVCCPutProto(eng, nil, txnKey, proto.ZeroTimestamp, nil, txn); err != nil {
+		t.Fatal(err)
+	}
+	rcKey := responseCacheKey(1, proto.ClientCmdID{WallTime: 1, Random: 1})
+	if err := engine.MVCCPutProto(eng, nil, rcKey, proto.ZeroTimestamp, nil, &proto.ReadWriteCmdResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	gcArgs := func(keys ...proto.Key) (*proto.InternalGCRequest, *proto.InternalGCResponse) {
+		args := &proto.InternalGCRequest{
+			RequestHeader: proto.RequestHeader{
+				Timestamp: clock.Now(),
+				Replica:   proto.Replica{RangeID: 1},
+			},
+		}
+		for _, key := range keys {
+			args.Keys = append(args.Keys, proto.InternalGCRequest_GCKey{Key: key})
+		}
+		return args, &proto.InternalGCResponse{}
+	}
+
+	// Other range-local keys and the response cache entries of other
+	// ranges can't be garbage collected.
+	for _, key := range []proto.Key{engine.RangeLeaderLeaseKey(rng.Desc.RaftID), responseCacheKey(2, proto.ClientCmdID{WallTime: 1, Random: 1})} {
+		args, reply := gcArgs(key)
+		verifyErrorMatches(rng.AddCmd(proto.InternalGC, args, reply, true), "cannot garbage collect key", t)
+	}
+	// Nor can the live value of a key.
+	pArgs, pReply := putArgs(proto.Key("a"), []byte("value"), 1)
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	args, reply := gcArgs()
+	args.Keys = []proto.InternalGCRequest_GCKey{{Key: proto.Key("a"), Timestamp: pArgs.Timestamp}}
+	verifyErrorMatches(rng.AddCmd(proto.InternalGC, args, reply, true), "cannot garbage collect live value", t)
+
+	args, reply = gcArgs(txnKey, rcKey)
+	if err := rng.AddCmd(proto.InternalGC, args, reply, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []proto.Key{txnKey, rcKey} {
+		if val, err := engine.MVCCGet(eng, key, proto.ZeroTimestamp, nil); err != nil || val != nil {
+			t.Errorf("expected key %q to be deleted; got %+v, %v", key, val, err)
+		}
+	}
+}
+
 // TestInternalPushTxnBadKey verifies that args.Key equals args.PusheeTxn.ID.
 func TestInternalPushTxnBadKey(t *testing.T) {
 	s, rng, _, clock, _ := createTestRangeWithClock(t)
@@ -1510,6 +1620,179 @@ func TestRangeStats(t *testing.T) {
 	verifyRangeStats(eng, rng.RangeID, expMS, t)
 }
 
+// accumulateTSArgs returns an AccumulateTSRequest and
+// AccumulateTSResponse pair addressed to the default replica, with
+// one integer data point at each of the specified offsets.
+func accumulateTSArgs(key []byte, rangeID int64, offsets ...int32) (*proto.AccumulateTSRequest, *proto.AccumulateTSResponse) {
+	args := &proto.AccumulateTSRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:     key,
+			Replica: proto.Replica{RangeID: rangeID},
+		},
+		Data: proto.TimeSeriesData{
+			StartTimestamp:    1415398729,
+			DurationInSeconds: 3600,
+			SamplePrecision:   proto.SECONDS,
+		},
+	}
+	for _, offset := range offsets {
+		args.Data.Data = append(args.Data.Data, &proto.TimeSeriesDataPoint{
+			Offset:   offset,
+			ValueInt: gogoproto.Int64(int64(offset)),
+		})
+	}
+	return args, &proto.AccumulateTSResponse{}
+}
+
+// TestRangeAccumulateTS verifies that data points accumulated at a
+// key are merged into a single time series and that invalid or
+// transactional requests are rejected.
+func TestRangeAccumulateTS(t *testing.T) {
+	s, rng, _, eng := createTestRange(t)
+	defer s.Stop()
+	key := []byte("ts")
+	origMS, err := engine.MVCCGetRangeStats(eng, rng.RangeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expValBytes int64
+	for _, offsets := range [][]int32{{100, 200}, {300}} {
+		args, reply := accumulateTSArgs(key, 1, offsets...)
+		if err := rng.AddCmd(proto.AccumulateTS, args, reply, true); err != nil {
+			t.Fatal(err)
+		}
+		value, err := args.Data.ToValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expValBytes += int64(len(value.Bytes))
+	}
+
+	value, err := engine.MVCCGet(eng, key, proto.ZeroTimestamp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := proto.TimeSeriesFromValue(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expTS, _ := accumulateTSArgs(key, 1, 100, 200, 300)
+	if !gogoproto.Equal(ts, &expTS.Data) {
+		t.Errorf("expected merged time series %+v; got %+v", expTS.Data, ts)
+	}
+
+	// The merged bytes are accounted in the range stats.
+	ms, err := engine.MVCCGetRangeStats(eng, rng.RangeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.ValBytes-origMS.ValBytes != expValBytes || ms.LiveBytes-origMS.LiveBytes != expValBytes {
+		t.Errorf("expected val and live bytes to grow by %d; got %+v -> %+v", expValBytes, origMS, ms)
+	}
+
+	// Data points outside of the interval are rejected.
+	args, reply := accumulateTSArgs(key, 1, 3600)
+	if err := rng.AddCmd(proto.AccumulateTS, args, reply, true); err == nil {
+		t.Error("expected error accumulating data point outside of interval")
+	}
+	// So are transactional requests.
+	args, reply = accumulateTSArgs(key, 1, 400)
+	args.Txn = newTransaction("test", key, 1, proto.SERIALIZABLE, s.clock)
+	if err := rng.AddCmd(proto.AccumulateTS, args, reply, true); err == nil {
+		t.Error("expected error accumulating time series within a transaction")
+	}
+}
+
+// enqueueMessageArgs returns request/response pair for EnqueueMessage
+// RPC addressed to the inbox key within the specified transaction.
+func enqueueMessageArgs(inbox, msg []byte, txn *proto.Transaction, rangeID int64) (
+	*proto.EnqueueMessageRequest, *proto.EnqueueMessageResponse) {
+	args := &proto.EnqueueMessageRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:       inbox,
+			Timestamp: txn.Timestamp,
+			Replica:   proto.Replica{RangeID: rangeID},
+			Txn:       txn,
+		},
+		Msg: proto.Value{Bytes: msg},
+	}
+	return args, &proto.EnqueueMessageResponse{}
+}
+
+// reapQueueArgs returns request/response pair for ReapQueue RPC
+// addressed to the inbox key within the specified transaction.
+func reapQueueArgs(inbox []byte, maxResults int64, txn *proto.Transaction, rangeID int64) (
+	*proto.ReapQueueRequest, *proto.ReapQueueResponse) {
+	args := &proto.ReapQueueRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:       inbox,
+			Timestamp: txn.Timestamp,
+			Replica:   proto.Replica{RangeID: rangeID},
+			Txn:       txn,
+		},
+		MaxResults: maxResults,
+	}
+	return args, &proto.ReapQueueResponse{}
+}
+
+// TestRangeEnqueueAndReapQueue verifies that messages enqueued to an
+// inbox are reaped in order, up to the requested maximum, and that
+// inboxes whose keys share a prefix don't see each other's messages.
+func TestRangeEnqueueAndReapQueue(t *testing.T) {
+	s, rng, _, _ := createTestRange(t)
+	defer s.Stop()
+	inbox, otherInbox := []byte("a"), []byte("ab")
+	txn := newTransaction("test", inbox, 1, proto.SERIALIZABLE, s.clock)
+
+	for _, msg := range []string{"m0", "m1", "m2"} {
+		args, reply := enqueueMessageArgs(inbox, []byte(msg), txn, 1)
+		if err := rng.AddCmd(proto.EnqueueMessage, args, reply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	args, reply := enqueueMessageArgs(otherInbox, []byte("other"), txn, 1)
+	if err := rng.AddCmd(proto.EnqueueMessage, args, reply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		inbox       []byte
+		maxResults  int64
+		expMessages []string
+	}{
+		{inbox, 2, []string{"m0", "m1"}},
+		{inbox, 2, []string{"m2"}},
+		{inbox, 2, nil},
+		{otherInbox, 10, []string{"other"}},
+		{otherInbox, 10, nil},
+	}
+	for i, test := range testCases {
+		args, reply := reapQueueArgs(test.inbox, test.maxResults, txn, 1)
+		if err := rng.AddCmd(proto.ReapQueue, args, reply, true); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		var msgs []string
+		for _, msg := range reply.Messages {
+			msgs = append(msgs, string(msg.Bytes))
+		}
+		if !reflect.DeepEqual(msgs, test.expMessages) {
+			t.Errorf("%d: expected messages %q; got %q", i, test.expMessages, msgs)
+		}
+	}
+
+	// Reaping requires a transaction and a positive maximum.
+	rArgs, rReply := reapQueueArgs(inbox, 0, txn, 1)
+	if err := rng.AddCmd(proto.ReapQueue, rArgs, rReply, true); err == nil {
+		t.Error("expected error reaping queue with max results of 0")
+	}
+	rArgs, rReply = reapQueueArgs(inbox, 1, txn, 1)
+	rArgs.Txn = nil
+	if err := rng.AddCmd(proto.ReapQueue, rArgs, rReply, true); err == nil {
+		t.Error("expected error reaping queue outside of a transaction")
+	}
+}
+
 // TestRemoteRaftCommand ensures that commands entering the raft
 // subsystem from other nodes are applied correctly.
 func TestRemoteRaftCommand(t *testing.T) {
@@ -1540,3 +1823,473 @@ func TestRemoteRaftCommand(t *testing.T) {
 		t.Errorf("expected 5, got %d", localIncReply.NewValue)
 	}
 }
+
+// TestRangeLeaderLease verifies that reads acquire a leader lease,
+// that a lease held by another replica is honored until it expires,
+// and that reads are redirected to the lease holder.
+func TestRangeLeaderLease(t *testing.T) {
+	s, rng, mc, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	// Add a second replica to the range descriptor, so that it may
+	// request the lease.
+	desc := *rng.Desc
+	desc.Replicas = append(append([]proto.Replica(nil), desc.Replicas...),
+		proto.Replica{NodeID: 2, StoreID: 2, RangeID: 2})
+	rng.Lock()
+	rng.Desc = &desc
+	rng.Unlock()
+
+	// A read acquires the lease for this replica. As the range's first
+	// lease, it also resets the timestamp cache.
+	*mc = hlc.ManualClock(int64(*mc) + clock.MaxOffset().Nanoseconds() + 1)
+	gArgs, gReply := getArgs([]byte("a"), 1)
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	lease := rng.getLeaderLease()
+	if lease == nil || lease.Replica.StoreID != s.StoreID() {
+		t.Fatalf("expected lease held by store %d; got %+v", s.StoreID(), lease)
+	}
+	if rTS, _ := rng.tsCache.GetMax(proto.Key("z"), nil, proto.NoTxnMD5); rTS.Less(lease.Start) {
+		t.Errorf("expected timestamp cache low water mark to be reset; got %s", rTS)
+	}
+	persisted := &proto.Lease{}
+	if ok, err := engine.MVCCGetProto(s.Engine(), engine.RangeLeaderLeaseKey(desc.RaftID), proto.ZeroTimestamp, nil, persisted); !ok || err != nil {
+		t.Fatalf("expected persisted lease; got %t, %v", ok, err)
+	}
+	if !reflect.DeepEqual(persisted, lease) {
+		t.Errorf("expected persisted lease %+v; got %+v", lease, persisted)
+	}
+
+	leaseArgs := func(replica proto.Replica) (*proto.InternalLeaderLeaseRequest, *proto.InternalLeaderLeaseResponse) {
+		now := clock.Now()
+		expiration := now
+		expiration.WallTime += DefaultLeaderLeaseDuration.Nanoseconds()
+		return &proto.InternalLeaderLeaseRequest{
+			RequestHeader: proto.RequestHeader{Key: desc.StartKey, Timestamp: now},
+			Lease:         proto.Lease{Start: now, Expiration: expiration, Replica: replica},
+		}, &proto.InternalLeaderLeaseResponse{}
+	}
+
+	// The second replica may not acquire the lease while it's valid.
+	lArgs, lReply := leaseArgs(desc.Replicas[1])
+	err := rng.AddCmd(proto.InternalLeaderLease, lArgs, lReply, true)
+	if nlErr, ok := err.(*proto.NotLeaderError); !ok || nlErr.Leader.StoreID != s.StoreID() {
+		t.Fatalf("expected not leader error naming store %d; got %v", s.StoreID(), err)
+	}
+
+	// Once the lease expires, the second replica acquires it.
+	*mc = hlc.ManualClock(lease.Expiration.WallTime + 1)
+	lArgs, lReply = leaseArgs(desc.Replicas[1])
+	if err := rng.AddCmd(proto.InternalLeaderLease, lArgs, lReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads are now redirected to the second replica.
+	gArgs, gReply = getArgs([]byte("a"), 1)
+	err = rng.AddCmd(proto.Get, gArgs, gReply, true)
+	if nlErr, ok := err.(*proto.NotLeaderError); !ok || nlErr.Leader.StoreID != 2 {
+		t.Fatalf("expected not leader error naming store 2; got %v", err)
+	}
+	if _, ok := gReply.GoError().(*proto.NotLeaderError); !ok {
+		t.Errorf("expected not leader error in reply; got %v", gReply.GoError())
+	}
+
+	// After expiration of the second replica's lease, a read
+	// reacquires the lease and resets the timestamp cache.
+	*mc = hlc.ManualClock(lArgs.Lease.Expiration.WallTime + 1)
+	gArgs, gReply = getArgs([]byte("a"), 1)
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if lease := rng.getLeaderLease(); lease.Replica.StoreID != s.StoreID() {
+		t.Errorf("expected lease held by store %d; got %+v", s.StoreID(), lease)
+	}
+	rTS, wTS := rng.tsCache.GetMax(proto.Key("z"), nil, proto.NoTxnMD5)
+	if rTS.Less(lArgs.Lease.Expiration) || wTS.Less(lArgs.Lease.Expiration) {
+		t.Errorf("expected timestamp cache low water mark to be reset; got %s, %s", rTS, wTS)
+	}
+}
+
+// TestRangeLeaderLeaseMaxOffset verifies that the holder of a leader
+// lease stops using it the maximum clock offset before its expiration,
+// so that it never overlaps with a lease acquired by a replica whose
+// clock runs ahead, and that leases no longer than the maximum clock
+// offset are rejected.
+func TestRangeLeaderLeaseMaxOffset(t *testing.T) {
+	s, rng, mc, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+	const maxOffset = 100 * time.Millisecond
+	clock.SetMaxOffset(maxOffset)
+
+	desc := *rng.Desc
+	desc.Replicas = append(append([]proto.Replica(nil), desc.Replicas...),
+		proto.Replica{NodeID: 2, StoreID: 2, RangeID: 2})
+	rng.Lock()
+	rng.Desc = &desc
+	rng.Unlock()
+
+	// The second replica's clock runs ahead of this replica's by the
+	// maximum offset.
+	skewed := hlc.ManualClock(0)
+	skewedClock := hlc.NewClock(skewed.UnixNano)
+	setTime := func(nanos int64) {
+		*mc = hlc.ManualClock(nanos)
+		skewed = hlc.ManualClock(nanos + maxOffset.Nanoseconds())
+	}
+	leaseArgs := func(replica proto.Replica, duration time.Duration) (*proto.InternalLeaderLeaseRequest, *proto.InternalLeaderLeaseResponse) {
+		now := skewedClock.Now()
+		expiration := now
+		expiration.WallTime += duration.Nanoseconds()
+		return &proto.InternalLeaderLeaseRequest{
+			RequestHeader: proto.RequestHeader{Key: desc.StartKey, Timestamp: now},
+			Lease:         proto.Lease{Start: now, Expiration: expiration, Replica: replica},
+		}, &proto.InternalLeaderLeaseResponse{}
+	}
+
+	setTime(time.Second.Nanoseconds())
+	gArgs, gReply := getArgs([]byte("a"), 1)
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	lease := rng.getLeaderLease()
+	if lease == nil || lease.Replica.StoreID != s.StoreID() {
+		t.Fatalf("expected lease held by store %d; got %+v", s.StoreID(), lease)
+	}
+
+	// The lease is valid on its holder until the maximum offset before
+	// its expiration.
+	setTime(lease.Expiration.WallTime - maxOffset.Nanoseconds() - 1)
+	if err := rng.verifyLeaderLease(clock.Now()); err != nil {
+		t.Errorf("expected valid lease; got %s", err)
+	}
+	// Meanwhile, the second replica can't acquire it.
+	lArgs, lReply := leaseArgs(desc.Replicas[1], DefaultLeaderLeaseDuration)
+	if err := rng.AddCmd(proto.InternalLeaderLease, lArgs, lReply, true); err == nil {
+		t.Fatal("expected second replica's lease request to be refused")
+	}
+
+	// Once the second replica's clock reaches the expiration, the lease
+	// is no longer used by its holder and may be acquired.
+	setTime(lease.Expiration.WallTime - maxOffset.Nanoseconds())
+	if err := rng.verifyLeaderLease(clock.Now()); err == nil {
+		t.Error("expected lease to be invalid on its holder")
+	}
+	lArgs, lReply = leaseArgs(desc.Replicas[1], DefaultLeaderLeaseDuration)
+	if err := rng.AddCmd(proto.InternalLeaderLease, lArgs, lReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// A lease no longer than the maximum offset is rejected.
+	setTime(lArgs.Lease.Expiration.WallTime + 1)
+	lArgs, lReply = leaseArgs(desc.Replicas[1], maxOffset)
+	if err := rng.AddCmd(proto.InternalLeaderLease, lArgs, lReply, true); err == nil {
+		t.Error("expected lease no longer than the maximum clock offset to be rejected")
+	}
+}
+
+// TestRangeRaftSnapshot verifies that a snapshot of a range's data
+// replaces the data written after it when applied, and that a
+// snapshot no newer than the last applied index is ignored.
+func TestRangeRaftSnapshot(t *testing.T) {
+	s, rng, _, eng := createTestRange(t)
+	defer s.Stop()
+
+	put := func(key, value string) {
+		pArgs, pReply := putArgs([]byte(key), []byte(value), 1)
+		pArgs.Timestamp = s.Clock().Now()
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verify := func(key, expValue string) {
+		val, err := engine.MVCCGet(eng, proto.Key(key), s.Clock().Now(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expValue == "" && val != nil {
+			t.Errorf("expected no value for %q; got %q", key, val.Bytes)
+		} else if expValue != "" && (val == nil || string(val.Bytes) != expValue) {
+			t.Errorf("expected value %q for %q; got %+v", expValue, key, val)
+		}
+	}
+
+	put("a", "1")
+	put("b", "2")
+	snapshotID, err := s.CreateSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := rng.snapshotData(rng.Desc, snapshotID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.ReleaseSnapshot(snapshotID); err != nil {
+		t.Fatal(err)
+	}
+	put("a", "changed")
+	put("c", "3")
+
+	rng.RLock()
+	index := rng.appliedIndex + 1
+	rng.RUnlock()
+	if err := rng.applySnapshot(data, index); err != nil {
+		t.Fatal(err)
+	}
+	verify("a", "1")
+	verify("b", "2")
+	verify("c", "")
+	val, err := engine.MVCCGet(eng, engine.RaftAppliedIndexKey(rng.Desc.RaftID), proto.ZeroTimestamp, nil)
+	if err != nil || val == nil || uint64(val.GetInteger()) != index {
+		t.Errorf("expected applied index %d; got %+v, %v", index, val, err)
+	}
+
+	// Reapplying the snapshot at the same index has no effect.
+	put("c", "3")
+	if err := rng.applySnapshot(data, index); err != nil {
+		t.Fatal(err)
+	}
+	verify("c", "3")
+}
+
+// TestRangeRaftSnapshotSpan verifies that applying a snapshot whose
+// descriptor covers a different span of keys updates the store's
+// range lookups, and that a snapshot overlapping another range of the
+// store is refused.
+func TestRangeRaftSnapshotSpan(t *testing.T) {
+	s, rng, _, _ := createTestRange(t)
+	defer s.Stop()
+
+	snapshot := func(endKey proto.Key) []byte {
+		desc := *rng.Desc
+		desc.EndKey = endKey
+		snapshotID, err := s.CreateSnapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Engine().ReleaseSnapshot(snapshotID)
+		data, err := rng.snapshotData(&desc, snapshotID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	// The replica missed a split at "m".
+	rng.RLock()
+	index := rng.appliedIndex + 1
+	rng.RUnlock()
+	if err := rng.applySnapshot(snapshot(proto.Key("m")), index); err != nil {
+		t.Fatal(err)
+	}
+	if r := s.LookupRange(proto.Key("a"), nil); r != rng {
+		t.Errorf("expected range %d to contain %q; got %+v", rng.RangeID, "a", r)
+	}
+	if r := s.LookupRange(proto.Key("n"), nil); r != nil {
+		t.Errorf("expected no range to contain %q; got range %d", "n", r.RangeID)
+	}
+
+	// A snapshot overlapping another range of the store is refused.
+	newDesc, err := s.NewRangeDescriptor(proto.Key("m"), engine.KeyMax, rng.Desc.Replicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRange(NewRange(newDesc.Replicas[0].RangeID, newDesc, s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rng.applySnapshot(snapshot(engine.KeyMax), index+1); err == nil {
+		t.Error("expected overlapping snapshot to be refused")
+	}
+	if !rng.Desc.EndKey.Equal(proto.Key("m")) {
+		t.Errorf("expected range to end at %q; got %q", "m", rng.Desc.EndKey)
+	}
+}
+
+// TestRangeSnapshotMaxSize verifies that a range whose data exceeds
+// maxSnapshotSize isn't snapshotted.
+func TestRangeSnapshotMaxSize(t *testing.T) {
+	defer func(size int64) { maxSnapshotSize = size }(maxSnapshotSize)
+	s, rng, _, _ := createTestRange(t)
+	defer s.Stop()
+
+	pArgs, pReply := putArgs([]byte("a"), make([]byte, 1024), 1)
+	pArgs.Timestamp = s.Clock().Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	maxSnapshotSize = 1024
+	snapshotID, err := s.CreateSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Engine().ReleaseSnapshot(snapshotID)
+	if _, err := rng.snapshotData(rng.Desc, snapshotID); err == nil {
+		t.Error("expected snapshot exceeding the maximum size to fail")
+	}
+}
+
+// TestRangeRaftCommandAppliedIndex verifies that a raft command
+// records its index as applied along with its effects, and that a
+// command replayed at or below the applied index is skipped.
+func TestRangeRaftCommandAppliedIndex(t *testing.T) {
+	s, rng, _, eng := createTestRange(t)
+	defer s.Stop()
+
+	rng.RLock()
+	index := rng.appliedIndex + 1
+	rng.RUnlock()
+	process := func(value string, index uint64) {
+		pArgs, _ := putArgs([]byte("a"), []byte(value), 1)
+		pArgs.Timestamp = s.Clock().Now()
+		raftCmd := proto.InternalRaftCommand{RaftID: rng.Desc.RaftID}
+		raftCmd.Cmd.SetValue(pArgs)
+		rng.processRaftCommand(raftCmd, index)
+	}
+
+	process("1", index)
+	val, err := engine.MVCCGet(eng, engine.RaftAppliedIndexKey(rng.Desc.RaftID), proto.ZeroTimestamp, nil)
+	if err != nil || val == nil || uint64(val.GetInteger()) != index {
+		t.Errorf("expected applied index %d; got %+v, %v", index, val, err)
+	}
+
+	// Replaying the log doesn't apply the command again.
+	process("2", index)
+	val, err = engine.MVCCGet(eng, proto.Key("a"), s.Clock().Now(), nil)
+	if err != nil || val == nil || string(val.Bytes) != "1" {
+		t.Errorf("expected value \"1\"; got %+v, %v", val, err)
+	}
+}
+
+// TestRangeAdminChangeReplicasErrors verifies that invalid changes to
+// the range's replica set are rejected.
+func TestRangeAdminChangeReplicasErrors(t *testing.T) {
+	s, rng, _, _ := createTestRange(t)
+	defer s.Stop()
+
+	testCases := []struct {
+		changeType proto.ReplicaChangeType
+		replica    proto.Replica
+	}{
+		// Node 1 already holds a replica.
+		{proto.ADD_REPLICA, proto.Replica{NodeID: 1, StoreID: 2, RangeID: 2}},
+		// The replica executing the change can't be removed.
+		{proto.REMOVE_REPLICA, proto.Replica{NodeID: 1, StoreID: 1, RangeID: 1}},
+		// Store 3 doesn't hold a replica.
+		{proto.REMOVE_REPLICA, proto.Replica{NodeID: 3, StoreID: 3, RangeID: 3}},
+	}
+	for i, test := range testCases {
+		args := &proto.AdminChangeReplicasRequest{
+			RequestHeader: proto.RequestHeader{Key: engine.KeyMin},
+			ChangeType:    test.changeType,
+			Replica:       test.replica,
+		}
+		if err := rng.AddCmd(proto.AdminChangeReplicas, args, &proto.AdminChangeReplicasResponse{}, true); err == nil {
+			t.Errorf("%d: expected error changing replicas", i)
+		}
+	}
+}
+
+// TestRangeChangeReplicasLeaseHolder verifies that only the holder of
+// the leader lease changes the range's replicas and proposes committed
+// changes to the Raft group.
+func TestRangeChangeReplicasLeaseHolder(t *testing.T) {
+	s, rng, _, _ := createTestRange(t)
+	defer s.Stop()
+
+	desc := *rng.Desc
+	desc.Replicas = append(append([]proto.Replica(nil), desc.Replicas...),
+		proto.Replica{NodeID: 2, StoreID: 2, RangeID: 2})
+	rng.Lock()
+	rng.Desc = &desc
+	rng.Unlock()
+	setLease := func(replica proto.Replica) {
+		now := s.Clock().Now()
+		expiration := now
+		expiration.WallTime += DefaultLeaderLeaseDuration.Nanoseconds()
+		rng.applyLeaderLease(&proto.Lease{Start: now, Expiration: expiration, Replica: replica})
+	}
+	removal := &proto.ChangeReplicasTrigger{
+		UpdatedDesc: testRangeDescriptor,
+		ChangeType:  proto.REMOVE_REPLICA,
+		Replica:     desc.Replicas[1],
+	}
+	changed, stopWatching := rng.watchMemberChanges()
+	defer stopWatching()
+
+	// The replica on the other store holds the lease.
+	setLease(desc.Replicas[1])
+	args := &proto.AdminChangeReplicasRequest{
+		RequestHeader: proto.RequestHeader{Key: engine.KeyMin},
+		ChangeType:    proto.REMOVE_REPLICA,
+		Replica:       desc.Replicas[1],
+	}
+	err := rng.AddCmd(proto.AdminChangeReplicas, args, &proto.AdminChangeReplicasResponse{}, true)
+	if nlErr, ok := err.(*proto.NotLeaderError); !ok || nlErr.Leader.StoreID != 2 {
+		t.Fatalf("expected not leader error naming store 2; got %v", err)
+	}
+	rng.maybeProposeMemberChange(removal)
+	select {
+	case change := <-changed:
+		t.Fatalf("expected no membership change without the lease; got %+v", change)
+	case <-time.After(20 * raftTickInterval):
+	}
+
+	// Once this store's replica holds the lease, the committed removal
+	// is proposed to the Raft group.
+	setLease(desc.Replicas[0])
+	rng.maybeProposeMemberChange(removal)
+	select {
+	case change := <-changed:
+		if change.changeType != proto.REMOVE_REPLICA || change.nodeID != raftNodeID(2) {
+			t.Errorf("expected removal of node 2; got %+v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for membership change")
+	}
+}
+
+// TestRangeHoldsStructuredRows verifies that a range is taken to hold
+// structured rows exactly if it overlaps the key prefix of a stored
+// schema.
+func TestRangeHoldsStructuredRows(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+
+	prefix := proto.Key(encoding.EncodeString(nil, "sk"))
+	if err := store.ExecuteCmd(proto.AdminSplit, &proto.AdminSplitRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:     engine.KeyMin,
+			Replica: proto.Replica{StoreID: 1, RangeID: 1},
+		},
+		SplitKey: prefix.PrefixEnd(),
+	}, &proto.AdminSplitResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	rangeA := store.LookupRange(prefix, nil)
+	rangeB := store.LookupRange(prefix.PrefixEnd(), nil)
+
+	expectStructured := func(expA, expB bool) {
+		for _, test := range []struct {
+			rng      *Range
+			expected bool
+		}{{rangeA, expA}, {rangeB, expB}} {
+			structured, err := test.rng.holdsStructuredRows()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if structured != test.expected {
+				t.Errorf("range %d: expected structured rows %t; got %t", test.rng.RangeID, test.expected, structured)
+			}
+		}
+	}
+	expectStructured(false, false)
+
+	if err := store.DB().Call(proto.Put, &proto.PutRequest{
+		RequestHeader: proto.RequestHeader{Key: engine.MakeKey(engine.KeySchemaPrefix, proto.Key("sk"))},
+		Value:         proto.Value{Bytes: []byte("schema")},
+	}, &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	expectStructured(true, false)
+}
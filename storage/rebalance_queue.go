@@ -0,0 +1,222 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// rebalanceInterval is the interval between processing successive
+// ranges from a store's rebalance queue.
+var rebalanceInterval = 10 * time.Second
+
+// rebalanceQueue is an in-memory queue of ranges on a store which
+// may need a replica moved from an over-utilized store to an
+// under-utilized one. Ranges are added when the store starts, when a
+// range is split and when zone configs or store capacities change.
+// Ranges are processed one at a time; each queued range is present at
+// most once. Once the queue drains, all of the store's ranges are
+// queued again so that the store keeps rebalancing as capacities shift.
+type rebalanceQueue struct {
+	store *Store
+
+	sync.Mutex // Protects the following fields
+	ranges     []*Range
+	queued     map[int64]struct{} // Range IDs of queued ranges
+}
+
+// newRebalanceQueue returns a new, empty rebalance queue for the store.
+func newRebalanceQueue(store *Store) *rebalanceQueue {
+	return &rebalanceQueue{
+		store:  store,
+		queued: map[int64]struct{}{},
+	}
+}
+
+// add adds the range to the queue if it's not already queued.
+func (rq *rebalanceQueue) add(rng *Range) {
+	rq.Lock()
+	defer rq.Unlock()
+	if _, ok := rq.queued[rng.RangeID]; ok {
+		return
+	}
+	rq.queued[rng.RangeID] = struct{}{}
+	rq.ranges = append(rq.ranges, rng)
+}
+
+// addAll adds all of the store's ranges to the queue.
+func (rq *rebalanceQueue) addAll() {
+	rq.store.mu.RLock()
+	defer rq.store.mu.RUnlock()
+	for _, rng := range rq.store.ranges {
+		rq.add(rng)
+	}
+}
+
+// pop removes and returns the range at the head of the queue, or nil
+// if the queue is empty.
+func (rq *rebalanceQueue) pop() *Range {
+	rq.Lock()
+	defer rq.Unlock()
+	if len(rq.ranges) == 0 {
+		return nil
+	}
+	rng := rq.ranges[0]
+	rq.ranges = rq.ranges[1:]
+	delete(rq.queued, rng.RangeID)
+	return rng
+}
+
+// remove removes the range from the queue if it's queued.
+func (rq *rebalanceQueue) remove(rng *Range) {
+	rq.Lock()
+	defer rq.Unlock()
+	if _, ok := rq.queued[rng.RangeID]; !ok {
+		return
+	}
+	delete(rq.queued, rng.RangeID)
+	for i, queued := range rq.ranges {
+		if queued.RangeID == rng.RangeID {
+			rq.ranges = append(rq.ranges[:i], rq.ranges[i+1:]...)
+			break
+		}
+	}
+}
+
+// length returns the number of queued ranges.
+func (rq *rebalanceQueue) length() int {
+	rq.Lock()
+	defer rq.Unlock()
+	return len(rq.ranges)
+}
+
+// start processes queued ranges every rebalanceInterval until the
+// closer channel is closed.
+func (rq *rebalanceQueue) start(closer chan struct{}) {
+	ticker := time.NewTicker(rebalanceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rng := rq.pop()
+			if rng == nil {
+				rq.addAll()
+				continue
+			}
+			if err := rq.process(rng); err != nil {
+				log.Errorf("unable to rebalance range %d: %s", rng.RangeID, err)
+			}
+		case <-closer:
+			return
+		}
+	}
+}
+
+// process rebalances the range if one of its replicas resides on an
+// over-utilized store. A replica is first added on the least-utilized
+// store which matches the zone's attributes for the over-utilized
+// replica and is then removed from the over-utilized store. Only the
+// holder of the leader lease of a fully-replicated range rebalances
+// it, so that its replicas don't make conflicting changes.
+func (rq *rebalanceQueue) process(rng *Range) error {
+	if err := rng.verifyLeaderLease(rq.store.Clock().Now()); err != nil {
+		return nil
+	}
+	zone, err := rng.getZoneConfig()
+	if err != nil {
+		return err
+	}
+	rng.RLock()
+	replicas := append([]proto.Replica(nil), rng.Desc.Replicas...)
+	rng.RUnlock()
+	if len(replicas) < len(zone.ReplicaAttrs) {
+		log.V(1).Infof("range %d is not fully replicated; skipping rebalance", rng.RangeID)
+		return nil
+	}
+
+	alloc := rq.store.Allocator()
+	source, err := alloc.rebalanceSource(replicas)
+	if err != nil || source == nil {
+		return err
+	}
+	// The lease holder can't remove its own replica; the range is left
+	// for a later lease holder to rebalance.
+	if source.StoreID == rq.store.StoreID() {
+		log.V(1).Infof("range %d lease holder is on over-utilized store %d; skipping rebalance", rng.RangeID, source.StoreID)
+		return nil
+	}
+	target, err := alloc.rebalanceTarget(rebalanceAttrs(zone, replicas, source), replicas)
+	if err != nil || target == nil {
+		return err
+	}
+	replica := proto.Replica{
+		NodeID:  target.Node.NodeID,
+		StoreID: target.StoreID,
+		RangeID: rq.store.rangeIDAlloc.Allocate(),
+		Attrs:   *target.CombinedAttrs(),
+	}
+	log.Infof("rebalancing range %d from store %d to store %d", rng.RangeID, source.StoreID, target.StoreID)
+	if err := rq.changeReplicas(rng, proto.ADD_REPLICA, replica); err != nil {
+		return util.Errorf("unable to add replica on store %d: %s", target.StoreID, err)
+	}
+	if err := rq.changeReplicas(rng, proto.REMOVE_REPLICA, *source); err != nil {
+		return util.Errorf("unable to remove replica from store %d: %s", source.StoreID, err)
+	}
+	return nil
+}
+
+// changeReplicas adds or removes the replica to or from the range via
+// an AdminChangeReplicas command.
+func (rq *rebalanceQueue) changeReplicas(rng *Range, changeType proto.ReplicaChangeType, replica proto.Replica) error {
+	rng.RLock()
+	key := rng.Desc.StartKey
+	rng.RUnlock()
+	return rng.AddCmd(proto.AdminChangeReplicas, &proto.AdminChangeReplicasRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+		ChangeType:    changeType,
+		Replica:       replica,
+	}, &proto.AdminChangeReplicasResponse{}, true)
+}
+
+// rebalanceAttrs returns the attributes required of the store which
+// replaces source. These are the zone's replica attributes satisfied
+// by source or, if source matches none, the first of the zone's
+// replica attributes which is not satisfied by any of the replicas.
+func rebalanceAttrs(zone *proto.ZoneConfig, replicas []proto.Replica, source *proto.Replica) proto.Attributes {
+	for _, attrs := range zone.ReplicaAttrs {
+		if attrs.IsSubset(source.Attrs) {
+			return attrs
+		}
+	}
+	for _, attrs := range zone.ReplicaAttrs {
+		satisfied := false
+		for _, replica := range replicas {
+			if attrs.IsSubset(replica.Attrs) {
+				satisfied = true
+				break
+			}
+		}
+		if !satisfied {
+			return attrs
+		}
+	}
+	return proto.Attributes{}
+}
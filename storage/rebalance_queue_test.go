@@ -0,0 +1,148 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// TestRebalanceQueueAddPop verifies that ranges are queued at most
+// once and popped in the order in which they were added.
+func TestRebalanceQueueAddPop(t *testing.T) {
+	rq := newRebalanceQueue(nil)
+	r1, r2, r3 := &Range{RangeID: 1}, &Range{RangeID: 2}, &Range{RangeID: 3}
+	rq.add(r1)
+	rq.add(r2)
+	rq.add(r1)
+	rq.add(r3)
+	if l := rq.length(); l != 3 {
+		t.Fatalf("expected 3 queued ranges; got %d", l)
+	}
+	rq.remove(r2)
+	for i, exp := range []*Range{r1, r3, nil} {
+		if rng := rq.pop(); rng != exp {
+			t.Errorf("%d: expected range %+v; got %+v", i, exp, rng)
+		}
+	}
+	// A popped range may be queued again.
+	rq.add(r1)
+	if rng := rq.pop(); rng != r1 {
+		t.Errorf("expected range 1; got %+v", rng)
+	}
+}
+
+// TestRebalanceQueueCapacityUpdate verifies that a change to the
+// gossiped capacity of a store queues all of the store's ranges.
+func TestRebalanceQueueCapacityUpdate(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	for store.rebalanceQ.pop() != nil {
+	}
+
+	store.capacityGossipUpdate(gossip.KeyMaxAvailCapacityPrefix+"1", false)
+	if l := store.rebalanceQ.length(); l != 0 {
+		t.Errorf("expected no queued ranges for an unchanged capacity; got %d", l)
+	}
+	store.capacityGossipUpdate(gossip.KeyMaxAvailCapacityPrefix+"1", true)
+	if l, exp := store.rebalanceQ.length(), len(store.allRanges()); l != exp || l == 0 {
+		t.Errorf("expected %d queued ranges; got %d", exp, l)
+	}
+}
+
+// TestRebalanceQueueLeaseHolder verifies that of the two replicas of
+// a range, only the one holding the leader lease rebalances it.
+func TestRebalanceQueueLeaseHolder(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+
+	zoneConfig := testDefaultZoneConfig
+	zoneConfig.ReplicaAttrs = []proto.Attributes{{}, {}}
+	configMap, err := NewPrefixConfigMap([]*PrefixConfig{{engine.KeyMin, nil, &zoneConfig}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.gossip.AddInfo(gossip.KeyConfigZone, configMap, 0*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	rng := store.LookupRange(engine.KeyMin, nil)
+	desc := *rng.Desc
+	desc.Replicas = append(append([]proto.Replica(nil), desc.Replicas...),
+		proto.Replica{NodeID: 2, StoreID: 2, RangeID: 2})
+	rng.Lock()
+	rng.Desc = &desc
+	rng.Unlock()
+
+	// Count the allocator's lookups of stores, which a rebalance begins with.
+	lookups := 0
+	store.allocator.storeFinder = func(proto.Attributes) ([]*StoreDescriptor, error) {
+		lookups++
+		return nil, nil
+	}
+	setLease := func(replica proto.Replica) {
+		now := store.Clock().Now()
+		expiration := now
+		expiration.WallTime += DefaultLeaderLeaseDuration.Nanoseconds()
+		rng.applyLeaderLease(&proto.Lease{Start: now, Expiration: expiration, Replica: replica})
+	}
+
+	// The replica on the other store holds the lease.
+	setLease(desc.Replicas[1])
+	if err := store.rebalanceQ.process(rng); err != nil || lookups != 0 {
+		t.Errorf("expected no rebalance without the lease; got %v with %d lookups", err, lookups)
+	}
+	// This store's replica holds the lease.
+	setLease(desc.Replicas[0])
+	store.rebalanceQ.process(rng)
+	if lookups == 0 {
+		t.Error("expected the lease holder to attempt a rebalance")
+	}
+}
+
+// TestRebalanceAttrs verifies the choice of attributes required of a
+// replacement replica.
+func TestRebalanceAttrs(t *testing.T) {
+	ssd := proto.Attributes{Attrs: []string{"a", "ssd"}}
+	hdd := proto.Attributes{Attrs: []string{"a", "hdd"}}
+	mem := proto.Attributes{Attrs: []string{"a", "mem"}}
+	replicas := []proto.Replica{
+		{StoreID: 1, Attrs: ssd},
+		{StoreID: 2, Attrs: proto.Attributes{Attrs: []string{"b", "hdd"}}},
+		{StoreID: 3, Attrs: mem},
+	}
+	testCases := []struct {
+		source   int
+		expAttrs proto.Attributes
+	}{
+		// A replica matching the zone config is replaced in kind.
+		{0, ssd},
+		{2, mem},
+		// A mismatched replica is replaced by one with the missing attributes.
+		{1, hdd},
+	}
+	for i, test := range testCases {
+		attrs := rebalanceAttrs(&multiDisksConfig, replicas, &replicas[test.source])
+		if !reflect.DeepEqual(attrs, test.expAttrs) {
+			t.Errorf("%d: expected attributes %v; got %v", i, test.expAttrs, attrs)
+		}
+	}
+}
@@ -135,6 +135,17 @@ func (rc *ResponseCache) GetResponse(cmdID proto.ClientCmdID, reply proto.Respon
 	return false, nil
 }
 
+// hasResponse returns true if a response is cached for the specified
+// cmdID. Unlike GetResponse, it neither waits on nor marks the
+// command as inflight.
+func (rc *ResponseCache) hasResponse(cmdID proto.ClientCmdID) (bool, error) {
+	if cmdID.IsEmpty() {
+		return false, nil
+	}
+	rwResp := proto.ReadWriteCmdResponse{}
+	return engine.MVCCGetProto(rc.engine, responseCacheKey(rc.rangeID, cmdID), proto.ZeroTimestamp, nil, &rwResp)
+}
+
 // CopyInto copies all the cached results from one response cache into
 // another. The cache will be locked while copying is in progress;
 // failures decoding individual cache entries return an error. The
@@ -189,12 +200,12 @@ func (rc *ResponseCache) PutResponse(cmdID proto.ClientCmdID, reply proto.Respon
 }
 
 // shouldCacheResponse returns whether the response should be cached.
-// Responses with write-too-old and write-intent errors are are
+// Responses with write-too-old, write-intent and txn push errors are
 // retried on the server, and so are not recorded in the response
 // cache in the hopes of retrying to a successful outcome.
 func (rc *ResponseCache) shouldCacheResponse(reply proto.Response) bool {
 	switch reply.Header().GoError().(type) {
-	case *proto.WriteTooOldError, *proto.WriteIntentError:
+	case *proto.WriteTooOldError, *proto.WriteIntentError, *proto.TransactionPushError:
 		return false
 	}
 	return true
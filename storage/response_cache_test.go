@@ -212,7 +212,6 @@ func TestResponseCacheShouldCache(t *testing.T) {
 		{nil, true},
 		{&proto.ReadWithinUncertaintyIntervalError{}, true},
 		{&proto.TransactionAbortedError{}, true},
-		{&proto.TransactionPushError{}, true},
 		{&proto.TransactionRetryError{}, true},
 		{&proto.GenericError{}, true},
 		{&proto.RangeNotFoundError{}, true},
@@ -220,6 +219,7 @@ func TestResponseCacheShouldCache(t *testing.T) {
 		{&proto.TransactionStatusError{}, true},
 		{&proto.WriteIntentError{}, false},
 		{&proto.WriteTooOldError{}, false},
+		{&proto.TransactionPushError{}, false},
 	}
 
 	for i, test := range testCases {
@@ -29,6 +29,7 @@ import (
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/multiraft"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
@@ -42,6 +43,14 @@ const (
 	// GCResponseCacheExpiration is the expiration duration for response
 	// cache entries.
 	GCResponseCacheExpiration = 1 * time.Hour
+	// GCTransactionExpiration is the expiration duration for the
+	// records of finished transactions.
+	GCTransactionExpiration = 1 * time.Hour
+	// GCTxnTombstoneExpiration is the expiration duration for the
+	// records of transactions aborted without listing their intents.
+	// These are kept longer than other finished transactions so that
+	// a coordinator which fell behind still learns of the abort.
+	GCTxnTombstoneExpiration = 24 * GCTransactionExpiration
 	// raftIDAllocCount is the number of Raft IDs to allocate per allocation.
 	raftIDAllocCount = 10
 	// rangeIDAllocCount is the number of range IDs to allocate per allocation.
@@ -60,6 +69,14 @@ var RangeRetryOptions = util.RetryOptions{
 	MaxAttempts: 0, // retry indefinitely
 }
 
+// MaxTxnWait bounds the time a transactional pusher which loses a push
+// waits in the pushee's range for the pushee to commit, abort or
+// expire before the push fails. Zero disables waiting, so that losing
+// pushers fail immediately and restart with backoff. It's also what
+// breaks deadlocks among transactions waiting on different stores,
+// which deadlock detection doesn't see (see waitForPushee).
+var MaxTxnWait = 1 * time.Minute
+
 // verifyKeyLength verifies key length. Extra key length is allowed for
 // the local key prefix (for example, a transaction record), and also for
 // keys prefixed with the meta1 or meta2 addressing prefixes. There is a
@@ -168,13 +185,20 @@ type Store struct {
 
 	Ident        proto.StoreIdent
 	clock        *hlc.Clock
-	engine       engine.Engine  // The underlying key-value store
-	db           *client.KV     // Cockroach KV DB
-	allocator    *allocator     // Makes allocation decisions
-	gossip       *gossip.Gossip // Configs and store capacities
-	raftIDAlloc  *IDAllocator   // Raft ID allocator
-	rangeIDAlloc *IDAllocator   // Range ID allocator
-	configMu     sync.Mutex     // Limit config update processing
+	engine       engine.Engine   // The underlying key-value store
+	db           *client.KV      // Cockroach KV DB
+	allocator    *allocator      // Makes allocation decisions
+	gossip       *gossip.Gossip  // Configs and store capacities
+	raftIDAlloc  *IDAllocator    // Raft ID allocator
+	rangeIDAlloc *IDAllocator    // Range ID allocator
+	configMu     sync.Mutex      // Limit config update processing
+	rebalanceQ   *rebalanceQueue // Ranges which may need rebalancing
+	updateQ      *updateQueue    // Applies updates enqueued on ranges
+	txnGCQ       *txnGCQueue     // Garbage collects transaction records
+	gcQ          *gcQueue        // Garbage collects expired MVCC versions
+	intentQ      *intentQueue    // Resolves abandoned intents
+	txnCache     *TxnCache       // Recently pushed and finished txns
+	transport    multiraft.Transport
 	raft         raft
 	closer       chan struct{}
 
@@ -184,8 +208,10 @@ type Store struct {
 	rangesByRaftID map[int64]*Range // Map of ranges by raft ID
 }
 
-// NewStore returns a new instance of a store.
-func NewStore(clock *hlc.Clock, eng engine.Engine, db *client.KV, gossip *gossip.Gossip) *Store {
+// NewStore returns a new instance of a store. The transport is used
+// to communicate with the other members of the Raft consensus groups
+// of the store's ranges.
+func NewStore(clock *hlc.Clock, eng engine.Engine, db *client.KV, gossip *gossip.Gossip, transport multiraft.Transport) *Store {
 	s := &Store{
 		StoreFinder: &StoreFinder{gossip: gossip},
 
@@ -194,16 +220,23 @@ func NewStore(clock *hlc.Clock, eng engine.Engine, db *client.KV, gossip *gossip
 		db:             db,
 		allocator:      &allocator{},
 		gossip:         gossip,
-		raft:           newNoopRaft(),
+		transport:      transport,
 		closer:         make(chan struct{}),
 		ranges:         map[int64]*Range{},
 		rangesByRaftID: map[int64]*Range{},
 	}
 	s.allocator.storeFinder = s.findStores
+	s.rebalanceQ = newRebalanceQueue(s)
+	s.updateQ = newUpdateQueue(s)
+	s.txnGCQ = newTxnGCQueue(s)
+	s.gcQ = newGCQueue(s)
+	s.intentQ = newIntentQueue(s)
+	s.txnCache = NewTxnCache(defaultTxnCacheSize)
 	return s
 }
 
-// Stop calls Range.Stop() on all active ranges.
+// Stop calls Range.Stop() on all active ranges and shuts down Raft
+// processing.
 func (s *Store) Stop() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -213,8 +246,13 @@ func (s *Store) Stop() {
 	s.ranges = map[int64]*Range{}
 	s.rangesByKey = nil
 	s.rangesByRaftID = map[int64]*Range{}
+	s.rebalanceQ = newRebalanceQueue(s)
 	close(s.closer)
 	s.closer = make(chan struct{})
+	if s.raft != nil {
+		s.raft.stop()
+		s.raft = nil
+	}
 }
 
 // String formats a store for debug output.
@@ -241,7 +279,7 @@ func (s *Store) Start() error {
 	// response cache entries.
 	s.engine.SetGCTimeouts(func() (minTxnTS, minRCacheTS int64) {
 		now := s.clock.Now()
-		minTxnTS = 0 // disable GC of transactions until we know minimum write intent age
+		minTxnTS = 0 // transaction records are garbage collected by the txn GC queue
 		minRCacheTS = now.WallTime - GCResponseCacheExpiration.Nanoseconds()
 		return
 	})
@@ -254,6 +292,16 @@ func (s *Store) Start() error {
 		return &NotBootstrappedError{}
 	}
 
+	// Create the Raft instance through which this store participates
+	// in the consensus groups of its ranges, replacing any instance
+	// left over from a previous call to Start.
+	if s.raft != nil {
+		s.raft.stop()
+	}
+	if s.raft, err = newMultiRaft(raftNodeID(s.Ident.StoreID), s.transport, newRaftStorage(s.engine)); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	start := engine.KeyLocalRangeDescriptorPrefix
@@ -268,12 +316,25 @@ func (s *Store) Start() error {
 		if err := gogoproto.Unmarshal(kv.Value.Bytes, &desc); err != nil {
 			return false, err
 		}
-		rangeID := desc.FindReplica(s.Ident.StoreID).RangeID
+		// A descriptor without a replica on this store belongs to a
+		// replica whose removal from the range had not yet been
+		// applied when the store stopped.
+		replica := storeReplica(&desc, s.Ident.StoreID)
+		if replica == nil {
+			log.Warningf("store %d has no replica of range %q-%q; skipping", s.Ident.StoreID,
+				proto.Key(desc.StartKey), proto.Key(desc.EndKey))
+			return false, nil
+		}
+		rangeID := replica.RangeID
 		rng := NewRange(rangeID, &desc, s)
+		if err := s.raft.createGroup(desc.RaftID, raftMembers(&desc)); err != nil {
+			return false, err
+		}
 		rng.start()
 		s.ranges[rangeID] = rng
 		s.rangesByKey = append(s.rangesByKey, rng)
 		s.rangesByRaftID[desc.RaftID] = rng
+		s.rebalanceQ.add(rng)
 		return false, nil
 	}); err != nil {
 		return err
@@ -282,7 +343,20 @@ func (s *Store) Start() error {
 	sort.Sort(s.rangesByKey)
 
 	// Start Raft processing goroutine.
-	go s.processRaft(s.closer)
+	go s.processRaft(s.raft.committed(), s.closer)
+
+	// Start applying updates enqueued on the store's ranges.
+	go s.updateQ.start(s.closer)
+
+	// Start garbage collecting transaction records and response cache
+	// entries of the store's ranges.
+	go s.txnGCQ.start(s.closer)
+
+	// Start garbage collecting expired versions of the store's ranges.
+	go s.gcQ.start(s.closer)
+
+	// Start resolving intents abandoned by transaction coordinators.
+	go s.intentQ.start(s.closer)
 
 	// Register callbacks for any changes to accounting and zone
 	// configurations; we split ranges along prefix boundaries.
@@ -293,11 +367,24 @@ func (s *Store) Start() error {
 		// Callback triggers on capacity gossip from all stores.
 		capacityRegex := fmt.Sprintf("%s.*", gossip.KeyMaxAvailCapacityPrefix)
 		s.gossip.RegisterCallback(capacityRegex, s.capacityGossipUpdate)
+		// Rebalancing relies on gossiped zone configs and capacities.
+		go s.rebalanceQ.start(s.closer)
 	}
 
 	return nil
 }
 
+// capacityGossipUpdate is a callback for gossip updates to the
+// capacity of any store. In addition to tracking the store for the
+// StoreFinder, a changed capacity queues the store's ranges for
+// rebalancing, since a replica may now reside on an over-utilized store.
+func (s *Store) capacityGossipUpdate(key string, contentsChanged bool) {
+	s.StoreFinder.capacityGossipUpdate(key, contentsChanged)
+	if contentsChanged {
+		s.rebalanceQ.addAll()
+	}
+}
+
 // configGossipUpdate is a callback for gossip updates to
 // configuration maps which affect range split boundaries.
 func (s *Store) configGossipUpdate(key string, contentsChanged bool) {
@@ -320,6 +407,10 @@ func (s *Store) configGossipUpdate(key string, contentsChanged bool) {
 			return
 		}
 		s.maybeSplitRangesByConfigs(configMap)
+		// Replicas may no longer match an updated zone config.
+		if key == gossip.KeyConfigZone {
+			s.rebalanceQ.addAll()
+		}
 	default:
 		log.Warningf("unhandled gossip update to key %s", key)
 		return
@@ -406,6 +497,14 @@ func (s *Store) GetRange(rangeID int64) (*Range, error) {
 	return nil, proto.NewRangeNotFoundError(rangeID)
 }
 
+// allRanges returns a slice of the store's ranges, in key order. The
+// slice is a copy and may be used without holding the store's lock.
+func (s *Store) allRanges() []*Range {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*Range(nil), s.rangesByKey...)
+}
+
 // LookupRange looks up a range via binary search over the sorted
 // "rangesByKey" RangeSlice. Returns nil if no range is found for
 // specified key range. Note that the specified keys are transformed
@@ -512,6 +611,23 @@ func (s *Store) Engine() engine.Engine { return s.engine }
 // DB accessor.
 func (s *Store) DB() *client.KV { return s.db }
 
+// UpdateQueueDepth returns the number of updates enqueued on the
+// store's ranges which were pending as of the update queue's last
+// pass.
+func (s *Store) UpdateQueueDepth() int64 { return s.updateQ.length() }
+
+// VisitRanges implements a visitor pattern over the store's ranges.
+// The specified function is invoked with each range in turn, in key
+// order.
+func (s *Store) VisitRanges(visitor func(r *Range) error) error {
+	for _, rng := range s.allRanges() {
+		if err := visitor(rng); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Allocator accessor.
 func (s *Store) Allocator() *allocator { return s.allocator }
 
@@ -551,25 +667,138 @@ func (s *Store) SplitRange(origRng, newRng *Range) error {
 	// concurrent range accesses.
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if err := s.raft.createGroup(newRng.Desc.RaftID, raftMembers(newRng.Desc)); err != nil {
+		return err
+	}
 	origRng.Desc.EndKey = append([]byte(nil), newRng.Desc.StartKey...)
 	newRng.start()
 	s.ranges[newRng.RangeID] = newRng
 	s.rangesByKey = append(s.rangesByKey, newRng)
 	s.rangesByRaftID[newRng.Desc.RaftID] = newRng
 	sort.Sort(s.rangesByKey)
+	// Each half of the split may need rebalancing.
+	s.rebalanceQ.add(origRng)
+	s.rebalanceQ.add(newRng)
 	return nil
 }
 
 // AddRange adds the range to the store's range map and to the sorted
-// rangesByKey slice.
-func (s *Store) AddRange(rng *Range) {
+// rangesByKey slice and joins the range's Raft consensus group.
+func (s *Store) AddRange(rng *Range) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if err := s.raft.createGroup(rng.Desc.RaftID, raftMembers(rng.Desc)); err != nil {
+		return err
+	}
+	s.addRangeLocked(rng)
+	return nil
+}
+
+// addRangeLocked starts the range and adds it to the store's range
+// maps. The store's lock must be held.
+func (s *Store) addRangeLocked(rng *Range) {
 	rng.start()
 	s.ranges[rng.RangeID] = rng
 	s.rangesByKey = append(s.rangesByKey, rng)
-	s.rangesByRaftID[rng.RangeID] = rng
+	s.rangesByRaftID[rng.Desc.RaftID] = rng
+	sort.Sort(s.rangesByKey)
+}
+
+// CommitSnapshot commits the batch holding a snapshot of the range's
+// data and replaces the range's descriptor with the snapshot's, which
+// may cover a different span of keys if this replica missed a split
+// or merge. As with SplitRange, this is done with the store lock held
+// to prevent races with LookupRange. The snapshot is refused if its
+// span overlaps another range of the store.
+func (s *Store) CommitSnapshot(rng *Range, desc *proto.RangeDescriptor, batch engine.Engine) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.rangesByKey {
+		if other != rng && other.Desc.StartKey.Less(desc.EndKey) && desc.StartKey.Less(other.Desc.EndKey) {
+			return util.Errorf("snapshot span %q-%q overlaps range %d %q-%q",
+				desc.StartKey, desc.EndKey, other.RangeID, other.Desc.StartKey, other.Desc.EndKey)
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	rng.Lock()
+	rng.Desc = desc
+	rng.Unlock()
 	sort.Sort(s.rangesByKey)
+	return nil
+}
+
+// storeReplica returns the replica of the range described by desc
+// which resides on the given store, or nil if there is none.
+func storeReplica(desc *proto.RangeDescriptor, storeID int32) *proto.Replica {
+	for i := range desc.Replicas {
+		if desc.Replicas[i].StoreID == storeID {
+			return &desc.Replicas[i]
+		}
+	}
+	return nil
+}
+
+// addRangeFromSnapshot creates a replica of a range which has been
+// added to this store from the snapshot with which the leader of the
+// range's consensus group brings the new replica up to date. The
+// consensus group itself was joined when its first message arrived.
+func (s *Store) addRangeFromSnapshot(raftID int64, data []byte, index uint64) error {
+	snap := &proto.RaftSnapshotData{}
+	if err := gogoproto.Unmarshal(data, snap); err != nil {
+		return util.Errorf("unable to decode snapshot of raft group %d: %s", raftID, err)
+	}
+	desc := snap.RangeDescriptor
+	if desc.RaftID != raftID {
+		return util.Errorf("snapshot of raft group %d received for raft group %d", desc.RaftID, raftID)
+	}
+	replica := storeReplica(&desc, s.StoreID())
+	if replica == nil {
+		return util.Errorf("snapshot of raft group %d has no replica on store %d", raftID, s.StoreID())
+	}
+	rng := NewRange(replica.RangeID, &desc, s)
+	if err := rng.applySnapshot(data, index); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addRangeLocked(rng)
+	log.Infof("added replica of range %d to store %d", rng.RangeID, s.StoreID())
+	return nil
+}
+
+// MergeRange expands the subsuming range to absorb the adjacent range
+// with the given Raft ID, whose data has been taken over by the
+// subsuming range. The subsumed range is stopped and removed from the
+// store's range maps, and the store leaves its consensus group. Its
+// Raft log and state are cleared by the merge trigger.
+func (s *Store) MergeRange(subsumingRng *Range, updatedEndKey proto.Key, subsumedRaftID int64) error {
+	if !subsumingRng.Desc.EndKey.Less(updatedEndKey) {
+		return util.Errorf("the new end key is not greater than the current one: %q <= %q",
+			updatedEndKey, subsumingRng.Desc.EndKey)
+	}
+	// As with SplitRange, the end key of the subsuming range is updated
+	// with the store lock held to prevent races with LookupRange.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subsumedRng, ok := s.rangesByRaftID[subsumedRaftID]
+	if !ok {
+		return util.Errorf("could not find the subsumed range with raft ID %d", subsumedRaftID)
+	}
+	if !bytes.Equal(subsumedRng.Desc.StartKey, subsumingRng.Desc.EndKey) {
+		return util.Errorf("range %d %q-%q is not adjacent to subsumed range %d %q-%q",
+			subsumingRng.RangeID, subsumingRng.Desc.StartKey, subsumingRng.Desc.EndKey,
+			subsumedRng.RangeID, subsumedRng.Desc.StartKey, subsumedRng.Desc.EndKey)
+	}
+	if err := s.removeRangeLocked(subsumedRng); err != nil {
+		return util.Errorf("unable to remove subsumed range %d: %s", subsumedRng.RangeID, err)
+	}
+	if err := s.raft.removeGroup(subsumedRaftID); err != nil {
+		return util.Errorf("unable to remove raft group of subsumed range %d: %s", subsumedRng.RangeID, err)
+	}
+	subsumingRng.Desc.EndKey = append([]byte(nil), updatedEndKey...)
+	return nil
 }
 
 // RemoveRange removes the range from the store's range map and from
@@ -577,7 +806,15 @@ func (s *Store) AddRange(rng *Range) {
 func (s *Store) RemoveRange(rng *Range) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.removeRangeLocked(rng)
+}
+
+// removeRangeLocked stops the range, fails its pending commands and
+// removes it from the store's range maps. The store's lock must be
+// held.
+func (s *Store) removeRangeLocked(rng *Range) error {
 	rng.stop()
+	rng.failPendingCmds()
 	delete(s.ranges, rng.RangeID)
 	// Find the range in rangesByKey slice and swap it to end of slice
 	// and truncate.
@@ -590,7 +827,9 @@ func (s *Store) RemoveRange(rng *Range) error {
 	lastIdx := len(s.rangesByKey) - 1
 	s.rangesByKey[lastIdx], s.rangesByKey[n] = s.rangesByKey[n], s.rangesByKey[lastIdx]
 	s.rangesByKey = s.rangesByKey[:lastIdx]
+	sort.Sort(s.rangesByKey)
 	delete(s.rangesByRaftID, rng.Desc.RaftID)
+	s.rebalanceQ.remove(rng)
 	return nil
 }
 
@@ -665,6 +904,7 @@ func (s *Store) ExecuteCmd(method string, args proto.Request, reply proto.Respon
 	// Backoff and retry loop for handling errors.
 	retryOpts := RangeRetryOptions
 	retryOpts.Tag = method
+	var waitDeadline time.Time
 	err = util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
 		// Add the command to the range for execution; exit retry loop on success.
 		reply.Reset()
@@ -697,6 +937,17 @@ func (s *Store) ExecuteCmd(method string, args proto.Request, reply proto.Respon
 				header.Timestamp.Logical++
 			}
 			return util.RetryContinue, nil
+		case *proto.TransactionPushError:
+			// A transactional pusher which loses waits for the pushee to
+			// finish and then pushes again.
+			if method == proto.InternalPushTxn && header.Txn != nil && MaxTxnWait > 0 {
+				if waitDeadline.IsZero() {
+					waitDeadline = time.Now().Add(MaxTxnWait)
+				}
+				if s.waitForPushee(rng, args.(*proto.InternalPushTxnRequest), &t.PusheeTxn, waitDeadline) {
+					return util.RetryReset, nil
+				}
+			}
 		}
 		return util.RetryBreak, nil
 	})
@@ -718,6 +969,11 @@ func (s *Store) ExecuteCmd(method string, args proto.Request, reply proto.Respon
 // error's Resolved flag to true so the client retries the command
 // immediately. If the push fails, we set the error's Resolved flag to
 // false so that the client backs off before reissuing the command.
+//
+// The push is skipped if the store's txn cache shows the conflicting
+// transaction to be already finished, or already pushed past the
+// command's timestamp on a read/write conflict; the intent is then
+// resolved using the cached transaction.
 func (s *Store) maybeResolveWriteIntentError(rng *Range, method string, args proto.Request, reply proto.Response) error {
 	err := reply.Header().GoError()
 	wiErr, ok := err.(*proto.WriteIntentError)
@@ -727,35 +983,43 @@ func (s *Store) maybeResolveWriteIntentError(rng *Range, method string, args pro
 
 	log.V(1).Infof("resolving write intent on %s %q: %s", method, args.Header().Key, wiErr)
 
-	// Attempt to push the transaction which created the conflicting intent.
-	pushArgs := &proto.InternalPushTxnRequest{
-		RequestHeader: proto.RequestHeader{
-			Timestamp:    args.Header().Timestamp,
-			Key:          wiErr.Txn.ID,
-			User:         args.Header().User,
-			UserPriority: args.Header().UserPriority,
-			Txn:          args.Header().Txn,
-		},
-		PusheeTxn: wiErr.Txn,
-		Abort:     proto.IsReadWrite(method), // abort if cmd is read/write
-	}
-	pushReply := &proto.InternalPushTxnResponse{}
-	s.db.Call(proto.InternalPushTxn, pushArgs, pushReply)
-	if pushErr := pushReply.GoError(); pushErr != nil {
-		log.V(1).Infof("push %q failed: %s", pushArgs.Header().Key, pushErr)
-
-		// For write/write conflicts within a transaction, propagate the
-		// push failure, not the original write intent error. The push
-		// failure will instruct the client to restart the transaction
-		// with a backoff.
-		if args.Header().Txn != nil && proto.IsReadWrite(method) {
-			reply.Header().SetGoError(pushErr)
-			return pushErr
-		}
-		// For read/write conflicts, return the write intent error which
-		// engages backoff/retry (with !Resolved). We don't need to
-		// restart the txn, only resend the read with a backoff.
-		return err
+	abort := proto.IsReadWrite(method) // abort if cmd is read/write
+	pushee, ok := s.txnCache.Get(wiErr.Txn.ID)
+	if ok && isPushed(pushee, &wiErr.Txn, abort, args.Header().Timestamp) {
+		log.V(1).Infof("found pushed txn %s in txn cache", pushee)
+	} else {
+		// Attempt to push the transaction which created the conflicting intent.
+		pushArgs := &proto.InternalPushTxnRequest{
+			RequestHeader: proto.RequestHeader{
+				Timestamp:    args.Header().Timestamp,
+				Key:          wiErr.Txn.ID,
+				User:         args.Header().User,
+				UserPriority: args.Header().UserPriority,
+				Txn:          args.Header().Txn,
+			},
+			PusheeTxn: wiErr.Txn,
+			Abort:     abort,
+		}
+		pushReply := &proto.InternalPushTxnResponse{}
+		s.db.Call(proto.InternalPushTxn, pushArgs, pushReply)
+		if pushErr := pushReply.GoError(); pushErr != nil {
+			log.V(1).Infof("push %q failed: %s", pushArgs.Header().Key, pushErr)
+
+			// For write/write conflicts within a transaction, propagate the
+			// push failure, not the original write intent error. The push
+			// failure will instruct the client to restart the transaction
+			// with a backoff.
+			if args.Header().Txn != nil && abort {
+				reply.Header().SetGoError(pushErr)
+				return pushErr
+			}
+			// For read/write conflicts, return the write intent error which
+			// engages backoff/retry (with !Resolved). We don't need to
+			// restart the txn, only resend the read with a backoff.
+			return err
+		}
+		pushee = pushReply.PusheeTxn
+		s.txnCache.Add(pushee)
 	}
 	wiErr.Resolved = true // success!
 
@@ -765,10 +1029,10 @@ func (s *Store) maybeResolveWriteIntentError(rng *Range, method string, args pro
 			// Use the pushee's timestamp, which might be lower than the
 			// pusher's request timestamp. No need to push the intent higher
 			// than the pushee's txn!
-			Timestamp: pushReply.PusheeTxn.Timestamp,
+			Timestamp: pushee.Timestamp,
 			Key:       wiErr.Key,
 			User:      UserRoot,
-			Txn:       pushReply.PusheeTxn,
+			Txn:       pushee,
 		},
 	}
 	resolveReply := &proto.InternalResolveIntentResponse{}
@@ -780,14 +1044,125 @@ func (s *Store) maybeResolveWriteIntentError(rng *Range, method string, args pro
 	return wiErr
 }
 
+// isPushed returns true if the cached state of the intent's
+// transaction makes a push unnecessary: the transaction is committed
+// or aborted, or, if it need not be aborted, its timestamp has already
+// been pushed past the pusher's timestamp in the intent's epoch.
+func isPushed(cached, intentTxn *proto.Transaction, abort bool, timestamp proto.Timestamp) bool {
+	if cached.Status != proto.PENDING {
+		return true
+	}
+	return !abort && cached.Epoch >= intentTxn.Epoch && timestamp.Less(cached.Timestamp)
+}
+
+// waitForPushee blocks the pusher of a failed push in the range's txn
+// wait queue until the pushee's record changes or the pushee's
+// heartbeat expires. Returns true if the push should be retried, or
+// false if the deadline passed or the store is stopping. If waiting
+// would deadlock the pusher, the push is forced instead, aborting the
+// pushee or pushing its timestamp regardless of priority.
+//
+// Deadlocks are detected by following the push graph through the txn
+// wait queues of the store's ranges; cycles which span stores aren't
+// detected and are only broken by the deadline.
+func (s *Store) waitForPushee(rng *Range, args *proto.InternalPushTxnRequest, pushee *proto.Transaction, deadline time.Time) bool {
+	w := rng.txnWaitQ.Enqueue(args.Txn, pushee)
+	defer rng.txnWaitQ.Dequeue(w)
+
+	// Having been enqueued, the pusher is visible to transactions which
+	// push it later; at least one member of a cycle sees the full
+	// cycle.
+	if s.isDeadlocked(args.Txn.ID, pushee.ID) {
+		log.V(1).Infof("pusher %s is deadlocked with pushee %s", args.Txn, pushee)
+		args.Force = true
+		return true
+	}
+
+	lastHeartbeat := pushee.Timestamp
+	if pushee.LastHeartbeat != nil {
+		lastHeartbeat = *pushee.LastHeartbeat
+	}
+	expiration := time.Duration(lastHeartbeat.WallTime + 2*DefaultHeartbeatInterval.Nanoseconds() - s.clock.PhysicalNow())
+	log.V(1).Infof("pusher %s waiting on pushee %s", args.Txn, pushee)
+	select {
+	case <-w.Updated:
+		return true
+	case <-time.After(expiration):
+		return true
+	case <-time.After(deadline.Sub(time.Now())):
+		return false
+	case <-s.closer:
+		return false
+	}
+}
+
+// isDeadlocked returns whether the pushee, or any transaction it's
+// waiting on in turn, is waiting on the pusher in the txn wait queue
+// of one of the store's ranges.
+func (s *Store) isDeadlocked(pusherID, pusheeID []byte) bool {
+	ranges := s.allRanges()
+	visited := map[string]struct{}{}
+	queue := [][]byte{pusheeID}
+	for len(queue) > 0 {
+		txnID := queue[0]
+		queue = queue[1:]
+		if bytes.Equal(txnID, pusherID) {
+			return true
+		}
+		if _, ok := visited[string(txnID)]; ok {
+			continue
+		}
+		visited[string(txnID)] = struct{}{}
+		for _, rng := range ranges {
+			queue = append(queue, rng.txnWaitQ.WaitingOn(txnID)...)
+		}
+	}
+	return false
+}
+
 // ProposeRaftCommand submits a command to raft.
 func (s *Store) ProposeRaftCommand(cmd proto.InternalRaftCommand) {
 	s.raft.propose(cmd)
 }
 
-// processRaft processes read/write commands that have been committed
-// by the raft consensus algorithm, dispatching them to the
-// appropriate range. This method processes indefinitely or until
+// CompactRaftLog discards the Raft log of the consensus group with
+// the given Raft ID through index, replacing it with a snapshot
+// containing data.
+func (s *Store) CompactRaftLog(raftID int64, index uint64, data []byte) error {
+	return s.raft.compact(raftID, index, data)
+}
+
+// ChangeRaftMembership proposes adding or removing the replica on the
+// given store to or from the consensus group with the given Raft ID.
+func (s *Store) ChangeRaftMembership(raftID int64, changeType proto.ReplicaChangeType, storeID int32) error {
+	return s.raft.changeMembership(raftID, changeType, raftNodeID(storeID))
+}
+
+// applyMemberChange handles a change to the membership of the range's
+// consensus group which has been committed. If this store's replica
+// was removed, the store leaves the consensus group, and the range is
+// removed from the store and its data, including its Raft log and
+// state, is cleared.
+func (s *Store) applyMemberChange(rng *Range, change memberChange) error {
+	rng.memberChangeCommitted(change)
+	if change.changeType != proto.REMOVE_REPLICA || change.nodeID != raftNodeID(s.StoreID()) {
+		return nil
+	}
+	log.Infof("removing replica of range %d from store %d", rng.RangeID, s.StoreID())
+	if err := s.raft.removeGroup(rng.Desc.RaftID); err != nil {
+		return err
+	}
+	if err := s.RemoveRange(rng); err != nil {
+		return err
+	}
+	return rng.Destroy()
+}
+
+// processRaft processes read/write commands, membership changes and
+// snapshots that have been committed by the raft consensus algorithm,
+// dispatching them to the appropriate range, as well as commands
+// whose proposal raft abandoned. A snapshot for a raft
+// group without a range creates a replica newly added to this store. This method processes indefinitely or until
 // Store.Stop() is invoked.
 //
 // TODO(bdarnell): when Raft elects this node as the leader for any
@@ -805,24 +1180,97 @@ func (s *Store) ProposeRaftCommand(cmd proto.InternalRaftCommand) {
 //   and be able to access the new leader's state machine BEFORE
 //   the overlapping writes are applied.
 //
-// TODO(bdarnell): remove the closer argument and access s.closer directly
-// when we no longer reassign s.closer in s.Stop.
-func (s *Store) processRaft(closer chan struct{}) {
+// TODO: remove the committed and closer arguments and access
+// s.raft and s.closer directly when we no longer reassign them in s.Stop.
+func (s *Store) processRaft(committed <-chan committedEntry, closer chan struct{}) {
+	// Entries of raft groups held back by a merge, keyed by Raft ID.
+	held := map[int64][]committedEntry{}
 	for {
 		select {
-		case raftCmd := <-s.raft.committed():
-			s.mu.Lock()
-			r, ok := s.rangesByRaftID[raftCmd.RaftID]
-			s.mu.Unlock()
-			if !ok {
-				log.Errorf("got committed raft command for %d but have no range with that ID",
-					raftCmd.RaftID)
-			} else {
-				r.processRaftCommand(raftCmd)
-			}
+		case entry := <-committed:
+			s.applyRaftEntry(entry, held)
 
 		case <-closer:
 			return
 		}
 	}
 }
+
+// applyRaftEntry dispatches a committed raft entry to its range. The
+// commit of a merge and all entries of the subsuming range which
+// follow it are held back until this store's replica of the subsumed
+// range has applied the freeze preceding the merge (see AdminMerge),
+// as the merge takes over that replica's data. Once the subsumed
+// replica has caught up, the held entries are applied.
+func (s *Store) applyRaftEntry(entry committedEntry, held map[int64][]committedEntry) {
+	if entries, ok := held[entry.raftID]; ok {
+		held[entry.raftID] = append(entries, entry)
+		return
+	}
+	if !s.mergeCaughtUp(entry) {
+		held[entry.raftID] = []committedEntry{entry}
+		return
+	}
+
+	s.mu.Lock()
+	r, ok := s.rangesByRaftID[entry.raftID]
+	s.mu.Unlock()
+	switch {
+	case !ok && entry.snapshot != nil:
+		if err := s.addRangeFromSnapshot(entry.raftID, entry.snapshot, entry.index); err != nil {
+			log.Errorf("unable to add range from raft snapshot: %s", err)
+		}
+	case !ok:
+		log.Errorf("got committed raft entry for %d but have no range with that ID",
+			entry.raftID)
+	case entry.err != nil:
+		r.abandonRaftCommand(entry.cmd, entry.err)
+	case entry.snapshot != nil:
+		if err := r.applySnapshot(entry.snapshot, entry.index); err != nil {
+			log.Errorf("unable to apply raft snapshot: %s", err)
+		}
+	case entry.change != nil:
+		if err := s.applyMemberChange(r, *entry.change); err != nil {
+			log.Errorf("unable to apply raft membership change: %s", err)
+		}
+	default:
+		r.processRaftCommand(entry.cmd, entry.index)
+	}
+
+	// The entry may have caught up a subsumed replica.
+	for raftID, entries := range held {
+		if !s.mergeCaughtUp(entries[0]) {
+			continue
+		}
+		delete(held, raftID)
+		for _, e := range entries {
+			s.applyRaftEntry(e, held)
+		}
+	}
+}
+
+// mergeCaughtUp returns false if the entry commits a merge whose
+// subsumed range has a replica on this store which hasn't yet applied
+// the freeze preceding the merge.
+func (s *Store) mergeCaughtUp(entry committedEntry) bool {
+	if entry.err != nil || entry.snapshot != nil || entry.change != nil {
+		return true
+	}
+	args, ok := entry.cmd.Cmd.GetValue().(*proto.EndTransactionRequest)
+	if !ok || !args.Commit || args.MergeTrigger == nil {
+		return true
+	}
+	subsumedDesc := &args.MergeTrigger.SubsumedDesc
+	s.mu.Lock()
+	_, ok = s.rangesByRaftID[subsumedDesc.RaftID]
+	s.mu.Unlock()
+	if !ok {
+		// The merge trigger fails without a subsumed replica.
+		return true
+	}
+	frozen, err := mergeFrozen(s.engine, subsumedDesc.StartKey)
+	if err != nil {
+		log.Errorf("unable to read merge freeze of range %q: %s", subsumedDesc.StartKey, err)
+	}
+	return frozen || err != nil
+}
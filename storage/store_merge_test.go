@@ -0,0 +1,287 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+func adminMergeArgs(key []byte, rangeID int64) (*proto.AdminMergeRequest, *proto.AdminMergeResponse) {
+	args := &proto.AdminMergeRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:     key,
+			Replica: proto.Replica{RangeID: rangeID},
+		},
+	}
+	reply := &proto.AdminMergeResponse{}
+	return args, reply
+}
+
+// createSplitRanges splits the first range at "b" and returns the
+// two resulting ranges.
+func createSplitRanges(store *storage.Store, t *testing.T) (*storage.Range, *storage.Range) {
+	args, reply := adminSplitArgs(engine.KeyMin, []byte("b"), 1)
+	if err := store.ExecuteCmd(proto.AdminSplit, args, reply); err != nil {
+		t.Fatal(err)
+	}
+	rangeA := store.LookupRange([]byte("a"), nil)
+	rangeB := store.LookupRange([]byte("c"), nil)
+	if rangeA.RangeID == rangeB.RangeID {
+		t.Fatalf("expected split at \"b\"; got a single range %d", rangeA.RangeID)
+	}
+	return rangeA, rangeB
+}
+
+// TestStoreRangeMergeTwoEmptyRanges verifies that two adjacent, empty
+// ranges are merged into one range covering both key spans.
+func TestStoreRangeMergeTwoEmptyRanges(t *testing.T) {
+	store := createTestStore(t)
+	defer store.Stop()
+
+	rangeA, rangeB := createSplitRanges(store, t)
+
+	args, reply := adminMergeArgs(engine.KeyMin, rangeA.RangeID)
+	if err := store.ExecuteCmd(proto.AdminMerge, args, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	rng := store.LookupRange([]byte("a"), nil)
+	if rng != rangeA || store.LookupRange([]byte("c"), nil) != rangeA {
+		t.Errorf("expected range %d to cover both halves of the split", rangeA.RangeID)
+	}
+	if !bytes.Equal(rng.Desc.StartKey, engine.KeyMin) || !bytes.Equal(rng.Desc.EndKey, engine.KeyMax) {
+		t.Errorf("expected merged range to cover KeyMin-KeyMax; got %q-%q", rng.Desc.StartKey, rng.Desc.EndKey)
+	}
+	if _, err := store.GetRange(rangeB.RangeID); err == nil {
+		t.Errorf("expected subsumed range %d to be removed from the store", rangeB.RangeID)
+	}
+}
+
+// TestStoreRangeMergeWithData verifies that the data, stats and
+// response cache of the subsumed range are taken over by the merged
+// range.
+func TestStoreRangeMergeWithData(t *testing.T) {
+	store := createTestStore(t)
+	defer store.Stop()
+	content := proto.Key("testing!")
+
+	rangeA, rangeB := createSplitRanges(store, t)
+
+	// Write some values left and right of the split key.
+	pArgs, pReply := putArgs([]byte("aaa"), content, rangeA.RangeID)
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+	pArgs, pReply = putArgs([]byte("ccc"), content, rangeB.RangeID)
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+	incArgs, incReply := incrementArgs([]byte("wobble"), 10, rangeB.RangeID)
+	incArgs.CmdID = proto.ClientCmdID{WallTime: 12, Random: 42}
+	if err := store.ExecuteCmd(proto.Increment, incArgs, incReply); err != nil {
+		t.Fatal(err)
+	}
+
+	msA, err := engine.MVCCGetRangeStats(store.Engine(), rangeA.RangeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msB, err := engine.MVCCGetRangeStats(store.Engine(), rangeB.RangeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, reply := adminMergeArgs(engine.KeyMin, rangeA.RangeID)
+	if err := store.ExecuteCmd(proto.AdminMerge, args, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify both values are readable from the merged range.
+	for _, key := range []string{"aaa", "ccc"} {
+		gArgs, gReply := getArgs([]byte(key), rangeA.RangeID)
+		if err := store.ExecuteCmd(proto.Get, gArgs, gReply); err != nil {
+			t.Fatal(err)
+		}
+		if gReply.Value == nil || !bytes.Equal(gReply.Value.Bytes, content) {
+			t.Errorf("%s: expected value %q; got %+v", key, content, gReply.Value)
+		}
+	}
+
+	// Resending the increment to the merged range must be answered
+	// from the response cache copied from the subsumed range.
+	incArgs.Replica.RangeID = rangeA.RangeID
+	incReply = &proto.IncrementResponse{}
+	if err := store.ExecuteCmd(proto.Increment, incArgs, incReply); err != nil {
+		t.Fatal(err)
+	}
+	if incReply.NewValue != 10 {
+		t.Errorf("response cache not copied correctly to merged range; expected %d but got %d", 10, incReply.NewValue)
+	}
+
+	// The stats of the merged range must be the sum of both ranges'
+	// and those of the subsumed range must be cleared. The merge
+	// transaction itself wrote range descriptors and addressing
+	// records, which only adds keys or versions.
+	ms, err := engine.MVCCGetRangeStats(store.Engine(), rangeA.RangeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.KeyCount < msA.KeyCount+msB.KeyCount {
+		t.Errorf("expected merged key count >= %d + %d; got %d", msA.KeyCount, msB.KeyCount, ms.KeyCount)
+	}
+	verifyRangeStats(store.Engine(), rangeB.RangeID, engine.MVCCStats{}, t)
+}
+
+// TestStoreRangeMergeRaftState verifies that the merge clears the Raft
+// log and state of the subsumed range.
+func TestStoreRangeMergeRaftState(t *testing.T) {
+	store := createTestStore(t)
+	defer store.Stop()
+
+	rangeA, rangeB := createSplitRanges(store, t)
+	raftID := rangeB.Desc.RaftID
+
+	spans := func() [][2]proto.Key {
+		logPrefix := engine.RaftLogPrefix(raftID)
+		stateKey := engine.RaftStateKey(raftID)
+		return [][2]proto.Key{{logPrefix, logPrefix.PrefixEnd()}, {stateKey, stateKey.Next()}}
+	}
+	for _, span := range spans() {
+		kvs, err := engine.Scan(store.Engine(), engine.MVCCEncodeKey(span[0]), engine.MVCCEncodeKey(span[1]), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(kvs) == 0 {
+			t.Fatalf("expected raft data for range %d at %q before merge", rangeB.RangeID, span[0])
+		}
+	}
+
+	args, reply := adminMergeArgs(engine.KeyMin, rangeA.RangeID)
+	if err := store.ExecuteCmd(proto.AdminMerge, args, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, span := range spans() {
+		kvs, err := engine.Scan(store.Engine(), engine.MVCCEncodeKey(span[0]), engine.MVCCEncodeKey(span[1]), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(kvs) != 0 {
+			t.Errorf("expected raft data at %q to be cleared by merge; got %d keys", span[0], len(kvs))
+		}
+	}
+	// The freeze of the subsumed range was lifted by the merge.
+	pArgs, pReply := putArgs([]byte("ccc"), []byte("value"), rangeA.RangeID)
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStoreRangeMergeFrozen verifies that a range frozen for a merge
+// rejects writes to its data but still serves reads.
+func TestStoreRangeMergeFrozen(t *testing.T) {
+	store := createTestStore(t)
+	defer store.Stop()
+
+	_, rangeB := createSplitRanges(store, t)
+
+	freezeKey := engine.RangeMergeFreezeKey(rangeB.Desc.StartKey)
+	if err := store.DB().Call(proto.Put, &proto.PutRequest{
+		RequestHeader: proto.RequestHeader{Key: freezeKey},
+		Value:         proto.Value{Integer: gogoproto.Int64(1)},
+	}, &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	pArgs, pReply := putArgs([]byte("ccc"), []byte("value"), rangeB.RangeID)
+	err := store.ExecuteCmd(proto.Put, pArgs, pReply)
+	if _, ok := err.(*proto.RangeKeyMismatchError); !ok {
+		t.Fatalf("expected write to frozen range to fail with range key mismatch; got %v", err)
+	}
+	gArgs, gReply := getArgs([]byte("ccc"), rangeB.RangeID)
+	if err := store.ExecuteCmd(proto.Get, gArgs, gReply); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lifting the freeze allows writes again.
+	if err := store.DB().Call(proto.Delete, &proto.DeleteRequest{
+		RequestHeader: proto.RequestHeader{Key: freezeKey},
+	}, &proto.DeleteResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	pArgs, pReply = putArgs([]byte("ccc"), []byte("value"), rangeB.RangeID)
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStoreRangeMergeOnDelete verifies that a deletion which leaves a
+// range below the zone's minimum size merges it with the range which
+// follows it.
+func TestStoreRangeMergeOnDelete(t *testing.T) {
+	store := createTestStore(t)
+	defer store.Stop()
+
+	zoneConfig := &proto.ZoneConfig{
+		ReplicaAttrs:  []proto.Attributes{proto.Attributes{}},
+		RangeMinBytes: 1 << 20,
+		RangeMaxBytes: 1 << 22,
+	}
+	if err := store.DB().PutProto(engine.MakeKey(engine.KeyConfigZonePrefix, engine.KeyMin), zoneConfig); err != nil {
+		t.Fatal(err)
+	}
+	rangeA, rangeB := createSplitRanges(store, t)
+
+	pArgs, pReply := putArgs([]byte("aaa"), []byte("value"), rangeA.RangeID)
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+	dArgs := &proto.DeleteRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:     []byte("aaa"),
+			Replica: proto.Replica{RangeID: rangeA.RangeID},
+		},
+	}
+	if err := store.ExecuteCmd(proto.Delete, dArgs, &proto.DeleteResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := util.IsTrueWithin(func() bool {
+		return store.LookupRange([]byte("c"), nil) == rangeA
+	}, time.Second); err != nil {
+		t.Errorf("expected range %d to be merged into range %d", rangeB.RangeID, rangeA.RangeID)
+	}
+}
+
+// TestStoreRangeMergeLastRange verifies that merging the last range
+// fails.
+func TestStoreRangeMergeLastRange(t *testing.T) {
+	store := createTestStore(t)
+	defer store.Stop()
+
+	args, reply := adminMergeArgs(engine.KeyMin, 1)
+	if err := store.ExecuteCmd(proto.AdminMerge, args, reply); err == nil {
+		t.Error("expected an error merging the last range")
+	}
+}
@@ -24,12 +24,14 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	gogoproto "code.google.com/p/gogoprotobuf/proto"
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/multiraft"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/rpc"
 	"github.com/cockroachdb/cockroach/storage/engine"
@@ -93,7 +95,7 @@ func createTestStore(t *testing.T) (*Store, *hlc.ManualClock) {
 	manual := hlc.ManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	eng := engine.NewInMem(proto.Attributes{}, 1<<20)
-	store := NewStore(clock, eng, nil, g)
+	store := NewStore(clock, eng, nil, g, multiraft.NewLocalRPCTransport())
 	if err := store.Bootstrap(proto.StoreIdent{StoreID: 1}); err != nil {
 		t.Fatal(err)
 	}
@@ -110,13 +112,27 @@ func createTestStore(t *testing.T) (*Store, *hlc.ManualClock) {
 	return store, &manual
 }
 
+// holdLeaderLease installs a leader lease held by the store's replica
+// of the range which never expires, so that the store's queues
+// process the range however far a test advances the clock.
+func holdLeaderLease(t *testing.T, store *Store, rangeID int64) {
+	rng, err := store.GetRange(rangeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng.applyLeaderLease(&proto.Lease{
+		Expiration: proto.MaxTimestamp,
+		Replica:    *rng.GetReplica(),
+	})
+}
+
 // TestStoreInitAndBootstrap verifies store initialization and
 // bootstrap.
 func TestStoreInitAndBootstrap(t *testing.T) {
 	manual := hlc.ManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
 	eng := engine.NewInMem(proto.Attributes{}, 1<<20)
-	store := NewStore(clock, eng, nil, nil)
+	store := NewStore(clock, eng, nil, nil, multiraft.NewLocalRPCTransport())
 
 	// Can't start as haven't bootstrapped.
 	if err := store.Start(); err == nil {
@@ -140,7 +156,7 @@ func TestStoreInitAndBootstrap(t *testing.T) {
 	}
 
 	// Now, attempt to initialize a store with a now-bootstrapped range.
-	store = NewStore(clock, eng, nil, nil)
+	store = NewStore(clock, eng, nil, nil, multiraft.NewLocalRPCTransport())
 	if err := store.Start(); err != nil {
 		t.Errorf("failure initializing bootstrapped store: %s", err)
 	}
@@ -162,7 +178,7 @@ func TestBootstrapOfNonEmptyStore(t *testing.T) {
 	}
 	manual := hlc.ManualClock(0)
 	clock := hlc.NewClock(manual.UnixNano)
-	store := NewStore(clock, eng, nil, nil)
+	store := NewStore(clock, eng, nil, nil, multiraft.NewLocalRPCTransport())
 
 	// Can't init as haven't bootstrapped.
 	if err := store.Start(); err == nil {
@@ -422,8 +438,10 @@ func TestStoreRangesByKey(t *testing.T) {
 // TestStoreResolveWriteIntent adds write intent and then verifies
 // that a put returns success and aborts intent's txn in the event the
 // pushee has lower priority. Othwerise, verifies that a
-// TransactionPushError is returned.
+// TransactionPushError is returned when pushers don't wait.
 func TestStoreResolveWriteIntent(t *testing.T) {
+	defer func(maxWait time.Duration) { MaxTxnWait = maxWait }(MaxTxnWait)
+	MaxTxnWait = 0
 	store, _ := createTestStore(t)
 	defer store.Stop()
 
@@ -480,6 +498,179 @@ func TestStoreResolveWriteIntent(t *testing.T) {
 	}
 }
 
+// pushCountingSender counts the InternalPushTxn calls it forwards.
+type pushCountingSender struct {
+	testSender
+	pushes int32
+}
+
+// Send counts pushes and forwards the call to the test sender.
+func (db *pushCountingSender) Send(call *client.Call) {
+	if call.Method == proto.InternalPushTxn {
+		atomic.AddInt32(&db.pushes, 1)
+	}
+	db.testSender.Send(call)
+}
+
+// TestStoreResolveWriteIntentTxnCache verifies that once a push has
+// aborted a transaction, further intents of that transaction are
+// resolved using the store's txn cache instead of pushing again.
+func TestStoreResolveWriteIntentTxnCache(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	sender := &pushCountingSender{testSender: testSender{store: store}}
+	store.db = client.NewKV(sender, nil)
+
+	keys := []proto.Key{proto.Key("a"), proto.Key("b")}
+	pushee := newTransaction("pushee", keys[0], 1, proto.SERIALIZABLE, store.clock)
+	pusher := newTransaction("pusher", keys[0], 1, proto.SERIALIZABLE, store.clock)
+	pushee.Priority = 1
+	pusher.Priority = 2 // Pusher will win.
+
+	// Lay down intents using the pushee's txn.
+	for _, key := range keys {
+		pArgs, pReply := putArgs(key, []byte("value"), 1)
+		pArgs.Timestamp = store.clock.Now()
+		pArgs.Txn = pushee
+		if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Writing each key with the pusher's txn succeeds, but only the
+	// first intent requires a push.
+	for i, key := range keys {
+		pArgs, pReply := putArgs(key, []byte("value2"), 1)
+		pArgs.Timestamp = store.clock.Now()
+		pArgs.Txn = pusher
+		if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+			t.Fatalf("%d: expected intent resolved; got unexpected error: %s", i, err)
+		}
+		if pushes := atomic.LoadInt32(&sender.pushes); pushes != 1 {
+			t.Errorf("%d: expected 1 push; got %d", i, pushes)
+		}
+	}
+	if txn, ok := store.txnCache.Get(pushee.ID); !ok || txn.Status != proto.ABORTED {
+		t.Errorf("expected aborted pushee in txn cache; got %s, %t", txn, ok)
+	}
+}
+
+// waitForTxnWaiters waits until the given number of pushers are
+// waiting in the range's txn wait queue.
+func waitForTxnWaiters(rng *Range, count int, t *testing.T) {
+	for i := 0; rng.txnWaitQ.Len() != count; i++ {
+		if i == 500 {
+			t.Fatalf("expected %d waiting pushers; got %d", count, rng.txnWaitQ.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestStoreTxnWaitQueue verifies that a transactional pusher which
+// loses against a pending pushee waits for the pushee to finish and
+// then proceeds.
+func TestStoreTxnWaitQueue(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	rng := store.LookupRange(proto.Key("a"), nil)
+
+	key := proto.Key("a")
+	pusher := newTransaction("pusher", key, 1, proto.SERIALIZABLE, store.clock)
+	pushee := newTransaction("pushee", key, 1, proto.SERIALIZABLE, store.clock)
+	pushee.Priority = 2
+	pusher.Priority = 1 // Pusher will lose.
+
+	pArgs, pReply := putArgs(key, []byte("value"), 1)
+	pArgs.Timestamp = store.clock.Now()
+	pArgs.Txn = pushee
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		pArgs, pReply := putArgs(key, []byte("value"), 1)
+		pArgs.Timestamp = store.clock.Now()
+		pArgs.Txn = pusher
+		done <- store.ExecuteCmd(proto.Put, pArgs, pReply)
+	}()
+	waitForTxnWaiters(rng, 1, t)
+	select {
+	case err := <-done:
+		t.Fatalf("expected pusher to wait on pushee; got %v", err)
+	default:
+	}
+
+	eArgs, eReply := endTxnArgs(pushee, true, 1)
+	if err := store.ExecuteCmd(proto.EndTransaction, eArgs, eReply); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected pusher to succeed once pushee committed; got %s", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("pusher failed to proceed once pushee committed")
+	}
+	if l := rng.txnWaitQ.Len(); l != 0 {
+		t.Errorf("expected no waiting pushers; got %d", l)
+	}
+}
+
+// TestStoreTxnWaitQueueDeadlock verifies that two transactions which
+// each wait on the other are detected as deadlocked and that the
+// deadlock is broken by aborting one of them.
+func TestStoreTxnWaitQueueDeadlock(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	rng := store.LookupRange(proto.Key("a"), nil)
+
+	// Transactions with equal priorities and timestamps can't push
+	// each other.
+	txnA := newTransaction("a", proto.Key("a"), 1, proto.SERIALIZABLE, store.clock)
+	txnB := newTransaction("b", proto.Key("b"), 1, proto.SERIALIZABLE, store.clock)
+	txnA.Priority, txnB.Priority = 1, 1
+	txnB.Timestamp = txnA.Timestamp
+	put := func(key proto.Key, txn *proto.Transaction) error {
+		pArgs, pReply := putArgs(key, []byte("value"), 1)
+		pArgs.Timestamp = txn.Timestamp
+		pArgs.Txn = txn
+		return store.ExecuteCmd(proto.Put, pArgs, pReply)
+	}
+	if err := put(proto.Key("a"), txnA); err != nil {
+		t.Fatal(err)
+	}
+	if err := put(proto.Key("b"), txnB); err != nil {
+		t.Fatal(err)
+	}
+
+	doneA := make(chan error, 1)
+	go func() { doneA <- put(proto.Key("b"), txnA) }()
+	waitForTxnWaiters(rng, 1, t)
+
+	// B closes the cycle and aborts A rather than waiting.
+	if err := put(proto.Key("a"), txnB); err != nil {
+		t.Fatalf("expected deadlock to be broken; got %s", err)
+	}
+	record := &proto.Transaction{}
+	if ok, err := engine.MVCCGetProto(store.Engine(), engine.MakeKey(engine.KeyLocalTransactionPrefix, txnA.ID),
+		proto.ZeroTimestamp, nil, record); err != nil || !ok || record.Status != proto.ABORTED {
+		t.Errorf("expected txn A to be aborted; got %s, %t, %v", record, ok, err)
+	}
+
+	// A still waits on B until B commits.
+	eArgs, eReply := endTxnArgs(txnB, true, 1)
+	if err := store.ExecuteCmd(proto.EndTransaction, eArgs, eReply); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-doneA:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("txn A failed to proceed once txn B committed")
+	}
+}
+
 // TestStoreResolveWriteIntentRollback verifies that resolving a write
 // intent by aborting it yields the previous value.
 func TestStoreResolveWriteIntentRollback(t *testing.T) {
@@ -743,3 +934,150 @@ func TestStoreResolveWriteIntentNoTxn(t *testing.T) {
 		t.Errorf("expected transaction aborted error; got %s", err)
 	}
 }
+
+// TestStoreAddRangeFromSnapshot verifies that a store creates a
+// replica of a range from a snapshot which lists a replica on the
+// store, and rejects snapshots which don't.
+func TestStoreAddRangeFromSnapshot(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	rng, err := store.GetRange(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1)
+	pArgs.Timestamp = store.Clock().Now()
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+
+	// Take snapshots of the range as it is and as it would be with an
+	// additional replica on store 2.
+	desc := *rng.Desc
+	desc.Replicas = append(append([]proto.Replica(nil), desc.Replicas...),
+		proto.Replica{NodeID: 2, StoreID: 2, RangeID: 5})
+	snapshotID, err := store.CreateSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldData, err := rng.snapshotData(rng.Desc, snapshotID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := rng.snapshotData(&desc, snapshotID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Engine().ReleaseSnapshot(snapshotID); err != nil {
+		t.Fatal(err)
+	}
+
+	eng := engine.NewInMem(proto.Attributes{}, 1<<20)
+	newStore := NewStore(store.Clock(), eng, nil, nil, multiraft.NewLocalRPCTransport())
+	if err := newStore.Bootstrap(proto.StoreIdent{NodeID: 2, StoreID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := newStore.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer newStore.Stop()
+
+	if err := newStore.addRangeFromSnapshot(desc.RaftID, oldData, 10); err == nil {
+		t.Error("expected error adding range from snapshot without replica on store")
+	}
+	if err := newStore.addRangeFromSnapshot(desc.RaftID+1, data, 10); err == nil {
+		t.Error("expected error adding range from snapshot of another raft group")
+	}
+	if err := newStore.addRangeFromSnapshot(desc.RaftID, data, 10); err != nil {
+		t.Fatal(err)
+	}
+	if newRng := newStore.LookupRange(proto.Key("a"), nil); newRng == nil || newRng.RangeID != 5 {
+		t.Fatalf("expected range 5 to contain key \"a\"; got %+v", newRng)
+	}
+	val, err := engine.MVCCGet(eng, proto.Key("a"), store.Clock().Now(), nil)
+	if err != nil || val == nil || !bytes.Equal(val.Bytes, []byte("value")) {
+		t.Errorf("expected value \"value\" for key \"a\"; got %+v, %v", val, err)
+	}
+}
+
+// TestStoreRemoveRangeFailsPendingCmds verifies that commands awaiting
+// execution by a range are failed with a RangeNotFoundError when the
+// range is removed from its store.
+func TestStoreRemoveRangeFailsPendingCmds(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	rng := splitTestRange(store, engine.KeyMin, proto.Key("a"), t)
+
+	pArgs, pReply := putArgs([]byte("b"), []byte("value"), rng.RangeID)
+	cmd := &pendingCmd{Method: proto.Put, Args: pArgs, Reply: pReply, done: make(chan error, 1)}
+	rng.Lock()
+	rng.pendingCmds[makeCmdIDKey(proto.ClientCmdID{WallTime: 1, Random: 1})] = cmd
+	rng.Unlock()
+
+	if err := store.RemoveRange(rng); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-cmd.done:
+		if _, ok := err.(*proto.RangeNotFoundError); !ok {
+			t.Errorf("expected range not found error; got %v", err)
+		}
+		if _, ok := pReply.GoError().(*proto.RangeNotFoundError); !ok {
+			t.Errorf("expected range not found error in reply; got %v", pReply.GoError())
+		}
+	default:
+		t.Error("expected pending command to be signaled")
+	}
+}
+
+// TestStoreApplyMemberChange verifies that a store removes a range
+// and clears its data once the store's replica has been removed from
+// the range's consensus group.
+func TestStoreApplyMemberChange(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	rng := splitTestRange(store, engine.KeyMin, proto.Key("a"), t)
+	pArgs, pReply := putArgs([]byte("b"), []byte("value"), rng.RangeID)
+	pArgs.Timestamp = store.Clock().Now()
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes which don't remove this store's replica have no effect.
+	if err := store.applyMemberChange(rng, memberChange{changeType: proto.ADD_REPLICA, nodeID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.applyMemberChange(rng, memberChange{changeType: proto.REMOVE_REPLICA, nodeID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetRange(rng.RangeID); err != nil {
+		t.Fatal(err)
+	}
+
+	removal := memberChange{changeType: proto.REMOVE_REPLICA, nodeID: raftNodeID(store.StoreID())}
+	if err := store.applyMemberChange(rng, removal); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetRange(rng.RangeID); err == nil {
+		t.Error("expected range to be removed from store")
+	}
+	if val, err := engine.MVCCGet(store.Engine(), proto.Key("b"), store.Clock().Now(), nil); err != nil || val != nil {
+		t.Errorf("expected range data to be cleared; got %+v, %v", val, err)
+	}
+	// The store has left the range's consensus group and cleared its
+	// Raft log and state.
+	raftID := rng.Desc.RaftID
+	if err := store.raft.removeGroup(raftID); err == nil {
+		t.Error("expected store to have left the range's consensus group")
+	}
+	for _, key := range []proto.Key{engine.RaftStateKey(raftID), engine.RaftLogPrefix(raftID)} {
+		count := 0
+		err := engine.MVCCIterateCommitted(store.Engine(), key, key.PrefixEnd(), func(kv proto.KeyValue) (bool, error) {
+			count++
+			return false, nil
+		})
+		if err != nil || count != 0 {
+			t.Errorf("expected no raft data under %q; got %d entries, %v", key, count, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"sync"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+const (
+	// defaultTxnCacheSize is the maximum number of transactions held
+	// in a store's transaction cache.
+	defaultTxnCacheSize = 1024
+)
+
+// A TxnCache is an LRU cache of the most recently observed states of
+// transactions which have been pushed, committed or aborted, keyed by
+// transaction ID. The store consults it before pushing the owner of a
+// conflicting intent: an intent of a transaction known to be finished,
+// or already pushed past the pusher's timestamp, can be resolved
+// without another push to the range holding the transaction record.
+//
+// Cached states never go stale in a way that matters: a finished
+// transaction stays finished, and a pending transaction's timestamp
+// only moves forward.
+//
+// TxnCache is thread safe.
+type TxnCache struct {
+	sync.Mutex
+	cache   *util.UnorderedCache
+	maxSize int
+}
+
+// NewTxnCache returns a new transaction cache holding at most maxSize
+// transactions.
+func NewTxnCache(maxSize int) *TxnCache {
+	tc := &TxnCache{
+		cache:   util.NewUnorderedCache(util.CacheConfig{Policy: util.CacheLRU}),
+		maxSize: maxSize,
+	}
+	tc.cache.CacheConfig.ShouldEvict = tc.shouldEvict
+	return tc
+}
+
+// Add records the transaction's state, unless the cache already holds
+// a more recent state of the same transaction.
+func (tc *TxnCache) Add(txn *proto.Transaction) {
+	tc.Lock()
+	defer tc.Unlock()
+	if value, ok := tc.cache.Get(string(txn.ID)); ok {
+		cached := value.(*proto.Transaction)
+		if cached.Status != proto.PENDING || (txn.Status == proto.PENDING &&
+			(txn.Epoch < cached.Epoch || !cached.Timestamp.Less(txn.Timestamp))) {
+			return
+		}
+	}
+	tc.cache.Add(string(txn.ID), gogoproto.Clone(txn).(*proto.Transaction))
+}
+
+// Get returns a copy of the cached state of the transaction with the
+// given ID, if any.
+func (tc *TxnCache) Get(txnID []byte) (*proto.Transaction, bool) {
+	tc.Lock()
+	defer tc.Unlock()
+	if value, ok := tc.cache.Get(string(txnID)); ok {
+		return gogoproto.Clone(value.(*proto.Transaction)).(*proto.Transaction), true
+	}
+	return nil, false
+}
+
+// Len returns the number of transactions in the cache.
+func (tc *TxnCache) Len() int {
+	tc.Lock()
+	defer tc.Unlock()
+	return tc.cache.Len()
+}
+
+// shouldEvict returns true if the cache holds more than its maximum
+// number of transactions.
+func (tc *TxnCache) shouldEvict(size int, key, value interface{}) bool {
+	return size > tc.maxSize
+}
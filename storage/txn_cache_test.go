@@ -0,0 +1,76 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// TestTxnCacheAdd verifies that the cache keeps the most recent state
+// of each transaction and never replaces a finished transaction.
+func TestTxnCacheAdd(t *testing.T) {
+	tc := NewTxnCache(defaultTxnCacheSize)
+	id := []byte("txn")
+	if _, ok := tc.Get(id); ok {
+		t.Fatal("expected empty cache")
+	}
+
+	testCases := []struct {
+		txn       proto.Transaction
+		expStatus proto.TransactionStatus
+		expWall   int64
+	}{
+		{proto.Transaction{ID: id, Timestamp: proto.Timestamp{WallTime: 2}}, proto.PENDING, 2},
+		// An older timestamp doesn't replace the pushed timestamp.
+		{proto.Transaction{ID: id, Timestamp: proto.Timestamp{WallTime: 1}}, proto.PENDING, 2},
+		{proto.Transaction{ID: id, Timestamp: proto.Timestamp{WallTime: 3}}, proto.PENDING, 3},
+		{proto.Transaction{ID: id, Status: proto.ABORTED, Timestamp: proto.Timestamp{WallTime: 1}}, proto.ABORTED, 1},
+		// A finished transaction is never replaced.
+		{proto.Transaction{ID: id, Timestamp: proto.Timestamp{WallTime: 4}}, proto.ABORTED, 1},
+	}
+	for i, test := range testCases {
+		tc.Add(&test.txn)
+		txn, ok := tc.Get(id)
+		if !ok {
+			t.Fatalf("%d: expected txn in cache", i)
+		}
+		if txn.Status != test.expStatus || txn.Timestamp.WallTime != test.expWall {
+			t.Errorf("%d: expected %s at %d; got %s", i, test.expStatus, test.expWall, txn)
+		}
+	}
+}
+
+// TestTxnCacheEviction verifies that the cache holds at most its
+// maximum number of transactions, evicting the least recently used.
+func TestTxnCacheEviction(t *testing.T) {
+	tc := NewTxnCache(2)
+	for _, id := range []string{"a", "b"} {
+		tc.Add(&proto.Transaction{ID: []byte(id), Status: proto.ABORTED})
+	}
+	// Touch "a" so that "b" is evicted next.
+	if _, ok := tc.Get([]byte("a")); !ok {
+		t.Fatal("expected txn a in cache")
+	}
+	tc.Add(&proto.Transaction{ID: []byte("c"), Status: proto.COMMITTED})
+	if l := tc.Len(); l != 2 {
+		t.Errorf("expected 2 txns in cache; got %d", l)
+	}
+	if _, ok := tc.Get([]byte("b")); ok {
+		t.Error("expected txn b to be evicted")
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// txnGCInterval is the interval between successive passes of a
+// store's transaction GC queue over its ranges.
+var txnGCInterval = 1 * time.Minute
+
+// txnGCQueue garbage collects the transaction records and response
+// cache entries of the ranges of a store. Each pass visits every
+// range whose leader lease the store holds:
+//
+//   - Pending transactions which haven't been heartbeat within twice
+//     the heartbeat interval are aborted.
+//   - Finished transactions older than GCTransactionExpiration have
+//     the intents listed in their records resolved and, once all are
+//     resolved, their records deleted. Aborted records which list no
+//     intents, such as those of transactions aborted by a push, whose
+//     intents are known only to their coordinators, are kept as
+//     tombstones so that a late coordinator learns of the abort, until
+//     they're older than GCTxnTombstoneExpiration.
+//   - Response cache entries older than GCResponseCacheExpiration are
+//     deleted.
+//
+// Deletions are proposed as InternalGC commands, so that every
+// replica removes the same data regardless of its engine.
+type txnGCQueue struct {
+	*rangeScanner
+	store *Store
+}
+
+// newTxnGCQueue returns a new transaction GC queue for the store.
+func newTxnGCQueue(store *Store) *txnGCQueue {
+	gq := &txnGCQueue{store: store}
+	gq.rangeScanner = newRangeScanner("txn gc queue", store, txnGCInterval, gq.process)
+	return gq
+}
+
+// process garbage collects the transaction records and response
+// cache entries of the range.
+func (gq *txnGCQueue) process(rng *Range) error {
+	now := gq.store.clock.Now()
+	heartbeatExpiry := now.WallTime - 2*DefaultHeartbeatInterval.Nanoseconds()
+	txnExpiry := now.WallTime - GCTransactionExpiration.Nanoseconds()
+	tombstoneExpiry := now.WallTime - GCTxnTombstoneExpiration.Nanoseconds()
+	rcExpiry := now.WallTime - GCResponseCacheExpiration.Nanoseconds()
+
+	rng.RLock()
+	start := engine.MakeKey(engine.KeyLocalTransactionPrefix, rng.Desc.StartKey)
+	end := engine.MakeKey(engine.KeyLocalTransactionPrefix, rng.Desc.EndKey)
+	rng.RUnlock()
+
+	// Collect the records before acting on them, as pushes and intent
+	// resolutions write to the engine being iterated.
+	var txns []*proto.Transaction
+	var txnKeys []proto.Key
+	if err := engine.MVCCIterateCommitted(gq.store.Engine(), start, end, func(kv proto.KeyValue) (bool, error) {
+		txn := &proto.Transaction{}
+		if err := gogoproto.Unmarshal(kv.Value.Bytes, txn); err != nil {
+			return false, err
+		}
+		txns = append(txns, txn)
+		txnKeys = append(txnKeys, kv.Key)
+		return false, nil
+	}); err != nil {
+		return err
+	}
+
+	var gcKeys []proto.InternalGCRequest_GCKey
+	for i, txn := range txns {
+		switch txn.Status {
+		case proto.PENDING:
+			lastHeartbeat := txn.Timestamp
+			if txn.LastHeartbeat != nil {
+				lastHeartbeat = *txn.LastHeartbeat
+			}
+			if lastHeartbeat.WallTime < heartbeatExpiry {
+				if err := gq.abortTxn(rng, txn); err != nil {
+					log.Warningf("unable to abort abandoned transaction %s: %s", txn, err)
+				}
+			}
+		case proto.COMMITTED, proto.ABORTED:
+			if txn.Timestamp.WallTime >= txnExpiry {
+				continue
+			}
+			// Without its intents, an aborted record is a tombstone,
+			// which has nothing to resolve but expires later.
+			if txn.Status == proto.ABORTED && len(txn.Intents) == 0 {
+				if txn.Timestamp.WallTime >= tombstoneExpiry {
+					continue
+				}
+			} else if err := gq.resolveIntents(txn); err != nil {
+				log.Warningf("unable to resolve intents of transaction %s: %s", txn, err)
+				continue
+			}
+			gcKeys = append(gcKeys, proto.InternalGCRequest_GCKey{Key: txnKeys[i]})
+		}
+	}
+
+	prefix := responseCacheKeyPrefix(rng.RangeID)
+	if err := gq.store.Engine().Iterate(engine.MVCCEncodeKey(prefix), engine.MVCCEncodeKey(prefix.PrefixEnd()), func(kv proto.RawKeyValue) (bool, error) {
+		cmdID, err := rng.respCache.decodeKey(kv.Key)
+		if err != nil {
+			return false, err
+		}
+		if cmdID.WallTime < rcExpiry {
+			key, _, _ := engine.MVCCDecodeKey(kv.Key)
+			gcKeys = append(gcKeys, proto.InternalGCRequest_GCKey{Key: key})
+		}
+		return false, nil
+	}); err != nil {
+		return err
+	}
+
+	if len(gcKeys) == 0 {
+		return nil
+	}
+	rng.RLock()
+	key := rng.Desc.StartKey
+	rng.RUnlock()
+	return gq.store.ExecuteCmd(proto.InternalGC, &proto.InternalGCRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:     key,
+			User:    UserRoot,
+			Replica: *rng.GetReplica(),
+		},
+		Keys: gcKeys,
+	}, &proto.InternalGCResponse{})
+}
+
+// abortTxn aborts the abandoned transaction by pushing it.
+func (gq *txnGCQueue) abortTxn(rng *Range, txn *proto.Transaction) error {
+	log.Infof("aborting abandoned transaction %s", txn)
+	return gq.store.ExecuteCmd(proto.InternalPushTxn, &proto.InternalPushTxnRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:     txn.ID,
+			User:    UserRoot,
+			Replica: *rng.GetReplica(),
+		},
+		PusheeTxn: *txn,
+		Abort:     true,
+	}, &proto.InternalPushTxnResponse{})
+}
+
+// resolveIntents resolves the intents listed in the record of the
+// finished transaction. The intents may reside on any range.
+func (gq *txnGCQueue) resolveIntents(txn *proto.Transaction) error {
+	resolveTxn := gogoproto.Clone(txn).(*proto.Transaction)
+	resolveTxn.Intents = nil
+	for _, intent := range txn.Intents {
+		if err := gq.store.db.Call(proto.InternalResolveIntent, &proto.InternalResolveIntentRequest{
+			RequestHeader: proto.RequestHeader{
+				Timestamp: txn.Timestamp,
+				Key:       intent.Key,
+				EndKey:    intent.EndKey,
+				User:      UserRoot,
+				Txn:       resolveTxn,
+			},
+		}, &proto.InternalResolveIntentResponse{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/hlc"
+)
+
+// TestTxnGCQueue verifies that the transaction GC queue aborts
+// abandoned transactions, resolves the intents of expired finished
+// transactions before deleting their records, keeps the records of
+// transactions aborted without listing their intents, and deletes
+// expired response cache entries, leaving recent data in place.
+func TestTxnGCQueue(t *testing.T) {
+	store, manual := createTestStore(t)
+	defer store.Stop()
+	holdLeaderLease(t, store, 1)
+	eng := store.Engine()
+
+	*manual = hlc.ManualClock(1)
+	abandoned := newTransaction("abandoned", proto.Key("a"), 1, proto.SERIALIZABLE, store.clock)
+	expired := newTransaction("expired", proto.Key("b"), 1, proto.SERIALIZABLE, store.clock)
+	if err := engine.MVCCPut(eng, nil, proto.Key("b"), expired.Timestamp, proto.Value{Bytes: []byte("value")}, expired); err != nil {
+		t.Fatal(err)
+	}
+	expired.Status = proto.COMMITTED
+	expired.Intents = []proto.Intent{{Key: proto.Key("b")}}
+	pushed := newTransaction("pushed", proto.Key("d"), 1, proto.SERIALIZABLE, store.clock)
+	pushed.Status = proto.ABORTED
+	oldCmdID := proto.ClientCmdID{WallTime: 1, Random: 1}
+
+	now := GCTransactionExpiration + GCResponseCacheExpiration
+	*manual = hlc.ManualClock(now.Nanoseconds())
+	recent := newTransaction("recent", proto.Key("c"), 1, proto.SERIALIZABLE, store.clock)
+	recent.Status = proto.COMMITTED
+	recentCmdID := proto.ClientCmdID{WallTime: now.Nanoseconds(), Random: 1}
+
+	for _, txn := range []*proto.Transaction{abandoned, expired, pushed, recent} {
+		key := engine.MakeKey(engine.KeyLocalTransactionPrefix, txn.ID)
+		if err := engine.MVCCPutProto(eng, nil, key, proto.ZeroTimestamp, nil, txn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, cmdID := range []proto.ClientCmdID{oldCmdID, recentCmdID} {
+		if err := engine.MVCCPutProto(eng, nil, responseCacheKey(1, cmdID), proto.ZeroTimestamp, nil,
+			&proto.ReadWriteCmdResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	*manual = hlc.ManualClock((now + time.Second).Nanoseconds())
+	store.txnGCQ.processAll()
+
+	getTxn := func(txn *proto.Transaction) (*proto.Transaction, bool) {
+		key := engine.MakeKey(engine.KeyLocalTransactionPrefix, txn.ID)
+		record := &proto.Transaction{}
+		ok, err := engine.MVCCGetProto(eng, key, proto.ZeroTimestamp, nil, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record, ok
+	}
+	if record, ok := getTxn(abandoned); !ok || record.Status != proto.ABORTED {
+		t.Errorf("expected abandoned transaction to be aborted; got %s", record)
+	}
+	if _, ok := getTxn(expired); ok {
+		t.Errorf("expected record of expired transaction to be deleted")
+	}
+	if record, ok := getTxn(pushed); !ok || record.Status != proto.ABORTED {
+		t.Errorf("expected record of pushed transaction to be kept as a tombstone; got %s", record)
+	}
+	if record, ok := getTxn(recent); !ok || record.Status != proto.COMMITTED {
+		t.Errorf("expected record of recent transaction to be kept; got %s", record)
+	}
+	// The intent of the expired transaction is resolved and its value
+	// visible to non-transactional readers.
+	if val, err := engine.MVCCGet(eng, proto.Key("b"), store.clock.Now(), nil); err != nil || val == nil {
+		t.Errorf("expected intent of expired transaction to be committed; got %+v, %v", val, err)
+	}
+
+	rwResp := &proto.ReadWriteCmdResponse{}
+	if ok, err := engine.MVCCGetProto(eng, responseCacheKey(1, oldCmdID), proto.ZeroTimestamp, nil, rwResp); err != nil || ok {
+		t.Errorf("expected expired response cache entry to be deleted; got %t, %v", ok, err)
+	}
+	if ok, err := engine.MVCCGetProto(eng, responseCacheKey(1, recentCmdID), proto.ZeroTimestamp, nil, rwResp); err != nil || !ok {
+		t.Errorf("expected recent response cache entry to be kept; got %t, %v", ok, err)
+	}
+}
+
+// TestTxnGCQueueTombstone verifies that the record of a transaction
+// aborted without listing its intents is deleted once it's older than
+// GCTxnTombstoneExpiration.
+func TestTxnGCQueueTombstone(t *testing.T) {
+	store, manual := createTestStore(t)
+	defer store.Stop()
+	holdLeaderLease(t, store, 1)
+	eng := store.Engine()
+
+	*manual = hlc.ManualClock(1)
+	pushed := newTransaction("pushed", proto.Key("a"), 1, proto.SERIALIZABLE, store.clock)
+	pushed.Status = proto.ABORTED
+	key := engine.MakeKey(engine.KeyLocalTransactionPrefix, pushed.ID)
+	if err := engine.MVCCPutProto(eng, nil, key, proto.ZeroTimestamp, nil, pushed); err != nil {
+		t.Fatal(err)
+	}
+
+	expectRecord := func(expected bool) {
+		ok, err := engine.MVCCGetProto(eng, key, proto.ZeroTimestamp, nil, &proto.Transaction{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != expected {
+			t.Errorf("expected tombstone record to exist: %t; got %t", expected, ok)
+		}
+	}
+
+	*manual = hlc.ManualClock((GCTxnTombstoneExpiration - time.Second).Nanoseconds())
+	store.txnGCQ.processAll()
+	expectRecord(true)
+
+	*manual = hlc.ManualClock((GCTxnTombstoneExpiration + time.Second).Nanoseconds())
+	store.txnGCQ.processAll()
+	expectRecord(false)
+}
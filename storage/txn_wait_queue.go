@@ -0,0 +1,128 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"bytes"
+	"sync"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// A TxnWaitQueue holds the pushers which have lost a push against a
+// transaction whose record is stored in the range, so that they may
+// wait for the pushee to finish instead of restarting with backoff.
+//
+// A pusher which loses is added to the queue via Enqueue(). Whenever
+// the pushee's record changes, UpdateTxn() passes the new record to
+// each pusher waiting on it and removes them from the queue; the
+// pushers then push again. A pusher which stops waiting for any other
+// reason removes itself via Dequeue().
+//
+// The queue also exposes the edges of the push graph it holds via
+// WaitingOn(), which the store follows to detect deadlocks.
+//
+// TxnWaitQueue is thread safe.
+type TxnWaitQueue struct {
+	sync.Mutex
+	waiters map[string][]*TxnWaiter // Keyed by pushee txn ID
+}
+
+// A TxnWaiter is a pusher waiting on a pushee in a TxnWaitQueue.
+type TxnWaiter struct {
+	pusherID []byte
+	pusheeID []byte
+	// Updated receives the pushee's record when it changes.
+	Updated chan *proto.Transaction
+}
+
+// NewTxnWaitQueue returns a new, empty transaction wait queue.
+func NewTxnWaitQueue() *TxnWaitQueue {
+	return &TxnWaitQueue{
+		waiters: map[string][]*TxnWaiter{},
+	}
+}
+
+// Enqueue adds the pusher to the transactions waiting on the pushee
+// and returns its waiter.
+func (q *TxnWaitQueue) Enqueue(pusher, pushee *proto.Transaction) *TxnWaiter {
+	q.Lock()
+	defer q.Unlock()
+	w := &TxnWaiter{
+		pusherID: pusher.ID,
+		pusheeID: pushee.ID,
+		Updated:  make(chan *proto.Transaction, 1),
+	}
+	q.waiters[string(pushee.ID)] = append(q.waiters[string(pushee.ID)], w)
+	return w
+}
+
+// Dequeue removes the waiter from the queue, if it's still waiting.
+func (q *TxnWaitQueue) Dequeue(w *TxnWaiter) {
+	q.Lock()
+	defer q.Unlock()
+	waiters := q.waiters[string(w.pusheeID)]
+	for i, other := range waiters {
+		if other == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(q.waiters, string(w.pusheeID))
+	} else {
+		q.waiters[string(w.pusheeID)] = waiters
+	}
+}
+
+// UpdateTxn passes the updated record of txn to the pushers waiting
+// on it and removes them from the queue.
+func (q *TxnWaitQueue) UpdateTxn(txn *proto.Transaction) {
+	q.Lock()
+	defer q.Unlock()
+	for _, w := range q.waiters[string(txn.ID)] {
+		w.Updated <- gogoproto.Clone(txn).(*proto.Transaction)
+	}
+	delete(q.waiters, string(txn.ID))
+}
+
+// WaitingOn returns the IDs of the transactions in the queue which the
+// transaction with the given ID is waiting on.
+func (q *TxnWaitQueue) WaitingOn(txnID []byte) [][]byte {
+	q.Lock()
+	defer q.Unlock()
+	var pusheeIDs [][]byte
+	for _, waiters := range q.waiters {
+		for _, w := range waiters {
+			if bytes.Equal(w.pusherID, txnID) {
+				pusheeIDs = append(pusheeIDs, w.pusheeID)
+			}
+		}
+	}
+	return pusheeIDs
+}
+
+// Len returns the number of pushers waiting in the queue.
+func (q *TxnWaitQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	n := 0
+	for _, waiters := range q.waiters {
+		n += len(waiters)
+	}
+	return n
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// TestTxnWaitQueueUpdate verifies that updating a transaction passes
+// its record to the pushers waiting on it and removes them from the
+// queue, leaving pushers waiting on other transactions in place.
+func TestTxnWaitQueueUpdate(t *testing.T) {
+	q := NewTxnWaitQueue()
+	txnA := &proto.Transaction{ID: []byte("a")}
+	txnB := &proto.Transaction{ID: []byte("b")}
+	txnC := &proto.Transaction{ID: []byte("c")}
+
+	w1 := q.Enqueue(txnA, txnC)
+	w2 := q.Enqueue(txnB, txnC)
+	w3 := q.Enqueue(txnC, txnA)
+	if l := q.Len(); l != 3 {
+		t.Fatalf("expected 3 waiters; got %d", l)
+	}
+
+	q.UpdateTxn(&proto.Transaction{ID: []byte("c"), Status: proto.COMMITTED})
+	for i, w := range []*TxnWaiter{w1, w2} {
+		select {
+		case txn := <-w.Updated:
+			if txn.Status != proto.COMMITTED {
+				t.Errorf("%d: expected committed pushee; got %s", i, txn)
+			}
+		default:
+			t.Errorf("%d: expected waiter to be notified", i)
+		}
+	}
+	select {
+	case txn := <-w3.Updated:
+		t.Errorf("expected waiter on other txn not to be notified; got %s", txn)
+	default:
+	}
+	if l := q.Len(); l != 1 {
+		t.Errorf("expected 1 waiter; got %d", l)
+	}
+
+	// Dequeuing an already notified waiter is a noop.
+	q.Dequeue(w1)
+	q.Dequeue(w3)
+	if l := q.Len(); l != 0 {
+		t.Errorf("expected no waiters; got %d", l)
+	}
+}
+
+// TestTxnWaitQueueWaitingOn verifies the edges of the push graph
+// exposed by the queue.
+func TestTxnWaitQueueWaitingOn(t *testing.T) {
+	q := NewTxnWaitQueue()
+	txnA := &proto.Transaction{ID: []byte("a")}
+	txnB := &proto.Transaction{ID: []byte("b")}
+	txnC := &proto.Transaction{ID: []byte("c")}
+
+	q.Enqueue(txnA, txnB)
+	w := q.Enqueue(txnA, txnC)
+	pusheeIDs := q.WaitingOn(txnA.ID)
+	if len(pusheeIDs) != 2 {
+		t.Fatalf("expected txn a to wait on 2 txns; got %q", pusheeIDs)
+	}
+	if ids := q.WaitingOn(txnB.ID); len(ids) != 0 {
+		t.Errorf("expected txn b to wait on no txns; got %q", ids)
+	}
+
+	q.Dequeue(w)
+	if ids := q.WaitingOn(txnA.ID); len(ids) != 1 || !bytes.Equal(ids[0], txnB.ID) {
+		t.Errorf("expected txn a to wait on txn b; got %q", ids)
+	}
+}
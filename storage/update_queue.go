@@ -0,0 +1,215 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// updateInterval is the interval between successive passes of a
+// store's update queue over its ranges.
+var updateInterval = 1 * time.Second
+
+// updateMaxAttempts is the number of times in a row an update may fail
+// to be applied before it's moved to the range's dead letter queue.
+var updateMaxAttempts = 10
+
+// updateQueue applies the updates enqueued via EnqueueUpdate on the
+// ranges of a store. Each pass visits every range whose leader lease
+// the store holds and applies its committed updates in key order; the
+// intents of updates enqueued by transactions which are still pending
+// are skipped. An update which fails is left in place and retried on
+// the next pass, while the updates following it are still applied. Once an update has failed updateMaxAttempts times in
+// a row, it's moved to the dead letter queue at
+// engine.UpdateDeadLetterKey, where it's kept for inspection.
+//
+// Each update is sent with a command ID derived from that of the
+// EnqueueUpdate request (see applyCmdID) and deleted once applied.
+// Should an update be applied but not deleted, its reapplication is
+// answered from the response cache, so that updates are applied
+// exactly once. This guarantee holds only as long as the response
+// cache entry survives: the transaction GC queue removes entries
+// older than GCResponseCacheExpiration (1h), so an update whose
+// deletion keeps failing for longer may be applied again.
+type updateQueue struct {
+	*rangeScanner
+	store   *Store
+	pending int64 // Updates left pending so far in the current pass
+	depth   int64 // Updates pending as of the last pass; accessed atomically
+
+	mu       sync.Mutex
+	failures map[string]int // Failed attempts in a row, by queue key
+}
+
+// newUpdateQueue returns a new update queue for the store.
+func newUpdateQueue(store *Store) *updateQueue {
+	uq := &updateQueue{store: store, failures: map[string]int{}}
+	uq.rangeScanner = newRangeScanner("update queue", store, updateInterval, uq.processRange)
+	uq.passDone = uq.recordDepth
+	return uq
+}
+
+// length returns the number of updates which were pending at the end
+// of the last pass.
+func (uq *updateQueue) length() int64 {
+	return atomic.LoadInt64(&uq.depth)
+}
+
+// processRange applies the pending updates of the range, counting
+// those left pending towards the queue's depth.
+func (uq *updateQueue) processRange(rng *Range) error {
+	pending, err := uq.process(rng)
+	uq.pending += int64(pending)
+	return err
+}
+
+// recordDepth records the number of updates left pending by the
+// pass which just finished.
+func (uq *updateQueue) recordDepth() {
+	atomic.StoreInt64(&uq.depth, uq.pending)
+	uq.pending = 0
+}
+
+// process applies the committed updates enqueued on the range in key
+// order. Returns the number of updates left pending and the last
+// error encountered.
+func (uq *updateQueue) process(rng *Range) (int, error) {
+	rng.RLock()
+	start := engine.MakeLocalKey(engine.KeyLocalUpdateQueuePrefix, rng.Desc.StartKey)
+	end := engine.MakeLocalKey(engine.KeyLocalUpdateQueuePrefix, rng.Desc.EndKey)
+	rng.RUnlock()
+
+	var updates []proto.KeyValue
+	if err := engine.MVCCIterateCommitted(uq.store.Engine(), start, end, func(kv proto.KeyValue) (bool, error) {
+		updates = append(updates, kv)
+		return false, nil
+	}); err != nil {
+		return 0, err
+	}
+	var pending int
+	var lastErr error
+	for _, kv := range updates {
+		err := uq.apply(rng, kv)
+		if err == nil {
+			uq.clearFailures(kv.Key)
+			continue
+		}
+		if uq.recordFailure(kv.Key) >= updateMaxAttempts {
+			log.Errorf("moving update at %q to dead letter queue after %d failed attempts: %s",
+				kv.Key, updateMaxAttempts, err)
+			if err = uq.deadLetter(kv); err == nil {
+				uq.clearFailures(kv.Key)
+				continue
+			}
+		}
+		pending++
+		lastErr = err
+	}
+	return pending, lastErr
+}
+
+// recordFailure counts a failed attempt to apply the update queued at
+// key and returns the number of attempts in a row which have failed.
+func (uq *updateQueue) recordFailure(key proto.Key) int {
+	uq.mu.Lock()
+	defer uq.mu.Unlock()
+	uq.failures[string(key)]++
+	return uq.failures[string(key)]
+}
+
+// clearFailures forgets the failed attempts to apply the update
+// queued at key.
+func (uq *updateQueue) clearFailures(key proto.Key) {
+	uq.mu.Lock()
+	defer uq.mu.Unlock()
+	delete(uq.failures, string(key))
+}
+
+// deadLetter moves the update stored at kv to the dead letter queue.
+func (uq *updateQueue) deadLetter(kv proto.KeyValue) error {
+	txnOpts := &client.TransactionOptions{Name: "dead letter update"}
+	return uq.store.db.RunTransaction(txnOpts, func(txn *client.KV) error {
+		txn.Prepare(proto.Put, &proto.PutRequest{
+			RequestHeader: proto.RequestHeader{Key: engine.UpdateDeadLetterKey(kv.Key)},
+			Value:         proto.Value{Bytes: kv.Value.Bytes},
+		}, &proto.PutResponse{})
+		txn.Prepare(proto.Delete, &proto.DeleteRequest{
+			RequestHeader: proto.RequestHeader{Key: kv.Key},
+		}, &proto.DeleteResponse{})
+		return nil
+	})
+}
+
+// apply executes the queued update stored at kv and then deletes it
+// from the range.
+func (uq *updateQueue) apply(rng *Range, kv proto.KeyValue) error {
+	queued := &proto.QueuedUpdate{}
+	if err := gogoproto.Unmarshal(kv.Value.Bytes, queued); err != nil {
+		return err
+	}
+	args, ok := queued.Update.GetValue().(proto.Request)
+	if !ok {
+		return util.Errorf("queued update at %q is empty", kv.Key)
+	}
+	method, err := proto.MethodForRequest(args)
+	if err != nil {
+		return err
+	}
+	reply, err := proto.CreateReply(method)
+	if err != nil {
+		return err
+	}
+	// The update executes at the time it's applied, outside of the
+	// transaction which enqueued it.
+	header := args.Header()
+	header.Timestamp = proto.ZeroTimestamp
+	header.CmdID = applyCmdID(queued.CmdID)
+	header.User = UserRoot
+	header.Replica = proto.Replica{}
+	header.Txn = nil
+	// The update is sent directly rather than via KV.Call, which would
+	// replace the command ID.
+	uq.store.db.Sender().Send(&client.Call{Method: method, Args: args, Reply: reply})
+	if err := reply.Header().GoError(); err != nil {
+		return util.Errorf("unable to apply queued %s update: %s", method, err)
+	}
+
+	return uq.store.ExecuteCmd(proto.Delete, &proto.DeleteRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:     kv.Key,
+			User:    UserRoot,
+			Replica: *rng.GetReplica(),
+		},
+	}, &proto.DeleteResponse{})
+}
+
+// applyCmdID returns the command ID under which the update enqueued
+// with the supplied command ID is applied. It must differ from the
+// enqueueing command's ID, whose response cache entry would otherwise
+// answer the update in place of executing it, and be the same on
+// every attempt, so that retries are answered from the response cache.
+func applyCmdID(cmdID proto.ClientCmdID) proto.ClientCmdID {
+	return proto.ClientCmdID{WallTime: cmdID.WallTime, Random: cmdID.Random + 1}
+}
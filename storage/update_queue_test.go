@@ -0,0 +1,222 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// enqueueUpdateArgs returns an EnqueueUpdateRequest and
+// EnqueueUpdateResponse pair addressed to the default replica for
+// the specified key, enqueueing the supplied update.
+func enqueueUpdateArgs(key proto.Key, update proto.Request, cmdID proto.ClientCmdID) (
+	*proto.EnqueueUpdateRequest, *proto.EnqueueUpdateResponse) {
+	args := &proto.EnqueueUpdateRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:     key,
+			User:    UserRoot,
+			CmdID:   cmdID,
+			Replica: proto.Replica{StoreID: 1, RangeID: 1},
+		},
+	}
+	if update != nil {
+		args.Update.SetValue(update)
+	}
+	return args, &proto.EnqueueUpdateResponse{}
+}
+
+// TestUpdateQueueApply verifies that an enqueued update is applied
+// by the update queue and removed from the range once applied.
+func TestUpdateQueueApply(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	holdLeaderLease(t, store, 1)
+
+	key := proto.Key("a")
+	put, _ := putArgs(key, []byte("value"), 1)
+	cmdID := proto.ClientCmdID{WallTime: 1, Random: 1}
+	args, reply := enqueueUpdateArgs(key, put, cmdID)
+	if err := store.ExecuteCmd(proto.EnqueueUpdate, args, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// The update isn't applied until the queue processes the range.
+	if val, err := engine.MVCCGet(store.Engine(), key, store.clock.Now(), nil); err != nil || val != nil {
+		t.Fatalf("expected no value before update is applied; got %+v, %v", val, err)
+	}
+
+	store.updateQ.processAll()
+	if depth := store.UpdateQueueDepth(); depth != 0 {
+		t.Errorf("expected empty update queue; got depth %d", depth)
+	}
+	val, err := engine.MVCCGet(store.Engine(), key, store.clock.Now(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val == nil || !bytes.Equal(val.Bytes, []byte("value")) {
+		t.Errorf("expected update to be applied; got %+v", val)
+	}
+	queueKey := engine.UpdateQueueKey(key, cmdID)
+	if val, err := engine.MVCCGet(store.Engine(), queueKey, store.clock.Now(), nil); err != nil || val != nil {
+		t.Errorf("expected queued update to be deleted; got %+v, %v", val, err)
+	}
+}
+
+// TestUpdateQueueReapply verifies that an update which is applied
+// but left in the queue isn't applied again, and that the command ID
+// under which it's applied doesn't collide with the enqueue's.
+func TestUpdateQueueReapply(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	holdLeaderLease(t, store, 1)
+
+	key := proto.Key("a")
+	inc, _ := incrementArgs(key, 1, 1)
+	cmdID := proto.ClientCmdID{WallTime: 1, Random: 1}
+	args, reply := enqueueUpdateArgs(key, inc, cmdID)
+	if err := store.ExecuteCmd(proto.EnqueueUpdate, args, reply); err != nil {
+		t.Fatal(err)
+	}
+	queueKey := engine.UpdateQueueKey(key, cmdID)
+	queued := &proto.QueuedUpdate{}
+	if ok, err := engine.MVCCGetProto(store.Engine(), queueKey, store.clock.Now(), nil, queued); !ok || err != nil {
+		t.Fatalf("expected queued update; got %t, %v", ok, err)
+	}
+
+	expectCounter := func() {
+		val, err := engine.MVCCGet(store.Engine(), key, store.clock.Now(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val == nil || val.GetInteger() != 1 {
+			t.Errorf("expected update to be applied once; got %+v", val)
+		}
+	}
+	store.updateQ.processAll()
+	expectCounter()
+
+	// Simulate a failure to delete the update once applied by
+	// enqueueing it again under the same key.
+	if err := engine.MVCCPutProto(store.Engine(), nil, queueKey, store.clock.Now(), nil, queued); err != nil {
+		t.Fatal(err)
+	}
+	store.updateQ.processAll()
+	if depth := store.UpdateQueueDepth(); depth != 0 {
+		t.Errorf("expected empty update queue; got depth %d", depth)
+	}
+	expectCounter()
+}
+
+// TestUpdateQueueInvalidUpdates verifies that EnqueueUpdate rejects
+// empty updates, updates of unsupported methods, updates without a
+// command ID and updates which could never be applied.
+func TestUpdateQueueInvalidUpdates(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+
+	key := proto.Key("a")
+	cmdID := proto.ClientCmdID{WallTime: 1, Random: 1}
+	put, _ := putArgs(key, []byte("value"), 1)
+	get, _ := getArgs(key, 1)
+	localPut, _ := putArgs(engine.MakeLocalKey(engine.KeyLocalUpdateQueuePrefix, key), []byte("value"), 1)
+	deleteRange := &proto.DeleteRangeRequest{
+		RequestHeader: proto.RequestHeader{Key: proto.Key("b"), EndKey: key},
+	}
+	accumulate := &proto.AccumulateTSRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+		Data:          proto.TimeSeriesData{StartTimestamp: 1415398729},
+	}
+	testCases := []struct {
+		update proto.Request
+		cmdID  proto.ClientCmdID
+	}{
+		{nil, cmdID},
+		{get, cmdID},
+		{put, proto.ClientCmdID{}},
+		{localPut, cmdID},
+		{deleteRange, cmdID},
+		{accumulate, cmdID},
+	}
+	for i, test := range testCases {
+		args, reply := enqueueUpdateArgs(key, test.update, test.cmdID)
+		if err := store.ExecuteCmd(proto.EnqueueUpdate, args, reply); err == nil {
+			t.Errorf("%d: expected error enqueueing invalid update", i)
+		}
+	}
+}
+
+// TestUpdateQueueDeadLetter verifies that an update which fails to be
+// applied doesn't hold up the updates following it, and that it's
+// moved to the dead letter queue once it has failed updateMaxAttempts
+// times in a row.
+func TestUpdateQueueDeadLetter(t *testing.T) {
+	defer func(attempts int) { updateMaxAttempts = attempts }(updateMaxAttempts)
+	updateMaxAttempts = 2
+	// Keep the store's own passes from counting failed attempts.
+	defer func(interval time.Duration) { updateInterval = interval }(updateInterval)
+	updateInterval = time.Hour
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	holdLeaderLease(t, store, 1)
+
+	// Incrementing a key which holds a non-integer value fails.
+	badKey, goodKey := proto.Key("a"), proto.Key("b")
+	pArgs, pReply := putArgs(badKey, []byte("value"), 1)
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+	inc, _ := incrementArgs(badKey, 1, 1)
+	badCmdID := proto.ClientCmdID{WallTime: 1, Random: 1}
+	args, reply := enqueueUpdateArgs(badKey, inc, badCmdID)
+	if err := store.ExecuteCmd(proto.EnqueueUpdate, args, reply); err != nil {
+		t.Fatal(err)
+	}
+	put, _ := putArgs(goodKey, []byte("value"), 1)
+	args, reply = enqueueUpdateArgs(goodKey, put, proto.ClientCmdID{WallTime: 1, Random: 3})
+	if err := store.ExecuteCmd(proto.EnqueueUpdate, args, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	store.updateQ.processAll()
+	if depth := store.UpdateQueueDepth(); depth != 1 {
+		t.Errorf("expected failed update to be left pending; got depth %d", depth)
+	}
+	if val, err := engine.MVCCGet(store.Engine(), goodKey, store.clock.Now(), nil); err != nil || val == nil {
+		t.Errorf("expected update following a failed update to be applied; got %+v, %v", val, err)
+	}
+
+	store.updateQ.processAll()
+	if depth := store.UpdateQueueDepth(); depth != 0 {
+		t.Errorf("expected empty update queue; got depth %d", depth)
+	}
+	queueKey := engine.UpdateQueueKey(badKey, badCmdID)
+	if val, err := engine.MVCCGet(store.Engine(), queueKey, store.clock.Now(), nil); err != nil || val != nil {
+		t.Errorf("expected failed update to be removed from the queue; got %+v, %v", val, err)
+	}
+	queued := &proto.QueuedUpdate{}
+	deadKey := engine.UpdateDeadLetterKey(queueKey)
+	if ok, err := engine.MVCCGetProto(store.Engine(), deadKey, store.clock.Now(), nil, queued); !ok || err != nil {
+		t.Fatalf("expected failed update in dead letter queue; got %t, %v", ok, err)
+	}
+	if queued.CmdID != badCmdID {
+		t.Errorf("expected dead letter update with command ID %+v; got %+v", badCmdID, queued.CmdID)
+	}
+}
@@ -19,17 +19,33 @@
 package structured
 
 import (
+	"reflect"
+	"sort"
+	"sync"
+
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
 )
 
+// scanBatchSize is the maximum number of column values read by each
+// scan of ScanRows.
+const scanBatchSize = 1000
+
 // A DB interface provides methods to access a datastore
 // using a structured data API.
 type DB interface {
 	PutSchema(*Schema) error
 	DeleteSchema(*Schema) error
 	GetSchema(string) (*Schema, error)
+
+	PutRow(s *Schema, table string, obj interface{}) error
+	GetRow(s *Schema, table string, obj interface{}) (bool, error)
+	DeleteRow(s *Schema, table string, obj interface{}) error
+	ScanRows(s *Schema, table string, start, end interface{}, maxRows int64, objs interface{}) error
+	GetInterleavedRows(s *Schema, table string, obj interface{}, children map[string]interface{}) (bool, error)
+	LookupRows(s *Schema, table, column string, value interface{}, maxRows int64, objs interface{}) error
 }
 
 // A structuredDB satisfies the DB interface using the
@@ -37,16 +53,23 @@ type DB interface {
 type structuredDB struct {
 	// kvDB is a client to the monolithic key-value map.
 	kvDB *client.KV
+
+	sync.Mutex // Protects sequences
+	// sequences holds the sequences of auto-increment columns, keyed
+	// by sequence key.
+	sequences map[string]*sequence
 }
 
 // NewDB returns a key-value datastore client which connects to the
 // Cockroach cluster via the supplied gossip instance.
 func NewDB(kvDB *client.KV) DB {
-	return &structuredDB{kvDB: kvDB}
+	return &structuredDB{kvDB: kvDB, sequences: map[string]*sequence{}}
 }
 
 // PutSchema inserts s into the kv store for subsequent
-// usage by clients.
+// usage by clients. Once stored, the schema's rows are recognized by
+// the ranges holding them, which aren't split between a top-level row
+// and the rows interleaved in it.
 func (db *structuredDB) PutSchema(s *Schema) error {
 	if err := s.Validate(); err != nil {
 		return err
@@ -76,3 +99,413 @@ func (db *structuredDB) GetSchema(key string) (*Schema, error) {
 	}
 	return s, err
 }
+
+// PutRow writes the row obj to the table, replacing any existing row
+// with the same primary key. obj is either a Go struct corresponding
+// to the table (or a pointer to one) or a map from column name to
+// value, such as a decoded JSON object. Each column value is stored
+// at its own key, the row's key followed by the column key; columns
+// for which obj has no value are deleted. Auto-increment columns for
+// which obj has no value, or the zero value, are allocated the next
+// value of their sequence, which is stored back in obj unless it's a
+// struct passed by value. The entries of the table's indexes are
+// updated in the same transaction. Returns a UniqueConstraintError if
+// another row has the value of a column with a unique index, or a
+// ForeignKeyError if a row referenced by a foreign key doesn't exist.
+func (db *structuredDB) PutRow(s *Schema, table string, obj interface{}) error {
+	t, err := s.getTable(table)
+	if err != nil {
+		return err
+	}
+	r, err := getRow(t, obj)
+	if err != nil {
+		return err
+	}
+	auto, err := db.allocateAutoValues(s, t, r)
+	if err != nil {
+		return err
+	}
+	key, err := rowKey(s, t, r)
+	if err != nil {
+		return err
+	}
+	if err := db.kvDB.RunTransaction(&client.TransactionOptions{Name: "put row"}, func(txn *client.KV) error {
+		return putRow(txn, s, t, key, r)
+	}); err != nil {
+		return err
+	}
+	return setColumns(t, r, auto, obj)
+}
+
+// GetRow reads the row of the table with the primary key given by
+// obj, which is either a pointer to a Go struct corresponding to the
+// table or a map from column name to value, and stores its column
+// values in obj. Returns false if the row doesn't exist.
+func (db *structuredDB) GetRow(s *Schema, table string, obj interface{}) (bool, error) {
+	t, err := s.getTable(table)
+	if err != nil {
+		return false, err
+	}
+	r, err := getRow(t, obj)
+	if err != nil {
+		return false, err
+	}
+	key, err := rowKey(s, t, r)
+	if err != nil {
+		return false, err
+	}
+	if err := db.kvDB.RunTransaction(&client.TransactionOptions{Name: "get row"}, func(txn *client.KV) error {
+		r, err = readRow(txn, t, key, t.Columns)
+		return err
+	}); err != nil || len(r) == 0 {
+		return false, err
+	}
+	return true, setRow(t, r, obj)
+}
+
+// DeleteRow deletes the row of the table with the primary key given
+// by obj, which is either a Go struct corresponding to the table (or
+// a pointer to one) or a map from column name to value, along with
+// its index entries. Rows referencing the deleted row via foreign keys
+// are deleted or have their foreign key columns cleared, according to
+// the foreign key's ondelete policy, in the same transaction.
+func (db *structuredDB) DeleteRow(s *Schema, table string, obj interface{}) error {
+	t, err := s.getTable(table)
+	if err != nil {
+		return err
+	}
+	r, err := getRow(t, obj)
+	if err != nil {
+		return err
+	}
+	key, err := rowKey(s, t, r)
+	if err != nil {
+		return err
+	}
+	return db.kvDB.RunTransaction(&client.TransactionOptions{Name: "delete row"}, func(txn *client.KV) error {
+		return deleteRow(txn, s, t, key)
+	})
+}
+
+// ScanRows reads up to maxRows rows of the table in primary key order,
+// or all of them if maxRows is zero, and appends them to objs, which
+// is a pointer to a slice of Go structs corresponding to the table or
+// of maps from column name to value. The scan starts at the row given
+// by start and ends before the row given by end; either may be nil to
+// scan from the beginning or to the end of the table. start and end
+// may give values for only a leading subset of the primary key
+// columns. The rows of an interleaved table are scanned within the
+// key span of its top-level table. If the top-level table is
+// scattered, the scan fans out across its non-empty hash buckets and
+// the rows found are merged back into primary key order.
+func (db *structuredDB) ScanRows(s *Schema, table string, start, end interface{}, maxRows int64, objs interface{}) error {
+	t, err := s.getTable(table)
+	if err != nil {
+		return err
+	}
+	root := t.root()
+	startKey, endKey := tableKey(s, root), tableKey(s, root).PrefixEnd()
+	if start != nil {
+		r, err := getRow(t, start)
+		if err != nil {
+			return err
+		}
+		startKey, _ = rowKeyPrefix(s, t, r)
+	}
+	if end != nil {
+		r, err := getRow(t, end)
+		if err != nil {
+			return err
+		}
+		endKey, _ = rowKeyPrefix(s, t, r)
+	}
+
+	var rows keyedRows
+	if err := db.kvDB.RunTransaction(&client.TransactionOptions{Name: "scan rows"}, func(txn *client.KV) error {
+		rows = nil
+		scan := func(startKey, endKey proto.Key) error {
+			var n int64
+			return scanRows(txn, s, startKey, endKey, func(rt *Table, key proto.Key, r row) bool {
+				if rt != t {
+					return false
+				}
+				rows = append(rows, keyedRow{key: unscatterKey(s, root, key), r: r})
+				n++
+				return maxRows > 0 && n == maxRows
+			})
+		}
+		if !root.isScattered() {
+			return scan(startKey, endKey)
+		}
+		return scanBuckets(txn, s, root, startKey, endKey, scan)
+	}); err != nil {
+		return err
+	}
+	sort.Sort(rows)
+	if maxRows > 0 && int64(len(rows)) > maxRows {
+		rows = rows[:maxRows]
+	}
+	return appendRows(t, rows.rows(), objs)
+}
+
+// GetInterleavedRows reads the row of the table with the primary key
+// given by obj, as GetRow does, along with the rows interleaved in it,
+// directly or indirectly, via a single scan of the row's key span. The
+// interleaved rows are appended, in key order, to the slices of
+// children, which maps the names of interleaved tables to pointers to
+// slices as taken by ScanRows. Rows of interleaved tables missing from
+// children are skipped. Returns false if the row doesn't exist.
+func (db *structuredDB) GetInterleavedRows(s *Schema, table string, obj interface{}, children map[string]interface{}) (bool, error) {
+	t, err := s.getTable(table)
+	if err != nil {
+		return false, err
+	}
+	for name := range children {
+		ct, err := s.getTable(name)
+		if err != nil {
+			return false, err
+		}
+		if !ct.interleavedIn(t) {
+			return false, util.Errorf("table %q is not interleaved in table %q", ct.Name, t.Name)
+		}
+	}
+	r, err := getRow(t, obj)
+	if err != nil {
+		return false, err
+	}
+	key, err := rowKey(s, t, r)
+	if err != nil {
+		return false, err
+	}
+
+	var parent row
+	var rows map[*Table][]row
+	if err := db.kvDB.RunTransaction(&client.TransactionOptions{Name: "get interleaved rows"}, func(txn *client.KV) error {
+		parent, rows = nil, map[*Table][]row{}
+		return scanRows(txn, s, key, key.PrefixEnd(), func(rt *Table, _ proto.Key, r row) bool {
+			if rt == t {
+				parent = r
+			} else if _, ok := children[rt.Name]; ok {
+				rows[rt] = append(rows[rt], r)
+			}
+			return false
+		})
+	}); err != nil || parent == nil {
+		return false, err
+	}
+	if err := setRow(t, parent, obj); err != nil {
+		return false, err
+	}
+	for name, objs := range children {
+		ct := s.byName[name]
+		if err := appendRows(ct, rows[ct], objs); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// LookupRows reads up to maxRows rows of the table, or all of them if
+// maxRows is zero, whose value of the indexed column equals value, and
+// appends them to objs, which is a pointer to a slice of Go structs
+// corresponding to the table or of maps from column name to value.
+// Rows are returned in primary key order.
+func (db *structuredDB) LookupRows(s *Schema, table, column string, value interface{}, maxRows int64, objs interface{}) error {
+	t, err := s.getTable(table)
+	if err != nil {
+		return err
+	}
+	c, ok := t.byName[column]
+	if !ok {
+		return util.Errorf("table %q has no column %q", t.Name, column)
+	}
+	if !isIndexed(c) {
+		return util.Errorf("table %q: column %q has no secondary or unique index", t.Name, c.Name)
+	}
+	v, err := normalizeValue(c, value)
+	if err != nil {
+		return err
+	}
+
+	var rows []row
+	if err := db.kvDB.RunTransaction(&client.TransactionOptions{Name: "lookup rows"}, func(txn *client.KV) error {
+		rows = nil
+		keys, err := lookupIndex(txn, s, t, c, v, maxRows)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			r, err := readRow(txn, t, key, t.Columns)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, r)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return appendRows(t, rows, objs)
+}
+
+// putRow writes the row r, whose key is given, to the table within the
+// transaction, after verifying its foreign keys, and updates the
+// entries of the table's indexes.
+func putRow(txn *client.KV, s *Schema, t *Table, key proto.Key, r row) error {
+	if indexed := t.indexedColumns(); len(indexed) > 0 {
+		old, err := readRow(txn, t, key, indexed)
+		if err != nil {
+			return err
+		}
+		if err := checkForeignKeys(txn, s, t, old, r); err != nil {
+			return err
+		}
+		if err := updateIndexes(txn, s, t, key, old, r); err != nil {
+			return err
+		}
+	}
+	for _, c := range t.Columns {
+		colKey := columnKey(key, c)
+		v, ok := r[c.Name]
+		if !ok {
+			txn.Prepare(proto.Delete, &proto.DeleteRequest{
+				RequestHeader: proto.RequestHeader{Key: colKey},
+			}, &proto.DeleteResponse{})
+			continue
+		}
+		value := encodeValue(c, v)
+		value.InitChecksum(colKey)
+		txn.Prepare(proto.Put, &proto.PutRequest{
+			RequestHeader: proto.RequestHeader{Key: colKey},
+			Value:         value,
+		}, &proto.PutResponse{})
+	}
+	return txn.Flush()
+}
+
+// scanRows scans the keys from startKey to endKey within the
+// transaction and calls fn with the table, key and column values of
+// each row found, in key order, until fn returns true. The keys must lie
+// within the key span of one of the schema's top-level tables. The
+// column values of a row are stored contiguously, ahead of the rows
+// interleaved in it.
+func scanRows(txn *client.KV, s *Schema, startKey, endKey proto.Key, fn func(*Table, proto.Key, row) bool) error {
+	var lastKey proto.Key
+	var lastTable *Table
+	var r row
+	for key := startKey; key.Less(endKey); {
+		reply := &proto.ScanResponse{}
+		if err := txn.Call(proto.Scan, &proto.ScanRequest{
+			RequestHeader: proto.RequestHeader{Key: key, EndKey: endKey},
+			MaxResults:    scanBatchSize,
+		}, reply); err != nil {
+			return err
+		}
+		for _, kv := range reply.Rows {
+			t, rKey, c, err := parseKey(s, kv.Key)
+			if err != nil {
+				return err
+			}
+			if !rKey.Equal(lastKey) {
+				if r != nil && fn(lastTable, lastKey, r) {
+					return nil
+				}
+				r, lastKey, lastTable = row{}, rKey, t
+			}
+			v, err := decodeValue(c, &kv.Value)
+			if err != nil {
+				return err
+			}
+			r[c.Name] = v
+		}
+		if len(reply.Rows) < scanBatchSize {
+			break
+		}
+		key = reply.Rows[len(reply.Rows)-1].Key.Next()
+	}
+	if r != nil {
+		fn(lastTable, lastKey, r)
+	}
+	return nil
+}
+
+// readRow reads the values of the given columns of the row with the
+// given key within the transaction. The returned row is empty if the
+// row doesn't exist.
+func readRow(txn *client.KV, t *Table, key proto.Key, columns []*Column) (row, error) {
+	replies := make([]*proto.GetResponse, len(columns))
+	for i, c := range columns {
+		replies[i] = &proto.GetResponse{}
+		txn.Prepare(proto.Get, &proto.GetRequest{
+			RequestHeader: proto.RequestHeader{Key: columnKey(key, c)},
+		}, replies[i])
+	}
+	if err := txn.Flush(); err != nil {
+		return nil, err
+	}
+	r := row{}
+	for i, c := range columns {
+		if replies[i].Value == nil {
+			continue
+		}
+		v, err := decodeValue(c, replies[i].Value)
+		if err != nil {
+			return nil, err
+		}
+		r[c.Name] = v
+	}
+	return r, nil
+}
+
+// deleteRow deletes the row with the given key from the table within
+// the transaction, along with its index entries, and then applies the
+// ondelete policies of the foreign keys referencing it.
+func deleteRow(txn *client.KV, s *Schema, t *Table, key proto.Key) error {
+	old, err := readRow(txn, t, key, t.Columns)
+	if err != nil || len(old) == 0 {
+		return err
+	}
+	if err := updateIndexes(txn, s, t, key, old, row{}); err != nil {
+		return err
+	}
+	for _, c := range t.Columns {
+		txn.Prepare(proto.Delete, &proto.DeleteRequest{
+			RequestHeader: proto.RequestHeader{Key: columnKey(key, c)},
+		}, &proto.DeleteResponse{})
+	}
+	if err := txn.Flush(); err != nil {
+		return err
+	}
+	return applyOnDelete(txn, s, t, old)
+}
+
+// appendRows appends the rows to objs, which is a pointer to a slice
+// of Go structs corresponding to the table or of maps from column name
+// to value.
+func appendRows(t *Table, rows []row, objs interface{}) error {
+	sv := reflect.ValueOf(objs)
+	if sv.Kind() != reflect.Ptr || sv.Elem().Kind() != reflect.Slice {
+		return util.Errorf("table %q: expected pointer to slice of rows; got %T", t.Name, objs)
+	}
+	slice := sv.Elem()
+	for _, r := range rows {
+		var ev reflect.Value
+		if slice.Type().Elem().Kind() == reflect.Map {
+			ev = reflect.ValueOf(map[string]interface{}{})
+			if err := setRow(t, r, ev.Interface()); err != nil {
+				return err
+			}
+		} else {
+			ev = reflect.New(slice.Type().Elem()).Elem()
+			if ev.Kind() != reflect.Struct {
+				return util.Errorf("table %q: expected slice of structs or maps; got %T", t.Name, objs)
+			}
+			if err := setStruct(t, r, ev); err != nil {
+				return err
+			}
+		}
+		slice = reflect.Append(slice, ev)
+	}
+	sv.Elem().Set(slice)
+	return nil
+}
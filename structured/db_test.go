@@ -18,6 +18,9 @@
 package structured_test
 
 import (
+	"encoding/json"
+	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/cockroachdb/cockroach/proto"
@@ -58,12 +61,452 @@ func TestPutGetDeleteSchema(t *testing.T) {
 	}
 }
 
+// createTestDB bootstraps a cluster and returns a structured DB client
+// connected to it, along with the registered test schema.
+func createTestDB(t *testing.T) (structured.DB, *structured.Schema) {
+	s, err := createTestSchema()
+	if err != nil {
+		t.Fatalf("could not create test schema: %v", err)
+	}
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	localDB, err := server.BootstrapCluster("test-cluster", e)
+	if err != nil {
+		t.Fatalf("unable to boostrap cluster: %v", err)
+	}
+	db := structured.NewDB(localDB)
+	if err := db.PutSchema(s); err != nil {
+		t.Fatalf("could not register schema: %v", err)
+	}
+	return db, s
+}
+
+func TestPutGetDeleteRow(t *testing.T) {
+	db, s := createTestDB(t)
+
+	user := &User{ID: 531, Name: "Spencer", Email: "spencer@foo.com"}
+	if err := db.PutRow(s, "User", user); err != nil {
+		t.Fatalf("could not put row: %v", err)
+	}
+	got := &User{ID: 531}
+	if ok, err := db.GetRow(s, "User", got); err != nil || !ok {
+		t.Fatalf("could not get row: %t, %v", ok, err)
+	}
+	if !reflect.DeepEqual(got, user) {
+		t.Errorf("expected %+v; got %+v", user, got)
+	}
+
+	// Rows may also be JSON objects, keyed by column name.
+	var identity map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"Key": "email:spencer@foo.com", "UserID": 531}`), &identity); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutRow(s, "Identity", identity); err != nil {
+		t.Fatalf("could not put row: %v", err)
+	}
+	gotIdentity := map[string]interface{}{"Key": "email:spencer@foo.com"}
+	if ok, err := db.GetRow(s, "Identity", gotIdentity); err != nil || !ok {
+		t.Fatalf("could not get row: %t, %v", ok, err)
+	}
+	if userID := gotIdentity["UserID"]; userID != int64(531) {
+		t.Errorf("expected user ID 531; got %v", userID)
+	}
+
+	if err := db.DeleteRow(s, "User", user); err != nil {
+		t.Fatalf("could not delete row: %v", err)
+	}
+	if ok, err := db.GetRow(s, "User", &User{ID: 531}); err != nil || ok {
+		t.Errorf("expected row to be deleted; got %t, %v", ok, err)
+	}
+
+	// Rows must specify their primary key.
+	if err := db.PutRow(s, "Identity", map[string]interface{}{"UserID": 531}); err == nil {
+		t.Error("expected error putting row without primary key")
+	}
+}
+
+func TestScanRows(t *testing.T) {
+	db, s := createTestDB(t)
+
+	// Posts reference their photo stream and photo.
+	for id := int64(1); id <= 3; id++ {
+		if err := db.PutRow(s, "PhotoStream", &PhotoStream{ID: id}); err != nil {
+			t.Fatalf("could not put row: %v", err)
+		}
+		if err := db.PutRow(s, "Photo", map[string]interface{}{"ID": id}); err != nil {
+			t.Fatalf("could not put row: %v", err)
+		}
+	}
+	// Put rows out of order.
+	for _, ids := range [][2]int64{{2, 1}, {1, 2}, {1, 1}, {3, 1}, {1, 3}} {
+		post := &StreamPost{PhotoStreamID: ids[0], PhotoID: ids[1], Timestamp: ids[0]*10 + ids[1]}
+		if err := db.PutRow(s, "StreamPost", post); err != nil {
+			t.Fatalf("could not put row: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		start, end interface{}
+		maxRows    int64
+		expIDs     [][2]int64
+	}{
+		{nil, nil, 0, [][2]int64{{1, 1}, {1, 2}, {1, 3}, {2, 1}, {3, 1}}},
+		{nil, nil, 2, [][2]int64{{1, 1}, {1, 2}}},
+		// Scan the posts of a single photo stream.
+		{map[string]interface{}{"PhotoStreamID": 1}, map[string]interface{}{"PhotoStreamID": 2}, 0,
+			[][2]int64{{1, 1}, {1, 2}, {1, 3}}},
+		{&StreamPost{PhotoStreamID: 1, PhotoID: 2}, nil, 3, [][2]int64{{1, 2}, {1, 3}, {2, 1}}},
+	}
+	for i, test := range testCases {
+		var posts []StreamPost
+		if err := db.ScanRows(s, "StreamPost", test.start, test.end, test.maxRows, &posts); err != nil {
+			t.Fatalf("%d: could not scan rows: %v", i, err)
+		}
+		if len(posts) != len(test.expIDs) {
+			t.Fatalf("%d: expected %d rows; got %+v", i, len(test.expIDs), posts)
+		}
+		for j, post := range posts {
+			ids := test.expIDs[j]
+			if post.PhotoStreamID != ids[0] || post.PhotoID != ids[1] || post.Timestamp != ids[0]*10+ids[1] {
+				t.Errorf("%d: expected row %d to be %v; got %+v", i, j, ids, post)
+			}
+		}
+	}
+
+	// Rows may also be scanned into maps.
+	var posts []map[string]interface{}
+	if err := db.ScanRows(s, "StreamPost", nil, nil, 1, &posts); err != nil {
+		t.Fatalf("could not scan rows: %v", err)
+	}
+	if len(posts) != 1 || posts[0]["Timestamp"] != int64(11) {
+		t.Errorf("expected first post; got %+v", posts)
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	db, s := createTestDB(t)
+
+	users := []*User{
+		{ID: 3, Name: "Spencer", Email: "spencer@foo.com"},
+		{ID: 1, Name: "Peter", Email: "peter@foo.com"},
+		{ID: 2, Name: "Spencer", Email: "spencer@bar.com"},
+	}
+	for _, user := range users {
+		if err := db.PutRow(s, "User", user); err != nil {
+			t.Fatalf("could not put row: %v", err)
+		}
+	}
+	lookup := func(column string, value interface{}) []int64 {
+		var found []User
+		if err := db.LookupRows(s, "User", column, value, 0, &found); err != nil {
+			t.Fatalf("could not look up %s=%v: %v", column, value, err)
+		}
+		var ids []int64
+		for _, user := range found {
+			ids = append(ids, user.ID)
+		}
+		return ids
+	}
+	if ids := lookup("Name", "Spencer"); !reflect.DeepEqual(ids, []int64{2, 3}) {
+		t.Errorf("expected users 2 and 3; got %v", ids)
+	}
+	if ids := lookup("Email", "peter@foo.com"); !reflect.DeepEqual(ids, []int64{1}) {
+		t.Errorf("expected user 1; got %v", ids)
+	}
+
+	// A duplicate email fails with a constraint error and leaves the
+	// row unchanged.
+	err := db.PutRow(s, "User", &User{ID: 1, Name: "Peter", Email: "spencer@foo.com"})
+	if _, ok := err.(*structured.UniqueConstraintError); !ok {
+		t.Fatalf("expected unique constraint error; got %v", err)
+	}
+	if ids := lookup("Email", "peter@foo.com"); !reflect.DeepEqual(ids, []int64{1}) {
+		t.Errorf("expected user 1; got %v", ids)
+	}
+
+	// Updating a row moves its index entries.
+	if err := db.PutRow(s, "User", &User{ID: 3, Name: "Andy", Email: "andy@foo.com"}); err != nil {
+		t.Fatalf("could not put row: %v", err)
+	}
+	if ids := lookup("Name", "Spencer"); !reflect.DeepEqual(ids, []int64{2}) {
+		t.Errorf("expected user 2; got %v", ids)
+	}
+	if ids := lookup("Email", "spencer@foo.com"); len(ids) != 0 {
+		t.Errorf("expected no users; got %v", ids)
+	}
+	// The freed email may be reused.
+	if err := db.PutRow(s, "User", &User{ID: 1, Name: "Peter", Email: "spencer@foo.com"}); err != nil {
+		t.Fatalf("could not put row: %v", err)
+	}
+
+	// Deleting a row removes its index entries.
+	if err := db.DeleteRow(s, "User", &User{ID: 2}); err != nil {
+		t.Fatalf("could not delete row: %v", err)
+	}
+	if ids := lookup("Name", "Spencer"); len(ids) != 0 {
+		t.Errorf("expected no users; got %v", ids)
+	}
+	if ids := lookup("Email", "spencer@bar.com"); len(ids) != 0 {
+		t.Errorf("expected no users; got %v", ids)
+	}
+}
+
+func TestForeignKeys(t *testing.T) {
+	db, s := createTestDB(t)
+
+	// A foreign key must reference an existing row.
+	err := db.PutRow(s, "Identity", &Identity{Key: "email:spencer@foo.com", UserID: 1})
+	if _, ok := err.(*structured.ForeignKeyError); !ok {
+		t.Fatalf("expected foreign key error; got %v", err)
+	}
+	puts := []struct {
+		table string
+		obj   interface{}
+	}{
+		{"User", &User{ID: 1, Name: "Spencer"}},
+		{"Identity", &Identity{Key: "email:spencer@foo.com", UserID: 1}},
+		// A zero foreign key is null and needn't reference a row.
+		{"Identity", &Identity{Key: "phone:6464174337"}},
+		{"PhotoStream", &PhotoStream{ID: 1, UserID: 1}},
+		{"Photo", map[string]interface{}{"ID": 1, "UserID": 1}},
+		{"Photo", map[string]interface{}{"ID": 2, "UserID": 1}},
+		{"StreamPost", &StreamPost{PhotoStreamID: 1, PhotoID: 1}},
+		{"StreamPost", &StreamPost{PhotoStreamID: 1, PhotoID: 2}},
+		{"Comment", &Comment{PhotoStreamID: 1, ID: 1, UserID: 1, Message: "hi"}},
+	}
+	for _, put := range puts {
+		if err := db.PutRow(s, put.table, put.obj); err != nil {
+			t.Fatalf("could not put %s row: %v", put.table, err)
+		}
+	}
+
+	// Deleting the user nulls out the references to it.
+	if err := db.DeleteRow(s, "User", &User{ID: 1}); err != nil {
+		t.Fatalf("could not delete row: %v", err)
+	}
+	identity := &Identity{Key: "email:spencer@foo.com"}
+	if ok, err := db.GetRow(s, "Identity", identity); err != nil || !ok {
+		t.Fatalf("could not get row: %t, %v", ok, err)
+	}
+	if identity.UserID != 0 {
+		t.Errorf("expected null user ID; got %d", identity.UserID)
+	}
+	comment := &Comment{PhotoStreamID: 1, ID: 1}
+	if ok, err := db.GetRow(s, "Comment", comment); err != nil || !ok {
+		t.Fatalf("could not get row: %t, %v", ok, err)
+	}
+	if comment.UserID != 0 || comment.Message != "hi" {
+		t.Errorf("expected comment with null user ID; got %+v", comment)
+	}
+
+	// Deleting a photo deletes its posts, as the reference is part of
+	// their primary key.
+	if err := db.DeleteRow(s, "Photo", map[string]interface{}{"ID": 1}); err != nil {
+		t.Fatalf("could not delete row: %v", err)
+	}
+	if ok, err := db.GetRow(s, "StreamPost", &StreamPost{PhotoStreamID: 1, PhotoID: 1}); err != nil || ok {
+		t.Errorf("expected post to be deleted; got %t, %v", ok, err)
+	}
+	if ok, err := db.GetRow(s, "StreamPost", &StreamPost{PhotoStreamID: 1, PhotoID: 2}); err != nil || !ok {
+		t.Errorf("expected post to remain; got %t, %v", ok, err)
+	}
+
+	// Deleting the photo stream cascades to its interleaved posts and
+	// comments.
+	if err := db.DeleteRow(s, "PhotoStream", &PhotoStream{ID: 1}); err != nil {
+		t.Fatalf("could not delete row: %v", err)
+	}
+	var posts []StreamPost
+	if err := db.ScanRows(s, "StreamPost", nil, nil, 0, &posts); err != nil || len(posts) != 0 {
+		t.Errorf("expected no posts; got %+v, %v", posts, err)
+	}
+	var comments []Comment
+	if err := db.ScanRows(s, "Comment", nil, nil, 0, &comments); err != nil || len(comments) != 0 {
+		t.Errorf("expected no comments; got %+v, %v", comments, err)
+	}
+}
+
+func TestInterleavedRows(t *testing.T) {
+	db, s := createTestDB(t)
+
+	for id := int64(1); id <= 2; id++ {
+		if err := db.PutRow(s, "Photo", map[string]interface{}{"ID": id}); err != nil {
+			t.Fatalf("could not put row: %v", err)
+		}
+	}
+	for id := int64(1); id <= 2; id++ {
+		puts := []struct {
+			table string
+			obj   interface{}
+		}{
+			{"PhotoStream", &PhotoStream{ID: id, Title: "stream"}},
+			{"StreamPost", &StreamPost{PhotoStreamID: id, PhotoID: 1}},
+			{"StreamPost", &StreamPost{PhotoStreamID: id, PhotoID: 2}},
+			{"Comment", &Comment{PhotoStreamID: id, ID: 2, Message: "second"}},
+			{"Comment", &Comment{PhotoStreamID: id, ID: 1, Message: "first"}},
+		}
+		for _, put := range puts {
+			if err := db.PutRow(s, put.table, put.obj); err != nil {
+				t.Fatalf("could not put %s row: %v", put.table, err)
+			}
+		}
+	}
+
+	// Interleaved rows are stored within the span of the rows they're
+	// interleaved in, but scan like the rows of any other table.
+	var comments []Comment
+	if err := db.ScanRows(s, "Comment", nil, nil, 0, &comments); err != nil {
+		t.Fatalf("could not scan rows: %v", err)
+	}
+	if len(comments) != 4 {
+		t.Fatalf("expected 4 comments; got %+v", comments)
+	}
+	for i, comment := range comments {
+		if comment.PhotoStreamID != int64(i/2+1) || comment.ID != int64(i%2+1) {
+			t.Errorf("expected comment %d of stream %d; got %+v", i%2+1, i/2+1, comment)
+		}
+	}
+	var streams []PhotoStream
+	if err := db.ScanRows(s, "PhotoStream", nil, nil, 0, &streams); err != nil || len(streams) != 2 {
+		t.Errorf("expected 2 photo streams; got %+v, %v", streams, err)
+	}
+
+	// A photo stream may be read along with its posts and comments.
+	stream := &PhotoStream{ID: 2}
+	var posts []StreamPost
+	comments = nil
+	ok, err := db.GetInterleavedRows(s, "PhotoStream", stream, map[string]interface{}{
+		"StreamPost": &posts,
+		"Comment":    &comments,
+	})
+	if err != nil || !ok {
+		t.Fatalf("could not get interleaved rows: %t, %v", ok, err)
+	}
+	if stream.Title != "stream" {
+		t.Errorf("expected photo stream 2; got %+v", stream)
+	}
+	if len(posts) != 2 || posts[0].PhotoID != 1 || posts[1].PhotoID != 2 || posts[1].PhotoStreamID != 2 {
+		t.Errorf("expected posts of photo stream 2; got %+v", posts)
+	}
+	if len(comments) != 2 || comments[0].Message != "first" || comments[1].Message != "second" {
+		t.Errorf("expected comments of photo stream 2; got %+v", comments)
+	}
+
+	// Only interleaved tables may be read along with a row.
+	if _, err := db.GetInterleavedRows(s, "PhotoStream", stream, map[string]interface{}{"Photo": &posts}); err == nil {
+		t.Error("expected error reading table which isn't interleaved")
+	}
+	if ok, err := db.GetInterleavedRows(s, "PhotoStream", &PhotoStream{ID: 3}, nil); err != nil || ok {
+		t.Errorf("expected missing photo stream; got %t, %v", ok, err)
+	}
+}
+
+func TestAutoIncrement(t *testing.T) {
+	db, s := createTestDB(t)
+
+	// Zero-valued auto-increment fields are allocated the next value of
+	// the column's sequence, which is stored back in the row.
+	for i := int64(1); i <= 3; i++ {
+		user := &User{Name: "Spencer", Email: fmt.Sprintf("spencer%d@foo.com", i)}
+		if err := db.PutRow(s, "User", user); err != nil {
+			t.Fatalf("could not put row: %v", err)
+		}
+		if user.ID != i {
+			t.Errorf("expected user ID %d; got %d", i, user.ID)
+		}
+	}
+	// Explicit values are kept.
+	if err := db.PutRow(s, "User", &User{ID: 100}); err != nil {
+		t.Fatalf("could not put row: %v", err)
+	}
+	if ok, err := db.GetRow(s, "User", &User{ID: 100}); err != nil || !ok {
+		t.Errorf("could not get row: %t, %v", ok, err)
+	}
+
+	// Sequences begin at the column's start value.
+	for i := int64(10000); i <= 10001; i++ {
+		photo := map[string]interface{}{"UserID": 1}
+		if err := db.PutRow(s, "Photo", photo); err != nil {
+			t.Fatalf("could not put row: %v", err)
+		}
+		if photo["ID"] != i {
+			t.Errorf("expected photo ID %d; got %v", i, photo["ID"])
+		}
+	}
+
+	// Each column has its own sequence, also within an interleaved table.
+	if err := db.PutRow(s, "PhotoStream", &PhotoStream{ID: 1}); err != nil {
+		t.Fatalf("could not put row: %v", err)
+	}
+	comment := &Comment{PhotoStreamID: 1, UserID: 1}
+	if err := db.PutRow(s, "Comment", comment); err != nil {
+		t.Fatalf("could not put row: %v", err)
+	}
+	if comment.ID != 1 {
+		t.Errorf("expected comment ID 1; got %d", comment.ID)
+	}
+}
+
+func TestScatteredRows(t *testing.T) {
+	db, s := createTestDB(t)
+
+	// User IDs are scattered; scans and index lookups must still return
+	// rows in primary key order.
+	for id := int64(50); id >= 1; id-- {
+		if err := db.PutRow(s, "User", &User{ID: id, Name: "Spencer", Email: fmt.Sprintf("spencer%d@foo.com", id)}); err != nil {
+			t.Fatalf("could not put row: %v", err)
+		}
+	}
+	expIDs := func(start, end int64) []int64 {
+		var ids []int64
+		for id := start; id < end; id++ {
+			ids = append(ids, id)
+		}
+		return ids
+	}
+	testCases := []struct {
+		start, end interface{}
+		maxRows    int64
+		expIDs     []int64
+	}{
+		{nil, nil, 0, expIDs(1, 51)},
+		{nil, nil, 10, expIDs(1, 11)},
+		{&User{ID: 20}, &User{ID: 30}, 0, expIDs(20, 30)},
+		{&User{ID: 45}, nil, 3, expIDs(45, 48)},
+	}
+	for i, test := range testCases {
+		var users []User
+		if err := db.ScanRows(s, "User", test.start, test.end, test.maxRows, &users); err != nil {
+			t.Fatalf("%d: could not scan rows: %v", i, err)
+		}
+		var ids []int64
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		if !reflect.DeepEqual(ids, test.expIDs) {
+			t.Errorf("%d: expected IDs %v; got %v", i, test.expIDs, ids)
+		}
+	}
+
+	var users []User
+	if err := db.LookupRows(s, "User", "Name", "Spencer", 5, &users); err != nil {
+		t.Fatalf("could not look up rows: %v", err)
+	}
+	var ids []int64
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	if !reflect.DeepEqual(ids, expIDs(1, 6)) {
+		t.Errorf("expected IDs %v; got %v", expIDs(1, 6), ids)
+	}
+}
+
 // User is a top-level table. User IDs are scattered, meaning a two
 // byte hash of the ID from the UserID sequence is prepended to yield
 // a randomly distributed keyspace.
 type User struct {
-	ID   int64  `roach:"id,pk,auto,scatter"`
-	Name string `roach:"na"`
+	ID    int64  `roach:"id,pk,auto,scatter"`
+	Name  string `roach:"na,secondaryindex"`
+	Email string `roach:"em,uniqueindex"`
 }
 
 // Identity is a top-level table as identities must be queried by key
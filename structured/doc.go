@@ -201,7 +201,10 @@ Tag Specifications
   "ondelete" optionally specifies behavior if referenced object is
   deleted. Foreign keys create a secondary index. It isn't necessary
   to specify secondaryindex; however, to specify that a foreign key
-  denotes a one-to-one relation, specify "uniqueindex". "interleave"
+  denotes a one-to-one relation, specify "uniqueindex". Writing a row
+  fails unless each non-null foreign key references an existing row;
+  in Go structs, the zero value of a foreign key field outside the
+  primary key denotes null. "interleave"
   optionally specifies that all of the data for structs of this type
   will be placed "next to" the referenced table for data locality.
 
@@ -227,7 +230,10 @@ Tag Specifications
   the foreign key column to nil. If "interleave" was specified for
   this foreign key, then "cascade" is the mandatory default value;
   specifying "setnull" for an interleaved foreign key results in a
-  schema validation error.
+  schema validation error. Foreign key columns which are part of the
+  primary key can't be set to nil, so objects referencing the deleted
+  object from their primary key are always deleted. The deletion and
+  its consequences are applied in a single transaction.
 
   pk: (Primary Key) specifies the field is the primary key or part of
   a composite primary key. The first field with pk specified will form
@@ -238,7 +244,8 @@ Tag Specifications
   scatter: randomizes the placement of the data within the table's
   keyspace by prepending a two-byte hash of the entire primary key to
   the actual key used to store the value in cockroach. "scatter" may
-  only be specified on the first field with "pk" specified.
+  only be specified on the first field with "pk" specified, and not
+  in interleaved tables.
 
   secondaryindex: a secondary index on the column value. Tuples from
   this table (or alternatively, instances of this struct) may be
@@ -246,7 +253,13 @@ Tag Specifications
 
   auto[=<start-value>]: specifies that the value of this field
   auto-increments from a monotonically increasing sequence starting
-  at the optional start value.
+  at the optional start value, which must be positive and defaults to
+  1. When an object is written without a value for the field, or
+  with its zero value, it's allocated the next value of the sequence,
+  which is stored back in the object (unless a struct is passed by
+  value). Each client reserves blocks of values from a cluster-wide
+  counter, so values are unique but those allocated by different
+  clients interleave, and values may be skipped.
 
   uniqueindex: a secondary index where uniqueness of the column value
   is enforced.
@@ -303,51 +316,73 @@ tuple from table User would have key prefix "pdb/us". The tuple's key
 suffix is an encoded concatenation of column values marked as part of
 the primary key. For a user with ID=531, the full key would be
 "pdb/us/<OrderedEncode(531)>" (from here on out, we'll shorten
-OrderedEncode to just "E"). The value stored with the key is a
-gob-encoded map[string]interface{}, with each column value (excepting
-primary keys) as an entry in the map, indexed by column key.
+OrderedEncode to just "E"). Schema, table and column keys are ordered
+encoded as strings and primary key values according to their column
+type, using the key encodings in util/encoding. Each column value of
+the tuple, including those of the primary key columns, is stored
+separately, with the tuple's key followed by an encoded nil (shown as
+"-") and the column key as key. Columns without a value have no key.
+
+  pdb/us/<E(529)>/-/id: 529
+  pdb/us/<E(529)>/-/na: "Peter"
+  pdb/us/<E(530)>/-/id: 530
+  pdb/us/<E(530)>/-/na: "Spencer"
+  pdb/us/<E(531)>/-/id: 531
+  pdb/us/<E(531)>/-/na: "Andy"
+
+Rows are read and written via the PutRow, GetRow, DeleteRow and
+ScanRows methods of DB, each of which runs within a transaction. A
+row is either a Go struct corresponding to the table or a map from
+column name to value, such as a decoded JSON object. Rows support
+columns of type "integer", "float", "string", "blob" and "time".
+Below, the data for a tuple is abbreviated as a single key.
 
   pdb/us/<E(529)>: <data for user 529>
   pdb/us/<E(530)>: <data for user 530>
   pdb/us/<E(531)>: <data for user 531>
 
 If a primary key column has the "scatter" option specified, the
-encoded value for the key is additionally prefixed with the first two
-bytes of a hash of the entire primary key value. For example, If the
-hash of 531 is 1532079858. The first two bytes are 0xf2 and 0xae. The
-key would be "pdb/us/\xf2\xae<E(531)>". This provides an essentially
-random location for the columnar data of User 531 within the
-"pdb/us/..." keyspace. Using the "scatter" option prevents hotspots in
-non-uniformly distributed data. For example, primary keys generated
-from a monotonically-increasing sequence or from the current
-time. Keep in mind, however, that using "scatter" makes range scans
-impossible.
-
-  pdb/us/\x09\x31<E(530)>: <data for user 530>
+encoded value for the key is additionally prefixed with a bucket
+holding the first two bytes of a CRC-32 checksum of the entire
+encoded primary key value, binary encoded (abbreviated as
+B(<bytes>)). For example, if the checksum of E(531) is 0xf2ae07f2,
+the key would be "pdb/us/B(\xf2\xae)<E(531)>". This provides an
+essentially random location for the columnar data of User 531 within
+the "pdb/us/..." keyspace. Using the "scatter" option prevents
+hotspots in non-uniformly distributed data. For example, primary keys
+generated from a monotonically-increasing sequence or from the
+current time. Keep in mind, however, that range scans of a scattered
+table are more expensive: a scan fans out across the table's
+non-empty buckets, of which there may be up to 65536, and merges the
+rows found back into primary key order.
+
+  pdb/us/B(\x09\x31)<E(530)>: <data for user 530>
   ...
-  pdb/us/\x50\xae<E(531)>: <data for user 531>
+  pdb/us/B(\x50\xb9)<E(529)>: <data for user 529>
   ...
-  pdb/us/\xf2\xb9<E(529)>: <data for user 529>
+  pdb/us/B(\xf2\xae)<E(531)>: <data for user 531>
 
 If a foreign key column has the "interleave" option specified, the
 data for the table is co-located with the table referenced by the
-foreign key. For example, let's consider an additional table in the
-schema, Address, containing addresses for Users (abbreviated for
-concision).
+foreign key. The foreign key columns must be the leading columns of
+the primary key, in the order of the referenced primary key. For
+example, let's consider an additional table in the schema, Address,
+containing addresses for Users (abbreviated for concision).
 
   - table: Address
     table_key: ad
     columns:
-    - column:      ID
-      column_key:  id
-      type:        integer
-      primary_key: true
-
     - column:      UserID
       column_key:  ui
       type:        integer
       foreign_key: User.ID
       interleave:  true
+      primary_key: true
+
+    - column:      ID
+      column_key:  id
+      type:        integer
+      primary_key: true
 
     - column:     Street
       column_key: st
@@ -363,20 +398,30 @@ Transactions modifying both a user and an address (on account
 creation, for example) are likely to be part of the same cockroach key
 range and therefore will be committed without requiring a distributed
 transaction. If "interleave" is specified, the referenced table
-entity's key is used as a key prefix. This makes for longer keys, but
-guarantees all data is proximately located. If User 531 has two
-addresses, with ids 35 & 56 respectively, the underlying data would
-look like:
-
-  pdb/us/<E(529)>: <data for user 529>
+entity's key, followed by an encoded nil, is used as a key prefix. The
+table key follows, binary encoded to distinguish it from column keys,
+and then the values of all of the primary key columns. This makes for
+longer keys, but guarantees all data is proximately located. If User
+531 has two addresses, with ids 35 & 56 respectively, the underlying
+data would look like:
+
+  pdb/us/<E(529)>/-: <data for user 529>
   ...
-  pdb/us/<E(531)>: <data for user 531>
-  pdb/us/<E(531)>/ad/<E(35)>: <data for address 35>
-  pdb/us/<E(531)>/ad/<E(56)>: <data for address 56>
+  pdb/us/<E(531)>/-: <data for user 531>
+  pdb/us/<E(531)>/-/ad/<E(531)><E(35)>: <data for address 35>
+  pdb/us/<E(531)>/-/ad/<E(531)><E(56)>: <data for address 56>
   ...
-  pdb/us/<E(600)>: <data for user 600>
+  pdb/us/<E(600)>/-: <data for user 600>
   ...
 
+A user and its addresses may be read with a single scan via DB's
+GetInterleavedRows method; addresses may also be scanned on their own
+like the rows of any other table. The keys of a top-level row and of
+all rows interleaved in it share the top-level row key followed by an
+encoded nil as prefix. Ranges overlapping the key prefix of a schema
+stored by PutSchema are never split between a top-level row and its
+interleaved rows (see engine.IsValidSplitKey).
+
 Indexes
 
 Indexes provide efficient access to rows in the database by columnar
@@ -426,13 +471,24 @@ As can be seen above, the keyspace taken by the UserEmail index table
 follows the User table. In order to lookup the user with email
 "foo@bar.com", a range scan is initiated for the lower bound of
 "foo@bar.com", and keys with matching prefix are decoded to yield the
-list of matching user IDs.
+list of matching user IDs. (Strictly, index entries hold the key of
+the row without the schema key prefix, e.g. us/<E(531)>, rather than
+just its primary key, so as to locate interleaved rows as well. The
+hash bucket of scattered rows is left out, so that rows with the same
+indexed value are listed in primary key order.)
 
 Secondary indexes have a single term and which exactly mirrors their
 value. User.Email is an example of this. For email=X, the term is X.
 Secondary indexes contain only keys, no values. If the secondary index
-is unique, then a range scan is done on insert to verify that no other
-index term with the same prefix is already present.
+is unique, the primary key is instead stored as the value of the term's
+key, which is written via a conditional put on insert to verify that
+no other row already has the same term. A violation fails the write
+with a UniqueConstraintError. Index entries are maintained within the
+same transaction as the row writes, and rows may be looked up by their
+indexed values via DB's LookupRows method.
+
+  pdb/us:em/<E(baz@fubar.com)>: <E(10247)>
+  pdb/us:em/<E(foo@bar.com)>: <E(531)>
 
 Index types other than secondary, such as "location" and "fulltext",
 may yield multiple index terms for a column value. Full text indexes,
@@ -0,0 +1,158 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package structured
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// A ForeignKeyError indicates that a row couldn't be written because
+// the row referenced by one of its foreign keys doesn't exist.
+type ForeignKeyError struct {
+	Table        string
+	ForeignTable string
+	// Key holds the values of the referenced primary key columns,
+	// keyed by column name.
+	Key map[string]interface{}
+}
+
+// Error formats the foreign key violation.
+func (e *ForeignKeyError) Error() string {
+	return fmt.Sprintf("table %q: foreign key references missing row %v of table %q", e.Table, e.Key, e.ForeignTable)
+}
+
+// sortedTableNames returns the keys of a foreign key map in sorted
+// order, so that foreign keys are always processed in the same order.
+func sortedTableNames(fks map[string]map[string]*Column) []string {
+	names := make([]string, 0, len(fks))
+	for name := range fks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// foreignRow returns the primary key values of the row of table ft
+// referenced by row r of table t, keyed by the referenced column
+// names, and whether r references a row at all. A foreign key is null
+// if all of its columns are absent; returns an error if only some of
+// them are.
+func foreignRow(t, ft *Table, r row) (row, bool, error) {
+	fkCols := t.foreignKeys[ft.Name]
+	ref := row{}
+	for refName, c := range fkCols {
+		if v, ok := r[c.Name]; ok {
+			ref[refName] = v
+		}
+	}
+	if len(ref) == 0 {
+		return nil, false, nil
+	}
+	if len(ref) < len(fkCols) {
+		return nil, false, util.Errorf("table %q: foreign key to table %q is incomplete", t.Name, ft.Name)
+	}
+	return ref, true, nil
+}
+
+// checkForeignKeys verifies within the transaction that the rows
+// referenced by the foreign keys of row r of the table exist. Foreign
+// keys which are null or unchanged from the old row are not checked.
+// Returns a ForeignKeyError if a referenced row doesn't exist.
+func checkForeignKeys(txn *client.KV, s *Schema, t *Table, old, r row) error {
+	for _, ftName := range sortedTableNames(t.foreignKeys) {
+		ft := s.byName[ftName]
+		ref, ok, err := foreignRow(t, ft, r)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if oldRef, _, _ := foreignRow(t, ft, old); reflect.DeepEqual(oldRef, ref) {
+			continue
+		}
+		refKey, err := rowKey(s, ft, ref)
+		if err != nil {
+			return err
+		}
+		reply := &proto.GetResponse{}
+		if err := txn.Call(proto.Get, &proto.GetRequest{
+			RequestHeader: proto.RequestHeader{Key: columnKey(refKey, ft.primaryKey[0])},
+		}, reply); err != nil {
+			return err
+		}
+		if reply.Value == nil {
+			return &ForeignKeyError{Table: t.Name, ForeignTable: ft.Name, Key: ref}
+		}
+	}
+	return nil
+}
+
+// applyOnDelete applies the ondelete policies of the foreign keys
+// referencing row r, which was just deleted from the table, within the
+// transaction. Referencing rows are found via the index of the column
+// referencing the first column of the table's primary key. With
+// "cascade", referencing rows are deleted in turn; with "setnull",
+// their foreign key columns are cleared. Primary key columns can't be
+// cleared, so rows which reference the deleted row from their primary
+// key are always deleted.
+func applyOnDelete(txn *client.KV, s *Schema, t *Table, r row) error {
+	for _, rtName := range sortedTableNames(t.incomingForeignKeys) {
+		rt := s.byName[rtName]
+		fkCols := rt.foreignKeys[t.Name]
+		pkName := t.primaryKey[0].Name
+		keys, err := lookupIndex(txn, s, rt, fkCols[pkName], r[pkName], 0)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			rr, err := readRow(txn, rt, key, rt.Columns)
+			if err != nil {
+				return err
+			}
+			// The index only matches the first column of a composite
+			// foreign key; skip rows which reference another row.
+			cascade, match := false, len(rr) > 0
+			for refName, c := range fkCols {
+				if !reflect.DeepEqual(rr[c.Name], r[refName]) {
+					match = false
+				}
+				cascade = cascade || c.OnDelete == "cascade" || c.PrimaryKey
+			}
+			if !match {
+				continue
+			}
+			if cascade {
+				err = deleteRow(txn, s, rt, key)
+			} else {
+				for _, c := range fkCols {
+					delete(rr, c.Name)
+				}
+				err = putRow(txn, s, rt, key, rr)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
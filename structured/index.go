@@ -0,0 +1,195 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package structured
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// A UniqueConstraintError indicates that a row couldn't be written
+// because another row of the table already has the same value for a
+// column with a unique index.
+type UniqueConstraintError struct {
+	Table  string
+	Column string
+	Value  interface{}
+}
+
+// Error formats the unique constraint violation.
+func (e *UniqueConstraintError) Error() string {
+	return fmt.Sprintf("table %q: duplicate value %v for unique column %q", e.Table, e.Value, e.Column)
+}
+
+// indexType returns the type of the column's secondary or unique
+// index, or "" if it has neither. Foreign key columns without such an
+// index have an implicit secondary index, which is used to find the
+// rows referencing a deleted row. Fulltext and location indexes are
+// not yet maintained.
+func indexType(c *Column) string {
+	switch {
+	case c.Index == indexTypeSecondary || c.Index == indexTypeUnique:
+		return c.Index
+	case c.ForeignKey != "":
+		return indexTypeSecondary
+	}
+	return ""
+}
+
+// isIndexed returns true if the column has a secondary or unique
+// index, whether explicit or implied by a foreign key.
+func isIndexed(c *Column) bool {
+	return indexType(c) != ""
+}
+
+// indexedColumns returns the table's columns with secondary or unique
+// indexes, including foreign key columns.
+func (t *Table) indexedColumns() []*Column {
+	var indexed []*Column
+	for _, c := range t.Columns {
+		if isIndexed(c) {
+			indexed = append(indexed, c)
+		}
+	}
+	return indexed
+}
+
+// indexKey returns the key prefix of the index entries for value v of
+// the column: the encoded schema key, followed by the encoded index
+// table key, <table key>:<column key>, and the encoded value.
+//
+// A unique index holds a single entry at this key, whose value is the
+// unscattered key of the row without the schema key prefix. A
+// secondary index holds an entry for each row with the value, at this
+// key followed by the row's unscattered key without the schema key
+// prefix. Either way, the entries of rows with the same value are
+// ordered by primary key, also for interleaved and scattered tables.
+func indexKey(s *Schema, t *Table, c *Column, v interface{}) proto.Key {
+	key := encoding.EncodeString(schemaKey(s), t.Key+":"+c.Key)
+	return proto.Key(encodeKeyValue(key, c, v))
+}
+
+// updateIndexes updates the entries of the table's indexes for the
+// row with the given key from the values of the old row to those of
+// the new one, within the transaction. Entries for unchanged values
+// are left alone. New unique index entries are written first, via
+// conditional puts which fail if another row has the same value.
+func updateIndexes(txn *client.KV, s *Schema, t *Table, key proto.Key, old, r row) error {
+	suffix := unscatterKey(s, t.root(), key)[len(schemaKey(s)):]
+	var deletes, puts []proto.Key
+	for _, c := range t.indexedColumns() {
+		oldV, hadOld := old[c.Name]
+		newV, hasNew := r[c.Name]
+		var oldKey, newKey proto.Key
+		if hadOld {
+			oldKey = indexKey(s, t, c, oldV)
+		}
+		if hasNew {
+			newKey = indexKey(s, t, c, newV)
+		}
+		if hadOld && hasNew && oldKey.Equal(newKey) {
+			continue
+		}
+		if indexType(c) == indexTypeSecondary {
+			if hadOld {
+				oldKey = append(oldKey, suffix...)
+			}
+			if hasNew {
+				newKey = append(newKey, suffix...)
+			}
+		}
+		if hadOld {
+			deletes = append(deletes, oldKey)
+		}
+		if !hasNew {
+			continue
+		}
+		if indexType(c) == indexTypeSecondary {
+			puts = append(puts, newKey)
+			continue
+		}
+		value := proto.Value{Bytes: suffix}
+		value.InitChecksum(newKey)
+		reply := &proto.ConditionalPutResponse{}
+		if err := txn.Call(proto.ConditionalPut, &proto.ConditionalPutRequest{
+			RequestHeader: proto.RequestHeader{Key: newKey},
+			Value:         value,
+		}, reply); err != nil {
+			if reply.ActualValue != nil && !bytes.Equal(reply.ActualValue.Bytes, suffix) {
+				return &UniqueConstraintError{Table: t.Name, Column: c.Name, Value: newV}
+			}
+			return err
+		}
+	}
+	for _, k := range deletes {
+		txn.Prepare(proto.Delete, &proto.DeleteRequest{
+			RequestHeader: proto.RequestHeader{Key: k},
+		}, &proto.DeleteResponse{})
+	}
+	for _, k := range puts {
+		value := proto.Value{Bytes: []byte{}}
+		value.InitChecksum(k)
+		txn.Prepare(proto.Put, &proto.PutRequest{
+			RequestHeader: proto.RequestHeader{Key: k},
+			Value:         value,
+		}, &proto.PutResponse{})
+	}
+	return txn.Flush()
+}
+
+// lookupIndex returns the keys of up to maxRows rows of the table, or
+// all of them if maxRows is zero, whose value of the indexed column is
+// v, in primary key order.
+func lookupIndex(txn *client.KV, s *Schema, t *Table, c *Column, v interface{}, maxRows int64) ([]proto.Key, error) {
+	prefix := indexKey(s, t, c, v)
+	sKey := schemaKey(s)
+	if indexType(c) == indexTypeUnique {
+		reply := &proto.GetResponse{}
+		if err := txn.Call(proto.Get, &proto.GetRequest{
+			RequestHeader: proto.RequestHeader{Key: prefix},
+		}, reply); err != nil || reply.Value == nil {
+			return nil, err
+		}
+		return []proto.Key{scatterKey(s, t.root(), append(append(proto.Key(nil), sKey...), reply.Value.Bytes...))}, nil
+	}
+
+	var keys []proto.Key
+	endKey := prefix.PrefixEnd()
+	for key := prefix; key.Less(endKey); {
+		reply := &proto.ScanResponse{}
+		if err := txn.Call(proto.Scan, &proto.ScanRequest{
+			RequestHeader: proto.RequestHeader{Key: key, EndKey: endKey},
+			MaxResults:    scanBatchSize,
+		}, reply); err != nil {
+			return nil, err
+		}
+		for _, kv := range reply.Rows {
+			if maxRows > 0 && int64(len(keys)) == maxRows {
+				return keys, nil
+			}
+			keys = append(keys, scatterKey(s, t.root(), append(append(proto.Key(nil), sKey...), kv.Key[len(prefix):]...)))
+		}
+		if len(reply.Rows) < scanBatchSize {
+			break
+		}
+		key = reply.Rows[len(reply.Rows)-1].Key.Next()
+	}
+	return keys, nil
+}
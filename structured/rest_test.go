@@ -27,6 +27,8 @@ import (
 	"reflect"
 	"sync"
 	"testing"
+
+	"github.com/cockroachdb/cockroach/util"
 )
 
 var (
@@ -63,6 +65,34 @@ func (db *testDB) GetSchema(key string) (*Schema, error) {
 	return nil, nil
 }
 
+// errNoRows is returned by the row methods of testDB; the REST server
+// only uses the schema methods.
+var errNoRows = util.Errorf("testDB does not store rows")
+
+func (db *testDB) PutRow(s *Schema, table string, obj interface{}) error {
+	return errNoRows
+}
+
+func (db *testDB) GetRow(s *Schema, table string, obj interface{}) (bool, error) {
+	return false, errNoRows
+}
+
+func (db *testDB) DeleteRow(s *Schema, table string, obj interface{}) error {
+	return errNoRows
+}
+
+func (db *testDB) ScanRows(s *Schema, table string, start, end interface{}, maxRows int64, objs interface{}) error {
+	return errNoRows
+}
+
+func (db *testDB) GetInterleavedRows(s *Schema, table string, obj interface{}, children map[string]interface{}) (bool, error) {
+	return false, errNoRows
+}
+
+func (db *testDB) LookupRows(s *Schema, table, column string, value interface{}, maxRows int64, objs interface{}) error {
+	return errNoRows
+}
+
 func newTestDB() *testDB {
 	return &testDB{kv: map[string]interface{}{}}
 }
@@ -0,0 +1,528 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package structured
+
+import (
+	"bytes"
+	"encoding/base64"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// A row is the set of column values of a single table row, keyed by
+// column name. Values are normalized according to the column's type:
+// integers are int64s, floats are float64s, strings are strings,
+// blobs are []bytes and times are time.Times. Columns without a value
+// are absent.
+type row map[string]interface{}
+
+// getTable returns the schema's table with the given name. The schema
+// is validated first if necessary, as is the case for schemas which
+// have been read back from the datastore.
+func (s *Schema) getTable(name string) (*Table, error) {
+	if s.byName == nil {
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	t, ok := s.byName[name]
+	if !ok {
+		return nil, util.Errorf("schema %q has no table %q", s.Name, name)
+	}
+	return t, nil
+}
+
+var (
+	// encodedNil separates a row key from the keys which extend it:
+	// the keys of the row's column values and of the rows interleaved
+	// in it. The keys of a top-level row and of all rows interleaved
+	// in it, directly or indirectly, thus share the top-level row key
+	// followed by encodedNil as prefix, which the storage engine uses
+	// to avoid splitting interleaved rows from their top-level row.
+	encodedNil = encoding.EncodeNil()
+	// interleaveTag is the first byte of the binary encoded table key
+	// which follows encodedNil in the keys of interleaved rows. Column
+	// keys are encoded as strings, and thus begin with another byte.
+	interleaveTag = encoding.EncodeBinary(nil, nil)[0]
+)
+
+// schemaKey returns the prefix of the keys of all of the schema's rows
+// and index entries: the encoded schema key.
+func schemaKey(s *Schema) proto.Key {
+	return proto.Key(encoding.EncodeString(nil, s.Key))
+}
+
+// tableKey returns the prefix of the keys of all rows of the top-level
+// table and of the rows interleaved in them: the encoded schema key
+// followed by the encoded table key.
+func tableKey(s *Schema, t *Table) proto.Key {
+	return proto.Key(encoding.EncodeString(schemaKey(s), t.Key))
+}
+
+// root returns the top-level table in which the table is interleaved,
+// directly or indirectly, or the table itself if it isn't interleaved.
+func (t *Table) root() *Table {
+	for t.parent != nil {
+		t = t.parent
+	}
+	return t
+}
+
+// interleavedIn returns true if the table is interleaved in table p,
+// directly or indirectly.
+func (t *Table) interleavedIn(p *Table) bool {
+	for a := t.parent; a != nil; a = a.parent {
+		if a == p {
+			return true
+		}
+	}
+	return false
+}
+
+// rowKey returns the key of the row, including the hash bucket of its
+// top-level row if the top-level table is scattered. Returns an error
+// if the row lacks a value for a primary key column.
+func rowKey(s *Schema, t *Table, r row) (proto.Key, error) {
+	key, n := rowKeyPrefix(s, t, r)
+	if n < len(t.primaryKey) {
+		return nil, util.Errorf("table %q: missing value for primary key column %q", t.Name, t.primaryKey[n].Name)
+	}
+	return scatterKey(s, t.root(), key), nil
+}
+
+// rowKeyPrefix returns the key prefix of the table's rows which have
+// the row's values for the leading primary key columns for which it
+// has a value, along with the number of columns encoded. The key of a
+// row of a top-level table is the table key followed by the encoded
+// values of the row's primary key columns. The key of an interleaved
+// row is the key of the row it's interleaved in, as referenced by the
+// leading primary key columns, followed by encodedNil, the binary
+// encoded table key and the encoded values of all of the row's
+// primary key columns. The returned key is unscattered; see
+// scatterKey.
+func rowKeyPrefix(s *Schema, t *Table, r row) (proto.Key, int) {
+	var key []byte
+	if t.parent == nil {
+		key = []byte(tableKey(s, t))
+	} else {
+		pr := row{}
+		for i, c := range t.parent.primaryKey {
+			if v, ok := r[t.primaryKey[i].Name]; ok {
+				pr[c.Name] = v
+			}
+		}
+		pKey, n := rowKeyPrefix(s, t.parent, pr)
+		if n < len(t.parent.primaryKey) {
+			return pKey, n
+		}
+		key = encoding.EncodeBinary(append([]byte(pKey), encodedNil...), []byte(t.Key))
+	}
+	for i, c := range t.primaryKey {
+		v, ok := r[c.Name]
+		if !ok {
+			return proto.Key(key), i
+		}
+		key = encodeKeyValue(key, c, v)
+	}
+	return proto.Key(key), len(t.primaryKey)
+}
+
+// columnKey returns the key at which the column's value is stored for
+// the row with the given key: the row key followed by encodedNil and
+// the encoded column key.
+func columnKey(key proto.Key, c *Column) proto.Key {
+	return proto.Key(encoding.EncodeString(append(append([]byte(nil), key...), encodedNil...), c.Key))
+}
+
+// encodeKeyValue appends the ordered encoding of v, a normalized value
+// of the column, to b.
+func encodeKeyValue(b []byte, c *Column, v interface{}) []byte {
+	switch c.Type {
+	case columnTypeInteger:
+		return encoding.EncodeInt(b, v.(int64))
+	case columnTypeFloat:
+		return encoding.EncodeFloat(b, v.(float64))
+	case columnTypeString:
+		return encoding.EncodeString(b, v.(string))
+	case columnTypeBlob:
+		return encoding.EncodeBinary(b, v.([]byte))
+	case columnTypeTime:
+		return encoding.EncodeInt(b, v.(time.Time).UnixNano())
+	}
+	panic(util.Errorf("column %q: unsupported key type %q", c.Name, c.Type))
+}
+
+// skipKeyValue returns the remainder of b after skipping over the
+// encoded value of the column at its start.
+func skipKeyValue(b []byte, c *Column) []byte {
+	switch c.Type {
+	case columnTypeInteger, columnTypeTime:
+		b, _ = encoding.DecodeInt(b)
+	case columnTypeFloat:
+		b = encoding.SkipFloat(b)
+	case columnTypeString:
+		b, _ = encoding.DecodeString(b)
+	case columnTypeBlob:
+		b, _ = encoding.DecodeBinary(b)
+	default:
+		panic(util.Errorf("column %q: unsupported key type %q", c.Name, c.Type))
+	}
+	return b
+}
+
+// parseKey parses the key of a column value of a row of one of the
+// schema's tables into the row's table, the row key and the column.
+func parseKey(s *Schema, key proto.Key) (*Table, proto.Key, *Column, error) {
+	b, tKey := encoding.DecodeString([]byte(key[len(schemaKey(s)):]))
+	t, ok := s.byKey[tKey]
+	if !ok || t.parent != nil {
+		return nil, nil, nil, util.Errorf("schema %q: key %q has unknown table %q", s.Name, key, tKey)
+	}
+	if t.isScattered() {
+		b = b[bucketLen:]
+	}
+	for {
+		for _, c := range t.primaryKey {
+			b = skipKeyValue(b, c)
+		}
+		rKey := key[:len(key)-len(b)]
+		if len(b) <= len(encodedNil) || !bytes.HasPrefix(b, encodedNil) {
+			return nil, nil, nil, util.Errorf("table %q: key %q has no column", t.Name, key)
+		}
+		b = b[len(encodedNil):]
+		if b[0] != interleaveTag {
+			_, colKey := encoding.DecodeString(b)
+			c, ok := t.byKey[colKey]
+			if !ok {
+				return nil, nil, nil, util.Errorf("table %q: key %q has unknown column %q", t.Name, key, colKey)
+			}
+			return t, rKey, c, nil
+		}
+		var childKey []byte
+		b, childKey = encoding.DecodeBinary(b)
+		child, ok := s.byKey[string(childKey)]
+		if !ok || child.parent != t {
+			return nil, nil, nil, util.Errorf("table %q: key %q has unknown interleaved table %q", t.Name, key, childKey)
+		}
+		t = child
+	}
+}
+
+// encodeValue returns the value stored for v, a normalized value of the
+// column. Integers and times are stored as integer values; all other
+// types as bytes.
+func encodeValue(c *Column, v interface{}) proto.Value {
+	switch c.Type {
+	case columnTypeInteger:
+		return proto.Value{Integer: gogoproto.Int64(v.(int64))}
+	case columnTypeFloat:
+		return proto.Value{Bytes: encoding.EncodeUint64(nil, math.Float64bits(v.(float64)))}
+	case columnTypeString:
+		return proto.Value{Bytes: []byte(v.(string))}
+	case columnTypeBlob:
+		return proto.Value{Bytes: v.([]byte)}
+	case columnTypeTime:
+		return proto.Value{Integer: gogoproto.Int64(v.(time.Time).UnixNano())}
+	}
+	panic(util.Errorf("column %q: unsupported value type %q", c.Name, c.Type))
+}
+
+// decodeValue returns the normalized column value stored in value.
+func decodeValue(c *Column, value *proto.Value) (interface{}, error) {
+	switch c.Type {
+	case columnTypeInteger, columnTypeTime:
+		if value.Integer == nil {
+			return nil, util.Errorf("column %q: expected integer value; got %+v", c.Name, value)
+		}
+		if c.Type == columnTypeTime {
+			return time.Unix(0, value.GetInteger()).UTC(), nil
+		}
+		return value.GetInteger(), nil
+	case columnTypeFloat:
+		if len(value.Bytes) != 8 {
+			return nil, util.Errorf("column %q: expected 8 byte float value; got %+v", c.Name, value)
+		}
+		_, bits := encoding.DecodeUint64(value.Bytes)
+		return math.Float64frombits(bits), nil
+	case columnTypeString:
+		return string(value.Bytes), nil
+	case columnTypeBlob:
+		return value.Bytes, nil
+	}
+	return nil, util.Errorf("column %q: unsupported value type %q", c.Name, c.Type)
+}
+
+// normalizeValue converts v, as found in a Go struct field or decoded
+// from a JSON object, to the normalized representation of a value of
+// the column. Integer columns accept Go bools and integers as well as
+// integral JSON numbers; blob columns accept base64-encoded strings
+// and time columns RFC 3339 strings.
+//
+// Values of primary key and indexed columns are encoded in keys, and
+// are rejected if their ordered encoding can't represent them:
+// strings containing NUL bytes and subnormal floats.
+func normalizeValue(c *Column, v interface{}) (interface{}, error) {
+	nv, err := convertValue(c, v)
+	if err != nil {
+		return nil, err
+	}
+	if !c.PrimaryKey && !isIndexed(c) {
+		return nv, nil
+	}
+	switch t := nv.(type) {
+	case string:
+		if strings.IndexByte(t, 0) != -1 {
+			return nil, util.Errorf("column %q: key value %q contains a NUL byte", c.Name, t)
+		}
+	case float64:
+		if t != 0 && math.Abs(t) < minNormalFloat {
+			return nil, util.Errorf("column %q: key value %g is subnormal", c.Name, t)
+		}
+	}
+	return nv, nil
+}
+
+// minNormalFloat is the smallest positive normal float64. The ordered
+// float encoding doesn't support the subnormal floats below it.
+var minNormalFloat = math.Float64frombits(1 << 52)
+
+// minTime and maxTime bound the times which can be stored, as times
+// are encoded as nanoseconds since the Unix epoch.
+var (
+	minTime = time.Unix(0, math.MinInt64)
+	maxTime = time.Unix(0, math.MaxInt64)
+)
+
+// convertValue converts v to the normalized representation of a value
+// of the column; see normalizeValue.
+func convertValue(c *Column, v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	switch c.Type {
+	case columnTypeInteger:
+		switch rv.Kind() {
+		case reflect.Bool:
+			if rv.Bool() {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u := rv.Uint()
+			if u > math.MaxInt64 {
+				return nil, util.Errorf("column %q: %d overflows %s", c.Name, u, c.Type)
+			}
+			return int64(u), nil
+		case reflect.Float32, reflect.Float64:
+			if f := rv.Float(); f == math.Trunc(f) {
+				// -2^63 is exactly representable; 2^63 is the first
+				// float above math.MaxInt64.
+				if f < math.MinInt64 || f >= -math.MinInt64 {
+					return nil, util.Errorf("column %q: %g overflows %s", c.Name, f, c.Type)
+				}
+				return int64(f), nil
+			}
+		}
+	case columnTypeFloat:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), nil
+		case reflect.Float32, reflect.Float64:
+			return rv.Float(), nil
+		}
+	case columnTypeString:
+		if rv.Kind() == reflect.String {
+			return rv.String(), nil
+		}
+	case columnTypeBlob:
+		switch t := v.(type) {
+		case []byte:
+			return append([]byte(nil), t...), nil
+		case string:
+			b, err := base64.StdEncoding.DecodeString(t)
+			if err != nil {
+				return nil, util.Errorf("column %q: %s", c.Name, err)
+			}
+			return b, nil
+		}
+	case columnTypeTime:
+		var tm time.Time
+		switch t := v.(type) {
+		case time.Time:
+			tm = t
+		case string:
+			var err error
+			if tm, err = time.Parse(time.RFC3339Nano, t); err != nil {
+				return nil, util.Errorf("column %q: %s", c.Name, err)
+			}
+		default:
+			return nil, util.Errorf("column %q: cannot store %T as %s", c.Name, v, c.Type)
+		}
+		if tm.Before(minTime) || tm.After(maxTime) {
+			return nil, util.Errorf("column %q: %s is outside the range of storable times", c.Name, tm)
+		}
+		return tm, nil
+	default:
+		return nil, util.Errorf("column %q: type %q is not supported in rows", c.Name, c.Type)
+	}
+	return nil, util.Errorf("column %q: cannot store %T as %s", c.Name, v, c.Type)
+}
+
+// getRow returns the normalized column values of obj, which is either
+// a Go struct corresponding to the table (or a pointer to one) or a
+// map from column name to value, such as a decoded JSON object. Nil
+// map values are treated as absent, as are the zero values of struct
+// fields of foreign key columns outside the primary key, which can't
+// otherwise express a null reference, and of auto-increment columns,
+// which are thus allocated a value when the row is written.
+func getRow(t *Table, obj interface{}) (row, error) {
+	r := row{}
+	if m, ok := obj.(map[string]interface{}); ok {
+		for name, v := range m {
+			c, ok := t.byName[name]
+			if !ok {
+				return nil, util.Errorf("table %q has no column %q", t.Name, name)
+			}
+			if v == nil {
+				continue
+			}
+			nv, err := normalizeValue(c, v)
+			if err != nil {
+				return nil, err
+			}
+			r[name] = nv
+		}
+		return r, nil
+	}
+	sv := reflect.Indirect(reflect.ValueOf(obj))
+	if sv.Kind() != reflect.Struct {
+		return nil, util.Errorf("table %q: expected struct or map row; got %T", t.Name, obj)
+	}
+	for _, c := range t.Columns {
+		fv := sv.FieldByName(c.Name)
+		if !fv.IsValid() {
+			continue
+		}
+		if ((c.ForeignKey != "" && !c.PrimaryKey) || c.Auto != nil) && isZero(fv) {
+			continue
+		}
+		nv, err := normalizeValue(c, fv.Interface())
+		if err != nil {
+			return nil, err
+		}
+		r[c.Name] = nv
+	}
+	return r, nil
+}
+
+// isZero returns true if v holds the zero value of its type. Empty
+// slices count as zero.
+func isZero(v reflect.Value) bool {
+	if v.Kind() == reflect.Slice {
+		return v.Len() == 0
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// setRow stores the column values of r in obj, which is either a
+// pointer to a Go struct corresponding to the table or a map from
+// column name to value. Struct fields of absent columns are zeroed.
+func setRow(t *Table, r row, obj interface{}) error {
+	if m, ok := obj.(map[string]interface{}); ok {
+		for name, v := range r {
+			m[name] = v
+		}
+		return nil
+	}
+	pv := reflect.ValueOf(obj)
+	if pv.Kind() != reflect.Ptr || pv.Elem().Kind() != reflect.Struct {
+		return util.Errorf("table %q: expected pointer to struct or map row; got %T", t.Name, obj)
+	}
+	return setStruct(t, r, pv.Elem())
+}
+
+// setStruct stores the column values of r in the fields of sv.
+func setStruct(t *Table, r row, sv reflect.Value) error {
+	for _, c := range t.Columns {
+		fv := sv.FieldByName(c.Name)
+		if !fv.IsValid() {
+			continue
+		}
+		v, ok := r[c.Name]
+		if !ok {
+			fv.Set(reflect.Zero(fv.Type()))
+			continue
+		}
+		if err := setField(c, fv, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setField stores v, a normalized value of the column, in the struct
+// field fv.
+func setField(c *Column, fv reflect.Value, v interface{}) error {
+	switch fv.Kind() {
+	case reflect.Bool:
+		fv.SetBool(v.(int64) != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fv.SetInt(v.(int64))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fv.SetUint(uint64(v.(int64)))
+	case reflect.Float32, reflect.Float64:
+		fv.SetFloat(v.(float64))
+	default:
+		rv := reflect.ValueOf(v)
+		if !rv.Type().AssignableTo(fv.Type()) {
+			return util.Errorf("column %q: cannot set field of type %s to %T", c.Name, fv.Type(), v)
+		}
+		fv.Set(rv)
+	}
+	return nil
+}
+
+// setColumns stores the values of the named columns of r in obj, which
+// is a Go struct corresponding to the table (or a pointer to one) or a
+// map from column name to value. The values can't be stored in a
+// struct passed by value, which is left alone.
+func setColumns(t *Table, r row, names []string, obj interface{}) error {
+	if m, ok := obj.(map[string]interface{}); ok {
+		for _, name := range names {
+			m[name] = r[name]
+		}
+		return nil
+	}
+	pv := reflect.ValueOf(obj)
+	if pv.Kind() != reflect.Ptr {
+		return nil
+	}
+	for _, name := range names {
+		if fv := pv.Elem().FieldByName(name); fv.IsValid() {
+			if err := setField(t.byName[name], fv, r[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
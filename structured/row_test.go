@@ -0,0 +1,91 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package structured
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestNormalizeKeyValues verifies that values of primary key and
+// indexed columns which can't be encoded in keys are rejected, while
+// the same values are accepted for other columns.
+func TestNormalizeKeyValues(t *testing.T) {
+	pk := func(typ string) *Column { return &Column{Name: "pk", Type: typ, PrimaryKey: true} }
+	idx := func(typ string) *Column { return &Column{Name: "idx", Type: typ, Index: indexTypeUnique} }
+	plain := func(typ string) *Column { return &Column{Name: "plain", Type: typ} }
+
+	testCases := []struct {
+		c     *Column
+		v     interface{}
+		expOK bool
+	}{
+		{pk(columnTypeString), "abc", true},
+		{pk(columnTypeString), "a\x00c", false},
+		{idx(columnTypeString), "a\x00c", false},
+		{plain(columnTypeString), "a\x00c", true},
+		{pk(columnTypeFloat), 1.5, true},
+		{pk(columnTypeFloat), 0.0, true},
+		{pk(columnTypeFloat), math.MaxFloat64, true},
+		{pk(columnTypeFloat), -minNormalFloat, true},
+		{pk(columnTypeFloat), 5e-324, false},
+		{idx(columnTypeFloat), -math.SmallestNonzeroFloat64, false},
+		{plain(columnTypeFloat), 5e-324, true},
+	}
+	for i, test := range testCases {
+		_, err := normalizeValue(test.c, test.v)
+		if ok := err == nil; ok != test.expOK {
+			t.Errorf("%d: expected ok=%t for %s value %v of column %q; got %v", i, test.expOK, test.c.Type, test.v, test.c.Name, err)
+		}
+	}
+}
+
+// TestConvertValueRange verifies that integer and time values which
+// can't be represented in their column's encoding are rejected rather
+// than wrapped.
+func TestConvertValueRange(t *testing.T) {
+	intCol := &Column{Name: "i", Type: columnTypeInteger}
+	timeCol := &Column{Name: "t", Type: columnTypeTime}
+
+	testCases := []struct {
+		c     *Column
+		v     interface{}
+		expOK bool
+	}{
+		{intCol, uint64(math.MaxInt64), true},
+		{intCol, uint64(math.MaxInt64) + 1, false},
+		{intCol, float64(math.MinInt64), true},
+		{intCol, float64(1 << 62), true},
+		{intCol, float64(1 << 63), false},
+		{intCol, -float64(1 << 64), false},
+		{intCol, math.Inf(1), false},
+		{intCol, math.NaN(), false},
+		{timeCol, time.Unix(0, math.MaxInt64), true},
+		{timeCol, time.Unix(0, math.MinInt64), true},
+		{timeCol, time.Unix(0, math.MaxInt64).Add(1), false},
+		{timeCol, time.Unix(0, math.MinInt64).Add(-1), false},
+		{timeCol, "1677-09-22T00:00:00Z", true},
+		{timeCol, "0001-01-01T00:00:00Z", false},
+		{timeCol, "9999-12-31T23:59:59Z", false},
+	}
+	for i, test := range testCases {
+		_, err := convertValue(test.c, test.v)
+		if ok := err == nil; ok != test.expOK {
+			t.Errorf("%d: expected ok=%t for %s value %v; got %v", i, test.expOK, test.c.Type, test.v, err)
+		}
+	}
+}
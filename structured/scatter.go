@@ -0,0 +1,124 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package structured
+
+import (
+	"bytes"
+	"hash/crc32"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// bucketLen is the length of the hash bucket which follows the table
+// key in the keys of scattered tables: two hash bytes, binary encoded
+// so that the storage engine can parse the keys.
+var bucketLen = len(encoding.EncodeBinary(nil, []byte{0, 0}))
+
+// isScattered returns true if the top-level table's primary key is
+// scattered.
+func (t *Table) isScattered() bool {
+	return t.parent == nil && t.primaryKey[0].Scatter
+}
+
+// scatterKey returns the key of a row of the top-level table t or of a
+// row interleaved in it, as built by rowKeyPrefix from the complete
+// primary key, with the hash bucket of its top-level row inserted
+// after the table key if t is scattered. The bucket holds the first
+// two bytes of the CRC-32 checksum of the encoded primary key values
+// of the top-level row.
+func scatterKey(s *Schema, t *Table, key proto.Key) proto.Key {
+	if !t.isScattered() {
+		return key
+	}
+	tKey := tableKey(s, t)
+	b := key[len(tKey):]
+	for _, c := range t.primaryKey {
+		b = skipKeyValue(b, c)
+	}
+	sum := crc32.ChecksumIEEE(key[len(tKey) : len(key)-len(b)])
+	sKey := encoding.EncodeBinary(append([]byte(nil), tKey...), []byte{byte(sum >> 24), byte(sum >> 16)})
+	return proto.Key(append(sKey, key[len(tKey):]...))
+}
+
+// unscatterKey returns the key of a row of the top-level table t or of
+// a row interleaved in it without the hash bucket inserted by
+// scatterKey. Unscattered keys sort in primary key order.
+func unscatterKey(s *Schema, t *Table, key proto.Key) proto.Key {
+	if !t.isScattered() {
+		return key
+	}
+	n := len(tableKey(s, t))
+	return append(append(proto.Key(nil), key[:n]...), key[n+bucketLen:]...)
+}
+
+// scanBuckets calls fn, in bucket order, with the key span of each
+// non-empty hash bucket of the scattered top-level table t which
+// corresponds to the span of unscattered keys from startKey to endKey.
+// The next non-empty bucket is found with a scan for a single key, so
+// empty buckets are skipped without being scanned.
+func scanBuckets(txn *client.KV, s *Schema, t *Table, startKey, endKey proto.Key, fn func(startKey, endKey proto.Key) error) error {
+	tKey := tableKey(s, t)
+	tEnd := tKey.PrefixEnd()
+	for key := tKey; key.Less(tEnd); {
+		reply := &proto.ScanResponse{}
+		if err := txn.Call(proto.Scan, &proto.ScanRequest{
+			RequestHeader: proto.RequestHeader{Key: key, EndKey: tEnd},
+			MaxResults:    1,
+		}, reply); err != nil {
+			return err
+		}
+		if len(reply.Rows) == 0 {
+			break
+		}
+		bucket := append(proto.Key(nil), reply.Rows[0].Key[:len(tKey)+bucketLen]...)
+		bStart := append(append(proto.Key(nil), bucket...), startKey[len(tKey):]...)
+		bEnd := bucket.PrefixEnd()
+		if bytes.HasPrefix(endKey, tKey) {
+			bEnd = append(append(proto.Key(nil), bucket...), endKey[len(tKey):]...)
+		}
+		if err := fn(bStart, bEnd); err != nil {
+			return err
+		}
+		key = bucket.PrefixEnd()
+	}
+	return nil
+}
+
+// A keyedRow is a row along with its unscattered key.
+type keyedRow struct {
+	key proto.Key
+	r   row
+}
+
+// keyedRows implements sort.Interface, ordering rows by unscattered
+// key, which merges the rows found in the buckets of a scattered table
+// back into primary key order.
+type keyedRows []keyedRow
+
+func (kr keyedRows) Len() int           { return len(kr) }
+func (kr keyedRows) Less(i, j int) bool { return kr[i].key.Less(kr[j].key) }
+func (kr keyedRows) Swap(i, j int)      { kr[i], kr[j] = kr[j], kr[i] }
+
+// rows returns the rows without their keys.
+func (kr keyedRows) rows() []row {
+	rows := make([]row, len(kr))
+	for i := range kr {
+		rows[i] = kr[i].r
+	}
+	return rows
+}
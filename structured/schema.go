@@ -147,6 +147,9 @@ type Table struct {
 	// delete the referencing object ("cascade") or set the columns
 	// null ("setnull").
 	incomingForeignKeys map[string]map[string]*Column
+	// parent is the table in which this table is interleaved, or nil
+	// if the table is a top-level table.
+	parent *Table
 }
 
 // TableSlice helpfully implements the sort interface.
@@ -304,6 +307,7 @@ func (s *Schema) Validate() error {
 		t.primaryKey = make([]*Column, 0, 1)
 		t.foreignKeys = map[string]map[string]*Column{}
 		t.incomingForeignKeys = map[string]map[string]*Column{}
+		t.parent = nil
 
 		// Validate table.
 		if err := s.validateTable(t); err != nil {
@@ -333,6 +337,17 @@ func (s *Schema) Validate() error {
 		}
 	}
 
+	// Fourth pass: verify interleaved tables end up in a top-level
+	// table.
+	for _, t := range s.Tables {
+		depth := 0
+		for p := t.parent; p != nil; p = p.parent {
+			if depth++; depth > len(s.Tables) {
+				return fmt.Errorf("table %q: interleaved tables form a cycle", t.Name)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -387,6 +402,9 @@ func (s *Schema) validateColumn(c *Column, t *Table) error {
 	if c.Auto != nil && c.Type != columnTypeInteger {
 		return fmt.Errorf("auto may only be specified with columns of type integer")
 	}
+	if c.Auto != nil && *c.Auto < 1 {
+		return fmt.Errorf("auto-increment start value %d must be positive", *c.Auto)
+	}
 
 	// Verify foreign key & associated options.
 	if c.ForeignKey != "" {
@@ -500,6 +518,23 @@ func (s *Schema) validateForeignKey(t *Table, fkTable string) error {
 	if !reflect.DeepEqual(fkColNames, ftPKColNames) {
 		return fmt.Errorf("component mismatch: foreign key has %s; primary key of ref'd table has %s", fkColNames, ftPKColNames)
 	}
+
+	// Interleaved rows are keyed by the key of the row they reference,
+	// so the foreign key must lead the primary key, column for column.
+	if lastCol.Interleave {
+		if t.parent != nil {
+			return fmt.Errorf("table is already interleaved in table %q", t.parent.Name)
+		}
+		if t.primaryKey[0].Scatter {
+			return fmt.Errorf("interleaved tables cannot scatter their primary key")
+		}
+		for i, c := range ft.primaryKey {
+			if i >= len(t.primaryKey) || t.primaryKey[i] != t.foreignKeys[fkTable][c.Name] {
+				return fmt.Errorf("interleaved foreign key columns must lead the primary key in the order of the primary key of table %q", fkTable)
+			}
+		}
+		t.parent = ft
+	}
 	return nil
 }
 
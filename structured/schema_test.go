@@ -294,6 +294,39 @@ func TestBadForeignKeys(t *testing.T) {
 	}
 }
 
+// TestInterleavedTables verifies interleaved tables are linked to the
+// tables they're interleaved in, and that interleaved foreign keys
+// must lead the primary key.
+func TestInterleavedTables(t *testing.T) {
+	s, err := createTestSchema()
+	if err != nil {
+		t.Fatalf("failed building schema: %s", err)
+	}
+	for _, name := range []string{"StreamPost", "Comment"} {
+		if s.byName[name].parent != s.byName["PhotoStream"] {
+			t.Errorf("expected %s to be interleaved in PhotoStream", name)
+		}
+	}
+	if s.byName["PhotoStream"].parent != nil {
+		t.Errorf("expected PhotoStream to be a top-level table")
+	}
+
+	// Identity.UserID isn't part of Identity's primary key.
+	c := s.byName["Identity"].byName["UserID"]
+	c.Interleave, c.OnDelete = true, ""
+	if err := s.Validate(); err == nil {
+		t.Errorf("expected error interleaving on foreign key outside primary key")
+	}
+	c.Interleave, c.OnDelete = false, "setnull"
+
+	// Interleaved rows are keyed by their parent row, so they can't
+	// be scattered.
+	s.byName["Comment"].byName["PhotoStreamID"].Scatter = true
+	if err := s.Validate(); err == nil {
+		t.Errorf("expected error scattering interleaved table")
+	}
+}
+
 // TestColumnOptions verifies settings of trivial column options
 // (e.g. "scatter").
 func TestColumnOptions(t *testing.T) {
@@ -329,3 +362,15 @@ func TestColumnOptions(t *testing.T) {
 		t.Errorf("expected full text index on PhotoStream.Title")
 	}
 }
+
+func TestBadAutoIncrement(t *testing.T) {
+	s, err := createTestSchema()
+	if err != nil {
+		t.Fatalf("failed building schema: %s", err)
+	}
+	start := int64(0)
+	s.byName["Photo"].byName["ID"].Auto = &start
+	if err := s.Validate(); err == nil {
+		t.Errorf("expected error validating auto-increment column starting at 0")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package structured
+
+import (
+	"sync"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/encoding"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// sequenceBlockSize is the number of values of an auto-increment
+// column's sequence reserved by each increment of its key.
+const sequenceBlockSize = 10
+
+// sequenceKey returns the key of the cluster-wide counter backing the
+// sequence of the auto-increment column: KeySequencePrefix followed by
+// the encoded schema, table and column keys.
+func sequenceKey(s *Schema, t *Table, c *Column) proto.Key {
+	return engine.MakeKey(engine.KeySequencePrefix,
+		proto.Key(encoding.EncodeString(encoding.EncodeString(schemaKey(s), t.Key), c.Key)))
+}
+
+// A sequence allocates the values of an auto-increment column. Values
+// are reserved in blocks by incrementing the sequence's key, as the
+// storage package's IDAllocator does, so that each value is handed out
+// only once across the cluster. Values are increasing on each node,
+// but values allocated by different nodes interleave, and values left
+// in a block are lost when the node exits.
+//
+// sequence is thread safe.
+type sequence struct {
+	sync.Mutex
+	key   proto.Key
+	start int64 // First value of the sequence
+	next  int64 // Next value to allocate from the current block
+	end   int64 // End of the current block (exclusive)
+}
+
+// allocate returns the next value of the sequence, reserving a new
+// block of values first if the current one is used up. The increment
+// is not part of any transaction, so values are never reused, even if
+// the row they were allocated for isn't written.
+func (sq *sequence) allocate(kvDB *client.KV) (int64, error) {
+	sq.Lock()
+	defer sq.Unlock()
+	if sq.next == sq.end {
+		if err := sq.reserveBlock(kvDB, sequenceBlockSize); err != nil {
+			return 0, err
+		}
+	}
+	sq.next++
+	return sq.next - 1, nil
+}
+
+// reserveBlock increments the sequence's key by incr and makes the
+// values reserved the current block. If the key is below the start of
+// the sequence, it's incremented again to skip the missing values.
+func (sq *sequence) reserveBlock(kvDB *client.KV, incr int64) error {
+	ir := &proto.IncrementResponse{}
+	if err := kvDB.Call(proto.Increment, &proto.IncrementRequest{
+		RequestHeader: proto.RequestHeader{Key: sq.key},
+		Increment:     incr,
+	}, ir); err != nil {
+		return err
+	}
+	if ir.NewValue < sq.start {
+		log.Warningf("sequence %q is currently set at %d; start is %d; allocating again to skip %d values",
+			sq.key, ir.NewValue, sq.start, sq.start-ir.NewValue)
+		return sq.reserveBlock(kvDB, sq.start-ir.NewValue+sequenceBlockSize-1)
+	}
+	sq.next, sq.end = ir.NewValue-incr+1, ir.NewValue+1
+	if sq.next < sq.start {
+		sq.next = sq.start
+	}
+	return nil
+}
+
+// getSequence returns the sequence of the auto-increment column,
+// creating it on first use.
+func (db *structuredDB) getSequence(s *Schema, t *Table, c *Column) *sequence {
+	key := sequenceKey(s, t, c)
+	db.Lock()
+	defer db.Unlock()
+	sq, ok := db.sequences[string(key)]
+	if !ok {
+		sq = &sequence{key: key, start: *c.Auto}
+		db.sequences[string(key)] = sq
+	}
+	return sq
+}
+
+// allocateAutoValues allocates values for the auto-increment columns
+// for which the row has no value and adds them to the row. Returns the
+// names of the columns allocated.
+func (db *structuredDB) allocateAutoValues(s *Schema, t *Table, r row) ([]string, error) {
+	var names []string
+	for _, c := range t.Columns {
+		if _, ok := r[c.Name]; ok || c.Auto == nil {
+			continue
+		}
+		v, err := db.getSequence(s, t, c).allocate(db.kvDB)
+		if err != nil {
+			return nil, err
+		}
+		r[c.Name] = v
+		names = append(names, c.Name)
+	}
+	return names, nil
+}
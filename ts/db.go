@@ -0,0 +1,88 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package ts
+
+import (
+	"math"
+	"time"
+
+	gogoproto "code.google.com/p/gogoprotobuf/proto"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// A Datapoint is a single sample of a time series: a value observed
+// at a wall time, expressed in nanoseconds since the unix epoch.
+type Datapoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// DB stores and queries time series data via a key-value client.
+type DB struct {
+	kv *client.KV
+}
+
+// NewDB returns a time series DB which reads and writes data via the
+// supplied key-value client.
+func NewDB(kv *client.KV) *DB {
+	return &DB{kv: kv}
+}
+
+// StoreData stores the datapoints of the named time series recorded
+// by source at the given resolution. The datapoints are grouped by
+// key interval and each group is merged into the data already stored
+// for its interval.
+func (db *DB) StoreData(r Resolution, name, source string, data []Datapoint) error {
+	var intervals []int64
+	tsData := map[int64]*proto.TimeSeriesData{}
+	for _, dp := range data {
+		start := r.intervalStart(dp.Timestamp)
+		ts, ok := tsData[start]
+		if !ok {
+			ts = &proto.TimeSeriesData{
+				StartTimestamp:    start / int64(time.Second),
+				DurationInSeconds: r.KeyDuration() / int64(time.Second),
+				SamplePrecision:   proto.SECONDS,
+			}
+			tsData[start] = ts
+			intervals = append(intervals, start)
+		}
+		offset := dp.Timestamp - start
+		offset -= offset % r.SampleDuration()
+		point := &proto.TimeSeriesDataPoint{Offset: int32(offset / int64(time.Second))}
+		// Integral values are stored as integers, which unlike the
+		// stored floating point values don't lose precision.
+		if v := dp.Value; v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 {
+			point.ValueInt = gogoproto.Int64(int64(v))
+		} else {
+			point.ValueFloat = gogoproto.Float32(float32(v))
+		}
+		ts.Data = append(ts.Data, point)
+	}
+
+	for _, start := range intervals {
+		if err := db.kv.Call(proto.AccumulateTS, &proto.AccumulateTSRequest{
+			RequestHeader: proto.RequestHeader{
+				Key: MakeDataKey(name, source, r, start),
+			},
+			Data: *tsData[start],
+		}, &proto.AccumulateTSResponse{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
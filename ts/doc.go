@@ -0,0 +1,34 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+/*
+Package ts stores and queries time series data in the cockroach
+key-value store.
+
+A time series is identified by a name (e.g. "cr.node.sys.goroutines")
+and is recorded by one or more sources (e.g. the nodes or stores of
+a cluster). Samples are stored at a fixed resolution: the samples of
+each source which fall within one interval of the resolution's key
+duration are kept in a single proto.TimeSeriesData value and new
+samples are merged into it via the AccumulateTS command, without
+reading the existing value.
+
+The keys of a time series sort by name, resolution and interval
+start before source, so that the data of all sources over a window of
+time is read with a single scan. Queries downsample the samples of
+each source to a requested period, interpolate over gaps and
+aggregate the sources into a single series of datapoints.
+*/
+package ts
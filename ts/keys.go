@@ -0,0 +1,126 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package ts
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// Resolution describes the granularity at which samples of a time
+// series are stored. Each resolution has a sample duration, to
+// which sample timestamps are truncated, and a key duration, which
+// is the length of the interval stored under a single key.
+type Resolution int64
+
+const (
+	// Resolution10s stores samples at a resolution of ten seconds,
+	// with an hour of samples per key.
+	Resolution10s Resolution = 1
+)
+
+// SampleDuration returns the duration, in nanoseconds, to which the
+// timestamps of samples stored at this resolution are truncated.
+func (r Resolution) SampleDuration() int64 {
+	switch r {
+	case Resolution10s:
+		return int64(10 * time.Second)
+	}
+	panic(fmt.Sprintf("unknown time series resolution %d", r))
+}
+
+// KeyDuration returns the duration, in nanoseconds, of the interval
+// of samples stored under a single key at this resolution.
+func (r Resolution) KeyDuration() int64 {
+	switch r {
+	case Resolution10s:
+		return int64(time.Hour)
+	}
+	panic(fmt.Sprintf("unknown time series resolution %d", r))
+}
+
+// isValid returns whether r is a known resolution.
+func (r Resolution) isValid() bool {
+	switch r {
+	case Resolution10s:
+		return true
+	}
+	return false
+}
+
+// intervalStart returns the start, in nanoseconds, of the key
+// interval containing the timestamp.
+func (r Resolution) intervalStart(timestamp int64) int64 {
+	return timestamp - timestamp%r.KeyDuration()
+}
+
+// makeDataKeyPrefix returns the prefix of the keys of all sources of
+// the named time series for the key interval starting at timestamp.
+func makeDataKeyPrefix(name string, r Resolution, timestamp int64) proto.Key {
+	k := encoding.EncodeBinary(nil, []byte(name))
+	k = encoding.EncodeInt(k, int64(r))
+	k = encoding.EncodeInt(k, timestamp/int64(time.Second))
+	return engine.MakeKey(engine.KeyTimeSeriesPrefix, k)
+}
+
+// MakeDataKey returns the key at which samples of the named time
+// series recorded by source at the given resolution are stored for
+// the key interval containing timestamp, in nanoseconds.
+func MakeDataKey(name, source string, r Resolution, timestamp int64) proto.Key {
+	prefix := makeDataKeyPrefix(name, r, r.intervalStart(timestamp))
+	return engine.MakeKey(prefix, encoding.EncodeBinary(nil, []byte(source)))
+}
+
+// DecodeDataKey decodes a key created by MakeDataKey, returning the
+// time series name and source, the resolution and the start of the
+// key interval in nanoseconds. An error is returned if the key isn't
+// a well-formed time series data key.
+func DecodeDataKey(key proto.Key) (name, source string, r Resolution, timestamp int64, err error) {
+	if !bytes.HasPrefix(key, engine.KeyTimeSeriesPrefix) {
+		return "", "", 0, 0, util.Errorf("key %q is not a time series key", key)
+	}
+	// The key decoding functions panic on malformed input.
+	defer func() {
+		if e := recover(); e != nil {
+			name, source, r, timestamp = "", "", 0, 0
+			err = util.Errorf("malformed time series key %q: %v", key, e)
+		}
+	}()
+	b := []byte(key[len(engine.KeyTimeSeriesPrefix):])
+	var nameBytes, sourceBytes []byte
+	var res, seconds int64
+	b, nameBytes = encoding.DecodeBinary(b)
+	b, res = encoding.DecodeInt(b)
+	b, seconds = encoding.DecodeInt(b)
+	b, sourceBytes = encoding.DecodeBinary(b)
+	if len(b) != 0 {
+		return "", "", 0, 0, util.Errorf("time series key %q has %d trailing bytes", key, len(b))
+	}
+	r = Resolution(res)
+	if !r.isValid() {
+		return "", "", 0, 0, util.Errorf("time series key %q has unknown resolution %d", key, res)
+	}
+	if seconds*int64(time.Second)%r.KeyDuration() != 0 {
+		return "", "", 0, 0, util.Errorf("time series key %q has misaligned interval start %ds", key, seconds)
+	}
+	return string(nameBytes), string(sourceBytes), r, seconds * int64(time.Second), nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package ts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// TestDataKeyRoundTrip verifies that data keys decode to the name,
+// source, resolution and interval start they were made from.
+func TestDataKeyRoundTrip(t *testing.T) {
+	hour := int64(time.Hour)
+	testCases := []struct {
+		name, source string
+		timestamp    int64
+		expStart     int64
+	}{
+		{"", "", 0, 0},
+		{"cr.node.sys.goroutines", "1", 5 * hour, 5 * hour},
+		{"cr.node.sys.goroutines", "1", 5*hour + 1, 5 * hour},
+		{"cr.store.bytes", "store\x00with\xffodd bytes", 7*hour - 1, 6 * hour},
+	}
+	for i, test := range testCases {
+		key := MakeDataKey(test.name, test.source, Resolution10s, test.timestamp)
+		name, source, r, start, err := DecodeDataKey(key)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if name != test.name || source != test.source || r != Resolution10s || start != test.expStart {
+			t.Errorf("%d: expected (%q, %q, %d, %d); got (%q, %q, %d, %d)", i,
+				test.name, test.source, Resolution10s, test.expStart, name, source, r, start)
+		}
+	}
+	if _, _, _, _, err := DecodeDataKey(proto.Key("a")); err == nil {
+		t.Error("expected an error decoding a non time series key")
+	}
+}
+
+// TestDecodeDataKeyErrors verifies that malformed time series keys
+// fail to decode.
+func TestDecodeDataKeyErrors(t *testing.T) {
+	key := MakeDataKey("name", "source", Resolution10s, int64(time.Hour))
+	prefix := makeDataKeyPrefix("name", Resolution10s, int64(time.Hour))
+	testCases := []proto.Key{
+		engine.KeyTimeSeriesPrefix,
+		engine.MakeKey(engine.KeyTimeSeriesPrefix, proto.Key("garbage")),
+		key[:len(key)-1],
+		engine.MakeKey(key, proto.Key("x")),
+		engine.MakeKey(makeDataKeyPrefix("name", Resolution(7), int64(time.Hour)),
+			encoding.EncodeBinary(nil, []byte("source"))),
+		engine.MakeKey(makeDataKeyPrefix("name", Resolution10s, int64(time.Minute)),
+			encoding.EncodeBinary(nil, []byte("source"))),
+		prefix,
+	}
+	for i, test := range testCases {
+		if _, _, _, _, err := DecodeDataKey(test); err == nil {
+			t.Errorf("%d: expected an error decoding %q", i, test)
+		}
+	}
+}
+
+// TestDataKeyOrdering verifies that data keys sort by name and
+// interval start before source.
+func TestDataKeyOrdering(t *testing.T) {
+	hour := int64(time.Hour)
+	keys := []proto.Key{
+		MakeDataKey("a", "z", Resolution10s, 0),
+		MakeDataKey("a", "a", Resolution10s, hour),
+		MakeDataKey("a", "b", Resolution10s, hour),
+		MakeDataKey("a", "a", Resolution10s, 10*hour),
+		MakeDataKey("ab", "a", Resolution10s, 0),
+		MakeDataKey("b", "a", Resolution10s, 0),
+	}
+	for i := 1; i < len(keys); i++ {
+		if !keys[i-1].Less(keys[i]) {
+			t.Errorf("expected key %d %q < key %d %q", i-1, keys[i-1], i, keys[i])
+		}
+	}
+	// All keys of an interval lie within the bounds of its prefix.
+	start, end := makeDataKeyPrefix("a", Resolution10s, hour), makeDataKeyPrefix("a", Resolution10s, 2*hour)
+	for _, key := range keys[1:3] {
+		if key.Less(start) || !key.Less(end) {
+			t.Errorf("expected key %q within [%q, %q)", key, start, end)
+		}
+	}
+}
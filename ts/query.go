@@ -0,0 +1,242 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package ts
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// An Aggregator combines a set of values into a single value.
+type Aggregator int
+
+const (
+	// AggregatorAvg averages the values.
+	AggregatorAvg Aggregator = iota
+	// AggregatorSum sums the values.
+	AggregatorSum
+	// AggregatorMin selects the least of the values.
+	AggregatorMin
+	// AggregatorMax selects the greatest of the values.
+	AggregatorMax
+)
+
+// ParseAggregator returns the aggregator with the given name: one of
+// "avg", "sum", "min" or "max". An empty name yields AggregatorAvg.
+func ParseAggregator(name string) (Aggregator, error) {
+	switch strings.ToLower(name) {
+	case "", "avg":
+		return AggregatorAvg, nil
+	case "sum":
+		return AggregatorSum, nil
+	case "min":
+		return AggregatorMin, nil
+	case "max":
+		return AggregatorMax, nil
+	}
+	return 0, util.Errorf("unknown aggregator %q", name)
+}
+
+// aggregate accumulates values to be combined by an Aggregator.
+type aggregate struct {
+	sum, min, max float64
+	count         int
+}
+
+// add adds the value to the aggregate.
+func (a *aggregate) add(v float64) {
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.sum += v
+	a.count++
+}
+
+// value returns the values added to the aggregate combined by agg.
+func (a *aggregate) value(agg Aggregator) float64 {
+	switch agg {
+	case AggregatorSum:
+		return a.sum
+	case AggregatorMin:
+		return a.min
+	case AggregatorMax:
+		return a.max
+	}
+	return a.sum / float64(a.count)
+}
+
+// A Query describes a time series query over a window of time.
+type Query struct {
+	// Name is the name of the queried time series.
+	Name string
+	// Sources restricts the query to data recorded by the listed
+	// sources. If empty, the data of all sources is queried.
+	Sources []string
+	// Start and End bound the queried window, in nanoseconds since the
+	// unix epoch. Start is inclusive and End is exclusive.
+	Start, End int64
+	// Resolution is the resolution at which the data is stored.
+	Resolution Resolution
+	// Period is the duration in nanoseconds of the intervals to which
+	// the samples of each source are downsampled. If zero, the sample
+	// duration of the resolution is used.
+	Period int64
+	// Downsampler combines the samples of a source within a period.
+	Downsampler Aggregator
+	// SourceAggregator combines the downsampled values of the sources
+	// at each period.
+	SourceAggregator Aggregator
+}
+
+// Query returns the datapoints of the time series described by q in
+// ascending order of time, one per period with data from at least
+// one source. The samples of each source are downsampled to the
+// query's period. Where a source has no data at a period for which
+// another source does, the source's value is interpolated linearly
+// between its neighboring periods, if it has data on both sides.
+// The values of the sources are then combined with the query's
+// source aggregator.
+func (db *DB) Query(q Query) ([]Datapoint, error) {
+	if q.End <= q.Start {
+		return nil, util.Errorf("query end %d must be greater than start %d", q.End, q.Start)
+	}
+	period := q.Period
+	if period == 0 {
+		period = q.Resolution.SampleDuration()
+	}
+	if period < 0 {
+		return nil, util.Errorf("query period must be positive: %d", period)
+	}
+	var sources map[string]struct{}
+	if len(q.Sources) > 0 {
+		sources = map[string]struct{}{}
+		for _, source := range q.Sources {
+			sources[source] = struct{}{}
+		}
+	}
+
+	// Scan the data of all key intervals which overlap the window.
+	startKey := makeDataKeyPrefix(q.Name, q.Resolution, q.Resolution.intervalStart(q.Start))
+	endKey := makeDataKeyPrefix(q.Name, q.Resolution, q.Resolution.intervalStart(q.End-1)+q.Resolution.KeyDuration())
+	reply := &proto.ScanResponse{}
+	if err := db.kv.Call(proto.Scan, proto.ScanArgs(startKey, endKey, 0), reply); err != nil {
+		return nil, err
+	}
+
+	// Downsample the samples of each source.
+	downsampled := map[string]map[int64]*aggregate{}
+	for _, row := range reply.Rows {
+		_, source, _, _, err := DecodeDataKey(row.Key)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := sources[source]; sources != nil && !ok {
+			continue
+		}
+		data, err := proto.TimeSeriesFromValue(&row.Value)
+		if err != nil {
+			return nil, err
+		}
+		unit := int64(time.Second)
+		if data.SamplePrecision == proto.MILLISECONDS {
+			unit = int64(time.Millisecond)
+		}
+		periods, ok := downsampled[source]
+		if !ok {
+			periods = map[int64]*aggregate{}
+			downsampled[source] = periods
+		}
+		for _, point := range data.Data {
+			timestamp := data.StartTimestamp*int64(time.Second) + int64(point.Offset)*unit
+			if timestamp < q.Start || timestamp >= q.End {
+				continue
+			}
+			timestamp -= timestamp % period
+			agg, ok := periods[timestamp]
+			if !ok {
+				agg = &aggregate{}
+				periods[timestamp] = agg
+			}
+			if point.ValueInt != nil {
+				agg.add(float64(point.GetValueInt()))
+			} else {
+				agg.add(float64(point.GetValueFloat()))
+			}
+		}
+	}
+
+	// Aggregate the sources at each period with data.
+	series := map[string][]Datapoint{}
+	timestamps := map[int64]struct{}{}
+	for source, periods := range downsampled {
+		points := make([]Datapoint, 0, len(periods))
+		for timestamp, agg := range periods {
+			points = append(points, Datapoint{Timestamp: timestamp, Value: agg.value(q.Downsampler)})
+			timestamps[timestamp] = struct{}{}
+		}
+		sort.Sort(datapointsByTime(points))
+		series[source] = points
+	}
+	var result []Datapoint
+	for timestamp := range timestamps {
+		agg := &aggregate{}
+		for _, points := range series {
+			if v, ok := interpolate(points, timestamp); ok {
+				agg.add(v)
+			}
+		}
+		result = append(result, Datapoint{Timestamp: timestamp, Value: agg.value(q.SourceAggregator)})
+	}
+	sort.Sort(datapointsByTime(result))
+	return result, nil
+}
+
+// interpolate returns the value of the series at the timestamp. If
+// the series has no datapoint at the timestamp, the value is
+// interpolated linearly between the datapoints before and after it.
+// Returns false if the timestamp is outside of the series.
+func interpolate(points []Datapoint, timestamp int64) (float64, bool) {
+	i := sort.Search(len(points), func(i int) bool {
+		return points[i].Timestamp >= timestamp
+	})
+	if i == len(points) {
+		return 0, false
+	}
+	if points[i].Timestamp == timestamp {
+		return points[i].Value, true
+	}
+	if i == 0 {
+		return 0, false
+	}
+	prev, next := points[i-1], points[i]
+	fraction := float64(timestamp-prev.Timestamp) / float64(next.Timestamp-prev.Timestamp)
+	return prev.Value + (next.Value-prev.Value)*fraction, true
+}
+
+// datapointsByTime implements sort.Interface for datapoints in
+// ascending order of time.
+type datapointsByTime []Datapoint
+
+func (d datapointsByTime) Len() int           { return len(d) }
+func (d datapointsByTime) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d datapointsByTime) Less(i, j int) bool { return d[i].Timestamp < d[j].Timestamp }
@@ -0,0 +1,237 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package ts
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/kv"
+	"github.com/cockroachdb/cockroach/multiraft"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/rpc"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/hlc"
+)
+
+// createTestDB creates a time series DB backed by a store with an
+// in-memory engine. The store must be stopped by the caller.
+func createTestDB(t *testing.T) (*DB, *storage.Store) {
+	clock := hlc.NewClock(hlc.UnixNano)
+	rpcContext := rpc.NewContext(clock, rpc.LoadInsecureTLSConfig())
+	g := gossip.New(rpcContext)
+	eng := engine.NewInMem(proto.Attributes{}, 50<<20)
+	lSender := kv.NewLocalSender()
+	sender := kv.NewTxnCoordSender(lSender, clock)
+	db := client.NewKV(sender, nil)
+	db.User = storage.UserRoot
+	store := storage.NewStore(clock, eng, db, g, multiraft.NewLocalRPCTransport())
+	if err := store.Bootstrap(proto.StoreIdent{StoreID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Start(); err != nil {
+		t.Fatal(err)
+	}
+	lSender.AddStore(store)
+	if err := store.BootstrapRange(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return NewDB(db), store
+}
+
+// t0 is the start of a key interval at which the test data begins.
+var t0 = 100 * int64(time.Hour)
+
+// seconds returns the timestamp s seconds after t0.
+func seconds(s int64) int64 {
+	return t0 + s*int64(time.Second)
+}
+
+// storeTestData stores the data of two sources of the series "test"
+// over two key intervals. Source "b" has no data at 10s and 20s.
+func storeTestData(db *DB, t *testing.T) {
+	hour := int64(time.Hour / time.Second)
+	data := map[string][]Datapoint{
+		"a": {
+			{seconds(0), 1.5},
+			{seconds(10), 3},
+			{seconds(20), 5},
+			{seconds(30), 7},
+			{seconds(hour), 200},
+		},
+		"b": {
+			{seconds(0), 10},
+			{seconds(30), 40},
+			{seconds(hour), 100},
+		},
+	}
+	for source, points := range data {
+		if err := db.StoreData(Resolution10s, "test", source, points); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Data of another series must not be returned.
+	if err := db.StoreData(Resolution10s, "testing", "a", []Datapoint{{seconds(0), 1000}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestQueryAggregators verifies that the sources of a series are
+// interpolated over gaps and combined by each of the aggregators.
+func TestQueryAggregators(t *testing.T) {
+	db, store := createTestDB(t)
+	defer store.Stop()
+	storeTestData(db, t)
+
+	hour := int64(time.Hour / time.Second)
+	times := []int64{seconds(0), seconds(10), seconds(20), seconds(30), seconds(hour)}
+	testCases := []struct {
+		agg       Aggregator
+		expValues []float64
+	}{
+		{AggregatorSum, []float64{11.5, 23, 35, 47, 300}},
+		{AggregatorAvg, []float64{5.75, 11.5, 17.5, 23.5, 150}},
+		{AggregatorMin, []float64{1.5, 3, 5, 7, 100}},
+		{AggregatorMax, []float64{10, 20, 30, 40, 200}},
+	}
+	for i, test := range testCases {
+		result, err := db.Query(Query{
+			Name:             "test",
+			Start:            t0,
+			End:              seconds(hour + 10),
+			Resolution:       Resolution10s,
+			SourceAggregator: test.agg,
+		})
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		var expected []Datapoint
+		for j, v := range test.expValues {
+			expected = append(expected, Datapoint{times[j], v})
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("%d: expected %v; got %v", i, expected, result)
+		}
+	}
+}
+
+// TestQueryDownsample verifies that samples are downsampled to the
+// query period, restricted to the requested sources and window.
+func TestQueryDownsample(t *testing.T) {
+	db, store := createTestDB(t)
+	defer store.Stop()
+	storeTestData(db, t)
+
+	hour := int64(time.Hour / time.Second)
+	period := 20 * int64(time.Second)
+	testCases := []struct {
+		q        Query
+		expected []Datapoint
+	}{
+		{
+			Query{Sources: []string{"a"}, Start: t0, End: seconds(hour + 10), Downsampler: AggregatorMax},
+			[]Datapoint{{seconds(0), 3}, {seconds(20), 7}, {seconds(hour), 200}},
+		},
+		{
+			Query{Sources: []string{"a"}, Start: t0, End: seconds(hour), Downsampler: AggregatorAvg},
+			[]Datapoint{{seconds(0), 2.25}, {seconds(20), 6}},
+		},
+		{
+			Query{Sources: []string{"a"}, Start: seconds(10), End: seconds(hour), Downsampler: AggregatorSum},
+			[]Datapoint{{seconds(0), 3}, {seconds(20), 12}},
+		},
+		{
+			Query{Start: t0, End: seconds(hour), Downsampler: AggregatorMin, SourceAggregator: AggregatorSum},
+			[]Datapoint{{seconds(0), 11.5}, {seconds(20), 45}},
+		},
+		{
+			Query{Sources: []string{"c"}, Start: t0, End: seconds(hour)},
+			nil,
+		},
+	}
+	for i, test := range testCases {
+		test.q.Name = "test"
+		test.q.Resolution = Resolution10s
+		test.q.Period = period
+		result, err := db.Query(test.q)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%d: expected %v; got %v", i, test.expected, result)
+		}
+	}
+
+	if _, err := db.Query(Query{Name: "test", Start: t0, End: t0, Resolution: Resolution10s}); err == nil {
+		t.Error("expected an error querying an empty window")
+	}
+}
+
+// TestQueryHTTP verifies the query parameters and response of the
+// HTTP endpoint.
+func TestQueryHTTP(t *testing.T) {
+	db, store := createTestDB(t)
+	defer store.Stop()
+	storeTestData(db, t)
+	s := httptest.NewServer(NewServer(db))
+	defer s.Close()
+
+	url := fmt.Sprintf("%s%s?name=test&sources=a,b&start=%d&end=%d&aggregator=max",
+		s.URL, QueryPrefix, t0, seconds(20))
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d; got %d", http.StatusOK, resp.StatusCode)
+	}
+	var result []Datapoint
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Datapoint{{seconds(0), 10}, {seconds(10), 3}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v; got %v", expected, result)
+	}
+
+	for _, query := range []string{
+		"start=0&end=10",
+		"name=test&start=10&end=0",
+		"name=test&start=0&end=10&aggregator=median",
+		"name=test&start=x&end=10",
+	} {
+		resp, err := http.Get(s.URL + QueryPrefix + "?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d; got %d", query, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
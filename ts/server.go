@@ -0,0 +1,121 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package ts
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// QueryPrefix is the endpoint for time series queries.
+const QueryPrefix = "/ts/query"
+
+const (
+	queryParamName        = "name"
+	queryParamSources     = "sources"
+	queryParamStart       = "start"
+	queryParamEnd         = "end"
+	queryParamPeriod      = "period"
+	queryParamDownsampler = "downsampler"
+	queryParamAggregator  = "aggregator"
+)
+
+// A Server provides an HTTP API to query time series data. A query
+// is a GET request with the following parameters:
+//
+//   name:        the name of the time series (required)
+//   sources:     comma-separated list of sources (default: all)
+//   start, end:  the queried window in nanoseconds since the unix epoch
+//   period:      the downsampling period in nanoseconds (default: 10s)
+//   downsampler: avg, sum, min or max (default: avg)
+//   aggregator:  avg, sum, min or max (default: avg)
+//
+// The response is a JSON-encoded list of datapoints.
+type Server struct {
+	db *DB
+}
+
+// NewServer allocates and returns a new server.
+func NewServer(db *DB) *Server {
+	return &Server{db: db}
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	q := Query{
+		Name:       r.FormValue(queryParamName),
+		Resolution: Resolution10s,
+	}
+	if len(q.Name) == 0 {
+		http.Error(w, "name must be non-empty", http.StatusBadRequest)
+		return
+	}
+	if sources := r.FormValue(queryParamSources); len(sources) > 0 {
+		q.Sources = strings.Split(sources, ",")
+	}
+	for param, v := range map[string]*int64{
+		queryParamStart:  &q.Start,
+		queryParamEnd:    &q.End,
+		queryParamPeriod: &q.Period,
+	} {
+		if len(r.FormValue(param)) == 0 {
+			continue
+		}
+		var err error
+		if *v, err = strconv.ParseInt(r.FormValue(param), 10, 64); err != nil {
+			http.Error(w, "error parsing "+param+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var err error
+	if q.Downsampler, err = ParseAggregator(r.FormValue(queryParamDownsampler)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.SourceAggregator, err = ParseAggregator(r.FormValue(queryParamAggregator)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.End <= q.Start {
+		http.Error(w, "end must be greater than start", http.StatusBadRequest)
+		return
+	}
+	if q.Period < 0 {
+		http.Error(w, "period must be non-negative", http.StatusBadRequest)
+		return
+	}
+
+	datapoints, err := s.db.Query(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if datapoints == nil {
+		datapoints = []Datapoint{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(datapoints); err != nil {
+		log.Errorf("could not json encode response: %v", err)
+	}
+}
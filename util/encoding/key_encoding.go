@@ -96,7 +96,7 @@ func DecodeString(b []byte) ([]byte, string) {
 	}
 	for i, v := range b[1:] {
 		if v == orderedEncodingTerminator {
-			return b[1+i:], string(b[1 : 1+i])
+			return b[2+i:], string(b[1 : 1+i])
 		}
 	}
 	panic("encoded string must have terminator byte")
@@ -391,6 +391,46 @@ func EncodeFloat(b []byte, f float64) []byte {
 	return nil
 }
 
+// SkipFloat returns the remaining byte slice after skipping over the
+// float64 encoded at the start of buf by EncodeFloat. Every encoding
+// which isn't a single byte ends with the only 0x00 byte it contains.
+func SkipFloat(buf []byte) []byte {
+	switch buf[0] {
+	case orderedEncodingNaN, orderedEncodingNegativeInfinity, orderedEncodingZero, orderedEncodingInfinity:
+		return buf[1:]
+	}
+	idx := bytes.IndexByte(buf, orderedEncodingTerminator)
+	if idx == -1 {
+		panic("encoded float must have terminator byte")
+	}
+	return buf[idx+1:]
+}
+
+// PeekLength returns the length of the value encoded at the start of
+// b by one of the ordered encodings of this file, or -1 if b doesn't
+// begin with a complete encoded value. A binary value encoded by
+// EncodeBinaryFinal extends to the end of b.
+func PeekLength(b []byte) int {
+	if len(b) == 0 {
+		return -1
+	}
+	switch {
+	case b[0] == orderedEncodingNil, b[0] == orderedEncodingNaN, b[0] == orderedEncodingNegativeInfinity,
+		b[0] == orderedEncodingZero, b[0] == orderedEncodingInfinity:
+		return 1
+	case b[0] == orderedEncodingBinaryNoTermination:
+		return len(b)
+	case b[0] == orderedEncodingText, b[0] == orderedEncodingBinary,
+		b[0] >= 0x08 && b[0] <= 0x22:
+		// Strings, binaries and numbers other than the single byte
+		// encodings above end with the only 0x00 byte they contain.
+		if idx := bytes.IndexByte(b, orderedEncodingTerminator); idx != -1 {
+			return idx + 1
+		}
+	}
+	return -1
+}
+
 // floatMandE computes and returns the mantissa M and exponent E for f.
 //
 // The mantissa is a base-100 representation of the value. The exponent
@@ -20,6 +20,7 @@ package encoding
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"sort"
 	"testing"
 )
@@ -48,8 +49,10 @@ func TestEncodeDecodeString(t *testing.T) {
 		if buf[n-1] != orderedEncodingTerminator {
 			t.Errorf("expected terminating byte (%#x), got %#x", orderedEncodingTerminator, buf[n-1])
 		}
-		_, s := DecodeString(buf)
-
+		remainder, s := DecodeString(buf)
+		if len(remainder) != 0 {
+			t.Errorf("unexpected remainder decoding %q: %s", c.text, prettyBytes(remainder))
+		}
 		if s != c.text {
 			t.Errorf("error decoding string: expected %q, got %q", c.text, s)
 		}
@@ -301,3 +304,45 @@ func disabledTestFloatMandE(t *testing.T) {
 		}
 	}
 }
+
+func TestSkipFloat(t *testing.T) {
+	testCases := []float64{
+		math.NaN(), math.Inf(-1), -1e20, -12.5, -0.001, 0, 0.001, 1, 99.5, 1e20, math.Inf(1),
+	}
+	for _, f := range testCases {
+		enc := EncodeString(EncodeFloat([]byte{}, f), "suffix")
+		remainder, s := DecodeString(SkipFloat(enc))
+		if len(remainder) != 0 || s != "suffix" {
+			t.Errorf("unexpected remainder after skipping %v: %s", f, prettyBytes(remainder))
+		}
+	}
+}
+
+func TestPeekLength(t *testing.T) {
+	testCases := [][]byte{
+		EncodeNil(),
+		EncodeString(nil, ""),
+		EncodeString(nil, "foo"),
+		EncodeBinary(nil, []byte{}),
+		EncodeBinary(nil, []byte{0, 1, 0xff}),
+		EncodeInt(nil, 0),
+		EncodeInt(nil, -1),
+		EncodeInt(nil, 1<<40),
+		EncodeInt(nil, math.MinInt64),
+		EncodeFloat(nil, -0.001),
+		EncodeFloat(nil, math.Inf(1)),
+	}
+	for i, enc := range testCases {
+		if l := PeekLength(append(enc, EncodeString(nil, "suffix")...)); l != len(enc) {
+			t.Errorf("%d: expected length %d of %s; got %d", i, len(enc), prettyBytes(enc), l)
+		}
+	}
+	if l := PeekLength(EncodeBinaryFinal([]byte("foo"))); l != 4 {
+		t.Errorf("expected final binary to extend to end; got length %d", l)
+	}
+	for i, b := range [][]byte{nil, {0x00}, {0x24, 'a'}, {0xff}} {
+		if l := PeekLength(b); l != -1 {
+			t.Errorf("%d: expected no value in %s; got length %d", i, prettyBytes(b), l)
+		}
+	}
+}
@@ -128,7 +128,8 @@ type MetricSystem struct {
 	gaugeFuncs   map[string]func() float64
 	gaugeFuncsMu sync.Mutex
 	// Has reaper() been started?
-	reaping bool
+	reaping   bool
+	reapingMu sync.Mutex
 	// Close this to bring down this MetricSystem
 	shutdownChan chan struct{}
 }
@@ -516,8 +517,6 @@ func (ms *MetricSystem) updateSubscribers() {
 // collects and processes metrics, and pushes
 // them to the corresponding subscribing channels.
 func (ms *MetricSystem) reaper() {
-	ms.reaping = true
-
 	// create goroutine pool to handle multiple processing tasks at once
 	processChan := make(chan func(), 16)
 	for i := 0; i < int(math.Max(float64(runtime.NumCPU()/4), 4)); i++ {
@@ -543,7 +542,9 @@ func (ms *MetricSystem) reaper() {
 		select {
 		case _, ok := <-ms.shutdownChan:
 			if !ok {
+				ms.reapingMu.Lock()
 				ms.reaping = false
+				ms.reapingMu.Unlock()
 				close(processChan)
 				return
 			}
@@ -633,7 +634,10 @@ func (ms *MetricSystem) reaper() {
 // metric submitters, and a reaper goroutine that harvests metrics at the
 // default interval of every 60 seconds.
 func (ms *MetricSystem) Start() {
+	ms.reapingMu.Lock()
+	defer ms.reapingMu.Unlock()
 	if !ms.reaping {
+		ms.reaping = true
 		go ms.reaper()
 	}
 }